@@ -0,0 +1,74 @@
+// Command authz-fsck checks the table for the inconsistencies earlier versions, partial failures
+// and manual edits may have left: half-edges missing their mirrored half, edges to missing nodes
+// and malformed items. It reports them and, with -apply, repairs them.
+//
+// Usage:
+//
+//	authz-fsck [-organisation id] [-apply] [settings flags]
+//
+// Without -organisation the whole table is scanned. The exit status is 1 if inconsistencies are found
+// and not repaired. The table is configured as for the service, see package settings.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/repository"
+	"github.com/dbuduev/authz-service-go/settings"
+	"github.com/google/uuid"
+	"log"
+	"os"
+)
+
+func main() {
+	organisation := flag.String("organisation", "", "the id of the organisation to check, the whole table is checked if empty")
+	apply := flag.Bool("apply", false, "repair the inconsistencies, by default they are only reported")
+	cfg, err := settings.Load(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatalf("failed to load configuration, %v", err)
+	}
+	ctx := context.Background()
+
+	graph, err := cfg.GraphClient(ctx)
+	if err != nil {
+		log.Fatalf("failed to load configuration, %v", err)
+	}
+	repo := repository.CreateRepository(graph)
+
+	var inconsistencies []repository.Inconsistency
+	if *organisation == "" {
+		inconsistencies, err = repo.CheckAll(ctx)
+	} else {
+		orgId, parseErr := uuid.Parse(*organisation)
+		if parseErr != nil {
+			log.Fatalf("invalid organisation id %q: %v", *organisation, parseErr)
+		}
+		inconsistencies, err = repo.CheckOrganisation(ctx, orgId)
+	}
+	if err != nil {
+		log.Fatalf("check failed: %v", err)
+	}
+
+	for _, x := range inconsistencies {
+		fmt.Println(x)
+		for _, item := range x.Delete {
+			fmt.Printf("\tdelete %s %s\n", item.GlobalId, item.TypeTarget)
+		}
+		for _, edge := range x.Insert {
+			fmt.Printf("\tinsert %s -> %s %s %v\n", edge.Id, edge.TargetNodeType, edge.TargetNodeId, edge.Tags)
+		}
+	}
+	fmt.Printf("%d inconsistencies found\n", len(inconsistencies))
+	if len(inconsistencies) == 0 {
+		return
+	}
+	if !*apply {
+		fmt.Println("dry run, rerun with -apply to repair them")
+		os.Exit(1)
+	}
+	if err := repo.Repair(ctx, inconsistencies); err != nil {
+		log.Fatalf("repair failed: %v", err)
+	}
+	fmt.Println("repaired")
+}
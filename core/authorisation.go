@@ -1,69 +1,219 @@
 package core
 
 import (
+	"context"
+	"github.com/dbuduev/authz-service-go/sphinx"
 	"github.com/google/uuid"
+	"time"
 )
 
 type AuthorisationCore struct {
 	repository Repository
+	// clock returns the time the assignments validity is checked at, time.Now if nil.
+	clock func() time.Time
 }
 
 func CreateAuthorisationCore(repository Repository) AuthorisationCore {
 	return AuthorisationCore{repository: repository}
 }
 
-// FindOpByName returns nil if operation is not found.
-func (ac *AuthorisationCore) FindOpByName(organisationId uuid.UUID, name string) *Operation {
-	ops, err := ac.repository.GetAllOperations(organisationId)
-	if err != nil {
-		panic(err)
+func (ac *AuthorisationCore) now() time.Time {
+	if ac.clock == nil {
+		return time.Now()
 	}
+	return ac.clock()
+}
 
-	for i := 0; i < len(ops); i++ {
-		if ops[i].Name == name {
-			return &ops[i]
-		}
+// FindOpByName looks the operation up in the operation catalogue.
+// It returns NotFoundError if operation is not found.
+func (ac *AuthorisationCore) FindOpByName(ctx context.Context, name string) (*Operation, error) {
+	if name == "" {
+		return nil, &Error{Kind: InvalidInputError}
 	}
 
-	return nil
+	op, err := ac.repository.GetOperationByName(ctx, name)
+	if err != nil {
+		return nil, Classify(err)
+	}
+
+	return &op, nil
 }
 
 // WhereAuthorised returns a slice of branch or branch group ids where the operation is authorised for the user.
-func (ac *AuthorisationCore) WhereAuthorised(organisationId, userId, opId uuid.UUID) []uuid.UUID {
-	r := ac.repository
+// The operation is authorised by the roles having it and by the roles including them.
+// The branches and branch groups where the operation is denied, directly or through an enclosing branch group,
+// are left out. A branch group is still returned if only some of its branches are denied,
+// use WhereAuthorisedBranches to get the exact branches.
+// The organisation id in the result stands for an organisation-wide grant covering every branch.
+func (ac *AuthorisationCore) WhereAuthorised(ctx context.Context, organisationId, userId, opId uuid.UUID) ([]uuid.UUID, error) {
+	if err := validateIds(organisationId, userId, opId); err != nil {
+		return nil, err
+	}
+	ids, _, err := newChecker(ac.repository, organisationId, ac.now()).whereAuthorised(ctx, userId, opId)
+	return ids, err
+}
+
+// WhereAuthorisedBranches is a variant of WhereAuthorised which expands branch groups
+// into the branches they contain, directly or through nested branch groups, using the organisation hierarchy.
+// Denied branches are left out of the expansion.
+// An organisation-wide grant is expanded into all the current branches of the organisation.
+// If includeGroups is true, the branch groups the operation is granted in are returned as well,
+// together with the branch groups containing the branches where it is authorised.
+func (ac *AuthorisationCore) WhereAuthorisedBranches(ctx context.Context, organisationId, userId, opId uuid.UUID, includeGroups bool) (AuthorisedBranches, error) {
+	if err := validateIds(organisationId, userId, opId); err != nil {
+		return AuthorisedBranches{}, err
+	}
+	c := newChecker(ac.repository, organisationId, ac.now())
+	ids, denying, err := c.whereAuthorised(ctx, userId, opId)
+	if err != nil || len(ids) == 0 {
+		return AuthorisedBranches{}, err
+	}
 
-	// 1. op -> [role]
-	roles, err := r.GetRolesByOperation(organisationId, opId)
+	hierarchy, err := c.getHierarchy(ctx)
 	if err != nil {
-		panic(err)
+		return AuthorisedBranches{}, err
 	}
-	// no roles supporting this operation. TODO: log with warning level.
-	if len(roles) == 0 {
-		return nil
+	groupsOfBranch, err := c.getBranchGroupsOfBranch(ctx)
+	if err != nil {
+		return AuthorisedBranches{}, err
+	}
+	allGroups, err := ac.repository.GetAllBranchGroups(ctx, organisationId)
+	if err != nil {
+		return AuthorisedBranches{}, Classify(err)
+	}
+	// The empty branch groups are not in the hierarchy, they must not be taken for branches.
+	content := make(sphinx.BranchGroupContent, len(hierarchy)+len(allGroups))
+	for id, members := range hierarchy {
+		content[id] = members
+	}
+	for _, g := range allGroups {
+		content[g.Id] = hierarchy[g.Id]
+	}
+
+	organisationWide := false
+	for i, id := range ids {
+		if id == organisationId {
+			organisationWide = true
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if organisationWide {
+		ids, err = ac.allBranches(ctx, organisationId, ids)
+		if err != nil {
+			return AuthorisedBranches{}, err
+		}
+		for _, g := range allGroups {
+			ids = append(ids, g.Id)
+		}
 	}
 
-	// 2. uid, role -> B, where B = [b|bg]
-	assignments, err := r.GetUserRolesAssignments(organisationId, userId)
+	branches, groups := content.Expand(ids)
+	result := AuthorisedBranches{
+		Branches:         withoutDenied(branches, denying, groupsOfBranch),
+		OrganisationWide: organisationWide,
+	}
+	if includeGroups {
+		result.BranchGroups = containingGroups(withoutDenied(groups, denying, groupsOfBranch), result.Branches, groupsOfBranch)
+	}
+
+	return result, nil
+}
+
+// allBranches appends the ids of all the branches of the organisation to ids.
+func (ac *AuthorisationCore) allBranches(ctx context.Context, organisationId uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error) {
+	branches, err := ac.repository.GetAllBranches(ctx, organisationId)
 	if err != nil {
-		panic(err)
+		return nil, Classify(err)
+	}
+	for _, b := range branches {
+		ids = append(ids, b.Id)
+	}
+	return ids, nil
+}
+
+// containingGroups adds the branch groups containing any of the branches, directly or transitively, to groups.
+// The result is de-duplicated and sorted.
+func containingGroups(groups, branches []uuid.UUID, groupsOfBranch sphinx.BranchGroupsOfBranch) []uuid.UUID {
+	set := make(map[uuid.UUID]struct{}, len(groups))
+	for _, g := range groups {
+		set[g] = struct{}{}
+	}
+	for _, b := range branches {
+		for _, g := range groupsOfBranch.Ancestors(b) {
+			set[g] = struct{}{}
+		}
+	}
+	result := make([]uuid.UUID, 0, len(set))
+	for g := range set {
+		result = append(result, g)
+	}
+	sphinx.Sort(result)
+	return result
+}
+
+// Check decides whether the user may perform the operation in the branch.
+// A role assigned in a branch group containing the branch, directly or through nested branch groups,
+// allows the operation as well.
+// An assignment made directly in the branch takes priority over the one made in a branch group,
+// and an assignment made in a branch group takes priority over the one made in a group containing it.
+// An organisation-wide assignment covers every branch and has the lowest priority.
+//
+// Only the assignments in effect at the time of the check are taken into account.
+//
+// Denials take precedence over grants: if a role having the operation is denied to the user
+// in the branch, in any branch group containing it or organisation-wide, the operation is not allowed
+// whatever roles are assigned to the user and wherever they are assigned.
+func (ac *AuthorisationCore) Check(ctx context.Context, organisationId, userId, opId, branchId uuid.UUID) (Decision, error) {
+	if err := validateIds(organisationId, userId, opId, branchId); err != nil {
+		return Decision{}, err
+	}
+	return newChecker(ac.repository, organisationId, ac.now()).check(ctx, userId, opId, branchId)
+}
+
+// Explain makes the same decision as Check and returns the reasoning behind it:
+// the roles having the operation, the branch groups containing the branch
+// and how each assignment of the user relates to the operation and the branch.
+func (ac *AuthorisationCore) Explain(ctx context.Context, organisationId, userId, opId, branchId uuid.UUID) (Explanation, error) {
+	if err := validateIds(organisationId, userId, opId, branchId); err != nil {
+		return Explanation{}, err
+	}
+	return newChecker(ac.repository, organisationId, ac.now()).explain(ctx, userId, opId, branchId)
+}
+
+// CheckMany answers a batch of checks in a single organisation.
+// Repository reads are shared across the batch: the hierarchy is read at most once,
+// roles are read once per operation and assignments are read once per user.
+// The i-th decision corresponds to the i-th request.
+func (ac *AuthorisationCore) CheckMany(ctx context.Context, organisationId uuid.UUID, requests []CheckRequest) ([]Decision, error) {
+	if err := validateIds(organisationId); err != nil {
+		return nil, err
+	}
+	for _, request := range requests {
+		if err := validateIds(request.UserId, request.OperationId, request.BranchId); err != nil {
+			return nil, err
+		}
 	}
 
-	branches := make(map[uuid.UUID]struct{}, len(assignments))
-	for _, assignment := range assignments {
-		for _, role := range roles {
-			if role == assignment.RoleId {
-				branches[assignment.BranchId] = struct{}{}
-			}
+	c := newChecker(ac.repository, organisationId, ac.now())
+	result := make([]Decision, len(requests))
+	for i, request := range requests {
+		decision, err := c.check(ctx, request.UserId, request.OperationId, request.BranchId)
+		if err != nil {
+			return nil, err
 		}
+		result[i] = decision
 	}
 
-	if len(branches) == 0 {
-		return nil
-	} else {
-		result := make([]uuid.UUID, 0, len(branches))
-		for b := range branches {
-			result = append(result, b)
+	return result, nil
+}
+
+// validateIds returns InvalidInputError if any of ids is uuid.Nil.
+func validateIds(ids ...uuid.UUID) error {
+	for _, id := range ids {
+		if id == uuid.Nil {
+			return &Error{Kind: InvalidInputError}
 		}
-		return result
 	}
+	return nil
 }
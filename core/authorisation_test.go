@@ -1,87 +1,221 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/dygraph"
 	"github.com/dbuduev/authz-service-go/sphinx"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 )
 
 type testRepository struct {
-	addOperation              func(op Operation) error
-	addRole                   func(role Role) error
-	addBranch                 func(b Branch) error
-	addBranchGroup            func(g BranchGroup) error
-	assignOperationToRole     func(x OperationAssignment) error
-	assignBranchToBranchGroup func(x BranchAssignment) error
-	getBranchesByBranchGroup  func(organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error)
-	getRolesByOperation       func(organisationId, opId uuid.UUID) ([]uuid.UUID, error)
-	getOperationsByRole       func(organisationId, roleId uuid.UUID) ([]uuid.UUID, error)
-	getAllRoles               func(organisationId uuid.UUID) ([]Role, error)
-	getAllOperations          func(organisationId uuid.UUID) ([]Operation, error)
-	assignRoleToUser          func(x UserRoleAssignment) error
-	getUserRolesAssignments   func(organisationId, userId uuid.UUID) ([]UserRoleAssignment, error)
-	getHierarchy              func(organisationId uuid.UUID) (sphinx.BranchGroupContent, error)
-}
-
-func (t testRepository) AddOperation(op Operation) error {
+	addOperation                 func(op Operation) error
+	addRole                      func(role Role) error
+	addBranch                    func(b Branch) error
+	addBranchGroup               func(g BranchGroup) error
+	assignOperationToRole        func(x OperationAssignment) error
+	assignBranchToBranchGroup    func(x BranchAssignment) error
+	getBranchesByBranchGroup     func(organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error)
+	getRolesByOperation          func(organisationId, opId uuid.UUID) ([]uuid.UUID, error)
+	getOperationsByRole          func(organisationId, roleId uuid.UUID) ([]uuid.UUID, error)
+	assignRoleToRole             func(x RoleInclusion) error
+	unassignRoleFromRole         func(x RoleInclusion) error
+	getRoleHierarchy             func(organisationId uuid.UUID) (sphinx.RoleContent, error)
+	getEffectiveRolesByOperation func(organisationId, opId uuid.UUID) ([]uuid.UUID, error)
+	getEffectiveOperationsByRole func(organisationId, roleId uuid.UUID) ([]uuid.UUID, error)
+	getAllRoles                  func(organisationId uuid.UUID) ([]Role, error)
+	getAllOperations             func() ([]Operation, error)
+	getAllBranches               func(organisationId uuid.UUID) ([]Branch, error)
+	getAllBranchGroups           func(organisationId uuid.UUID) ([]BranchGroup, error)
+	getRole                      func(organisationId, roleId uuid.UUID) (Role, error)
+	getOperation                 func(opId uuid.UUID) (Operation, error)
+	getOperationByName           func(name string) (Operation, error)
+	getRoleByName                func(organisationId uuid.UUID, name string) (Role, error)
+	indexNames                   func(organisationId uuid.UUID) error
+	assignRoleToUser             func(x UserRoleAssignment) error
+	getUserRolesAssignments      func(organisationId, userId uuid.UUID) ([]UserRoleAssignment, error)
+	getHierarchy                 func(organisationId uuid.UUID) (sphinx.BranchGroupContent, error)
+	unassignOperationFromRole    func(x OperationAssignment) error
+	removeBranchFromBranchGroup  func(x BranchAssignment) error
+	revokeRoleFromUser           func(x UserRoleAssignment) error
+	revokeUserRoles              func(organisationId, userId uuid.UUID) error
+	deleteOperation              func(opId uuid.UUID) error
+	registerOperations           func(ops []Operation) error
+	migrateOperations            func(organisationId uuid.UUID) error
+	migrateRoleInclusions        func(organisationId uuid.UUID) error
+	indexOperationOrganisations  func(organisationId uuid.UUID) error
+	deleteRole                   func(organisationId, roleId uuid.UUID) error
+	deleteBranch                 func(organisationId, branchId uuid.UUID) error
+	deleteBranchGroup            func(organisationId, branchGroupId uuid.UUID) error
+	importDocument               func(organisationId uuid.UUID, doc ImportDocument, progress func(ImportProgress)) (ImportReport, error)
+}
+
+func (t testRepository) AddOperation(_ context.Context, op Operation) error {
 	return t.addOperation(op)
 }
 
-func (t testRepository) AddRole(role Role) error {
+func (t testRepository) RegisterOperations(_ context.Context, ops []Operation) error {
+	return t.registerOperations(ops)
+}
+
+func (t testRepository) AddRole(_ context.Context, role Role) error {
 	return t.addRole(role)
 }
 
-func (t testRepository) AddBranch(b Branch) error {
+func (t testRepository) AddBranch(_ context.Context, b Branch) error {
 	return t.addBranch(b)
 }
 
-func (t testRepository) AddBranchGroup(g BranchGroup) error {
+func (t testRepository) AddBranchGroup(_ context.Context, g BranchGroup) error {
 	return t.addBranchGroup(g)
 }
 
-func (t testRepository) AssignOperationToRole(x OperationAssignment) error {
+func (t testRepository) AssignOperationToRole(_ context.Context, x OperationAssignment) error {
 	return t.assignOperationToRole(x)
 }
 
-func (t testRepository) AssignBranchToBranchGroup(x BranchAssignment) error {
+func (t testRepository) AssignBranchToBranchGroup(_ context.Context, x BranchAssignment) error {
 	return t.assignBranchToBranchGroup(x)
 }
 
-func (t testRepository) GetBranchesByBranchGroup(organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error) {
+func (t testRepository) GetBranchesByBranchGroup(_ context.Context, organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error) {
 	return t.getBranchesByBranchGroup(organisationId, branchGroupId)
 }
 
-func (t testRepository) GetRolesByOperation(organisationId, opId uuid.UUID) ([]uuid.UUID, error) {
+func (t testRepository) GetRolesByOperation(_ context.Context, organisationId, opId uuid.UUID) ([]uuid.UUID, error) {
 	return t.getRolesByOperation(organisationId, opId)
 }
 
-func (t testRepository) GetOperationsByRole(organisationId, roleId uuid.UUID) ([]uuid.UUID, error) {
+func (t testRepository) GetOperationsByRole(_ context.Context, organisationId, roleId uuid.UUID) ([]uuid.UUID, error) {
 	return t.getOperationsByRole(organisationId, roleId)
 }
 
-func (t testRepository) GetAllRoles(organisationId uuid.UUID) ([]Role, error) {
+func (t testRepository) AssignRoleToRole(_ context.Context, x RoleInclusion) error {
+	return t.assignRoleToRole(x)
+}
+
+func (t testRepository) UnassignRoleFromRole(_ context.Context, x RoleInclusion) error {
+	return t.unassignRoleFromRole(x)
+}
+
+// GetRoleHierarchy returns no role inclusions unless the test sets getRoleHierarchy.
+func (t testRepository) GetRoleHierarchy(_ context.Context, organisationId uuid.UUID) (sphinx.RoleContent, error) {
+	if t.getRoleHierarchy == nil {
+		return nil, nil
+	}
+	return t.getRoleHierarchy(organisationId)
+}
+
+func (t testRepository) GetEffectiveRolesByOperation(_ context.Context, organisationId, opId uuid.UUID) ([]uuid.UUID, error) {
+	return t.getEffectiveRolesByOperation(organisationId, opId)
+}
+
+func (t testRepository) GetEffectiveOperationsByRole(_ context.Context, organisationId, roleId uuid.UUID) ([]uuid.UUID, error) {
+	return t.getEffectiveOperationsByRole(organisationId, roleId)
+}
+
+func (t testRepository) GetAllRoles(_ context.Context, organisationId uuid.UUID) ([]Role, error) {
 	return t.getAllRoles(organisationId)
 }
 
-func (t testRepository) GetAllOperations(organisationId uuid.UUID) ([]Operation, error) {
-	return t.getAllOperations(organisationId)
+func (t testRepository) GetAllOperations(_ context.Context) ([]Operation, error) {
+	return t.getAllOperations()
+}
+
+func (t testRepository) GetAllBranches(_ context.Context, organisationId uuid.UUID) ([]Branch, error) {
+	return t.getAllBranches(organisationId)
+}
+
+func (t testRepository) GetAllBranchGroups(_ context.Context, organisationId uuid.UUID) ([]BranchGroup, error) {
+	return t.getAllBranchGroups(organisationId)
 }
 
-func (t testRepository) AssignRoleToUser(x UserRoleAssignment) error {
+func (t testRepository) GetRole(_ context.Context, organisationId, roleId uuid.UUID) (Role, error) {
+	return t.getRole(organisationId, roleId)
+}
+
+func (t testRepository) GetOperation(_ context.Context, opId uuid.UUID) (Operation, error) {
+	return t.getOperation(opId)
+}
+
+func (t testRepository) GetOperationByName(_ context.Context, name string) (Operation, error) {
+	return t.getOperationByName(name)
+}
+
+func (t testRepository) GetRoleByName(_ context.Context, organisationId uuid.UUID, name string) (Role, error) {
+	return t.getRoleByName(organisationId, name)
+}
+
+func (t testRepository) IndexNames(_ context.Context, organisationId uuid.UUID) error {
+	return t.indexNames(organisationId)
+}
+
+func (t testRepository) AssignRoleToUser(_ context.Context, x UserRoleAssignment) error {
 	return t.assignRoleToUser(x)
 }
 
-func (t testRepository) GetUserRolesAssignments(organisationId, userId uuid.UUID) ([]UserRoleAssignment, error) {
+func (t testRepository) GetUserRolesAssignments(_ context.Context, organisationId, userId uuid.UUID) ([]UserRoleAssignment, error) {
 	return t.getUserRolesAssignments(organisationId, userId)
 }
 
-func (t testRepository) GetHierarchy(organisationId uuid.UUID) (sphinx.BranchGroupContent, error) {
+func (t testRepository) GetHierarchy(_ context.Context, organisationId uuid.UUID) (sphinx.BranchGroupContent, error) {
 	return t.getHierarchy(organisationId)
 }
 
+func (t testRepository) UnassignOperationFromRole(_ context.Context, x OperationAssignment) error {
+	return t.unassignOperationFromRole(x)
+}
+
+func (t testRepository) RemoveBranchFromBranchGroup(_ context.Context, x BranchAssignment) error {
+	return t.removeBranchFromBranchGroup(x)
+}
+
+func (t testRepository) RevokeRoleFromUser(_ context.Context, x UserRoleAssignment) error {
+	return t.revokeRoleFromUser(x)
+}
+
+func (t testRepository) RevokeUserRoles(_ context.Context, organisationId, userId uuid.UUID) error {
+	return t.revokeUserRoles(organisationId, userId)
+}
+
+func (t testRepository) DeleteOperation(_ context.Context, opId uuid.UUID) error {
+	return t.deleteOperation(opId)
+}
+
+func (t testRepository) MigrateOperations(_ context.Context, organisationId uuid.UUID) error {
+	return t.migrateOperations(organisationId)
+}
+
+func (t testRepository) MigrateRoleInclusions(_ context.Context, organisationId uuid.UUID) error {
+	return t.migrateRoleInclusions(organisationId)
+}
+
+func (t testRepository) IndexOperationOrganisations(_ context.Context, organisationId uuid.UUID) error {
+	return t.indexOperationOrganisations(organisationId)
+}
+
+func (t testRepository) DeleteRole(_ context.Context, organisationId, roleId uuid.UUID) error {
+	return t.deleteRole(organisationId, roleId)
+}
+
+func (t testRepository) DeleteBranch(_ context.Context, organisationId, branchId uuid.UUID) error {
+	return t.deleteBranch(organisationId, branchId)
+}
+
+func (t testRepository) DeleteBranchGroup(_ context.Context, organisationId, branchGroupId uuid.UUID) error {
+	return t.deleteBranchGroup(organisationId, branchGroupId)
+}
+
+func (t testRepository) Import(_ context.Context, organisationId uuid.UUID, doc ImportDocument, progress func(ImportProgress)) (ImportReport, error) {
+	return t.importDocument(organisationId, doc, progress)
+}
+
 func GenId(id uuid.UUID, b byte) uuid.UUID {
 	return uuid.NewSHA1(id, []byte{b})
 }
@@ -91,59 +225,64 @@ func TestAuthorisationCore_FindOpByName(t *testing.T) {
 	ac := &AuthorisationCore{
 		repository: &repository,
 	}
-
-	type args struct {
-		organisationId uuid.UUID
-		name           string
+	id := uuid.New()
+	catalogue := func(name string) (Operation, error) {
+		for _, op := range []Operation{{GenId(id, 1), "manage-staff"}, {GenId(id, 2), "view-staff"}} {
+			if op.Name == name {
+				return op, nil
+			}
+		}
+		return Operation{}, fmt.Errorf("get node: %w", dygraph.NotFoundError)
 	}
+
 	tests := []struct {
-		name             string
-		getAllOperations func(orgId uuid.UUID) ([]Operation, error)
-		args             args
-		want             func(orgId uuid.UUID) *Operation
+		name               string
+		getOperationByName func(name string) (Operation, error)
+		opName             string
+		want               *Operation
+		wantErr            error
 	}{
 		{
-			name: "There's a match",
-			getAllOperations: func(orgId uuid.UUID) ([]Operation, error) {
-				return []Operation{{orgId, GenId(orgId, 1), "manage-staff"}, {orgId, GenId(orgId, 2), "view-staff"}}, nil
-			},
-			args: args{
-				organisationId: uuid.New(),
-				name:           "view-staff",
-			},
-			want: func(orgId uuid.UUID) *Operation {
-				return &Operation{
-					OrganisationId: orgId,
-					Id:             GenId(orgId, 2),
-					Name:           "view-staff",
-				}
+			name:               "There's a match",
+			getOperationByName: catalogue,
+			opName:             "view-staff",
+			want: &Operation{
+				Id:   GenId(id, 2),
+				Name: "view-staff",
 			},
 		},
 		{
-			name: "There's no match",
-			getAllOperations: func(orgId uuid.UUID) ([]Operation, error) {
-				return []Operation{{orgId, GenId(orgId, 1), "manage-staff"}, {orgId, GenId(orgId, 2), "view-staff"}}, nil
-			},
-			args: args{
-				organisationId: uuid.New(),
-				name:           "no-such-thing",
-			},
-			want: func(orgId uuid.UUID) *Operation {
-				return nil
+			name:               "There's no match",
+			getOperationByName: catalogue,
+			opName:             "no-such-thing",
+			wantErr:            NotFoundError,
+		},
+		{
+			name: "Throttled",
+			getOperationByName: func(name string) (Operation, error) {
+				return Operation{}, fmt.Errorf("get node: %w", dygraph.TooManyRequestsError)
 			},
+			opName:  "view-staff",
+			wantErr: ThrottledError,
+		},
+		{
+			name:    "No name",
+			wantErr: InvalidInputError,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository.getAllOperations = tt.getAllOperations
-			want := tt.want(tt.args.organisationId)
-			got := ac.FindOpByName(tt.args.organisationId, tt.args.name)
-			if want == nil {
+			repository.getOperationByName = tt.getOperationByName
+			got, err := ac.FindOpByName(context.Background(), tt.opName)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("FindOpByName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
 				if got != nil {
 					t.Errorf("FindOpByName() = %v, want nil", *got)
 				}
-			} else if got == nil || !reflect.DeepEqual(*got, *want) {
-				t.Errorf("FindOpByName() = %v, want %v", got, want)
+			} else if got == nil || !reflect.DeepEqual(*got, *tt.want) {
+				t.Errorf("FindOpByName() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -282,10 +421,707 @@ func TestAuthorisationCore_WhereAuthorised(t *testing.T) {
 			repository.getUserRolesAssignments = tt.getUserRolesAssignments
 
 			want := tt.want(tt.args.organisationId)
-			got := ac.WhereAuthorised(tt.args.organisationId, GenId(tt.args.organisationId, tt.args.userId), GenId(tt.args.organisationId, tt.args.opId))
+			got, err := ac.WhereAuthorised(context.Background(), tt.args.organisationId, GenId(tt.args.organisationId, tt.args.userId), GenId(tt.args.organisationId, tt.args.opId))
+			if err != nil {
+				t.Fatalf("WhereAuthorised() error = %v", err)
+			}
 			if diff := cmp.Diff(want, got, trans); diff != "" {
 				t.Errorf("WhereAuthorised() diff  %v", diff)
 			}
 		})
 	}
 }
+
+func TestAuthorisationCore_WhereAuthorisedBranches(t *testing.T) {
+	repository := testRepository{}
+	ac := &AuthorisationCore{
+		repository: &repository,
+	}
+	orgId := uuid.New()
+	userId := GenId(orgId, 1)
+	opId := GenId(orgId, 2)
+	role := GenId(orgId, 3)
+	g := [...]uuid.UUID{GenId(orgId, 20), GenId(orgId, 21), GenId(orgId, 22)}
+	b := [...]uuid.UUID{GenId(orgId, 10), GenId(orgId, 11), GenId(orgId, 12)}
+	sorted := func(ids ...uuid.UUID) []uuid.UUID {
+		sphinx.Sort(ids)
+		return ids
+	}
+
+	repository.getRolesByOperation = func(_, _ uuid.UUID) ([]uuid.UUID, error) {
+		return []uuid.UUID{role}, nil
+	}
+	repository.getHierarchy = func(_ uuid.UUID) (sphinx.BranchGroupContent, error) {
+		return sphinx.BranchGroupContent{
+			g[0]: {b[0], b[1]},
+			g[1]: {b[2]},
+		}, nil
+	}
+	repository.getAllBranchGroups = func(_ uuid.UUID) ([]BranchGroup, error) {
+		// g[2] is empty, so it is not in the hierarchy.
+		result := make([]BranchGroup, len(g))
+		for i, id := range g {
+			result[i] = BranchGroup{OrganisationId: orgId, Id: id}
+		}
+		return result, nil
+	}
+
+	tests := []struct {
+		name          string
+		branchIds     []uuid.UUID
+		includeGroups bool
+		want          AuthorisedBranches
+	}{
+		{
+			name: "No assignments",
+			want: AuthorisedBranches{},
+		},
+		{
+			name:      "Branch and overlapping group",
+			branchIds: []uuid.UUID{b[1], g[0]},
+			want:      AuthorisedBranches{Branches: sorted(b[0], b[1])},
+		},
+		{
+			name:          "Groups included",
+			branchIds:     []uuid.UUID{b[2], g[0]},
+			includeGroups: true,
+			want: AuthorisedBranches{
+				Branches:     sorted(b[0], b[1], b[2]),
+				BranchGroups: sorted(g[0], g[1]),
+			},
+		},
+		{
+			name:          "Groups of a branch granted directly",
+			branchIds:     []uuid.UUID{b[0]},
+			includeGroups: true,
+			want: AuthorisedBranches{
+				Branches:     []uuid.UUID{b[0]},
+				BranchGroups: []uuid.UUID{g[0]},
+			},
+		},
+		{
+			name:          "Empty group",
+			branchIds:     []uuid.UUID{g[2]},
+			includeGroups: true,
+			want: AuthorisedBranches{
+				Branches:     []uuid.UUID{},
+				BranchGroups: []uuid.UUID{g[2]},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository.getUserRolesAssignments = func(orgId, userId uuid.UUID) ([]UserRoleAssignment, error) {
+				result := make([]UserRoleAssignment, len(tt.branchIds))
+				for i, branchId := range tt.branchIds {
+					result[i] = UserRoleAssignment{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: branchId}
+				}
+				return result, nil
+			}
+			got, err := ac.WhereAuthorisedBranches(context.Background(), orgId, userId, opId, tt.includeGroups)
+			if err != nil {
+				t.Fatalf("WhereAuthorisedBranches() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("WhereAuthorisedBranches() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAuthorisationCore_Check(t *testing.T) {
+	repository := testRepository{}
+	ac := &AuthorisationCore{
+		repository: &repository,
+	}
+	orgId := uuid.New()
+	userId := GenId(orgId, 1)
+	opId := GenId(orgId, 2)
+	role := [...]uuid.UUID{GenId(orgId, 3), GenId(orgId, 4)}
+	g := [...]uuid.UUID{GenId(orgId, 20), GenId(orgId, 21), GenId(orgId, 22)}
+	b := [...]uuid.UUID{GenId(orgId, 10), GenId(orgId, 11), GenId(orgId, 12)}
+
+	repository.getHierarchy = func(_ uuid.UUID) (sphinx.BranchGroupContent, error) {
+		return sphinx.BranchGroupContent{
+			g[0]: {b[0], b[1]},
+			g[1]: {b[2]},
+			g[2]: {g[0]},
+		}, nil
+	}
+	assign := func(roleId, branchId uuid.UUID) UserRoleAssignment {
+		return UserRoleAssignment{OrganisationId: orgId, RoleId: roleId, UserId: userId, BranchId: branchId}
+	}
+	deny := func(roleId, branchId uuid.UUID) UserRoleAssignment {
+		return UserRoleAssignment{OrganisationId: orgId, RoleId: roleId, UserId: userId, BranchId: branchId, Deny: true}
+	}
+
+	tests := []struct {
+		name        string
+		roles       []uuid.UUID
+		assignments []UserRoleAssignment
+		branchId    uuid.UUID
+		want        Decision
+	}{
+		{
+			name:        "No role has the operation",
+			assignments: []UserRoleAssignment{assign(role[0], b[0])},
+			branchId:    b[0],
+			want:        Decision{Reason: ReasonNoRoleHasOperation},
+		},
+		{
+			name:        "Role without the operation",
+			roles:       []uuid.UUID{role[0]},
+			assignments: []UserRoleAssignment{assign(role[1], b[0])},
+			branchId:    b[0],
+			want:        Decision{Reason: ReasonNotGranted},
+		},
+		{
+			name:        "Granted in the branch",
+			roles:       []uuid.UUID{role[0]},
+			assignments: []UserRoleAssignment{assign(role[0], g[0]), assign(role[0], b[0])},
+			branchId:    b[0],
+			want:        Decision{Allowed: true, Reason: ReasonGrantedInBranch, RoleId: role[0], GrantedIn: b[0]},
+		},
+		{
+			name:        "Granted in a branch group",
+			roles:       []uuid.UUID{role[0], role[1]},
+			assignments: []UserRoleAssignment{assign(role[1], g[0])},
+			branchId:    b[1],
+			want:        Decision{Allowed: true, Reason: ReasonGrantedInBranchGroup, RoleId: role[1], GrantedIn: g[0]},
+		},
+		{
+			name:        "Granted in an enclosing branch group",
+			roles:       []uuid.UUID{role[0]},
+			assignments: []UserRoleAssignment{assign(role[0], g[2])},
+			branchId:    b[1],
+			want:        Decision{Allowed: true, Reason: ReasonGrantedInBranchGroup, RoleId: role[0], GrantedIn: g[2]},
+		},
+		{
+			name:        "Closest branch group first",
+			roles:       []uuid.UUID{role[0], role[1]},
+			assignments: []UserRoleAssignment{assign(role[1], g[2]), assign(role[0], g[0])},
+			branchId:    b[0],
+			want:        Decision{Allowed: true, Reason: ReasonGrantedInBranchGroup, RoleId: role[0], GrantedIn: g[0]},
+		},
+		{
+			name:        "Denied in the branch",
+			roles:       []uuid.UUID{role[0]},
+			assignments: []UserRoleAssignment{assign(role[0], b[0]), deny(role[0], b[0])},
+			branchId:    b[0],
+			want:        Decision{Reason: ReasonDeniedInBranch, RoleId: role[0], DeniedIn: b[0]},
+		},
+		{
+			name:        "Denied in an enclosing branch group",
+			roles:       []uuid.UUID{role[0], role[1]},
+			assignments: []UserRoleAssignment{assign(role[0], b[1]), deny(role[1], g[2])},
+			branchId:    b[1],
+			want:        Decision{Reason: ReasonDeniedInBranchGroup, RoleId: role[1], DeniedIn: g[2]},
+		},
+		{
+			name:        "Denied without a grant",
+			roles:       []uuid.UUID{role[0]},
+			assignments: []UserRoleAssignment{deny(role[0], g[0])},
+			branchId:    b[0],
+			want:        Decision{Reason: ReasonDeniedInBranchGroup, RoleId: role[0], DeniedIn: g[0]},
+		},
+		{
+			name:        "Denied in another branch",
+			roles:       []uuid.UUID{role[0]},
+			assignments: []UserRoleAssignment{assign(role[0], g[0]), deny(role[0], b[0])},
+			branchId:    b[1],
+			want:        Decision{Allowed: true, Reason: ReasonGrantedInBranchGroup, RoleId: role[0], GrantedIn: g[0]},
+		},
+		{
+			name:        "Denied role without the operation",
+			roles:       []uuid.UUID{role[0]},
+			assignments: []UserRoleAssignment{assign(role[0], b[0]), deny(role[1], b[0])},
+			branchId:    b[0],
+			want:        Decision{Allowed: true, Reason: ReasonGrantedInBranch, RoleId: role[0], GrantedIn: b[0]},
+		},
+		{
+			name:        "Branch group not containing the branch",
+			roles:       []uuid.UUID{role[0]},
+			assignments: []UserRoleAssignment{assign(role[0], g[1]), assign(role[0], b[0])},
+			branchId:    b[1],
+			want:        Decision{Reason: ReasonNotGranted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository.getRolesByOperation = func(_, _ uuid.UUID) ([]uuid.UUID, error) { return tt.roles, nil }
+			repository.getUserRolesAssignments = func(_, _ uuid.UUID) ([]UserRoleAssignment, error) { return tt.assignments, nil }
+			got, err := ac.Check(context.Background(), orgId, userId, opId, tt.branchId)
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Check() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAuthorisationCore_Denials(t *testing.T) {
+	repository := testRepository{}
+	ac := &AuthorisationCore{
+		repository: &repository,
+	}
+	orgId := uuid.New()
+	userId := GenId(orgId, 1)
+	opId := GenId(orgId, 2)
+	role := GenId(orgId, 3)
+	// g[1] contains g[0] and b[2], g[0] contains b[0] and b[1].
+	g := [...]uuid.UUID{GenId(orgId, 20), GenId(orgId, 21), GenId(orgId, 22)}
+	b := [...]uuid.UUID{GenId(orgId, 10), GenId(orgId, 11), GenId(orgId, 12), GenId(orgId, 13)}
+	sorted := func(ids ...uuid.UUID) []uuid.UUID {
+		sphinx.Sort(ids)
+		return ids
+	}
+
+	repository.getRolesByOperation = func(_, _ uuid.UUID) ([]uuid.UUID, error) {
+		return []uuid.UUID{role}, nil
+	}
+	repository.getHierarchy = func(_ uuid.UUID) (sphinx.BranchGroupContent, error) {
+		return sphinx.BranchGroupContent{
+			g[0]: {b[0], b[1]},
+			g[1]: {g[0], b[2]},
+			g[2]: {b[3]},
+		}, nil
+	}
+	repository.getAllBranchGroups = func(_ uuid.UUID) ([]BranchGroup, error) {
+		return []BranchGroup{{OrganisationId: orgId, Id: g[0]}, {OrganisationId: orgId, Id: g[1]}, {OrganisationId: orgId, Id: g[2]}}, nil
+	}
+	repository.getUserRolesAssignments = func(_, _ uuid.UUID) ([]UserRoleAssignment, error) {
+		return []UserRoleAssignment{
+			{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: g[1]},
+			{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: b[1]},
+			{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: g[2]},
+			{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: b[0], Deny: true},
+			{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: g[2], Deny: true},
+		}, nil
+	}
+
+	where, err := ac.WhereAuthorised(context.Background(), orgId, userId, opId)
+	if err != nil {
+		t.Fatalf("WhereAuthorised() error = %v", err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{g[1], b[1]}, where); diff != "" {
+		t.Errorf("WhereAuthorised() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := ac.WhereAuthorisedBranches(context.Background(), orgId, userId, opId, true)
+	if err != nil {
+		t.Fatalf("WhereAuthorisedBranches() error = %v", err)
+	}
+	want := AuthorisedBranches{Branches: sorted(b[1], b[2]), BranchGroups: sorted(g[0], g[1])}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("WhereAuthorisedBranches() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAuthorisationCore_TimeBoundAssignments(t *testing.T) {
+	now := time.Date(2021, 5, 3, 12, 0, 0, 0, time.UTC)
+	repository := testRepository{}
+	ac := &AuthorisationCore{
+		repository: &repository,
+		clock:      func() time.Time { return now },
+	}
+	orgId := uuid.New()
+	userId := GenId(orgId, 1)
+	opId := GenId(orgId, 2)
+	role := GenId(orgId, 3)
+	b := [...]uuid.UUID{GenId(orgId, 10), GenId(orgId, 11), GenId(orgId, 12), GenId(orgId, 13)}
+
+	repository.getRolesByOperation = func(_, _ uuid.UUID) ([]uuid.UUID, error) {
+		return []uuid.UUID{role}, nil
+	}
+	repository.getHierarchy = func(_ uuid.UUID) (sphinx.BranchGroupContent, error) {
+		return sphinx.BranchGroupContent{}, nil
+	}
+	repository.getUserRolesAssignments = func(_, _ uuid.UUID) ([]UserRoleAssignment, error) {
+		assign := func(branchId uuid.UUID, from, until time.Duration) UserRoleAssignment {
+			return UserRoleAssignment{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: branchId,
+				ValidFrom: now.Add(from), ValidUntil: now.Add(until)}
+		}
+		return []UserRoleAssignment{
+			assign(b[0], -time.Hour, time.Hour),
+			assign(b[1], -2*time.Hour, -time.Hour),
+			assign(b[2], time.Hour, 2*time.Hour),
+			{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: b[3], ValidUntil: now},
+		}, nil
+	}
+
+	where, err := ac.WhereAuthorised(context.Background(), orgId, userId, opId)
+	if err != nil {
+		t.Fatalf("WhereAuthorised() error = %v", err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{b[0]}, where); diff != "" {
+		t.Errorf("WhereAuthorised() mismatch (-want +got):\n%s", diff)
+	}
+
+	requests := make([]CheckRequest, len(b))
+	for i, branchId := range b {
+		requests[i] = CheckRequest{UserId: userId, OperationId: opId, BranchId: branchId}
+	}
+	decisions, err := ac.CheckMany(context.Background(), orgId, requests)
+	if err != nil {
+		t.Fatalf("CheckMany() error = %v", err)
+	}
+	for i, decision := range decisions {
+		if decision.Allowed != (i == 0) {
+			t.Errorf("CheckMany() decision %d = %v", i, decision)
+		}
+	}
+}
+
+func TestAuthorisationCore_RoleInclusion(t *testing.T) {
+	repository := testRepository{}
+	ac := &AuthorisationCore{
+		repository: &repository,
+	}
+	orgId := uuid.New()
+	userId := GenId(orgId, 1)
+	opId := GenId(orgId, 2)
+	// role[2] includes role[1] which includes role[0] having the operation.
+	role := [...]uuid.UUID{GenId(orgId, 3), GenId(orgId, 4), GenId(orgId, 5), GenId(orgId, 6)}
+	b := [...]uuid.UUID{GenId(orgId, 10), GenId(orgId, 11)}
+
+	repository.getRolesByOperation = func(_, _ uuid.UUID) ([]uuid.UUID, error) {
+		return []uuid.UUID{role[0]}, nil
+	}
+	repository.getRoleHierarchy = func(_ uuid.UUID) (sphinx.RoleContent, error) {
+		return sphinx.RoleContent{
+			role[2]: {role[1]},
+			role[1]: {role[0]},
+		}, nil
+	}
+	repository.getHierarchy = func(_ uuid.UUID) (sphinx.BranchGroupContent, error) {
+		return sphinx.BranchGroupContent{}, nil
+	}
+	repository.getUserRolesAssignments = func(_, _ uuid.UUID) ([]UserRoleAssignment, error) {
+		return []UserRoleAssignment{
+			{OrganisationId: orgId, RoleId: role[2], UserId: userId, BranchId: b[0]},
+			{OrganisationId: orgId, RoleId: role[3], UserId: userId, BranchId: b[1]},
+		}, nil
+	}
+
+	where, err := ac.WhereAuthorised(context.Background(), orgId, userId, opId)
+	if err != nil {
+		t.Fatalf("WhereAuthorised() error = %v", err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{b[0]}, where); diff != "" {
+		t.Errorf("WhereAuthorised() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := ac.Check(context.Background(), orgId, userId, opId, b[0])
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	want := Decision{Allowed: true, Reason: ReasonGrantedInBranch, RoleId: role[2], GrantedIn: b[0]}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Check() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = ac.Check(context.Background(), orgId, userId, opId, b[1])
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if diff := cmp.Diff(Decision{Reason: ReasonNotGranted}, got); diff != "" {
+		t.Errorf("Check() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAuthorisationCore_Explain(t *testing.T) {
+	repository := testRepository{}
+	now := time.Date(2021, 5, 1, 9, 0, 0, 0, time.UTC)
+	ac := &AuthorisationCore{
+		repository: &repository,
+		clock:      func() time.Time { return now },
+	}
+	orgId := uuid.New()
+	userId := GenId(orgId, 1)
+	opId := GenId(orgId, 2)
+	// role[1] includes role[0] having the operation, role[2] does not have it.
+	role := [...]uuid.UUID{GenId(orgId, 3), GenId(orgId, 4), GenId(orgId, 5)}
+	// g[0] contains g[1] which contains b[0].
+	g := [...]uuid.UUID{GenId(orgId, 20), GenId(orgId, 21)}
+	b := [...]uuid.UUID{GenId(orgId, 10), GenId(orgId, 11)}
+
+	repository.getRolesByOperation = func(_, _ uuid.UUID) ([]uuid.UUID, error) {
+		return []uuid.UUID{role[0]}, nil
+	}
+	repository.getRoleHierarchy = func(_ uuid.UUID) (sphinx.RoleContent, error) {
+		return sphinx.RoleContent{role[1]: {role[0]}}, nil
+	}
+	repository.getHierarchy = func(_ uuid.UUID) (sphinx.BranchGroupContent, error) {
+		return sphinx.BranchGroupContent{
+			g[0]: {g[1]},
+			g[1]: {b[0]},
+		}, nil
+	}
+	assignments := []UserRoleAssignment{
+		{OrganisationId: orgId, RoleId: role[1], UserId: userId, BranchId: g[0]},
+		{OrganisationId: orgId, RoleId: role[2], UserId: userId, BranchId: b[0]},
+		{OrganisationId: orgId, RoleId: role[1], UserId: userId, BranchId: b[1]},
+		{OrganisationId: orgId, RoleId: role[0], UserId: userId, BranchId: b[0], ValidUntil: now.Add(-time.Hour)},
+	}
+	repository.getUserRolesAssignments = func(_, _ uuid.UUID) ([]UserRoleAssignment, error) {
+		return assignments, nil
+	}
+
+	got, err := ac.Explain(context.Background(), orgId, userId, opId, b[0])
+	if err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+	want := Explanation{
+		Decision:       Decision{Allowed: true, Reason: ReasonGrantedInBranchGroup, RoleId: role[1], GrantedIn: g[0]},
+		Roles:          []uuid.UUID{role[0]},
+		IncludingRoles: []uuid.UUID{role[1]},
+		BranchGroups:   []uuid.UUID{g[1], g[0]},
+		Assignments: []ConsideredAssignment{
+			{Assignment: assignments[0], InEffect: true, HasOperation: true, Covers: CoverageBranchGroup, Decisive: true},
+			{Assignment: assignments[1], InEffect: true, Covers: CoverageBranch},
+			{Assignment: assignments[2], InEffect: true, HasOperation: true, Covers: CoverageNone},
+			{Assignment: assignments[3], HasOperation: true, Covers: CoverageBranch},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Explain() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := ac.Explain(context.Background(), orgId, uuid.Nil, opId, b[0]); !errors.Is(err, InvalidInputError) {
+		t.Errorf("Explain() error = %v, want %v", err, InvalidInputError)
+	}
+}
+
+func TestAuthorisationCore_OrganisationWide(t *testing.T) {
+	repository := testRepository{}
+	ac := &AuthorisationCore{
+		repository: &repository,
+	}
+	orgId := uuid.New()
+	userId := GenId(orgId, 1)
+	opId := GenId(orgId, 2)
+	role := GenId(orgId, 3)
+	g := [...]uuid.UUID{GenId(orgId, 20)}
+	b := [...]uuid.UUID{GenId(orgId, 10), GenId(orgId, 11), GenId(orgId, 12)}
+	sorted := func(ids ...uuid.UUID) []uuid.UUID {
+		sphinx.Sort(ids)
+		return ids
+	}
+
+	repository.getRolesByOperation = func(_, _ uuid.UUID) ([]uuid.UUID, error) {
+		return []uuid.UUID{role}, nil
+	}
+	repository.getHierarchy = func(_ uuid.UUID) (sphinx.BranchGroupContent, error) {
+		return sphinx.BranchGroupContent{
+			g[0]: {b[0], b[1]},
+		}, nil
+	}
+	repository.getAllBranches = func(_ uuid.UUID) ([]Branch, error) {
+		result := make([]Branch, len(b))
+		for i, id := range b {
+			result[i] = Branch{OrganisationId: orgId, Id: id}
+		}
+		return result, nil
+	}
+	repository.getAllBranchGroups = func(_ uuid.UUID) ([]BranchGroup, error) {
+		return []BranchGroup{{OrganisationId: orgId, Id: g[0]}}, nil
+	}
+
+	t.Run("Grant", func(t *testing.T) {
+		repository.getUserRolesAssignments = func(_, _ uuid.UUID) ([]UserRoleAssignment, error) {
+			return []UserRoleAssignment{
+				{OrganisationId: orgId, RoleId: role, UserId: userId, OrganisationWide: true},
+				{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: b[0]},
+				{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: b[1], Deny: true},
+			}, nil
+		}
+
+		where, err := ac.WhereAuthorised(context.Background(), orgId, userId, opId)
+		if err != nil {
+			t.Fatalf("WhereAuthorised() error = %v", err)
+		}
+		if diff := cmp.Diff([]uuid.UUID{orgId, b[0]}, where); diff != "" {
+			t.Errorf("WhereAuthorised() mismatch (-want +got):\n%s", diff)
+		}
+
+		branches, err := ac.WhereAuthorisedBranches(context.Background(), orgId, userId, opId, true)
+		if err != nil {
+			t.Fatalf("WhereAuthorisedBranches() error = %v", err)
+		}
+		want := AuthorisedBranches{Branches: sorted(b[0], b[2]), BranchGroups: []uuid.UUID{g[0]}, OrganisationWide: true}
+		if diff := cmp.Diff(want, branches); diff != "" {
+			t.Errorf("WhereAuthorisedBranches() mismatch (-want +got):\n%s", diff)
+		}
+
+		decisions, err := ac.CheckMany(context.Background(), orgId, []CheckRequest{
+			{UserId: userId, OperationId: opId, BranchId: b[0]},
+			{UserId: userId, OperationId: opId, BranchId: b[1]},
+			{UserId: userId, OperationId: opId, BranchId: b[2]},
+		})
+		if err != nil {
+			t.Fatalf("CheckMany() error = %v", err)
+		}
+		wantDecisions := []Decision{
+			{Allowed: true, Reason: ReasonGrantedInBranch, RoleId: role, GrantedIn: b[0]},
+			{Reason: ReasonDeniedInBranch, RoleId: role, DeniedIn: b[1]},
+			{Allowed: true, Reason: ReasonGrantedInOrganisation, RoleId: role, GrantedIn: orgId},
+		}
+		if diff := cmp.Diff(wantDecisions, decisions); diff != "" {
+			t.Errorf("CheckMany() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Deny", func(t *testing.T) {
+		repository.getUserRolesAssignments = func(_, _ uuid.UUID) ([]UserRoleAssignment, error) {
+			return []UserRoleAssignment{
+				{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: b[0]},
+				{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: g[0]},
+				{OrganisationId: orgId, RoleId: role, UserId: userId, OrganisationWide: true, Deny: true},
+			}, nil
+		}
+
+		where, err := ac.WhereAuthorised(context.Background(), orgId, userId, opId)
+		if err != nil {
+			t.Fatalf("WhereAuthorised() error = %v", err)
+		}
+		if len(where) != 0 {
+			t.Errorf("WhereAuthorised() = %v, want none", where)
+		}
+
+		got, err := ac.Check(context.Background(), orgId, userId, opId, b[0])
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		want := Decision{Reason: ReasonDeniedInOrganisation, RoleId: role, DeniedIn: orgId}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Check() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestAuthorisationCore_CheckMany(t *testing.T) {
+	repository := testRepository{}
+	ac := &AuthorisationCore{
+		repository: &repository,
+	}
+	orgId := uuid.New()
+	users := [...]uuid.UUID{GenId(orgId, 1), GenId(orgId, 2)}
+	ops := [...]uuid.UUID{GenId(orgId, 5), GenId(orgId, 6)}
+	role := GenId(orgId, 3)
+	g := GenId(orgId, 20)
+	b := [...]uuid.UUID{GenId(orgId, 10), GenId(orgId, 11)}
+
+	calls := make(map[string]int)
+	repository.getRolesByOperation = func(_, opId uuid.UUID) ([]uuid.UUID, error) {
+		calls["getRolesByOperation"]++
+		if opId == ops[0] {
+			return []uuid.UUID{role}, nil
+		}
+		return nil, nil
+	}
+	repository.getUserRolesAssignments = func(orgId, userId uuid.UUID) ([]UserRoleAssignment, error) {
+		calls["getUserRolesAssignments"]++
+		if userId == users[0] {
+			return []UserRoleAssignment{{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: g}}, nil
+		}
+		return []UserRoleAssignment{{OrganisationId: orgId, RoleId: role, UserId: userId, BranchId: b[1]}}, nil
+	}
+	repository.getHierarchy = func(_ uuid.UUID) (sphinx.BranchGroupContent, error) {
+		calls["getHierarchy"]++
+		return sphinx.BranchGroupContent{g: {b[0]}}, nil
+	}
+
+	requests := []CheckRequest{
+		{UserId: users[0], OperationId: ops[0], BranchId: b[0]},
+		{UserId: users[0], OperationId: ops[0], BranchId: b[1]},
+		{UserId: users[0], OperationId: ops[1], BranchId: b[0]},
+		{UserId: users[1], OperationId: ops[0], BranchId: b[0]},
+		{UserId: users[1], OperationId: ops[0], BranchId: b[1]},
+	}
+	want := []Decision{
+		{Allowed: true, Reason: ReasonGrantedInBranchGroup, RoleId: role, GrantedIn: g},
+		{Reason: ReasonNotGranted},
+		{Reason: ReasonNoRoleHasOperation},
+		{Reason: ReasonNotGranted},
+		{Allowed: true, Reason: ReasonGrantedInBranch, RoleId: role, GrantedIn: b[1]},
+	}
+	got, err := ac.CheckMany(context.Background(), orgId, requests)
+	if err != nil {
+		t.Fatalf("CheckMany() error = %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("CheckMany() mismatch (-want +got):\n%s", diff)
+	}
+
+	wantCalls := map[string]int{"getRolesByOperation": 2, "getUserRolesAssignments": 2, "getHierarchy": 1}
+	if diff := cmp.Diff(wantCalls, calls); diff != "" {
+		t.Errorf("CheckMany() repository calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAuthorisationCore_Errors(t *testing.T) {
+	repository := testRepository{}
+	ac := &AuthorisationCore{
+		repository: &repository,
+	}
+	orgId := uuid.New()
+	userId := GenId(orgId, 1)
+	opId := GenId(orgId, 2)
+	branchId := GenId(orgId, 3)
+	role := GenId(orgId, 4)
+
+	repository.getRolesByOperation = func(_, _ uuid.UUID) ([]uuid.UUID, error) {
+		return []uuid.UUID{role}, nil
+	}
+	repository.getUserRolesAssignments = func(_, _ uuid.UUID) ([]UserRoleAssignment, error) {
+		return nil, fmt.Errorf("get node edges of type: %w", dygraph.TooManyRequestsError)
+	}
+
+	tests := []struct {
+		name    string
+		f       func() error
+		wantErr error
+	}{
+		{
+			name: "WhereAuthorised throttled",
+			f: func() error {
+				_, err := ac.WhereAuthorised(context.Background(), orgId, userId, opId)
+				return err
+			},
+			wantErr: ThrottledError,
+		},
+		{
+			name: "WhereAuthorised invalid input",
+			f: func() error {
+				_, err := ac.WhereAuthorised(context.Background(), orgId, uuid.Nil, opId)
+				return err
+			},
+			wantErr: InvalidInputError,
+		},
+		{
+			name: "Check throttled",
+			f: func() error {
+				_, err := ac.Check(context.Background(), orgId, userId, opId, branchId)
+				return err
+			},
+			wantErr: ThrottledError,
+		},
+		{
+			name: "CheckMany invalid input",
+			f: func() error {
+				_, err := ac.CheckMany(context.Background(), orgId, []CheckRequest{{UserId: userId, OperationId: opId}})
+				return err
+			},
+			wantErr: InvalidInputError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.f(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
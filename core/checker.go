@@ -0,0 +1,347 @@
+package core
+
+import (
+	"context"
+	"github.com/dbuduev/authz-service-go/sphinx"
+	"github.com/google/uuid"
+	"time"
+)
+
+// checker makes authorisation decisions in an organisation.
+// It memoises repository reads, so a batch of checks queries each piece of data once.
+type checker struct {
+	repository     Repository
+	organisationId uuid.UUID
+	now            time.Time
+	roles          map[uuid.UUID][]uuid.UUID
+	assignments    map[uuid.UUID][]UserRoleAssignment
+	hierarchy      sphinx.BranchGroupContent
+	groups         sphinx.BranchGroupsOfBranch
+	includedBy     sphinx.RoleContent
+}
+
+func newChecker(repository Repository, organisationId uuid.UUID, now time.Time) *checker {
+	return &checker{
+		repository:     repository,
+		organisationId: organisationId,
+		now:            now,
+		roles:          make(map[uuid.UUID][]uuid.UUID),
+		assignments:    make(map[uuid.UUID][]UserRoleAssignment),
+	}
+}
+
+func (c *checker) check(ctx context.Context, userId, opId, branchId uuid.UUID) (Decision, error) {
+	roles, err := c.getRolesByOperation(ctx, opId)
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(roles) == 0 {
+		return Decision{Reason: ReasonNoRoleHasOperation}, nil
+	}
+
+	assignments, err := c.getUserRolesAssignments(ctx, userId)
+	if err != nil {
+		return Decision{}, err
+	}
+	granting, denying := matchingAssignments(roles, assignments)
+
+	if len(denying) > 0 {
+		groupsOfBranch, err := c.getBranchGroupsOfBranch(ctx)
+		if err != nil {
+			return Decision{}, err
+		}
+		if deny, ok := closestAssignment(denying, branchId, groupsOfBranch); ok {
+			reason := ReasonDeniedInBranchGroup
+			switch {
+			case deny.OrganisationWide:
+				reason = ReasonDeniedInOrganisation
+			case deny.BranchId == branchId:
+				reason = ReasonDeniedInBranch
+			}
+			return Decision{Reason: reason, RoleId: deny.RoleId, DeniedIn: c.scopeOf(deny)}, nil
+		}
+	}
+
+	if len(granting) == 0 {
+		return Decision{Reason: ReasonNotGranted}, nil
+	}
+
+	for _, assignment := range granting {
+		if assignment.BranchId == branchId {
+			return Decision{Allowed: true, Reason: ReasonGrantedInBranch, RoleId: assignment.RoleId, GrantedIn: branchId}, nil
+		}
+	}
+
+	groupsOfBranch, err := c.getBranchGroupsOfBranch(ctx)
+	if err != nil {
+		return Decision{}, err
+	}
+	if grant, ok := closestAssignment(granting, branchId, groupsOfBranch); ok {
+		reason := ReasonGrantedInBranchGroup
+		if grant.OrganisationWide {
+			reason = ReasonGrantedInOrganisation
+		}
+		return Decision{Allowed: true, Reason: reason, RoleId: grant.RoleId, GrantedIn: c.scopeOf(grant)}, nil
+	}
+
+	return Decision{Reason: ReasonNotGranted}, nil
+}
+
+// explain makes the decision and gathers the data it is based on.
+func (c *checker) explain(ctx context.Context, userId, opId, branchId uuid.UUID) (Explanation, error) {
+	decision, err := c.check(ctx, userId, opId, branchId)
+	if err != nil {
+		return Explanation{}, err
+	}
+	direct, err := c.repository.GetRolesByOperation(ctx, c.organisationId, opId)
+	if err != nil {
+		return Explanation{}, Classify(err)
+	}
+	roles, err := c.getRolesByOperation(ctx, opId)
+	if err != nil {
+		return Explanation{}, err
+	}
+	groupsOfBranch, err := c.getBranchGroupsOfBranch(ctx)
+	if err != nil {
+		return Explanation{}, err
+	}
+	// The checker only keeps the assignments in effect.
+	assignments, err := c.repository.GetUserRolesAssignments(ctx, c.organisationId, userId)
+	if err != nil {
+		return Explanation{}, Classify(err)
+	}
+
+	result := Explanation{
+		Decision:       decision,
+		Roles:          direct,
+		IncludingRoles: without(roles, direct),
+		BranchGroups:   groupsOfBranch.Ancestors(branchId),
+		Assignments:    make([]ConsideredAssignment, len(assignments)),
+	}
+	decidedIn := decision.GrantedIn
+	if !decision.Allowed {
+		decidedIn = decision.DeniedIn
+	}
+	for i, assignment := range assignments {
+		considered := ConsideredAssignment{
+			Assignment:   assignment,
+			InEffect:     assignment.ValidAt(c.now),
+			HasOperation: contains(roles, assignment.RoleId),
+			Covers:       CoverageNone,
+		}
+		switch {
+		case assignment.OrganisationWide:
+			considered.Covers = CoverageOrganisation
+		case assignment.BranchId == branchId:
+			considered.Covers = CoverageBranch
+		case contains(result.BranchGroups, assignment.BranchId):
+			considered.Covers = CoverageBranchGroup
+		}
+		considered.Decisive = decision.RoleId != uuid.Nil && considered.InEffect &&
+			assignment.RoleId == decision.RoleId && assignment.Deny == !decision.Allowed &&
+			c.scopeOf(assignment) == decidedIn
+		result.Assignments[i] = considered
+	}
+	return result, nil
+}
+
+// scopeOf returns the branch or branch group the assignment is made in,
+// or the organisation id for an organisation-wide assignment.
+func (c *checker) scopeOf(assignment UserRoleAssignment) uuid.UUID {
+	if assignment.OrganisationWide {
+		return c.organisationId
+	}
+	return assignment.BranchId
+}
+
+// whereAuthorised returns the branches and branch groups the operation is granted to the user in,
+// except the ones where it is denied, together with the denying assignments.
+// The organisation id stands for an organisation-wide grant.
+func (c *checker) whereAuthorised(ctx context.Context, userId, opId uuid.UUID) ([]uuid.UUID, []UserRoleAssignment, error) {
+	// 1. op -> [role]
+	roles, err := c.getRolesByOperation(ctx, opId)
+	if err != nil {
+		return nil, nil, err
+	}
+	// no roles supporting this operation. TODO: log with warning level.
+	if len(roles) == 0 {
+		return nil, nil, nil
+	}
+
+	// 2. uid, role -> B, where B = [b|bg]
+	assignments, err := c.getUserRolesAssignments(ctx, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	granting, denying := matchingAssignments(roles, assignments)
+	if len(granting) == 0 {
+		return nil, denying, nil
+	}
+
+	var groupsOfBranch sphinx.BranchGroupsOfBranch
+	if len(denying) > 0 {
+		groupsOfBranch, err = c.getBranchGroupsOfBranch(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	seen := make(map[uuid.UUID]struct{}, len(granting))
+	ids := make([]uuid.UUID, 0, len(granting))
+	for _, assignment := range granting {
+		id := c.scopeOf(assignment)
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	ids = withoutDenied(ids, denying, groupsOfBranch)
+	if len(ids) == 0 {
+		return nil, denying, nil
+	}
+	return ids, denying, nil
+}
+
+// getRolesByOperation returns the roles having the operation, either directly
+// or by including a role having it.
+func (c *checker) getRolesByOperation(ctx context.Context, opId uuid.UUID) ([]uuid.UUID, error) {
+	roles, ok := c.roles[opId]
+	if !ok {
+		var err error
+		roles, err = c.repository.GetRolesByOperation(ctx, c.organisationId, opId)
+		if err != nil {
+			return nil, Classify(err)
+		}
+		if len(roles) > 0 {
+			includedBy, err := c.getRolesIncludedBy(ctx)
+			if err != nil {
+				return nil, err
+			}
+			roles = includedBy.Closure(roles)
+		}
+		c.roles[opId] = roles
+	}
+	return roles, nil
+}
+
+func (c *checker) getRolesIncludedBy(ctx context.Context) (sphinx.RoleContent, error) {
+	if c.includedBy == nil {
+		hierarchy, err := c.repository.GetRoleHierarchy(ctx, c.organisationId)
+		if err != nil {
+			return nil, Classify(err)
+		}
+		c.includedBy = hierarchy.Reverse()
+	}
+	return c.includedBy, nil
+}
+
+// getUserRolesAssignments returns the assignments of the user in effect at the time of the check.
+func (c *checker) getUserRolesAssignments(ctx context.Context, userId uuid.UUID) ([]UserRoleAssignment, error) {
+	assignments, ok := c.assignments[userId]
+	if !ok {
+		all, err := c.repository.GetUserRolesAssignments(ctx, c.organisationId, userId)
+		if err != nil {
+			return nil, Classify(err)
+		}
+		for _, assignment := range all {
+			if assignment.ValidAt(c.now) {
+				assignments = append(assignments, assignment)
+			}
+		}
+		c.assignments[userId] = assignments
+	}
+	return assignments, nil
+}
+
+func (c *checker) getHierarchy(ctx context.Context) (sphinx.BranchGroupContent, error) {
+	if c.hierarchy == nil {
+		hierarchy, err := c.repository.GetHierarchy(ctx, c.organisationId)
+		if err != nil {
+			return nil, Classify(err)
+		}
+		c.hierarchy = hierarchy
+	}
+	return c.hierarchy, nil
+}
+
+func (c *checker) getBranchGroupsOfBranch(ctx context.Context) (sphinx.BranchGroupsOfBranch, error) {
+	if c.groups == nil {
+		hierarchy, err := c.getHierarchy(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.groups = hierarchy.Reverse()
+	}
+	return c.groups, nil
+}
+
+// matchingAssignments splits the assignments of any of the roles into granting and denying ones.
+func matchingAssignments(roles []uuid.UUID, assignments []UserRoleAssignment) (granting, denying []UserRoleAssignment) {
+	for _, assignment := range assignments {
+		for _, role := range roles {
+			if role == assignment.RoleId {
+				if assignment.Deny {
+					denying = append(denying, assignment)
+				} else {
+					granting = append(granting, assignment)
+				}
+				break
+			}
+		}
+	}
+	return granting, denying
+}
+
+// closestAssignment returns the assignment made in the branch or branch group designated by id,
+// or else the one made in the closest branch group containing it,
+// or else the organisation-wide one.
+func closestAssignment(assignments []UserRoleAssignment, id uuid.UUID, groups sphinx.BranchGroupsOfBranch) (UserRoleAssignment, bool) {
+	for _, assignment := range assignments {
+		if assignment.BranchId == id && !assignment.OrganisationWide {
+			return assignment, true
+		}
+	}
+	for _, group := range groups.Ancestors(id) {
+		for _, assignment := range assignments {
+			if assignment.BranchId == group && !assignment.OrganisationWide {
+				return assignment, true
+			}
+		}
+	}
+	for _, assignment := range assignments {
+		if assignment.OrganisationWide {
+			return assignment, true
+		}
+	}
+	return UserRoleAssignment{}, false
+}
+
+func contains(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// without returns the ids which are not excluded keeping their order.
+func without(ids, excluded []uuid.UUID) []uuid.UUID {
+	result := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !contains(excluded, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// withoutDenied returns the ids which are denied neither directly nor through an enclosing branch group.
+func withoutDenied(ids []uuid.UUID, denying []UserRoleAssignment, groups sphinx.BranchGroupsOfBranch) []uuid.UUID {
+	result := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if _, denied := closestAssignment(denying, id, groups); !denied {
+			result = append(result, id)
+		}
+	}
+	return result
+}
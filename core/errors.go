@@ -0,0 +1,71 @@
+package core
+
+import (
+	"errors"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/dbuduev/authz-service-go/sphinx"
+)
+
+// The error kinds returned by AuthorisationCore. Use errors.Is to test for them.
+var (
+	NotFoundError     = errors.New("not found")
+	ConflictError     = errors.New("conflict")
+	ThrottledError    = errors.New("throttled")
+	UnavailableError  = errors.New("unavailable")
+	InvalidInputError = errors.New("invalid input")
+	// ReferenceNotFoundError is returned when an assignment refers to a role, an operation,
+	// a branch or a branch group which does not exist, as opposed to NotFoundError
+	// which means the item being read or removed does not exist.
+	ReferenceNotFoundError = errors.New("reference not found")
+)
+
+// Error attributes an underlying error to one of the error kinds.
+// Both the kind and the underlying error can be matched with errors.Is.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// Classify attributes err to an error kind.
+// Errors which are already classified are returned as is, missing items are reported as NotFoundError,
+// missing nodes referred to by an assignment are reported as ReferenceNotFoundError,
+// duplicates, hierarchy cycles and concurrent changes are conflicts, throttling is reported as ThrottledError,
+// any other error is considered UnavailableError.
+// Classify returns nil if err is nil.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, dygraph.ReferenceNotFoundError):
+		return &Error{Kind: ReferenceNotFoundError, Err: err}
+	case errors.Is(err, dygraph.NotFoundError):
+		return &Error{Kind: NotFoundError, Err: err}
+	case errors.Is(err, dygraph.DuplicateError), errors.Is(err, sphinx.CycleError), errors.Is(err, dygraph.ConditionFailedError):
+		return &Error{Kind: ConflictError, Err: err}
+	case errors.Is(err, dygraph.TooManyRequestsError):
+		return &Error{Kind: ThrottledError, Err: err}
+	default:
+		return &Error{Kind: UnavailableError, Err: err}
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/dbuduev/authz-service-go/sphinx"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		kinds []error
+	}{
+		{
+			name:  "Duplicate",
+			err:   fmt.Errorf("insert record: %w", dygraph.DuplicateError),
+			kinds: []error{ConflictError, dygraph.DuplicateError},
+		},
+		{
+			name:  "Cycle",
+			err:   fmt.Errorf("assign branch group: %w", sphinx.CycleError),
+			kinds: []error{ConflictError, sphinx.CycleError},
+		},
+		{
+			name:  "Concurrent change",
+			err:   fmt.Errorf("transactional insert: %w", dygraph.ConditionFailedError),
+			kinds: []error{ConflictError, dygraph.ConditionFailedError},
+		},
+		{
+			name:  "Too many requests",
+			err:   fmt.Errorf("get nodes: %w", dygraph.TooManyRequestsError),
+			kinds: []error{ThrottledError, dygraph.TooManyRequestsError},
+		},
+		{
+			name:  "Missing",
+			err:   fmt.Errorf("transactional delete: %w", dygraph.NotFoundError),
+			kinds: []error{NotFoundError, dygraph.NotFoundError},
+		},
+		{
+			name:  "Missing reference",
+			err:   fmt.Errorf("ROLE 1: %w", dygraph.ReferenceNotFoundError),
+			kinds: []error{ReferenceNotFoundError, dygraph.ReferenceNotFoundError},
+		},
+		{
+			name:  "Unknown",
+			err:   errors.New("connection refused"),
+			kinds: []error{UnavailableError},
+		},
+		{
+			name:  "Already classified",
+			err:   &Error{Kind: NotFoundError},
+			kinds: []error{NotFoundError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(tt.err)
+			for _, kind := range tt.kinds {
+				if !errors.Is(got, kind) {
+					t.Errorf("Classify() = %v, want it to be %v", got, kind)
+				}
+			}
+		})
+	}
+	if Classify(nil) != nil {
+		t.Errorf("Classify(nil) should be nil")
+	}
+}
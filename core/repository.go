@@ -1,23 +1,49 @@
 package core
 
 import (
+	"context"
 	"github.com/dbuduev/authz-service-go/sphinx"
 	"github.com/google/uuid"
 )
 
 type Repository interface {
-	AddOperation(op Operation) error
-	AddRole(role Role) error
-	AddBranch(b Branch) error
-	AddBranchGroup(g BranchGroup) error
-	AssignOperationToRole(x OperationAssignment) error
-	AssignBranchToBranchGroup(x BranchAssignment) error
-	GetBranchesByBranchGroup(organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error)
-	GetRolesByOperation(organisationId, opId uuid.UUID) ([]uuid.UUID, error)
-	GetOperationsByRole(organisationId, roleId uuid.UUID) ([]uuid.UUID, error)
-	GetAllRoles(organisationId uuid.UUID) ([]Role, error)
-	GetAllOperations(organisationId uuid.UUID) ([]Operation, error)
-	AssignRoleToUser(x UserRoleAssignment) error
-	GetUserRolesAssignments(organisationId, userId uuid.UUID) ([]UserRoleAssignment, error)
-	GetHierarchy(organisationId uuid.UUID) (sphinx.BranchGroupContent, error)
+	AddOperation(ctx context.Context, op Operation) error
+	RegisterOperations(ctx context.Context, ops []Operation) error
+	AddRole(ctx context.Context, role Role) error
+	AddBranch(ctx context.Context, b Branch) error
+	AddBranchGroup(ctx context.Context, g BranchGroup) error
+	AssignOperationToRole(ctx context.Context, x OperationAssignment) error
+	AssignBranchToBranchGroup(ctx context.Context, x BranchAssignment) error
+	GetBranchesByBranchGroup(ctx context.Context, organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error)
+	GetRolesByOperation(ctx context.Context, organisationId, opId uuid.UUID) ([]uuid.UUID, error)
+	GetOperationsByRole(ctx context.Context, organisationId, roleId uuid.UUID) ([]uuid.UUID, error)
+	AssignRoleToRole(ctx context.Context, x RoleInclusion) error
+	UnassignRoleFromRole(ctx context.Context, x RoleInclusion) error
+	GetRoleHierarchy(ctx context.Context, organisationId uuid.UUID) (sphinx.RoleContent, error)
+	GetEffectiveRolesByOperation(ctx context.Context, organisationId, opId uuid.UUID) ([]uuid.UUID, error)
+	GetEffectiveOperationsByRole(ctx context.Context, organisationId, roleId uuid.UUID) ([]uuid.UUID, error)
+	GetAllRoles(ctx context.Context, organisationId uuid.UUID) ([]Role, error)
+	GetRole(ctx context.Context, organisationId, roleId uuid.UUID) (Role, error)
+	GetRoleByName(ctx context.Context, organisationId uuid.UUID, name string) (Role, error)
+	GetOperation(ctx context.Context, opId uuid.UUID) (Operation, error)
+	GetOperationByName(ctx context.Context, name string) (Operation, error)
+	GetAllOperations(ctx context.Context) ([]Operation, error)
+	GetAllBranches(ctx context.Context, organisationId uuid.UUID) ([]Branch, error)
+	GetAllBranchGroups(ctx context.Context, organisationId uuid.UUID) ([]BranchGroup, error)
+	AssignRoleToUser(ctx context.Context, x UserRoleAssignment) error
+	GetUserRolesAssignments(ctx context.Context, organisationId, userId uuid.UUID) ([]UserRoleAssignment, error)
+	GetHierarchy(ctx context.Context, organisationId uuid.UUID) (sphinx.BranchGroupContent, error)
+	UnassignOperationFromRole(ctx context.Context, x OperationAssignment) error
+	RemoveBranchFromBranchGroup(ctx context.Context, x BranchAssignment) error
+	RevokeRoleFromUser(ctx context.Context, x UserRoleAssignment) error
+	RevokeUserRoles(ctx context.Context, organisationId, userId uuid.UUID) error
+	DeleteOperation(ctx context.Context, opId uuid.UUID) error
+	MigrateOperations(ctx context.Context, organisationId uuid.UUID) error
+	IndexNames(ctx context.Context, organisationId uuid.UUID) error
+	MigrateRoleInclusions(ctx context.Context, organisationId uuid.UUID) error
+	IndexOperationOrganisations(ctx context.Context, organisationId uuid.UUID) error
+	DeleteRole(ctx context.Context, organisationId, roleId uuid.UUID) error
+	DeleteBranch(ctx context.Context, organisationId, branchId uuid.UUID) error
+	DeleteBranchGroup(ctx context.Context, organisationId, branchGroupId uuid.UUID) error
+	Import(ctx context.Context, organisationId uuid.UUID, doc ImportDocument, progress func(ImportProgress)) (ImportReport, error)
 }
@@ -1,13 +1,15 @@
 package core
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+)
 
-//TODO: Remove OrganisationId from Operation,
-//since operation is a property of the system.
+// Operation is a property of the system rather than of an organisation.
+// Operations are defined once in the operation catalogue and the roles of every organisation reference them.
 type Operation struct {
-	OrganisationId uuid.UUID
-	Id             uuid.UUID
-	Name           string
+	Id   uuid.UUID
+	Name string
 }
 
 type Role struct {
@@ -36,6 +38,14 @@ type OperationAssignment struct {
 	OperationId    uuid.UUID
 }
 
+// RoleInclusion makes the role include the role designated by IncludedRoleId,
+// so the role grants all the operations of the included one.
+type RoleInclusion struct {
+	OrganisationId uuid.UUID
+	RoleId         uuid.UUID
+	IncludedRoleId uuid.UUID
+}
+
 type BranchAssignment struct {
 	OrganisationId uuid.UUID
 	BranchId       uuid.UUID
@@ -47,4 +57,177 @@ type UserRoleAssignment struct {
 	RoleId         uuid.UUID
 	UserId         uuid.UUID
 	BranchId       uuid.UUID
+	// OrganisationWide makes the assignment cover every current and future branch and branch group
+	// of the organisation. BranchId of such an assignment is uuid.Nil.
+	OrganisationWide bool
+	// Deny turns the assignment into a denial of all the operations of the role
+	// in the branch or branch group, see AuthorisationCore.Check for the precedence rule.
+	Deny bool
+	// ValidFrom and ValidUntil bound the time window the assignment is in effect, a zero time means no bound.
+	// Expired assignments are eventually deleted from the storage.
+	ValidFrom  time.Time
+	ValidUntil time.Time
+}
+
+// ValidAt reports whether the assignment is in effect at t.
+// The window includes ValidFrom and excludes ValidUntil.
+func (a UserRoleAssignment) ValidAt(t time.Time) bool {
+	if !a.ValidFrom.IsZero() && t.Before(a.ValidFrom) {
+		return false
+	}
+	return a.ValidUntil.IsZero() || t.Before(a.ValidUntil)
+}
+
+// AuthorisedBranches holds the result of WhereAuthorisedBranches.
+type AuthorisedBranches struct {
+	// Branches contains every branch where the operation is authorised, de-duplicated and sorted.
+	Branches []uuid.UUID
+	// BranchGroups contains the sorted branch groups the operation is granted in.
+	// It is populated only on request.
+	BranchGroups []uuid.UUID
+	// OrganisationWide is true if the operation is granted in the whole organisation,
+	// so it is authorised in the branches created later as well.
+	OrganisationWide bool
+}
+
+// DecisionReason explains the outcome of a Check.
+type DecisionReason string
+
+const (
+	// ReasonNoRoleHasOperation means the operation is not assigned to any role.
+	ReasonNoRoleHasOperation DecisionReason = "no role has the operation"
+	// ReasonNotGranted means none of the user's roles having the operation is assigned in the branch
+	// or in a branch group containing the branch.
+	ReasonNotGranted DecisionReason = "operation not granted in the branch"
+	// ReasonGrantedInBranch means a role having the operation is assigned to the user in the branch.
+	ReasonGrantedInBranch DecisionReason = "granted in the branch"
+	// ReasonGrantedInBranchGroup means a role having the operation is assigned to the user
+	// in a branch group containing the branch.
+	ReasonGrantedInBranchGroup DecisionReason = "granted in a branch group containing the branch"
+	// ReasonDeniedInBranch means a role having the operation is denied to the user in the branch.
+	ReasonDeniedInBranch DecisionReason = "denied in the branch"
+	// ReasonDeniedInBranchGroup means a role having the operation is denied to the user
+	// in a branch group containing the branch.
+	ReasonDeniedInBranchGroup DecisionReason = "denied in a branch group containing the branch"
+	// ReasonGrantedInOrganisation means a role having the operation is assigned to the user organisation-wide.
+	ReasonGrantedInOrganisation DecisionReason = "granted in the organisation"
+	// ReasonDeniedInOrganisation means a role having the operation is denied to the user organisation-wide.
+	ReasonDeniedInOrganisation DecisionReason = "denied in the organisation"
+)
+
+// Decision is the result of a Check.
+type Decision struct {
+	Allowed bool
+	Reason  DecisionReason
+	// RoleId is the role granting or denying the operation. It is uuid.Nil if no assignment decided the outcome.
+	RoleId uuid.UUID
+	// GrantedIn is the branch or branch group the role is assigned in,
+	// or the organisation if the role is assigned organisation-wide. It is uuid.Nil unless Allowed is true.
+	GrantedIn uuid.UUID
+	// DeniedIn is the branch or branch group the role is denied in,
+	// or the organisation if the role is denied organisation-wide. It is uuid.Nil unless the operation is denied.
+	DeniedIn uuid.UUID
+}
+
+// Coverage tells how an assignment relates to the branch of a check.
+type Coverage string
+
+const (
+	// CoverageNone means the assignment is made in a branch or branch group not containing the branch.
+	CoverageNone Coverage = "none"
+	// CoverageBranch means the assignment is made in the branch.
+	CoverageBranch Coverage = "branch"
+	// CoverageBranchGroup means the assignment is made in a branch group containing the branch.
+	CoverageBranchGroup Coverage = "branch group"
+	// CoverageOrganisation means the assignment is organisation-wide.
+	CoverageOrganisation Coverage = "organisation"
+)
+
+// ConsideredAssignment is an assignment of the user as seen by Explain.
+type ConsideredAssignment struct {
+	Assignment UserRoleAssignment
+	// InEffect is false if the check is made outside the validity window of the assignment.
+	InEffect bool
+	// HasOperation is true if the role is one of the roles having the operation.
+	HasOperation bool
+	// Covers tells whether the assignment is made in the branch, in a branch group containing it
+	// or organisation-wide.
+	Covers Coverage
+	// Decisive is true for the assignment the decision is based on.
+	Decisive bool
+}
+
+// Explanation is the reasoning behind a decision, the result of Explain.
+type Explanation struct {
+	Decision Decision
+	// Roles are the roles the operation is assigned to.
+	Roles []uuid.UUID
+	// IncludingRoles are the roles having the operation by including one of Roles directly or transitively.
+	IncludingRoles []uuid.UUID
+	// BranchGroups are all the branch groups containing the branch, closer ones first.
+	BranchGroups []uuid.UUID
+	// Assignments are all the assignments of the user, including the ones not in effect.
+	Assignments []ConsideredAssignment
+}
+
+// CheckRequest is a single check answered by CheckMany.
+type CheckRequest struct {
+	UserId      uuid.UUID
+	OperationId uuid.UUID
+	BranchId    uuid.UUID
+}
+
+// The kinds of the items of an ImportDocument, as named in the import document.
+const (
+	ImportOperations           = "operations"
+	ImportRoles                = "roles"
+	ImportBranches             = "branches"
+	ImportBranchGroups         = "branch_groups"
+	ImportOperationAssignments = "operation_assignments"
+	ImportRoleInclusions       = "role_inclusions"
+	ImportMemberships          = "memberships"
+	ImportUserAssignments      = "user_assignments"
+)
+
+// ImportDocument is the authorisation model of an organisation imported at once, see Repository.Import.
+// The organisation ids of the items are ignored, the items are imported into the organisation given to Import.
+type ImportDocument struct {
+	// Operations are added to the operation catalogue unless they are there already.
+	Operations           []Operation
+	Roles                []Role
+	Branches             []Branch
+	BranchGroups         []BranchGroup
+	OperationAssignments []OperationAssignment
+	RoleInclusions       []RoleInclusion
+	// Memberships make branches and branch groups members of branch groups.
+	Memberships     []BranchAssignment
+	UserAssignments []UserRoleAssignment
+}
+
+// ImportItem designates an item of an ImportDocument by its kind, e.g. ImportRoles, and its index in the list.
+type ImportItem struct {
+	Kind  string
+	Index int
+}
+
+// ImportProblem is an item of an ImportDocument which is invalid or could not be written.
+type ImportProblem struct {
+	Item ImportItem
+	Err  error
+}
+
+// ImportProgress tells how many items of an ImportDocument are written so far.
+type ImportProgress struct {
+	Done  int
+	Total int
+}
+
+// ImportReport is the outcome of an import.
+type ImportReport struct {
+	Total int
+	// Created counts the items written by the import.
+	Created int
+	// Existing counts the items the organisation has already, they are left as they are.
+	Existing int
+	Problems []ImportProblem
 }
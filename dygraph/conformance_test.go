@@ -0,0 +1,544 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/testutils"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"sort"
+	"testing"
+	"time"
+)
+
+// graphDB lists the operations every graph backend implements.
+type graphDB interface {
+	InsertRecord(ctx context.Context, node *Node) error
+	InsertRecords(ctx context.Context, nodes []Node) error
+	GetNode(ctx context.Context, organisationId, id uuid.UUID, nodeType string) (Node, error)
+	GetNodes(ctx context.Context, organisationId uuid.UUID, nodeType string) ([]Node, error)
+	GetEdges(ctx context.Context, organisationId uuid.UUID, edgeType string) ([]Edge, error)
+	GetNodeEdgesOfType(ctx context.Context, organisationId, id uuid.UUID, edgeType string) ([]Edge, error)
+	GetNodesPage(ctx context.Context, organisationId uuid.UUID, nodeType string, limit int32, token string) ([]Node, string, error)
+	GetEdgesPage(ctx context.Context, organisationId uuid.UUID, edgeType string, limit int32, token string) ([]Edge, string, error)
+	GetNodeEdgesOfTypePage(ctx context.Context, organisationId, id uuid.UUID, edgeType string, limit int32, token string) ([]Edge, string, error)
+	TransactionalInsert(ctx context.Context, items []Edge) error
+	TransactionalInsertReferencing(ctx context.Context, items []Edge, references []Node) error
+	TransactionalInsertVersioned(ctx context.Context, items []Edge, references []Node, version Node, previous string) error
+	TransactionalDelete(ctx context.Context, items []Edge) error
+	DeleteNode(ctx context.Context, organisationId, id uuid.UUID, nodeType string) error
+	DeleteRecord(ctx context.Context, node *Node) error
+	GetItems(ctx context.Context, organisationId uuid.UUID) ([]Item, error)
+	ScanItems(ctx context.Context) ([]Item, error)
+	DeleteItems(ctx context.Context, items []Item) error
+	ReplaceItems(ctx context.Context, items []Item, edges []Edge, conditions []Condition) error
+}
+
+func TestMemoryGraph_Conformance(t *testing.T) {
+	testConformance(t, CreateMemoryGraph())
+}
+
+func TestDygraph_Conformance(t *testing.T) {
+	testutils.RequireDynamoDB(t)
+	testConformance(t, CreateTestGraphClient())
+}
+
+// testConformance is the suite every graph backend must pass.
+func testConformance(t *testing.T, g graphDB) {
+	sortEdges := cmp.Transformer("Sort", func(in []Edge) []Edge {
+		out := append([]Edge(nil), in...) // Copy input to avoid mutating it
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].Data < out[j].Data
+		})
+		return out
+	})
+	sortNodes := cmp.Transformer("Sort", func(in []Node) []Node {
+		out := append([]Node(nil), in...) // Copy input to avoid mutating it
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].Data < out[j].Data
+		})
+		return out
+	})
+	mustGetEdges := func(t *testing.T, orgId uuid.UUID, edgeType string) []Edge {
+		got, err := g.GetEdges(context.Background(), orgId, edgeType)
+		if err != nil {
+			t.Fatalf("Failed to get edges. The error %v.", err)
+		}
+		return got
+	}
+
+	t.Run("Nodes are matched by type prefix within an organisation", func(t *testing.T) {
+		orgId := uuid.New()
+		branch := Node{OrganisationId: orgId, Id: GenId(orgId, 1), Type: "BRANCH", Data: "a"}
+		group := Node{OrganisationId: orgId, Id: GenId(orgId, 2), Type: "BRANCH_GROUP", Data: "b"}
+		other := Node{OrganisationId: uuid.New(), Id: GenId(orgId, 3), Type: "BRANCH", Data: "c"}
+		for _, node := range []Node{branch, group, other} {
+			if err := g.InsertRecord(context.Background(), &node); err != nil {
+				t.Fatalf("Failed to insert node %v with error %v", node, err)
+			}
+		}
+
+		got, err := g.GetNodes(context.Background(), orgId, "BRANCH")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Node{branch, group}, got, sortNodes); diff != "" {
+			t.Errorf("GetNodes() diff %v", diff)
+		}
+		got, err = g.GetNodes(context.Background(), orgId, "BRANCH_GROUP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Node{group}, got); diff != "" {
+			t.Errorf("GetNodes() diff %v", diff)
+		}
+	})
+
+	t.Run("Get node by id and type", func(t *testing.T) {
+		node := Node{OrganisationId: uuid.New(), Id: uuid.New(), Type: "OP", Data: "view-member"}
+		if err := g.InsertRecord(context.Background(), &node); err != nil {
+			t.Fatal(err)
+		}
+		got, err := g.GetNode(context.Background(), node.OrganisationId, node.Id, node.Type)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(node, got); diff != "" {
+			t.Errorf("GetNode() diff %v", diff)
+		}
+		if _, err := g.GetNode(context.Background(), node.OrganisationId, node.Id, "ROLE"); !errors.Is(err, NotFoundError) {
+			t.Errorf("expected a not found error, got %v", err)
+		}
+	})
+
+	t.Run("Duplicate node", func(t *testing.T) {
+		node := Node{OrganisationId: uuid.New(), Id: uuid.New(), Type: "ROLE", Data: "Admin"}
+		if err := g.InsertRecord(context.Background(), &node); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.InsertRecord(context.Background(), &node); !errors.Is(err, DuplicateError) {
+			t.Errorf("expected a duplicate error, got %v", err)
+		}
+	})
+
+	t.Run("Inserting records is all or nothing", func(t *testing.T) {
+		orgId := uuid.New()
+		role := Node{OrganisationId: orgId, Id: GenId(orgId, 1), Type: "ROLE", Data: "Admin"}
+		name := Node{OrganisationId: orgId, Id: GenId(orgId, 2), Type: "NAME_ROLE", Data: role.Id.String()}
+		if err := g.InsertRecords(context.Background(), []Node{role, name}); err != nil {
+			t.Fatal(err)
+		}
+		other := Node{OrganisationId: orgId, Id: GenId(orgId, 3), Type: "ROLE", Data: "Admin"}
+		if err := g.InsertRecords(context.Background(), []Node{other, name}); !errors.Is(err, DuplicateError) {
+			t.Errorf("expected a duplicate error, got %v", err)
+		}
+
+		got, err := g.GetNodes(context.Background(), orgId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Node{role, name}, got, sortNodes); diff != "" {
+			t.Errorf("InsertRecords() diff %v", diff)
+		}
+	})
+
+	t.Run("Edges keep tags and are matched by type prefix", func(t *testing.T) {
+		orgId := uuid.New()
+		id := GenId(orgId, 1)
+		edges := []Edge{
+			{OrganisationId: orgId, Id: id, TargetNodeId: GenId(orgId, 2), TargetNodeType: "ROLE", Tags: []string{"tag1", "tag2"}, Data: "data1"},
+			{OrganisationId: orgId, Id: id, TargetNodeId: GenId(orgId, 3), TargetNodeType: "OP", Data: "data2"},
+			{OrganisationId: orgId, Id: GenId(orgId, 4), TargetNodeId: GenId(orgId, 5), TargetNodeType: "ROLE", Data: "data3"},
+		}
+		if err := g.TransactionalInsert(context.Background(), edges); err != nil {
+			t.Fatalf("Failed to insert edges %v with the error %v.", edges, err)
+		}
+
+		if diff := cmp.Diff([]Edge{edges[0], edges[2]}, mustGetEdges(t, orgId, "ROLE"), sortEdges); diff != "" {
+			t.Errorf("GetEdges() diff %v", diff)
+		}
+		got, err := g.GetNodeEdgesOfType(context.Background(), orgId, id, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(edges[:2], got, sortEdges); diff != "" {
+			t.Errorf("GetNodeEdgesOfType() diff %v", diff)
+		}
+	})
+
+	t.Run("Pages are bound to their query", func(t *testing.T) {
+		orgId := uuid.New()
+		id := GenId(orgId, 100)
+		nodes := make([]Node, 5)
+		edges := make([]Edge, 5)
+		for i := range nodes {
+			nodes[i] = Node{OrganisationId: orgId, Id: GenId(orgId, byte(i)), Type: "ROLE", Data: fmt.Sprintf("role%d", i)}
+			edges[i] = Edge{OrganisationId: orgId, Id: id, TargetNodeId: GenId(orgId, byte(i)), TargetNodeType: "ROLE", Data: fmt.Sprintf("edge%d", i)}
+		}
+		if err := g.InsertRecords(context.Background(), nodes); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.TransactionalInsert(context.Background(), edges); err != nil {
+			t.Fatal(err)
+		}
+
+		var gotNodes []Node
+		var gotEdges, gotNodeEdges []Edge
+		var nodesToken, edgesToken, nodeEdgesToken string
+		for token := ""; ; {
+			page, next, err := g.GetNodesPage(context.Background(), orgId, "ROLE", 2, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotNodes = append(gotNodes, page...)
+			if next == "" {
+				break
+			}
+			token, nodesToken = next, next
+		}
+		for token := ""; ; {
+			page, next, err := g.GetEdgesPage(context.Background(), orgId, "ROLE", 2, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotEdges = append(gotEdges, page...)
+			if next == "" {
+				break
+			}
+			token, edgesToken = next, next
+		}
+		for token := ""; ; {
+			page, next, err := g.GetNodeEdgesOfTypePage(context.Background(), orgId, id, "ROLE", 2, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotNodeEdges = append(gotNodeEdges, page...)
+			if next == "" {
+				break
+			}
+			token, nodeEdgesToken = next, next
+		}
+		if diff := cmp.Diff(nodes, gotNodes, sortNodes); diff != "" {
+			t.Errorf("GetNodesPage() diff %v", diff)
+		}
+		if diff := cmp.Diff(edges, gotEdges, sortEdges); diff != "" {
+			t.Errorf("GetEdgesPage() diff %v", diff)
+		}
+		if diff := cmp.Diff(edges, gotNodeEdges, sortEdges); diff != "" {
+			t.Errorf("GetNodeEdgesOfTypePage() diff %v", diff)
+		}
+
+		if _, _, err := g.GetNodesPage(context.Background(), uuid.New(), "ROLE", 2, nodesToken); !errors.Is(err, InvalidPageTokenError) {
+			t.Errorf("GetNodesPage() of another organisation: expected invalid page token error, got %v", err)
+		}
+		if _, _, err := g.GetNodesPage(context.Background(), orgId, "OP", 2, nodesToken); !errors.Is(err, InvalidPageTokenError) {
+			t.Errorf("GetNodesPage() of another type: expected invalid page token error, got %v", err)
+		}
+		if _, _, err := g.GetEdgesPage(context.Background(), orgId, "ROLE", 2, nodesToken); !errors.Is(err, InvalidPageTokenError) {
+			t.Errorf("GetEdgesPage() with a token of nodes: expected invalid page token error, got %v", err)
+		}
+		if _, _, err := g.GetNodeEdgesOfTypePage(context.Background(), orgId, id, "ROLE", 2, edgesToken); !errors.Is(err, InvalidPageTokenError) {
+			t.Errorf("GetNodeEdgesOfTypePage() with a token of edges: expected invalid page token error, got %v", err)
+		}
+		if _, _, err := g.GetNodeEdgesOfTypePage(context.Background(), orgId, GenId(orgId, 101), "ROLE", 2, nodeEdgesToken); !errors.Is(err, InvalidPageTokenError) {
+			t.Errorf("GetNodeEdgesOfTypePage() of another node: expected invalid page token error, got %v", err)
+		}
+	})
+
+	t.Run("Edges keep their validity window", func(t *testing.T) {
+		orgId := uuid.New()
+		now := time.Now().UTC().Truncate(time.Second)
+		edges := []Edge{
+			{OrganisationId: orgId, Id: GenId(orgId, 1), TargetNodeId: GenId(orgId, 2), TargetNodeType: "USER", Data: "data1", ValidFrom: now, ValidUntil: now.Add(time.Hour)},
+			{OrganisationId: orgId, Id: GenId(orgId, 3), TargetNodeId: GenId(orgId, 4), TargetNodeType: "USER", Data: "data2", ValidUntil: now.Add(-time.Hour)},
+		}
+		if err := g.TransactionalInsert(context.Background(), edges); err != nil {
+			t.Fatalf("Failed to insert edges %v with the error %v.", edges, err)
+		}
+
+		if diff := cmp.Diff(edges, mustGetEdges(t, orgId, "USER"), sortEdges); diff != "" {
+			t.Errorf("GetEdges() diff %v", diff)
+		}
+	})
+
+	t.Run("Transactional insert is all or nothing", func(t *testing.T) {
+		orgId := uuid.New()
+		existing := Edge{OrganisationId: orgId, Id: GenId(orgId, 1), TargetNodeId: GenId(orgId, 2), TargetNodeType: "ROLE", Data: "existing"}
+		fresh := Edge{OrganisationId: orgId, Id: GenId(orgId, 3), TargetNodeId: GenId(orgId, 4), TargetNodeType: "ROLE", Data: "fresh"}
+		if err := g.TransactionalInsert(context.Background(), []Edge{existing}); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.TransactionalInsert(context.Background(), []Edge{fresh, existing}); !errors.Is(err, DuplicateError) {
+			t.Errorf("expected a duplicate error, got %v", err)
+		}
+		if diff := cmp.Diff([]Edge{existing}, mustGetEdges(t, orgId, "ROLE")); diff != "" {
+			t.Errorf("TransactionalInsert() is not atomic, diff %v", diff)
+		}
+	})
+
+	t.Run("Referencing insert requires the referenced nodes", func(t *testing.T) {
+		orgId := uuid.New()
+		role := Node{OrganisationId: orgId, Id: GenId(orgId, 1), Type: "ROLE", Data: "Admin"}
+		if err := g.InsertRecord(context.Background(), &role); err != nil {
+			t.Fatal(err)
+		}
+		op := Node{OrganisationId: orgId, Id: GenId(orgId, 2), Type: "OP"}
+		edges := []Edge{
+			{OrganisationId: orgId, Id: role.Id, TargetNodeId: op.Id, TargetNodeType: "OP", Data: "op"},
+			{OrganisationId: orgId, Id: op.Id, TargetNodeId: role.Id, TargetNodeType: "ROLE", Data: "role"},
+		}
+		if err := g.TransactionalInsertReferencing(context.Background(), edges, []Node{role, op}); !errors.Is(err, ReferenceNotFoundError) {
+			t.Errorf("expected a reference not found error, got %v", err)
+		}
+		if got := mustGetEdges(t, orgId, ""); len(got) != 0 {
+			t.Errorf("TransactionalInsertReferencing() is not atomic, got %v", got)
+		}
+
+		op.Data = "manage-staff"
+		if err := g.InsertRecord(context.Background(), &op); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.TransactionalInsertReferencing(context.Background(), edges, []Node{role, {OrganisationId: orgId, Id: op.Id, Type: "OP"}}); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(edges, mustGetEdges(t, orgId, ""), sortEdges); diff != "" {
+			t.Errorf("TransactionalInsertReferencing() diff %v", diff)
+		}
+		if err := g.TransactionalInsertReferencing(context.Background(), edges, []Node{role, op}); !errors.Is(err, DuplicateError) {
+			t.Errorf("expected a duplicate error, got %v", err)
+		}
+	})
+
+	t.Run("Versioned insert requires the version read", func(t *testing.T) {
+		orgId := uuid.New()
+		role := Node{OrganisationId: orgId, Id: GenId(orgId, 1), Type: "ROLE", Data: "Admin"}
+		if err := g.InsertRecord(context.Background(), &role); err != nil {
+			t.Fatal(err)
+		}
+		version := Node{OrganisationId: orgId, Id: GenId(orgId, 9), Type: "VERSION", Data: "1"}
+		first := []Edge{{OrganisationId: orgId, Id: role.Id, TargetNodeId: GenId(orgId, 2), TargetNodeType: "OP", Data: "first"}}
+		second := []Edge{{OrganisationId: orgId, Id: role.Id, TargetNodeId: GenId(orgId, 3), TargetNodeType: "OP", Data: "second"}}
+		if err := g.TransactionalInsertVersioned(context.Background(), first, []Node{role}, version, ""); err != nil {
+			t.Fatal(err)
+		}
+		// The version read by the second insert is stale.
+		if err := g.TransactionalInsertVersioned(context.Background(), second, []Node{role}, version, ""); !errors.Is(err, ConditionFailedError) {
+			t.Errorf("expected a condition failed error, got %v", err)
+		}
+		next := version
+		next.Data = "2"
+		if err := g.TransactionalInsertVersioned(context.Background(), second, []Node{role}, next, "2"); !errors.Is(err, ConditionFailedError) {
+			t.Errorf("expected a condition failed error, got %v", err)
+		}
+		if diff := cmp.Diff(first, mustGetEdges(t, orgId, "OP")); diff != "" {
+			t.Errorf("TransactionalInsertVersioned() is not atomic, diff %v", diff)
+		}
+		if err := g.TransactionalInsertVersioned(context.Background(), second, []Node{role}, next, "1"); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(append(first, second...), mustGetEdges(t, orgId, "OP"), sortEdges); diff != "" {
+			t.Errorf("TransactionalInsertVersioned() diff %v", diff)
+		}
+		if got, err := g.GetNode(context.Background(), orgId, version.Id, "VERSION"); err != nil || got.Data != "2" {
+			t.Errorf("GetNode() = %v, %v, want version 2", got, err)
+		}
+	})
+
+	t.Run("Items are listed and deleted as stored", func(t *testing.T) {
+		orgId := uuid.New()
+		role := Node{OrganisationId: orgId, Id: GenId(orgId, 1), Type: "ROLE", Data: "Admin"}
+		if err := g.InsertRecord(context.Background(), &role); err != nil {
+			t.Fatal(err)
+		}
+		edge := Edge{OrganisationId: orgId, Id: role.Id, TargetNodeId: GenId(orgId, 2), TargetNodeType: "USER", Tags: []string{"ASSIGNED_IN_BRANCH", "b1"}, Data: "b1"}
+		if err := g.TransactionalInsert(context.Background(), []Edge{edge}); err != nil {
+			t.Fatal(err)
+		}
+
+		items, err := g.GetItems(context.Background(), orgId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 2 {
+			t.Fatalf("GetItems() = %v, want 2 items", items)
+		}
+		for _, item := range items {
+			if item.IsEdge() {
+				got, err := item.Edge()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(edge, got); diff != "" {
+					t.Errorf("Item.Edge() diff %v", diff)
+				}
+				continue
+			}
+			got, err := item.Node()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(role, got); diff != "" {
+				t.Errorf("Item.Node() diff %v", diff)
+			}
+		}
+		scanned, err := g.ScanItems(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := 0
+		for _, item := range scanned {
+			if item.OrganisationId == orgId.String() {
+				found++
+			}
+		}
+		if found != 2 {
+			t.Errorf("ScanItems() returned %d items of the organisation, want 2", found)
+		}
+
+		if err := g.DeleteItems(context.Background(), append(items, items[0])); err != nil {
+			t.Fatal(err)
+		}
+		if items, err = g.GetItems(context.Background(), orgId); err != nil || len(items) != 0 {
+			t.Errorf("GetItems() after DeleteItems() = %v, %v", items, err)
+		}
+	})
+
+	t.Run("Items are replaced only if the conditions hold", func(t *testing.T) {
+		orgId := uuid.New()
+		role := Node{OrganisationId: orgId, Id: GenId(orgId, 1), Type: "ROLE", Data: "Admin"}
+		op := Node{OrganisationId: orgId, Id: GenId(orgId, 2), Type: "OP", Data: "manage-staff"}
+		if err := g.InsertRecord(context.Background(), &role); err != nil {
+			t.Fatal(err)
+		}
+		stale := Edge{OrganisationId: orgId, Id: role.Id, TargetNodeId: GenId(orgId, 3), TargetNodeType: "OP", Data: "stale"}
+		if err := g.TransactionalInsert(context.Background(), []Edge{stale}); err != nil {
+			t.Fatal(err)
+		}
+		edge := Edge{OrganisationId: orgId, Id: role.Id, TargetNodeId: op.Id, TargetNodeType: "OP", Data: "op"}
+		conditions := []Condition{{Item: role.Item()}, {Item: op.Item(), Absent: true}}
+
+		if err := g.InsertRecord(context.Background(), &op); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.ReplaceItems(context.Background(), []Item{stale.Item()}, []Edge{edge}, conditions); !errors.Is(err, ConditionFailedError) {
+			t.Errorf("expected a condition failed error, got %v", err)
+		}
+		if diff := cmp.Diff([]Edge{stale}, mustGetEdges(t, orgId, "OP")); diff != "" {
+			t.Errorf("ReplaceItems() is not atomic, diff %v", diff)
+		}
+		if err := g.DeleteRecord(context.Background(), &op); err != nil {
+			t.Fatal(err)
+		}
+		missing := Edge{OrganisationId: orgId, Id: GenId(orgId, 4), TargetNodeId: role.Id, TargetNodeType: "ROLE"}
+		if err := g.ReplaceItems(context.Background(), []Item{stale.Item(), missing.Item()}, []Edge{edge}, conditions); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Edge{edge}, mustGetEdges(t, orgId, "")); diff != "" {
+			t.Errorf("ReplaceItems() diff %v", diff)
+		}
+		if err := g.ReplaceItems(context.Background(), nil, []Edge{edge}, nil); !errors.Is(err, ConditionFailedError) {
+			t.Errorf("expected a condition failed error for an existing edge, got %v", err)
+		}
+	})
+
+	t.Run("Transactional delete is all or nothing", func(t *testing.T) {
+		orgId := uuid.New()
+		existing := Edge{OrganisationId: orgId, Id: GenId(orgId, 1), TargetNodeId: GenId(orgId, 2), TargetNodeType: "ROLE", Tags: []string{"tag"}}
+		missing := Edge{OrganisationId: orgId, Id: GenId(orgId, 3), TargetNodeId: GenId(orgId, 4), TargetNodeType: "ROLE"}
+		if err := g.TransactionalInsert(context.Background(), []Edge{existing}); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.TransactionalDelete(context.Background(), []Edge{existing, missing}); !errors.Is(err, NotFoundError) {
+			t.Errorf("expected a not found error, got %v", err)
+		}
+		if diff := cmp.Diff([]Edge{existing}, mustGetEdges(t, orgId, "ROLE")); diff != "" {
+			t.Errorf("TransactionalDelete() is not atomic, diff %v", diff)
+		}
+		if err := g.TransactionalDelete(context.Background(), []Edge{existing}); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustGetEdges(t, orgId, ""); len(got) != 0 {
+			t.Errorf("TransactionalDelete() left edges %v", got)
+		}
+	})
+
+	t.Run("Deleting a node removes both halves of its edges", func(t *testing.T) {
+		orgId := uuid.New()
+		role := Node{OrganisationId: orgId, Id: GenId(orgId, 1), Type: "ROLE", Data: "Admin"}
+		kept := Node{OrganisationId: orgId, Id: GenId(orgId, 2), Type: "ROLE", Data: "PT"}
+		opId := GenId(orgId, 3)
+		userId := GenId(orgId, 4)
+		for _, node := range []Node{role, kept} {
+			if err := g.InsertRecord(context.Background(), &node); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tags := []string{"ASSIGNED_IN_BRANCH", "b"}
+		keptEdges := []Edge{
+			{OrganisationId: orgId, Id: opId, TargetNodeId: kept.Id, TargetNodeType: "ROLE", Data: "kept1"},
+			{OrganisationId: orgId, Id: kept.Id, TargetNodeId: opId, TargetNodeType: "OP", Data: "kept2"},
+		}
+		for _, edges := range [][]Edge{
+			{
+				{OrganisationId: orgId, Id: opId, TargetNodeId: role.Id, TargetNodeType: "ROLE"},
+				{OrganisationId: orgId, Id: role.Id, TargetNodeId: opId, TargetNodeType: "OP"},
+			},
+			{
+				{OrganisationId: orgId, Id: userId, TargetNodeId: role.Id, TargetNodeType: "ROLE", Tags: tags},
+				{OrganisationId: orgId, Id: role.Id, TargetNodeId: userId, TargetNodeType: "USER", Tags: tags},
+			},
+			keptEdges,
+		} {
+			if err := g.TransactionalInsert(context.Background(), edges); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := g.DeleteNode(context.Background(), orgId, role.Id, "ROLE"); err != nil {
+			t.Fatal(err)
+		}
+
+		nodes, err := g.GetNodes(context.Background(), orgId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]Node{kept}, nodes); diff != "" {
+			t.Errorf("DeleteNode() nodes diff %v", diff)
+		}
+		if diff := cmp.Diff(keptEdges, mustGetEdges(t, orgId, ""), sortEdges); diff != "" {
+			t.Errorf("DeleteNode() edges diff %v", diff)
+		}
+		if err := g.DeleteNode(context.Background(), orgId, role.Id, "ROLE"); !errors.Is(err, NotFoundError) {
+			t.Errorf("expected a not found error, got %v", err)
+		}
+	})
+	t.Run("Deleting a record keeps its edges", func(t *testing.T) {
+		orgId := uuid.New()
+		op := Node{OrganisationId: orgId, Id: GenId(orgId, 1), Type: "OP", Data: "view-member"}
+		if err := g.InsertRecord(context.Background(), &op); err != nil {
+			t.Fatal(err)
+		}
+		edges := []Edge{
+			{OrganisationId: orgId, Id: op.Id, TargetNodeId: GenId(orgId, 2), TargetNodeType: "ROLE", Data: "1"},
+			{OrganisationId: orgId, Id: GenId(orgId, 2), TargetNodeId: op.Id, TargetNodeType: "OP", Data: "2"},
+		}
+		if err := g.TransactionalInsert(context.Background(), edges); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.DeleteRecord(context.Background(), &op); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := g.GetNode(context.Background(), orgId, op.Id, op.Type); !errors.Is(err, NotFoundError) {
+			t.Errorf("expected a not found error, got %v", err)
+		}
+		if diff := cmp.Diff(edges, mustGetEdges(t, orgId, ""), sortEdges); diff != "" {
+			t.Errorf("DeleteRecord() edges diff %v", diff)
+		}
+		if err := g.DeleteRecord(context.Background(), &op); !errors.Is(err, NotFoundError) {
+			t.Errorf("expected a not found error, got %v", err)
+		}
+	})
+}
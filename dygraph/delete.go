@@ -0,0 +1,179 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"log"
+	"strings"
+)
+
+var NotFoundError = errors.New("not found")
+
+// MaxTransactionItems is the maximum number of items in a single TransactWriteItems call.
+const MaxTransactionItems = 25
+
+// TransactionalDelete deletes the edges atomically.
+// It fails with NotFoundError if any of the edges does not exist, in this case nothing is deleted.
+// Edges are identified by their organisation, id, target node and tags, the data is ignored.
+func (r *Dygraph) TransactionalDelete(ctx context.Context, items []Edge) error {
+	transactWriteItems := make([]types.TransactWriteItem, len(items))
+	for i := 0; i < len(items); i++ {
+		key, err := r.marshal(items[i].createEdgeDto().key())
+		if err != nil {
+			return err
+		}
+		transactWriteItems[i] = types.TransactWriteItem{
+			Delete: &types.Delete{
+				ConditionExpression: aws.String("attribute_exists(id)"),
+				Key:                 key,
+				TableName:           aws.String(r.getTableName()),
+			},
+		}
+	}
+	err := r.retry(ctx, "TransactWriteItems", func() error {
+		_, err := r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: transactWriteItems,
+		})
+		return err
+	})
+
+	if err != nil {
+		var transactionCancelledException *types.TransactionCanceledException
+		if errors.As(err, &transactionCancelledException) {
+			for i, reason := range transactionCancelledException.CancellationReasons {
+				if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
+					log.Printf("missing item %v", items[i])
+					return fmt.Errorf("transactional delete: %w", NotFoundError)
+				}
+			}
+		}
+		return fmt.Errorf("transactional delete: %w", wrapAwsError(err))
+	}
+
+	return nil
+}
+
+// DeleteRecord deletes the node record only, its edges are left intact.
+// It fails with NotFoundError if the node does not exist.
+func (r *Dygraph) DeleteRecord(ctx context.Context, node *Node) error {
+	key, err := r.marshal(node.createNodeDto().key())
+	if err != nil {
+		return err
+	}
+	err = r.retry(ctx, "TransactWriteItems", func() error {
+		_, err := r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []types.TransactWriteItem{
+				{
+					Delete: &types.Delete{
+						ConditionExpression: aws.String("attribute_exists(id)"),
+						Key:                 key,
+						TableName:           aws.String(r.getTableName()),
+					},
+				},
+			},
+		})
+		return err
+	})
+	if err != nil {
+		var transactionCancelledException *types.TransactionCanceledException
+		if errors.As(err, &transactionCancelledException) && !throttled(err) {
+			return fmt.Errorf("delete record %s: %w", node.Id, NotFoundError)
+		}
+		return fmt.Errorf("delete record: %w", wrapAwsError(err))
+	}
+
+	return nil
+}
+
+// DeleteNode deletes the node together with all of its edges and their mirrored halves,
+// i.e. the edges of the same tags pointing back to the node.
+// nodeType is the type the mirrored halves point to, it allows deleting edges of an entity
+// which has no node record, e.g. a user.
+// The deletion is performed in chunks of transactions, the node record is deleted last,
+// so that a failed deletion can be retried.
+// It fails with NotFoundError if there is neither a node nor an edge with the id.
+func (r *Dygraph) DeleteNode(ctx context.Context, organisationId, id uuid.UUID, nodeType string) error {
+	items, err := r.queryAll(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(r.getTableName()),
+		KeyConditionExpression: aws.String("globalId = :globalId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":globalId": &types.AttributeValueMemberS{Value: organisationId.String() + "_" + id.String()},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("delete node: %w", err)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("delete node %s: %w", id, NotFoundError)
+	}
+
+	var keys []*keyDto
+	var nodeKey *keyDto
+	for _, item := range items {
+		d := dto{}
+		if err := r.unmarshal(item, &d); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(d.TypeTarget, edgePrefix) {
+			nodeKey = d.key()
+			continue
+		}
+		edge := d.createEdge()
+		mirror := Edge{
+			OrganisationId: organisationId,
+			Id:             edge.TargetNodeId,
+			TargetNodeId:   id,
+			TargetNodeType: nodeType,
+			Tags:           edge.Tags,
+		}
+		keys = append(keys, d.key(), mirror.createEdgeDto().key())
+	}
+	// The keys come in pairs of an edge and its mirrored half, a transaction takes an even number of them
+	// so that both halves of an edge are always deleted together. The node goes last.
+	pairs := MaxTransactionItems - MaxTransactionItems%2
+	for start := 0; start < len(keys); start += pairs {
+		end := start + pairs
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := r.deleteKeys(ctx, keys[start:end]); err != nil {
+			return fmt.Errorf("delete node: %w", err)
+		}
+	}
+	if nodeKey != nil {
+		if err := r.deleteKeys(ctx, []*keyDto{nodeKey}); err != nil {
+			return fmt.Errorf("delete node: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Dygraph) deleteKeys(ctx context.Context, keys []*keyDto) error {
+	transactWriteItems := make([]types.TransactWriteItem, len(keys))
+	for i, k := range keys {
+		key, err := r.marshal(k)
+		if err != nil {
+			return err
+		}
+		transactWriteItems[i] = types.TransactWriteItem{
+			Delete: &types.Delete{
+				Key:       key,
+				TableName: aws.String(r.getTableName()),
+			},
+		}
+	}
+	err := r.retry(ctx, "TransactWriteItems", func() error {
+		_, err := r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: transactWriteItems,
+		})
+		return err
+	})
+
+	return wrapAwsError(err)
+}
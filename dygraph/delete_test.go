@@ -0,0 +1,122 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dbuduev/authz-service-go/testutils"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestDygraph_DeleteNode(t *testing.T) {
+	orgId := uuid.New()
+	roleId := GenId(orgId, 1)
+	opIds := make([]uuid.UUID, 20)
+	items := []*dto{(&Node{OrganisationId: orgId, Id: roleId, Type: "ROLE", Data: "Admin"}).createNodeDto()}
+	for i := range opIds {
+		opIds[i] = GenId(orgId, byte(10+i))
+		items = append(items, (&Edge{OrganisationId: orgId, Id: roleId, TargetNodeId: opIds[i], TargetNodeType: "OP"}).createEdgeDto())
+	}
+	userEdge := &Edge{OrganisationId: orgId, Id: roleId, TargetNodeId: GenId(orgId, 2), TargetNodeType: "USER", Tags: []string{"ASSIGNED_IN_BRANCH", "b1"}}
+	items = append(items, userEdge.createEdgeDto())
+
+	var transactions [][]string
+	stub := pagedQueryStub(t, items, 100)
+	stub.transactWriteItems = func(_ context.Context, input *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+		var keys []string
+		for _, item := range input.TransactItems {
+			globalId := item.Delete.Key["globalId"].(*types.AttributeValueMemberS).Value
+			typeTarget := item.Delete.Key["typeTarget"].(*types.AttributeValueMemberS).Value
+			keys = append(keys, globalId+" "+typeTarget)
+		}
+		transactions = append(transactions, keys)
+		return &dynamodb.TransactWriteItemsOutput{}, nil
+	}
+
+	err := CreateGraphClient(stub, "test").DeleteNode(context.Background(), orgId, roleId, "ROLE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeKey := items[0].GlobalId + " " + items[0].TypeTarget
+	if len(transactions) != 3 || len(transactions[2]) != 1 || transactions[2][0] != nodeKey {
+		t.Fatalf("DeleteNode() transactions %v, want the node record deleted last on its own", transactions)
+	}
+	transactionOf := make(map[string]int)
+	for i, keys := range transactions {
+		for _, key := range keys {
+			transactionOf[key] = i
+		}
+	}
+
+	var want [][2]string
+	for _, opId := range opIds {
+		e := (&Edge{OrganisationId: orgId, Id: roleId, TargetNodeId: opId, TargetNodeType: "OP"}).createEdgeDto()
+		m := (&Edge{OrganisationId: orgId, Id: opId, TargetNodeId: roleId, TargetNodeType: "ROLE"}).createEdgeDto()
+		want = append(want, [2]string{e.GlobalId + " " + e.TypeTarget, m.GlobalId + " " + m.TypeTarget})
+	}
+	e := userEdge.createEdgeDto()
+	m := (&Edge{OrganisationId: orgId, Id: userEdge.TargetNodeId, TargetNodeId: roleId, TargetNodeType: "ROLE", Tags: userEdge.Tags}).createEdgeDto()
+	want = append(want, [2]string{e.GlobalId + " " + e.TypeTarget, m.GlobalId + " " + m.TypeTarget})
+	// Both halves of every edge are deleted in the same transaction.
+	for _, pair := range want {
+		edge, okEdge := transactionOf[pair[0]]
+		mirror, okMirror := transactionOf[pair[1]]
+		if !okEdge || !okMirror || edge != mirror {
+			t.Errorf("DeleteNode() deleted %s in transaction %d, its mirror %s in %d", pair[0], edge, pair[1], mirror)
+		}
+	}
+	if len(transactionOf) != 2*len(want)+1 {
+		t.Errorf("DeleteNode() deleted %d keys, want %d", len(transactionOf), 2*len(want)+1)
+	}
+}
+
+func TestDygraph_DeleteNodeNotFound(t *testing.T) {
+	graphClient := CreateGraphClient(pagedQueryStub(t, nil, 1), "test")
+	if err := graphClient.DeleteNode(context.Background(), uuid.New(), uuid.New(), "ROLE"); !errors.Is(err, NotFoundError) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestDygraph_TransactionalDeleteNotFound(t *testing.T) {
+	stub := dynamodbAPIStub{
+		transactWriteItems: func(_ context.Context, _ *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+			return nil, &types.TransactionCanceledException{
+				CancellationReasons: []types.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")}},
+			}
+		},
+	}
+	err := CreateGraphClient(&stub, "test").TransactionalDelete(context.Background(), []Edge{{}, {}})
+	if !errors.Is(err, NotFoundError) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestDygraph_TransactionalInsertDelete(t *testing.T) {
+	testutils.RequireDynamoDB(t)
+	graphClient := CreateTestGraphClient()
+	orgId := uuid.New()
+	edges := []Edge{
+		{OrganisationId: orgId, Id: GenId(orgId, 1), TargetNodeId: GenId(orgId, 2), TargetNodeType: "ROLE", Tags: []string{"tag"}},
+		{OrganisationId: orgId, Id: GenId(orgId, 2), TargetNodeId: GenId(orgId, 1), TargetNodeType: "USER", Tags: []string{"tag"}},
+	}
+	if err := graphClient.TransactionalInsert(context.Background(), edges); err != nil {
+		t.Fatalf("Failed to insert edges %v with the error %v.", edges, err)
+	}
+	if err := graphClient.TransactionalDelete(context.Background(), edges); err != nil {
+		t.Fatalf("Failed to delete edges %v with the error %v.", edges, err)
+	}
+	got, err := graphClient.GetEdges(context.Background(), orgId, "")
+	if err != nil {
+		t.Fatalf("Failed to get edges. The error %v.", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Edges %v are not deleted", got)
+	}
+	if err := graphClient.TransactionalDelete(context.Background(), edges); !errors.Is(err, NotFoundError) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
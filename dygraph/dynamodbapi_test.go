@@ -8,6 +8,7 @@ import (
 type dynamodbAPIStub struct {
 	putItem            func(ctx context.Context, input *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 	query              func(ctx context.Context, input *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	scan               func(ctx context.Context, input *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
 	transactWriteItems func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
 }
 
@@ -19,6 +20,10 @@ func (d *dynamodbAPIStub) Query(ctx context.Context, input *dynamodb.QueryInput,
 	return d.query(ctx, input, optFns...)
 }
 
+func (d *dynamodbAPIStub) Scan(ctx context.Context, input *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return d.scan(ctx, input, optFns...)
+}
+
 func (d *dynamodbAPIStub) TransactWriteItems(ctx context.Context, input *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
 	return d.transactWriteItems(ctx, input, optFns...)
 }
@@ -0,0 +1,271 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+var MalformedItemError = errors.New("malformed item")
+
+// ConditionFailedError is returned when the table is no longer in the state a write expects.
+var ConditionFailedError = errors.New("condition failed")
+
+// Condition requires an item to exist, or not to exist if Absent is set, when a transaction is committed.
+type Condition struct {
+	Item   Item
+	Absent bool
+}
+
+// Item is an item of the table as it is stored. Unlike Node and Edge it can hold items
+// written by hand or by earlier versions which do not follow the structure of the table,
+// it is meant for the tools checking and repairing the table.
+type Item struct {
+	GlobalId       string
+	TypeTarget     string
+	OrganisationId string
+	Id             string
+	Type           string
+	Data           string
+	ValidFrom      int64
+	ExpiresAt      int64
+}
+
+// IsEdge reports whether the item is meant to be an edge rather than a node.
+func (i Item) IsEdge() bool {
+	return strings.HasPrefix(i.TypeTarget, edgePrefix)
+}
+
+// Node returns the node the item holds. It fails with MalformedItemError if the item is not a well-formed node.
+func (i Item) Node() (Node, error) {
+	orgId, id, err := i.ids()
+	if err != nil {
+		return Node{}, err
+	}
+	node := Node{OrganisationId: orgId, Id: id, Type: i.Type, Data: i.Data}
+	if *node.createNodeDto().key() != i.key() {
+		return Node{}, fmt.Errorf("node %s %s: %w", i.GlobalId, i.TypeTarget, MalformedItemError)
+	}
+	return node, nil
+}
+
+// Edge returns the edge the item holds. It fails with MalformedItemError if the item is not a well-formed edge.
+func (i Item) Edge() (Edge, error) {
+	orgId, id, err := i.ids()
+	if err != nil {
+		return Edge{}, err
+	}
+	typeTarget := strings.Split(i.TypeTarget, separator)
+	if len(typeTarget) < 2 || typeTarget[0] != edgePrefix+i.Type {
+		return Edge{}, fmt.Errorf("edge %s %s: %w", i.GlobalId, i.TypeTarget, MalformedItemError)
+	}
+	targetId, err := uuid.Parse(typeTarget[1])
+	if err != nil {
+		return Edge{}, fmt.Errorf("edge %s %s: %s: %w", i.GlobalId, i.TypeTarget, err, MalformedItemError)
+	}
+	edge := Edge{
+		OrganisationId: orgId,
+		Id:             id,
+		TargetNodeId:   targetId,
+		TargetNodeType: i.Type,
+		Data:           i.Data,
+		ValidFrom:      fromUnix(i.ValidFrom),
+		ValidUntil:     fromUnix(i.ExpiresAt),
+	}
+	if len(typeTarget) > 2 {
+		edge.Tags = typeTarget[2:]
+	}
+	if *edge.createEdgeDto().key() != i.key() {
+		return Edge{}, fmt.Errorf("edge %s %s: %w", i.GlobalId, i.TypeTarget, MalformedItemError)
+	}
+	return edge, nil
+}
+
+// Expired reports whether the item is an edge past its validity window, such items are being deleted by DynamoDB TTL.
+func (i Item) Expired(now time.Time) bool {
+	return i.ExpiresAt != 0 && i.ExpiresAt <= now.Unix()
+}
+
+func (i Item) ids() (uuid.UUID, uuid.UUID, error) {
+	orgId, err := uuid.Parse(i.OrganisationId)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("item %s %s: %s: %w", i.GlobalId, i.TypeTarget, err, MalformedItemError)
+	}
+	id, err := uuid.Parse(i.Id)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("item %s %s: %s: %w", i.GlobalId, i.TypeTarget, err, MalformedItemError)
+	}
+	return orgId, id, nil
+}
+
+func (i Item) key() keyDto {
+	return keyDto{GlobalId: i.GlobalId, TypeTarget: i.TypeTarget}
+}
+
+// GetItems returns all the items of the organisation.
+// Items without the organisationId attribute are not indexed, only ScanItems returns them.
+func (r *Dygraph) GetItems(ctx context.Context, organisationId uuid.UUID) ([]Item, error) {
+	items, err := r.queryAll(ctx, &dynamodb.QueryInput{
+		IndexName:              aws.String(globalIndexName),
+		TableName:              aws.String(r.getTableName()),
+		KeyConditionExpression: aws.String("organisationId = :organisationId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":organisationId": &types.AttributeValueMemberS{Value: organisationId.String()},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get items: %w", err)
+	}
+
+	return r.toItems(items)
+}
+
+// ScanItems returns all the items of the table.
+func (r *Dygraph) ScanItems(ctx context.Context) ([]Item, error) {
+	input := &dynamodb.ScanInput{TableName: aws.String(r.getTableName())}
+	var result []Item
+	for {
+		var output *dynamodb.ScanOutput
+		err := r.retry(ctx, "Scan", func() (err error) {
+			output, err = r.client.Scan(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan items: %w", wrapAwsError(err))
+		}
+		items, err := r.toItems(output.Items)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, items...)
+		if len(output.LastEvaluatedKey) == 0 {
+			return result, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// DeleteItems deletes the items, the items which do not exist and the repeated ones are ignored.
+// The deletion is performed in chunks of transactions, so it is not atomic.
+func (r *Dygraph) DeleteItems(ctx context.Context, items []Item) error {
+	// A transaction cannot touch the same item twice.
+	var keys []*keyDto
+	seen := make(map[keyDto]struct{}, len(items))
+	for i := range items {
+		key := items[i].key()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, &key)
+	}
+	for start := 0; start < len(keys); start += MaxTransactionItems {
+		end := start + MaxTransactionItems
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := r.deleteKeys(ctx, keys[start:end]); err != nil {
+			return fmt.Errorf("delete items: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ReplaceItems deletes the items and inserts the edges atomically provided the conditions hold
+// and none of the edges exists. It fails with ConditionFailedError otherwise, nothing is written then.
+// The items which do not exist are not deleted, the items designated by the conditions must be neither deleted nor inserted.
+func (r *Dygraph) ReplaceItems(ctx context.Context, items []Item, edges []Edge, conditions []Condition) error {
+	var transactWriteItems []types.TransactWriteItem
+	seen := make(map[keyDto]struct{}, len(items))
+	for i := range items {
+		key := items[i].key()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		av, err := r.marshal(&key)
+		if err != nil {
+			return err
+		}
+		transactWriteItems = append(transactWriteItems, types.TransactWriteItem{
+			Delete: &types.Delete{
+				Key:       av,
+				TableName: aws.String(r.getTableName()),
+			},
+		})
+	}
+	for i := range edges {
+		av, err := r.marshal(edges[i].createEdgeDto())
+		if err != nil {
+			return err
+		}
+		transactWriteItems = append(transactWriteItems, types.TransactWriteItem{
+			Put: &types.Put{
+				ConditionExpression: aws.String("attribute_not_exists(globalId)"),
+				Item:                av,
+				TableName:           aws.String(r.getTableName()),
+			},
+		})
+	}
+	for _, c := range conditions {
+		key := c.Item.key()
+		av, err := r.marshal(&key)
+		if err != nil {
+			return err
+		}
+		// The key attributes exist in every item, malformed ones included.
+		condition := "attribute_exists(globalId)"
+		if c.Absent {
+			condition = "attribute_not_exists(globalId)"
+		}
+		transactWriteItems = append(transactWriteItems, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				ConditionExpression: aws.String(condition),
+				Key:                 av,
+				TableName:           aws.String(r.getTableName()),
+			},
+		})
+	}
+	if len(transactWriteItems) == 0 {
+		return nil
+	}
+	err := r.retry(ctx, "TransactWriteItems", func() error {
+		_, err := r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: transactWriteItems,
+		})
+		return err
+	})
+	if err != nil {
+		var transactionCancelledException *types.TransactionCanceledException
+		if errors.As(err, &transactionCancelledException) {
+			for _, reason := range transactionCancelledException.CancellationReasons {
+				if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
+					return fmt.Errorf("replace items: %w", ConditionFailedError)
+				}
+			}
+		}
+		return fmt.Errorf("replace items: %w", wrapAwsError(err))
+	}
+
+	return nil
+}
+
+func (r *Dygraph) toItems(items []map[string]types.AttributeValue) ([]Item, error) {
+	result := make([]Item, len(items))
+	for i, item := range items {
+		d := dto{}
+		if err := r.unmarshal(item, &d); err != nil {
+			return nil, err
+		}
+		result[i] = Item(d)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,99 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestItem_Malformed(t *testing.T) {
+	orgId := uuid.New()
+	id := uuid.New()
+	edge := Item(*(&Edge{OrganisationId: orgId, Id: id, TargetNodeId: uuid.New(), TargetNodeType: "ROLE", Tags: []string{"INCLUDED_ROLE"}}).createEdgeDto())
+	node := Item(*(&Node{OrganisationId: orgId, Id: id, Type: "ROLE", Data: "Admin"}).createNodeDto())
+
+	tests := []struct {
+		name string
+		item Item
+		f    func(Item) error
+	}{
+		{"Edge without a target", Item{GlobalId: edge.GlobalId, TypeTarget: "edge_ROLE", OrganisationId: orgId.String(), Id: id.String(), Type: "ROLE"}, edgeOf},
+		{"Edge to a malformed id", Item{GlobalId: edge.GlobalId, TypeTarget: "edge_ROLE|r1", OrganisationId: orgId.String(), Id: id.String(), Type: "ROLE"}, edgeOf},
+		{"Edge of another type", Item{GlobalId: edge.GlobalId, TypeTarget: edge.TypeTarget, OrganisationId: orgId.String(), Id: id.String(), Type: "OP"}, edgeOf},
+		{"Edge of a malformed organisation", Item{GlobalId: edge.GlobalId, TypeTarget: edge.TypeTarget, OrganisationId: "0", Id: id.String(), Type: "ROLE"}, edgeOf},
+		{"Edge stored under another node", Item{GlobalId: orgId.String() + "_" + uuid.New().String(), TypeTarget: edge.TypeTarget, OrganisationId: orgId.String(), Id: id.String(), Type: "ROLE"}, edgeOf},
+		{"Node of a malformed id", Item{GlobalId: node.GlobalId, TypeTarget: node.TypeTarget, OrganisationId: orgId.String(), Id: "r1", Type: "ROLE"}, nodeOf},
+		{"Node of another type", Item{GlobalId: node.GlobalId, TypeTarget: node.TypeTarget, OrganisationId: orgId.String(), Id: id.String(), Type: "OP"}, nodeOf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.f(tt.item); !errors.Is(err, MalformedItemError) {
+				t.Errorf("expected a malformed item error, got %v", err)
+			}
+		})
+	}
+	if err := edgeOf(edge); err != nil {
+		t.Errorf("Edge() of a well-formed edge error = %v", err)
+	}
+	if err := nodeOf(node); err != nil {
+		t.Errorf("Node() of a well-formed node error = %v", err)
+	}
+}
+
+func edgeOf(item Item) error {
+	_, err := item.Edge()
+	return err
+}
+
+func nodeOf(item Item) error {
+	_, err := item.Node()
+	return err
+}
+
+func TestDygraph_ScanItems(t *testing.T) {
+	pages := [][]*dto{
+		{{GlobalId: "a", TypeTarget: "node_ROLE|a"}},
+		{{GlobalId: "b", TypeTarget: "edge_ROLE"}},
+	}
+	calls := 0
+	stub := dynamodbAPIStub{
+		scan: func(_ context.Context, input *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+			page := 0
+			if input.ExclusiveStartKey != nil {
+				page = 1
+			}
+			calls++
+			output := &dynamodb.ScanOutput{}
+			for _, item := range pages[page] {
+				av, err := marshal(item)
+				if err != nil {
+					t.Fatal(err)
+				}
+				output.Items = append(output.Items, av)
+			}
+			if page == 0 {
+				output.LastEvaluatedKey = map[string]types.AttributeValue{"globalId": &types.AttributeValueMemberS{Value: "a"}}
+			}
+			return output, nil
+		},
+	}
+	graphClient := CreateGraphClient(&stub, "test")
+
+	items, err := graphClient.ScanItems(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || len(items) != 2 || items[1].TypeTarget != "edge_ROLE" {
+		t.Errorf("ScanItems() = %v after %d calls", items, calls)
+	}
+
+	stub.scan = func(_ context.Context, _ *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+		return nil, &types.ProvisionedThroughputExceededException{}
+	}
+	if _, err := graphClient.ScanItems(context.Background()); !errors.Is(err, TooManyRequestsError) {
+		t.Errorf("expected too many request exception, got %v", err)
+	}
+}
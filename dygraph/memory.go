@@ -0,0 +1,423 @@
+package dygraph
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryGraph type implements the graph operations of Dygraph in memory.
+// It stores the same items as Dygraph does, so prefix matching on types,
+// tags and duplicate detection behave the same way. It is safe for concurrent use.
+type MemoryGraph struct {
+	mu    sync.RWMutex
+	items map[keyDto]dto
+}
+
+func CreateMemoryGraph() *MemoryGraph {
+	return &MemoryGraph{items: make(map[keyDto]dto)}
+}
+
+// InsertRecord inserts a node.
+func (m *MemoryGraph) InsertRecord(_ context.Context, node *Node) error {
+	d := node.createNodeDto()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.items[*d.key()]; ok {
+		return fmt.Errorf("insert record: %w", DuplicateError)
+	}
+	m.items[*d.key()] = *d
+
+	return nil
+}
+
+// InsertRecords inserts all the nodes or none of them.
+// It fails with DuplicateError if any of the nodes exists.
+func (m *MemoryGraph) InsertRecords(_ context.Context, nodes []Node) error {
+	dtos := make([]*dto, len(nodes))
+	seen := make(map[keyDto]struct{}, len(nodes))
+	for i := range nodes {
+		dtos[i] = nodes[i].createNodeDto()
+		key := *dtos[i].key()
+		if _, ok := seen[key]; ok {
+			return fmt.Errorf("transaction contains node %v twice", nodes[i])
+		}
+		seen[key] = struct{}{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range dtos {
+		if _, ok := m.items[*d.key()]; ok {
+			return fmt.Errorf("duplicate node %v: %w", nodes[i], DuplicateError)
+		}
+	}
+	for _, d := range dtos {
+		m.items[*d.key()] = *d
+	}
+
+	return nil
+}
+
+// GetNode returns the node of the type. It fails with NotFoundError if there is no such node.
+func (m *MemoryGraph) GetNode(_ context.Context, organisationId, id uuid.UUID, nodeType string) (Node, error) {
+	node := Node{OrganisationId: organisationId, Id: id, Type: nodeType}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d, ok := m.items[*node.createNodeDto().key()]
+	if !ok {
+		return Node{}, fmt.Errorf("get node %s: %w", id, NotFoundError)
+	}
+	return d.createNode(), nil
+}
+
+func (m *MemoryGraph) GetNodes(_ context.Context, organisationId uuid.UUID, nodeType string) ([]Node, error) {
+	items := m.query(func(d *dto) bool {
+		return d.OrganisationId == organisationId.String() && strings.HasPrefix(d.TypeTarget, nodePrefix+nodeType)
+	})
+
+	result := make([]Node, len(items))
+	for i, d := range items {
+		result[i] = d.createNode()
+	}
+	return result, nil
+}
+
+func (m *MemoryGraph) GetEdges(_ context.Context, organisationId uuid.UUID, edgeType string) ([]Edge, error) {
+	items := m.query(func(d *dto) bool {
+		return d.OrganisationId == organisationId.String() && strings.HasPrefix(d.TypeTarget, edgePrefix+edgeType)
+	})
+
+	return createEdges(items), nil
+}
+
+func (m *MemoryGraph) GetNodeEdgesOfType(_ context.Context, organisationId, id uuid.UUID, edgeType string) ([]Edge, error) {
+	globalId := organisationId.String() + "_" + id.String()
+	items := m.query(func(d *dto) bool {
+		return d.GlobalId == globalId && strings.HasPrefix(d.TypeTarget, edgePrefix+edgeType)
+	})
+
+	return createEdges(items), nil
+}
+
+// GetNodesPage is a cursor-based variant of GetNodes, see Dygraph.GetNodesPage.
+func (m *MemoryGraph) GetNodesPage(_ context.Context, organisationId uuid.UUID, nodeType string, limit int32, token string) ([]Node, string, error) {
+	query := map[string]string{":organisationId": organisationId.String(), ":type": nodePrefix + nodeType}
+	items, next, err := m.queryPage(query, func(d *dto) bool {
+		return d.OrganisationId == organisationId.String() && strings.HasPrefix(d.TypeTarget, nodePrefix+nodeType)
+	}, limit, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("get nodes page: %w", err)
+	}
+
+	result := make([]Node, len(items))
+	for i, d := range items {
+		result[i] = d.createNode()
+	}
+	return result, next, nil
+}
+
+// GetEdgesPage is a cursor-based variant of GetEdges, see Dygraph.GetNodesPage.
+func (m *MemoryGraph) GetEdgesPage(_ context.Context, organisationId uuid.UUID, edgeType string, limit int32, token string) ([]Edge, string, error) {
+	query := map[string]string{":organisationId": organisationId.String(), ":type": edgePrefix + edgeType}
+	items, next, err := m.queryPage(query, func(d *dto) bool {
+		return d.OrganisationId == organisationId.String() && strings.HasPrefix(d.TypeTarget, edgePrefix+edgeType)
+	}, limit, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("get edges page: %w", err)
+	}
+
+	return createEdges(items), next, nil
+}
+
+// GetNodeEdgesOfTypePage is a cursor-based variant of GetNodeEdgesOfType, see Dygraph.GetNodesPage.
+func (m *MemoryGraph) GetNodeEdgesOfTypePage(_ context.Context, organisationId, id uuid.UUID, edgeType string, limit int32, token string) ([]Edge, string, error) {
+	globalId := organisationId.String() + "_" + id.String()
+	query := map[string]string{":globalId": globalId, ":type": edgePrefix + edgeType}
+	items, next, err := m.queryPage(query, func(d *dto) bool {
+		return d.GlobalId == globalId && strings.HasPrefix(d.TypeTarget, edgePrefix+edgeType)
+	}, limit, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("get node edges of type page: %w", err)
+	}
+
+	return createEdges(items), next, nil
+}
+
+// TransactionalInsert inserts all the edges or none of them.
+func (m *MemoryGraph) TransactionalInsert(_ context.Context, items []Edge) error {
+	dtos, err := toDtos(items)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range dtos {
+		if _, ok := m.items[*d.key()]; ok {
+			return fmt.Errorf("duplicate item %v: %w", items[i], DuplicateError)
+		}
+	}
+	for _, d := range dtos {
+		m.items[*d.key()] = *d
+	}
+
+	return nil
+}
+
+// TransactionalInsertReferencing inserts all the edges or none of them provided the referenced nodes exist.
+// See Dygraph.TransactionalInsertReferencing.
+func (m *MemoryGraph) TransactionalInsertReferencing(_ context.Context, items []Edge, references []Node) error {
+	return m.insertReferencing(items, references, nil, "")
+}
+
+// TransactionalInsertVersioned inserts all the edges and the version node or none of them.
+// See Dygraph.TransactionalInsertVersioned.
+func (m *MemoryGraph) TransactionalInsertVersioned(_ context.Context, items []Edge, references []Node, version Node, previous string) error {
+	return m.insertReferencing(items, references, &version, previous)
+}
+
+func (m *MemoryGraph) insertReferencing(items []Edge, references []Node, version *Node, previous string) error {
+	dtos, err := toDtos(items)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range dtos {
+		if _, ok := m.items[*d.key()]; ok {
+			return fmt.Errorf("duplicate item %v: %w", items[i], DuplicateError)
+		}
+	}
+	for _, node := range references {
+		if _, ok := m.items[*node.createNodeDto().key()]; !ok {
+			return fmt.Errorf("%s %s: %w", node.Type, node.Id, ReferenceNotFoundError)
+		}
+	}
+	if version != nil {
+		d := version.createNodeDto()
+		if stored, ok := m.items[*d.key()]; ok != (previous != "") || stored.Data != previous {
+			return fmt.Errorf("version %v changed: %w", *version, ConditionFailedError)
+		}
+		m.items[*d.key()] = *d
+	}
+	for _, d := range dtos {
+		m.items[*d.key()] = *d
+	}
+
+	return nil
+}
+
+// TransactionalDelete deletes all the edges or none of them.
+// It fails with NotFoundError if any of the edges does not exist.
+func (m *MemoryGraph) TransactionalDelete(_ context.Context, items []Edge) error {
+	dtos, err := toDtos(items)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range dtos {
+		if _, ok := m.items[*d.key()]; !ok {
+			return fmt.Errorf("missing item %v: %w", items[i], NotFoundError)
+		}
+	}
+	for _, d := range dtos {
+		delete(m.items, *d.key())
+	}
+
+	return nil
+}
+
+// DeleteRecord deletes the node record only. See Dygraph.DeleteRecord.
+func (m *MemoryGraph) DeleteRecord(_ context.Context, node *Node) error {
+	key := *node.createNodeDto().key()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.items[key]; !ok {
+		return fmt.Errorf("delete record %s: %w", node.Id, NotFoundError)
+	}
+	delete(m.items, key)
+
+	return nil
+}
+
+// DeleteNode deletes the node together with all of its edges and their mirrored halves.
+// See Dygraph.DeleteNode.
+func (m *MemoryGraph) DeleteNode(_ context.Context, organisationId, id uuid.UUID, nodeType string) error {
+	globalId := organisationId.String() + "_" + id.String()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := false
+	for key, d := range m.items {
+		if d.GlobalId != globalId {
+			continue
+		}
+		found = true
+		delete(m.items, key)
+		if !strings.HasPrefix(d.TypeTarget, edgePrefix) {
+			continue
+		}
+		edge := d.createEdge()
+		mirror := Edge{
+			OrganisationId: organisationId,
+			Id:             edge.TargetNodeId,
+			TargetNodeId:   id,
+			TargetNodeType: nodeType,
+			Tags:           edge.Tags,
+		}
+		delete(m.items, *mirror.createEdgeDto().key())
+	}
+	if !found {
+		return fmt.Errorf("delete node %s: %w", id, NotFoundError)
+	}
+
+	return nil
+}
+
+// GetItems returns all the items of the organisation.
+func (m *MemoryGraph) GetItems(_ context.Context, organisationId uuid.UUID) ([]Item, error) {
+	return toItems(m.query(func(d *dto) bool {
+		return d.OrganisationId == organisationId.String()
+	})), nil
+}
+
+// ScanItems returns all the items.
+func (m *MemoryGraph) ScanItems(_ context.Context) ([]Item, error) {
+	return toItems(m.query(func(d *dto) bool {
+		return true
+	})), nil
+}
+
+// DeleteItems deletes the items, the items which do not exist are ignored.
+func (m *MemoryGraph) DeleteItems(_ context.Context, items []Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range items {
+		delete(m.items, item.key())
+	}
+
+	return nil
+}
+
+// ReplaceItems deletes the items and inserts the edges provided the conditions hold and none of the edges exists.
+// It fails with ConditionFailedError otherwise, nothing is written then.
+func (m *MemoryGraph) ReplaceItems(_ context.Context, items []Item, edges []Edge, conditions []Condition) error {
+	dtos, err := toDtos(edges)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range dtos {
+		if _, ok := m.items[*d.key()]; ok {
+			return fmt.Errorf("replace items, %s %s exists: %w", d.GlobalId, d.TypeTarget, ConditionFailedError)
+		}
+	}
+	for _, c := range conditions {
+		if _, ok := m.items[c.Item.key()]; ok == c.Absent {
+			return fmt.Errorf("replace items, condition on %s %s: %w", c.Item.GlobalId, c.Item.TypeTarget, ConditionFailedError)
+		}
+	}
+	for _, item := range items {
+		delete(m.items, item.key())
+	}
+	for _, d := range dtos {
+		m.items[*d.key()] = *d
+	}
+
+	return nil
+}
+
+// query returns the items matching the predicate ordered the way DynamoDB orders them in the index.
+func (m *MemoryGraph) query(predicate func(d *dto) bool) []dto {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []dto
+	for _, d := range m.items {
+		if predicate(&d) {
+			result = append(result, d)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TypeTarget != result[j].TypeTarget {
+			return result[i].TypeTarget < result[j].TypeTarget
+		}
+		return result[i].GlobalId < result[j].GlobalId
+	})
+
+	return result
+}
+
+// queryPage returns at most limit items matching the predicate which follow the key of the token.
+// The tokens have the same format as those of Dygraph.
+func (m *MemoryGraph) queryPage(query map[string]string, predicate func(d *dto) bool, limit int32, token string) ([]dto, string, error) {
+	startKey, err := decodePageToken(token, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	items := m.query(predicate)
+	if startKey != nil {
+		start := sort.Search(len(items), func(i int) bool {
+			if items[i].TypeTarget != startKey["typeTarget"] {
+				return items[i].TypeTarget > startKey["typeTarget"]
+			}
+			return items[i].GlobalId > startKey["globalId"]
+		})
+		items = items[start:]
+	}
+	if limit <= 0 || int(limit) >= len(items) {
+		return items, "", nil
+	}
+
+	items = items[:limit]
+	last := items[len(items)-1]
+	next, err := encodePageToken(query, map[string]string{"globalId": last.GlobalId, "typeTarget": last.TypeTarget})
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
+
+func toItems(items []dto) []Item {
+	result := make([]Item, len(items))
+	for i, d := range items {
+		result[i] = Item(d)
+	}
+	return result
+}
+
+// toDtos converts the edges rejecting a transaction which touches the same item twice as DynamoDB does.
+func toDtos(items []Edge) ([]*dto, error) {
+	result := make([]*dto, len(items))
+	seen := make(map[keyDto]struct{}, len(items))
+	for i := range items {
+		result[i] = items[i].createEdgeDto()
+		key := *result[i].key()
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("transaction contains item %v twice", items[i])
+		}
+		seen[key] = struct{}{}
+	}
+	return result, nil
+}
+
+func createEdges(items []dto) []Edge {
+	result := make([]Edge, len(items))
+	for i, d := range items {
+		result[i] = d.createEdge()
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+	"strconv"
+)
+
+// MigrationRecordType is the type of the nodes recording the migrations applied to the table.
+// The records are stored under the nil organisation, which is reserved for the operation catalogue,
+// so the catalogue must be read by record type, never as a whole.
+const MigrationRecordType = "MIGRATION"
+
+// Migration is a change of the data which is applied to a table once.
+// A migration may be interrupted, so it should be safe to apply it again.
+type Migration struct {
+	// Version orders the migrations, it is never reused.
+	Version     int
+	Description string
+	Apply       func(ctx context.Context) error
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d %s", m.Version, m.Description)
+}
+
+func (m Migration) record() Node {
+	return Node{
+		OrganisationId: uuid.Nil,
+		Id:             uuid.NewSHA1(uuid.Nil, []byte(strconv.Itoa(m.Version))),
+		Type:           MigrationRecordType,
+		Data:           m.Description,
+	}
+}
+
+type migrationRecords interface {
+	GetNodes(ctx context.Context, organisationId uuid.UUID, nodeType string) ([]Node, error)
+	InsertRecord(ctx context.Context, node *Node) error
+}
+
+// ApplyMigrations applies the migrations not recorded in the graph yet, in the order of their versions,
+// and records each of them once it is applied. It stops at the first failing migration
+// and returns the migrations applied before it.
+func ApplyMigrations(ctx context.Context, graph migrationRecords, migrations []Migration) ([]Migration, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("migrations %v and %v have the same version", sorted[i-1], sorted[i])
+		}
+	}
+
+	records, err := graph.GetNodes(ctx, uuid.Nil, MigrationRecordType)
+	if err != nil {
+		return nil, fmt.Errorf("get applied migrations: %w", err)
+	}
+	applied := make(map[uuid.UUID]struct{}, len(records))
+	for _, record := range records {
+		applied[record.Id] = struct{}{}
+	}
+
+	var result []Migration
+	for _, m := range sorted {
+		record := m.record()
+		if _, ok := applied[record.Id]; ok {
+			continue
+		}
+		if m.Apply != nil {
+			if err := m.Apply(ctx); err != nil {
+				return result, fmt.Errorf("apply migration %v: %w", m, err)
+			}
+		}
+		// Another instance may have applied the migration concurrently.
+		if err := graph.InsertRecord(ctx, &record); err != nil && !errors.Is(err, DuplicateError) {
+			return result, fmt.Errorf("record migration %v: %w", m, err)
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
@@ -0,0 +1,55 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestApplyMigrations(t *testing.T) {
+	graph := CreateMemoryGraph()
+	var log []int
+	migration := func(version int, err error) Migration {
+		return Migration{Version: version, Description: "test", Apply: func(context.Context) error {
+			log = append(log, version)
+			return err
+		}}
+	}
+	versions := func(ms []Migration) []int {
+		var result []int
+		for _, m := range ms {
+			result = append(result, m.Version)
+		}
+		return result
+	}
+	failure := errors.New("something went wrong")
+
+	applied, err := ApplyMigrations(context.Background(), graph, []Migration{migration(2, nil), migration(1, nil), migration(3, failure), migration(4, nil)})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the failure of the third migration, got %v", err)
+	}
+	if diff := cmp.Diff([]int{1, 2}, versions(applied)); diff != "" {
+		t.Errorf("ApplyMigrations() diff %v", diff)
+	}
+
+	applied, err = ApplyMigrations(context.Background(), graph, []Migration{migration(1, nil), migration(2, nil), migration(3, nil), migration(4, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int{3, 4}, versions(applied)); diff != "" {
+		t.Errorf("ApplyMigrations() after the failure diff %v", diff)
+	}
+	if diff := cmp.Diff([]int{1, 2, 3, 3, 4}, log); diff != "" {
+		t.Errorf("ApplyMigrations() applied diff %v", diff)
+	}
+
+	applied, err = ApplyMigrations(context.Background(), graph, []Migration{migration(1, nil), migration(2, nil), migration(3, nil), migration(4, nil)})
+	if err != nil || len(applied) != 0 {
+		t.Errorf("ApplyMigrations() of the applied migrations = %v, %v", applied, err)
+	}
+
+	if _, err := ApplyMigrations(context.Background(), graph, []Migration{migration(5, nil), migration(5, nil)}); err == nil {
+		t.Errorf("expected an error applying two migrations of the same version")
+	}
+}
@@ -17,17 +17,20 @@ var MarshalError = errors.New("marshalling error")
 var UnmarshalError = errors.New("unmarshalling error")
 var DuplicateError = errors.New("duplicate")
 var TooManyRequestsError = errors.New("too many requests")
+var ReferenceNotFoundError = errors.New("referenced node not found")
 
 type dynamoDBAPI interface {
 	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 	Query(ctx context.Context, input *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, input *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
 	TransactWriteItems(ctx context.Context, input *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
 }
 
 // Dygraph type implements graph operations on top of Amazon DynamoDB
 type Dygraph struct {
 	client      dynamoDBAPI
-	environment string
+	tableName   string
+	retryPolicy RetryPolicy
 	marshal     func(in interface{}) (map[string]types.AttributeValue, error)
 	unmarshal   func(m map[string]types.AttributeValue, out interface{}) error
 }
@@ -58,8 +61,7 @@ func wrapAwsError(err error) error {
 		log.Printf("duplicate item exception: %s", err)
 		err = fmt.Errorf("%s: %w", err, DuplicateError)
 	}
-	var tooManyRequests *types.ProvisionedThroughputExceededException
-	if errors.As(err, &tooManyRequests) {
+	if throttled(err) {
 		log.Printf("too many requests exception: %s", err)
 		err = fmt.Errorf("%s: %w", err, TooManyRequestsError)
 	}
@@ -67,34 +69,49 @@ func wrapAwsError(err error) error {
 	return err
 }
 
+// CreateGraphClient creates a graph stored in the table of the environment, see TableName.
 func CreateGraphClient(client dynamoDBAPI, environment string) *Dygraph {
+	return CreateGraphClientForTable(client, TableName(environment))
+}
+
+// CreateGraphClientForTable creates a graph stored in the table.
+func CreateGraphClientForTable(client dynamoDBAPI, tableName string) *Dygraph {
 	return &Dygraph{
 		client:      client,
-		environment: environment,
+		tableName:   tableName,
+		retryPolicy: DefaultRetryPolicy(),
 		marshal:     marshal,
 		unmarshal:   unmarshal,
 	}
 }
 
-func (r *Dygraph) getTableName() string {
-	const TableName = "Authorization"
+// TableName returns the name of the table of the environment.
+func TableName(environment string) string {
+	const prefix = "Authorization"
 
-	return TableName + "-" + r.environment
+	return prefix + "-" + environment
+}
+
+func (r *Dygraph) getTableName() string {
+	return r.tableName
 }
 
 //InsertRecord inserts a node
 //TODO: Why do I pass a pointer not a value?
-func (r *Dygraph) InsertRecord(node *Node) error {
+func (r *Dygraph) InsertRecord(ctx context.Context, node *Node) error {
 	item, err := r.marshal(node.createNodeDto())
 
 	if err != nil {
 		return err
 	}
 
-	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		ConditionExpression: aws.String("attribute_not_exists(id)"),
-		Item:                item,
-		TableName:           aws.String(r.getTableName()),
+	err = r.retry(ctx, "PutItem", func() error {
+		_, err := r.client.PutItem(ctx, &dynamodb.PutItemInput{
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+			Item:                item,
+			TableName:           aws.String(r.getTableName()),
+		})
+		return err
 	})
 
 	if err != nil {
@@ -104,79 +121,169 @@ func (r *Dygraph) InsertRecord(node *Node) error {
 	return nil
 }
 
-func (r *Dygraph) GetNodes(organisationId uuid.UUID, nodeType string) ([]Node, error) {
-	output, err := r.client.Query(context.TODO(), &dynamodb.QueryInput{
-		IndexName:              aws.String("GSIApplicationTypeTarget"),
+// InsertRecords inserts the nodes atomically.
+// It fails with DuplicateError if any of the nodes exists, in this case nothing is inserted.
+func (r *Dygraph) InsertRecords(ctx context.Context, nodes []Node) error {
+	transactWriteItems := make([]types.TransactWriteItem, len(nodes))
+	for i := range nodes {
+		item, err := r.marshal(nodes[i].createNodeDto())
+		if err != nil {
+			return err
+		}
+		transactWriteItems[i] = types.TransactWriteItem{
+			Put: &types.Put{
+				ConditionExpression: aws.String("attribute_not_exists(id)"),
+				Item:                item,
+				TableName:           aws.String(r.getTableName()),
+			},
+		}
+	}
+	err := r.retry(ctx, "TransactWriteItems", func() error {
+		_, err := r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: transactWriteItems,
+		})
+		return err
+	})
+	if err != nil {
+		var transactionCancelledException *types.TransactionCanceledException
+		if errors.As(err, &transactionCancelledException) {
+			for i, reason := range transactionCancelledException.CancellationReasons {
+				if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
+					log.Printf("duplicate item %v", nodes[i])
+					return fmt.Errorf("insert records: %w", DuplicateError)
+				}
+			}
+		}
+		return fmt.Errorf("insert records: %w", wrapAwsError(err))
+	}
+
+	return nil
+}
+
+// GetNode returns the node of the type. It fails with NotFoundError if there is no such node.
+func (r *Dygraph) GetNode(ctx context.Context, organisationId, id uuid.UUID, nodeType string) (Node, error) {
+	node := Node{OrganisationId: organisationId, Id: id, Type: nodeType}
+	items, err := r.queryAll(ctx, &dynamodb.QueryInput{
 		TableName:              aws.String(r.getTableName()),
-		KeyConditionExpression: aws.String("organisationId = :organisationId and begins_with(typeTarget, :type)"),
+		KeyConditionExpression: aws.String("globalId = :globalId and typeTarget = :typeTarget"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":organisationId": &types.AttributeValueMemberS{Value: organisationId.String()},
-			":type":           &types.AttributeValueMemberS{Value: nodePrefix + nodeType},
+			":globalId":   &types.AttributeValueMemberS{Value: node.createNodeDto().GlobalId},
+			":typeTarget": &types.AttributeValueMemberS{Value: node.createNodeDto().TypeTarget},
 		},
 	})
+	if err != nil {
+		return Node{}, fmt.Errorf("get node: %w", err)
+	}
+	if len(items) == 0 {
+		return Node{}, fmt.Errorf("get node %s: %w", id, NotFoundError)
+	}
 
+	nodes, err := r.toNodes(items)
 	if err != nil {
-		return nil, fmt.Errorf("get nodes: %w", wrapAwsError(err))
+		return Node{}, err
+	}
+	return nodes[0], nil
+}
 
+func (r *Dygraph) GetNodes(ctx context.Context, organisationId uuid.UUID, nodeType string) ([]Node, error) {
+	items, err := r.queryAll(ctx, r.nodesQuery(organisationId, nodeType))
+	if err != nil {
+		return nil, fmt.Errorf("get nodes: %w", err)
 	}
 
-	result := make([]Node, output.Count)
-	for i, item := range output.Items {
-		d := dto{}
-		err := r.unmarshal(item, &d)
-		if err != nil {
-			return nil, err
-		}
-		result[i] = d.createNode()
+	return r.toNodes(items)
+}
+
+func (r *Dygraph) GetEdges(ctx context.Context, organisationId uuid.UUID, edgeType string) ([]Edge, error) {
+	items, err := r.queryAll(ctx, r.edgesQuery(organisationId, edgeType))
+	if err != nil {
+		return nil, fmt.Errorf("get edges: %w", err)
 	}
 
-	return result, nil
+	return r.toEdges(items)
 }
 
-func (r *Dygraph) GetEdges(organisationId uuid.UUID, edgeType string) ([]Edge, error) {
-	output, err := r.client.Query(context.TODO(), &dynamodb.QueryInput{
-		IndexName:              aws.String("GSIApplicationTypeTarget"),
+func (r *Dygraph) GetNodeEdgesOfType(ctx context.Context, organisationId, id uuid.UUID, edgeType string) ([]Edge, error) {
+	items, err := r.queryAll(ctx, r.nodeEdgesOfTypeQuery(organisationId, id, edgeType))
+	if err != nil {
+		return nil, fmt.Errorf("get node edges of type: %w", err)
+	}
+
+	return r.toEdges(items)
+}
+
+func (r *Dygraph) nodesQuery(organisationId uuid.UUID, nodeType string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		IndexName:              aws.String(globalIndexName),
 		TableName:              aws.String(r.getTableName()),
 		KeyConditionExpression: aws.String("organisationId = :organisationId and begins_with(typeTarget, :type)"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":organisationId": &types.AttributeValueMemberS{Value: organisationId.String()},
-			":type":           &types.AttributeValueMemberS{Value: edgePrefix + edgeType},
+			":type":           &types.AttributeValueMemberS{Value: nodePrefix + nodeType},
 		},
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("get edges: %w", wrapAwsError(err))
 	}
+}
 
-	result := make([]Edge, output.Count)
-	for i, item := range output.Items {
-		d := dto{}
-		err := r.unmarshal(item, &d)
-		if err != nil {
-			return nil, err
-		}
-		result[i] = d.createEdge()
+func (r *Dygraph) edgesQuery(organisationId uuid.UUID, edgeType string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		IndexName:              aws.String(globalIndexName),
+		TableName:              aws.String(r.getTableName()),
+		KeyConditionExpression: aws.String("organisationId = :organisationId and begins_with(typeTarget, :type)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":organisationId": &types.AttributeValueMemberS{Value: organisationId.String()},
+			":type":           &types.AttributeValueMemberS{Value: edgePrefix + edgeType},
+		},
 	}
-
-	return result, nil
 }
 
-func (r *Dygraph) GetNodeEdgesOfType(organisationId, id uuid.UUID, edgeType string) ([]Edge, error) {
-	output, err := r.client.Query(context.TODO(), &dynamodb.QueryInput{
+func (r *Dygraph) nodeEdgesOfTypeQuery(organisationId, id uuid.UUID, edgeType string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
 		TableName:              aws.String(r.getTableName()),
 		KeyConditionExpression: aws.String("globalId = :globalId and begins_with(typeTarget, :type)"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":globalId": &types.AttributeValueMemberS{Value: organisationId.String() + "_" + id.String()},
 			":type":     &types.AttributeValueMemberS{Value: edgePrefix + edgeType},
 		},
-	})
+	}
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("get node edges of type: %w", wrapAwsError(err))
+// queryAll follows LastEvaluatedKey until the query is exhausted.
+func (r *Dygraph) queryAll(ctx context.Context, input *dynamodb.QueryInput) ([]map[string]types.AttributeValue, error) {
+	var result []map[string]types.AttributeValue
+	for {
+		var output *dynamodb.QueryOutput
+		err := r.retry(ctx, "Query", func() (err error) {
+			output, err = r.client.Query(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, wrapAwsError(err)
+		}
+		result = append(result, output.Items...)
+		if len(output.LastEvaluatedKey) == 0 {
+			return result, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
 	}
+}
 
-	result := make([]Edge, output.Count)
-	for i, item := range output.Items {
+func (r *Dygraph) toNodes(items []map[string]types.AttributeValue) ([]Node, error) {
+	result := make([]Node, len(items))
+	for i, item := range items {
+		d := dto{}
+		err := r.unmarshal(item, &d)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = d.createNode()
+	}
+
+	return result, nil
+}
+
+func (r *Dygraph) toEdges(items []map[string]types.AttributeValue) ([]Edge, error) {
+	result := make([]Edge, len(items))
+	for i, item := range items {
 		d := dto{}
 		err := r.unmarshal(item, &d)
 		if err != nil {
@@ -188,7 +295,7 @@ func (r *Dygraph) GetNodeEdgesOfType(organisationId, id uuid.UUID, edgeType stri
 	return result, nil
 }
 
-func (r *Dygraph) TransactionalInsert(items []Edge) error {
+func (r *Dygraph) TransactionalInsert(ctx context.Context, items []Edge) error {
 	transactWriteItems := make([]types.TransactWriteItem, len(items))
 	for i := 0; i < len(items); i++ {
 		av, err := r.marshal(items[i].createEdgeDto())
@@ -203,32 +310,118 @@ func (r *Dygraph) TransactionalInsert(items []Edge) error {
 			},
 		}
 	}
-	_, err := r.client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
-		TransactItems: transactWriteItems,
+	err := r.retry(ctx, "TransactWriteItems", func() error {
+		_, err := r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: transactWriteItems,
+		})
+		return err
 	})
 
 	if err != nil {
 		var transactionCancelledException *types.TransactionCanceledException
 		if errors.As(err, &transactionCancelledException) {
-			f := false
 			for i, reason := range transactionCancelledException.CancellationReasons {
-				if *reason.Code == "ConditionalCheckFailed" {
+				if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
 					log.Printf("duplicate item %v", items[i])
-					f = true
+					return fmt.Errorf("duplicate item exception: %w", DuplicateError)
 				}
 			}
-			// If the snippet is written like this the coverage is not 100%.
-			//if f {
-			//	return fmt.Errorf("duplicate item exception: %w", DuplicateError)
-			//}
-			//return err
-
-			// The coverage is 100%, but there is no test for !f.
-			if f {
-				err = fmt.Errorf("duplicate item exception: %w", DuplicateError)
-			}
+		}
+		return fmt.Errorf("transactional insert: %w", wrapAwsError(err))
+	}
+
+	return nil
+}
+
+// TransactionalInsertReferencing inserts the edges atomically provided all the referenced nodes exist.
+// The nodes are identified by their organisation, id and type, the data is ignored.
+// It fails with ReferenceNotFoundError if any of the nodes does not exist
+// and with DuplicateError if any of the edges exists, in both cases nothing is inserted.
+func (r *Dygraph) TransactionalInsertReferencing(ctx context.Context, items []Edge, references []Node) error {
+	return r.insertReferencing(ctx, items, references, nil, "")
+}
+
+// TransactionalInsertVersioned inserts the edges as TransactionalInsertReferencing does and writes the version node
+// in the same transaction provided its data is still previous, an empty previous meaning that the node does not exist.
+// It fails with ConditionFailedError if the version node changed, nothing is inserted then.
+func (r *Dygraph) TransactionalInsertVersioned(ctx context.Context, items []Edge, references []Node, version Node, previous string) error {
+	return r.insertReferencing(ctx, items, references, &version, previous)
+}
+
+func (r *Dygraph) insertReferencing(ctx context.Context, items []Edge, references []Node, version *Node, previous string) error {
+	transactWriteItems := make([]types.TransactWriteItem, 0, len(items)+len(references)+1)
+	for i := range items {
+		av, err := r.marshal(items[i].createEdgeDto())
+		if err != nil {
+			return err
+		}
+		transactWriteItems = append(transactWriteItems, types.TransactWriteItem{
+			Put: &types.Put{
+				ConditionExpression: aws.String("attribute_not_exists(id)"),
+				Item:                av,
+				TableName:           aws.String(r.getTableName()),
+			},
+		})
+	}
+	for i := range references {
+		key, err := r.marshal(references[i].createNodeDto().key())
+		if err != nil {
+			return err
+		}
+		transactWriteItems = append(transactWriteItems, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				ConditionExpression: aws.String("attribute_exists(id)"),
+				Key:                 key,
+				TableName:           aws.String(r.getTableName()),
+			},
+		})
+	}
+	if version != nil {
+		av, err := r.marshal(version.createNodeDto())
+		if err != nil {
 			return err
 		}
+		put := &types.Put{
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+			Item:                av,
+			TableName:           aws.String(r.getTableName()),
+		}
+		if previous != "" {
+			put.ConditionExpression = aws.String("#data = :previous")
+			put.ExpressionAttributeNames = map[string]string{"#data": "data"}
+			put.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":previous": &types.AttributeValueMemberS{Value: previous},
+			}
+		}
+		transactWriteItems = append(transactWriteItems, types.TransactWriteItem{Put: put})
+	}
+	err := r.retry(ctx, "TransactWriteItems", func() error {
+		_, err := r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: transactWriteItems,
+		})
+		return err
+	})
+	if err != nil {
+		var transactionCancelledException *types.TransactionCanceledException
+		if errors.As(err, &transactionCancelledException) {
+			for i, reason := range transactionCancelledException.CancellationReasons {
+				if reason.Code == nil || *reason.Code != "ConditionalCheckFailed" {
+					continue
+				}
+				if i >= len(items)+len(references) {
+					log.Printf("version %v changed", *version)
+					return fmt.Errorf("transactional insert: %w", ConditionFailedError)
+				}
+				if i >= len(items) {
+					node := references[i-len(items)]
+					log.Printf("missing node %v", node)
+					return fmt.Errorf("%s %s: %w", node.Type, node.Id, ReferenceNotFoundError)
+				}
+				log.Printf("duplicate item %v", items[i])
+				return fmt.Errorf("transactional insert: %w", DuplicateError)
+			}
+		}
+		return fmt.Errorf("transactional insert: %w", wrapAwsError(err))
 	}
 
 	return nil
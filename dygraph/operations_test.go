@@ -10,10 +10,12 @@ import (
 	"github.com/google/uuid"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 )
 
 func TestGetNodes(t *testing.T) {
+	testutils.RequireDynamoDB(t)
 	graphClient := CreateTestGraphClient()
 	node := Node{
 		OrganisationId: uuid.New(),
@@ -21,12 +23,12 @@ func TestGetNodes(t *testing.T) {
 		Type:           "ROLE",
 		Data:           "Branch manager",
 	}
-	err := graphClient.InsertRecord(&node)
+	err := graphClient.InsertRecord(context.Background(), &node)
 	if err != nil {
 		t.Fatalf("Failed to insert node %v with error %v", node, err)
 	}
 
-	result, err := graphClient.GetNodes(node.OrganisationId, node.Type)
+	result, err := graphClient.GetNodes(context.Background(), node.OrganisationId, node.Type)
 	if err != nil {
 		t.Fatalf("Failed to get nodes with error %v", err)
 	}
@@ -53,13 +55,19 @@ func TestDygraph_MarshalErrors(t *testing.T) {
 		{
 			name: "InsertRecord",
 			f: func() error {
-				return graphClient.InsertRecord(&Node{})
+				return graphClient.InsertRecord(context.Background(), &Node{})
 			},
 		},
 		{
 			name: "TransactionalInsert",
 			f: func() error {
-				return graphClient.TransactionalInsert([]Edge{{}})
+				return graphClient.TransactionalInsert(context.Background(), []Edge{{}})
+			},
+		},
+		{
+			name: "TransactionalInsertReferencing",
+			f: func() error {
+				return graphClient.TransactionalInsertReferencing(context.Background(), []Edge{{}}, []Node{{}})
 			},
 		},
 	}
@@ -74,6 +82,7 @@ func TestDygraph_MarshalErrors(t *testing.T) {
 	}
 }
 func TestDygraph_DuplicateErrors(t *testing.T) {
+	testutils.RequireDynamoDB(t)
 	graphClient := CreateTestGraphClient()
 
 	tests := []struct {
@@ -89,8 +98,8 @@ func TestDygraph_DuplicateErrors(t *testing.T) {
 					Type:           "PHONY",
 					Data:           "PHONY",
 				}
-				graphClient.InsertRecord(&node)
-				return graphClient.InsertRecord(&node)
+				graphClient.InsertRecord(context.Background(), &node)
+				return graphClient.InsertRecord(context.Background(), &node)
 			},
 		},
 		{
@@ -104,8 +113,8 @@ func TestDygraph_DuplicateErrors(t *testing.T) {
 					Tags:           nil,
 					Data:           "PHONY",
 				}
-				graphClient.TransactionalInsert([]Edge{edge})
-				return graphClient.TransactionalInsert([]Edge{edge})
+				graphClient.TransactionalInsert(context.Background(), []Edge{edge})
+				return graphClient.TransactionalInsert(context.Background(), []Edge{edge})
 			},
 		},
 	}
@@ -135,21 +144,21 @@ func TestDygraph_TooManyRequestsErrors(t *testing.T) {
 		{
 			name: "GetNodes",
 			f: func() error {
-				_, err := graphClient.GetNodes(uuid.New(), "ROLE")
+				_, err := graphClient.GetNodes(context.Background(), uuid.New(), "ROLE")
 				return err
 			},
 		},
 		{
 			name: "GetEdges",
 			f: func() error {
-				_, err := graphClient.GetEdges(uuid.New(), "ROLE")
+				_, err := graphClient.GetEdges(context.Background(), uuid.New(), "ROLE")
 				return err
 			},
 		},
 		{
 			name: "GetNodeEdgesOfType",
 			f: func() error {
-				_, err := graphClient.GetNodeEdgesOfType(uuid.New(), uuid.New(), "ROLE")
+				_, err := graphClient.GetNodeEdgesOfType(context.Background(), uuid.New(), uuid.New(), "ROLE")
 				return err
 			},
 		},
@@ -164,6 +173,51 @@ func TestDygraph_TooManyRequestsErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestDygraph_PassesContext(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	var got []context.Context
+	stub := dynamodbAPIStub{
+		putItem: func(ctx context.Context, _ *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+			got = append(got, ctx)
+			return &dynamodb.PutItemOutput{}, nil
+		},
+		query: func(ctx context.Context, _ *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			got = append(got, ctx)
+			return &dynamodb.QueryOutput{}, nil
+		},
+		transactWriteItems: func(ctx context.Context, _ *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+			got = append(got, ctx)
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		},
+	}
+	graphClient := CreateGraphClient(&stub, "test")
+	orgId := uuid.New()
+	edge := Edge{OrganisationId: orgId, Id: uuid.New(), TargetNodeId: uuid.New(), TargetNodeType: "ROLE"}
+
+	if err := graphClient.InsertRecord(ctx, &Node{OrganisationId: orgId, Id: uuid.New(), Type: "ROLE"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graphClient.GetNodes(ctx, orgId, "ROLE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := graphClient.TransactionalInsert(ctx, []Edge{edge}); err != nil {
+		t.Fatal(err)
+	}
+	if err := graphClient.TransactionalDelete(ctx, []Edge{edge}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 calls, got %d", len(got))
+	}
+	for i, c := range got {
+		if c.Value(key{}) != "request" {
+			t.Errorf("call %d did not get the caller's context", i)
+		}
+	}
+}
+
 func TestDygraph_UnmarshalErrors(t *testing.T) {
 	stub := dynamodbAPIStub{
 		query: func(_ context.Context, _ *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
@@ -185,21 +239,21 @@ func TestDygraph_UnmarshalErrors(t *testing.T) {
 		{
 			name: "GetNodes",
 			f: func() error {
-				_, err := graphClient.GetNodes(uuid.New(), "PHONY")
+				_, err := graphClient.GetNodes(context.Background(), uuid.New(), "PHONY")
 				return err
 			},
 		},
 		{
 			name: "GetEdges",
 			f: func() error {
-				_, err := graphClient.GetEdges(uuid.New(), "PHONY")
+				_, err := graphClient.GetEdges(context.Background(), uuid.New(), "PHONY")
 				return err
 			},
 		},
 		{
 			name: "GetNodeEdgesOfType",
 			f: func() error {
-				_, err := graphClient.GetNodeEdgesOfType(uuid.New(), uuid.New(), "PHONY")
+				_, err := graphClient.GetNodeEdgesOfType(context.Background(), uuid.New(), uuid.New(), "PHONY")
 				return err
 			},
 		},
@@ -216,6 +270,7 @@ func TestDygraph_UnmarshalErrors(t *testing.T) {
 }
 
 func TestDygraph_TransactionalInsertGetEdges(t *testing.T) {
+	testutils.RequireDynamoDB(t)
 	graphClient := CreateTestGraphClient()
 
 	type args struct {
@@ -264,12 +319,12 @@ func TestDygraph_TransactionalInsertGetEdges(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			edges := tt.args.getItems(tt.id)
-			err := graphClient.TransactionalInsert(edges)
+			err := graphClient.TransactionalInsert(context.Background(), edges)
 			if err != nil {
 				t.Fatalf("Failed to insert edges %v with the error %v.", edges, err)
 			}
 
-			got, err := graphClient.GetEdges(tt.id, "ROLE")
+			got, err := graphClient.GetEdges(context.Background(), tt.id, "ROLE")
 			if err != nil {
 				t.Fatalf("Failed to get edges. The error %v.", err)
 			}
@@ -282,6 +337,7 @@ func TestDygraph_TransactionalInsertGetEdges(t *testing.T) {
 }
 
 func TestDygraph_TransactionalInsertGetNodeEdgesOfType(t *testing.T) {
+	testutils.RequireDynamoDB(t)
 	graphClient := CreateTestGraphClient()
 
 	type args struct {
@@ -331,12 +387,12 @@ func TestDygraph_TransactionalInsertGetNodeEdgesOfType(t *testing.T) {
 
 	for _, tt := range tests {
 		edges := tt.args.getItems(tt.orgId, tt.id)
-		err := graphClient.TransactionalInsert(edges)
+		err := graphClient.TransactionalInsert(context.Background(), edges)
 		if err != nil {
 			t.Fatalf("Failed to insert edges %v with the error %v.", edges, err)
 		}
 
-		got, err := graphClient.GetNodeEdgesOfType(tt.orgId, tt.id, "ROLE")
+		got, err := graphClient.GetNodeEdgesOfType(context.Background(), tt.orgId, tt.id, "ROLE")
 		if err != nil {
 			t.Fatalf("Failed to get edges. The error %v.", err)
 		}
@@ -348,9 +404,19 @@ func TestDygraph_TransactionalInsertGetNodeEdgesOfType(t *testing.T) {
 }
 
 func CreateTestGraphClient() *Dygraph {
-	return CreateGraphClient(testutils.GetClient(), "test")
+	client := testutils.GetClient()
+	if testutils.UseDynamoDB() {
+		ensureTestTable.Do(func() {
+			if err := EnsureTable(context.Background(), client, TableName("test"), TableOptions{TimeToLive: true}); err != nil {
+				panic(err)
+			}
+		})
+	}
+	return CreateGraphClient(client, "test")
 }
 
+var ensureTestTable sync.Once
+
 func Test_marshal(t *testing.T) {
 	type args struct {
 		in interface{}
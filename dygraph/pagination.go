@@ -0,0 +1,161 @@
+package dygraph
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+var InvalidPageTokenError = errors.New("invalid page token")
+
+// GetNodesPage is a cursor-based variant of GetNodes.
+// It returns at most limit nodes, a non-positive limit means no limit besides the 1 MB DynamoDB page size.
+// The returned token is passed to the next call to continue the query,
+// an empty token starts a query and is returned when the query is exhausted.
+// A token of another query, e.g. of another organisation or type, fails with InvalidPageTokenError.
+func (r *Dygraph) GetNodesPage(ctx context.Context, organisationId uuid.UUID, nodeType string, limit int32, token string) ([]Node, string, error) {
+	items, next, err := r.queryPage(ctx, r.nodesQuery(organisationId, nodeType), limit, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("get nodes page: %w", err)
+	}
+
+	nodes, err := r.toNodes(items)
+	if err != nil {
+		return nil, "", err
+	}
+	return nodes, next, nil
+}
+
+// GetEdgesPage is a cursor-based variant of GetEdges. See GetNodesPage for the meaning of limit and token.
+func (r *Dygraph) GetEdgesPage(ctx context.Context, organisationId uuid.UUID, edgeType string, limit int32, token string) ([]Edge, string, error) {
+	items, next, err := r.queryPage(ctx, r.edgesQuery(organisationId, edgeType), limit, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("get edges page: %w", err)
+	}
+
+	edges, err := r.toEdges(items)
+	if err != nil {
+		return nil, "", err
+	}
+	return edges, next, nil
+}
+
+// GetNodeEdgesOfTypePage is a cursor-based variant of GetNodeEdgesOfType. See GetNodesPage for the meaning of limit and token.
+func (r *Dygraph) GetNodeEdgesOfTypePage(ctx context.Context, organisationId, id uuid.UUID, edgeType string, limit int32, token string) ([]Edge, string, error) {
+	items, next, err := r.queryPage(ctx, r.nodeEdgesOfTypeQuery(organisationId, id, edgeType), limit, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("get node edges of type page: %w", err)
+	}
+
+	edges, err := r.toEdges(items)
+	if err != nil {
+		return nil, "", err
+	}
+	return edges, next, nil
+}
+
+func (r *Dygraph) queryPage(ctx context.Context, input *dynamodb.QueryInput, limit int32, token string) ([]map[string]types.AttributeValue, string, error) {
+	if limit > 0 {
+		input.Limit = aws.Int32(limit)
+	}
+	query, err := stringValues(input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, "", err
+	}
+	startKey, err := decodePageToken(token, query)
+	if err != nil {
+		return nil, "", err
+	}
+	if startKey != nil {
+		input.ExclusiveStartKey = make(map[string]types.AttributeValue, len(startKey))
+		for name, value := range startKey {
+			input.ExclusiveStartKey[name] = &types.AttributeValueMemberS{Value: value}
+		}
+	}
+
+	var output *dynamodb.QueryOutput
+	err = r.retry(ctx, "Query", func() (err error) {
+		output, err = r.client.Query(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, "", wrapAwsError(err)
+	}
+
+	// All the key attributes of the table and its indexes are strings.
+	lastKey, err := stringValues(output.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	next, err := encodePageToken(query, lastKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return output.Items, next, nil
+}
+
+func stringValues(values map[string]types.AttributeValue) (map[string]string, error) {
+	result := make(map[string]string, len(values))
+	for name, value := range values {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return nil, fmt.Errorf("attribute %s is not a string: %w", name, MarshalError)
+		}
+		result[name] = s.Value
+	}
+	return result, nil
+}
+
+// pageToken is the content of the opaque token: the key to continue the query from
+// and the values the query was made with, so that the token is rejected by any other query.
+type pageToken struct {
+	Query map[string]string `json:"q"`
+	Key   map[string]string `json:"k"`
+}
+
+// encodePageToken turns the key the query stopped at into an opaque token, an empty key yields an empty token.
+func encodePageToken(query, key map[string]string) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	buf, err := json.Marshal(pageToken{Query: query, Key: key})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", err, MarshalError)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// decodePageToken returns the key to continue the query from, an empty token yields a nil key.
+// It fails with InvalidPageTokenError if the token is malformed or was issued for another query.
+func decodePageToken(token string, query map[string]string) (map[string]string, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", err, InvalidPageTokenError)
+	}
+	var plain pageToken
+	if err := json.Unmarshal(buf, &plain); err != nil || len(plain.Key) == 0 {
+		return nil, fmt.Errorf("malformed page token: %w", InvalidPageTokenError)
+	}
+	if len(plain.Query) != len(query) {
+		return nil, fmt.Errorf("page token of another query: %w", InvalidPageTokenError)
+	}
+	for name, value := range query {
+		if plain.Query[name] != value {
+			return nil, fmt.Errorf("page token of another query: %w", InvalidPageTokenError)
+		}
+	}
+
+	return plain.Key, nil
+}
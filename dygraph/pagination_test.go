@@ -0,0 +1,169 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"testing"
+)
+
+// pagedQueryStub serves the items in pages of the given size, keyed by typeTarget.
+func pagedQueryStub(t *testing.T, items []*dto, pageSize int) *dynamodbAPIStub {
+	return &dynamodbAPIStub{
+		query: func(_ context.Context, input *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			start := 0
+			if input.ExclusiveStartKey != nil {
+				last := input.ExclusiveStartKey["typeTarget"].(*types.AttributeValueMemberS).Value
+				for i, item := range items {
+					if item.TypeTarget == last {
+						start = i + 1
+					}
+				}
+			}
+			size := pageSize
+			if input.Limit != nil && int(*input.Limit) < size {
+				size = int(*input.Limit)
+			}
+			end := start + size
+			if end > len(items) {
+				end = len(items)
+			}
+			output := &dynamodb.QueryOutput{}
+			for _, item := range items[start:end] {
+				av, err := marshal(item)
+				if err != nil {
+					t.Fatal(err)
+				}
+				output.Items = append(output.Items, av)
+			}
+			output.Count = int32(len(output.Items))
+			if end < len(items) {
+				output.LastEvaluatedKey = map[string]types.AttributeValue{
+					"globalId":   &types.AttributeValueMemberS{Value: items[end-1].GlobalId},
+					"typeTarget": &types.AttributeValueMemberS{Value: items[end-1].TypeTarget},
+				}
+			}
+			return output, nil
+		},
+	}
+}
+
+func TestDygraph_QueriesFollowPagination(t *testing.T) {
+	orgId := uuid.New()
+	nodes := make([]Node, 5)
+	edges := make([]Edge, 5)
+	nodeItems := make([]*dto, len(nodes))
+	edgeItems := make([]*dto, len(edges))
+	for i := range nodes {
+		nodes[i] = Node{OrganisationId: orgId, Id: GenId(orgId, byte(i)), Type: "ROLE", Data: "role"}
+		nodeItems[i] = nodes[i].createNodeDto()
+		edges[i] = Edge{OrganisationId: orgId, Id: GenId(orgId, 100), TargetNodeId: GenId(orgId, byte(i)), TargetNodeType: "ROLE"}
+		edgeItems[i] = edges[i].createEdgeDto()
+	}
+
+	t.Run("GetNodes", func(t *testing.T) {
+		got, err := CreateGraphClient(pagedQueryStub(t, nodeItems, 2), "test").GetNodes(context.Background(), orgId, "ROLE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(nodes, got); diff != "" {
+			t.Errorf("GetNodes() diff %v", diff)
+		}
+	})
+	t.Run("GetEdges", func(t *testing.T) {
+		got, err := CreateGraphClient(pagedQueryStub(t, edgeItems, 2), "test").GetEdges(context.Background(), orgId, "ROLE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(edges, got); diff != "" {
+			t.Errorf("GetEdges() diff %v", diff)
+		}
+	})
+	t.Run("GetNodeEdgesOfType", func(t *testing.T) {
+		got, err := CreateGraphClient(pagedQueryStub(t, edgeItems, 3), "test").GetNodeEdgesOfType(context.Background(), orgId, GenId(orgId, 100), "ROLE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(edges, got); diff != "" {
+			t.Errorf("GetNodeEdgesOfType() diff %v", diff)
+		}
+	})
+}
+
+func TestDygraph_GetNodesPage(t *testing.T) {
+	orgId := uuid.New()
+	nodes := make([]Node, 5)
+	items := make([]*dto, len(nodes))
+	for i := range nodes {
+		nodes[i] = Node{OrganisationId: orgId, Id: GenId(orgId, byte(i)), Type: "OP", Data: "op"}
+		items[i] = nodes[i].createNodeDto()
+	}
+	graphClient := CreateGraphClient(pagedQueryStub(t, items, 10), "test")
+
+	var got []Node
+	token := ""
+	pages := 0
+	for {
+		page, next, err := graphClient.GetNodesPage(context.Background(), orgId, "OP", 2, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, page...)
+		pages++
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	if pages != 3 {
+		t.Errorf("GetNodesPage() pages = %d, want 3", pages)
+	}
+	if diff := cmp.Diff(nodes, got); diff != "" {
+		t.Errorf("GetNodesPage() diff %v", diff)
+	}
+}
+
+func TestDygraph_PageTokenOfAnotherQuery(t *testing.T) {
+	orgId := uuid.New()
+	items := make([]*dto, 3)
+	for i := range items {
+		node := Node{OrganisationId: orgId, Id: GenId(orgId, byte(i)), Type: "OP", Data: "op"}
+		items[i] = node.createNodeDto()
+	}
+	graphClient := CreateGraphClient(pagedQueryStub(t, items, 10), "test")
+	_, token, err := graphClient.GetNodesPage(context.Background(), orgId, "OP", 1, "")
+	if err != nil || token == "" {
+		t.Fatalf("GetNodesPage() = %q, %v", token, err)
+	}
+
+	if _, _, err := graphClient.GetNodesPage(context.Background(), uuid.New(), "OP", 1, token); !errors.Is(err, InvalidPageTokenError) {
+		t.Errorf("another organisation: expected invalid page token error, got %v", err)
+	}
+	if _, _, err := graphClient.GetNodesPage(context.Background(), orgId, "ROLE", 1, token); !errors.Is(err, InvalidPageTokenError) {
+		t.Errorf("another type: expected invalid page token error, got %v", err)
+	}
+	if _, _, err := graphClient.GetEdgesPage(context.Background(), orgId, "OP", 1, token); !errors.Is(err, InvalidPageTokenError) {
+		t.Errorf("edges: expected invalid page token error, got %v", err)
+	}
+}
+
+func TestDygraph_InvalidPageToken(t *testing.T) {
+	graphClient := CreateGraphClient(pagedQueryStub(t, nil, 1), "test")
+
+	for _, token := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		t.Run(token, func(t *testing.T) {
+			_, _, err := graphClient.GetEdgesPage(context.Background(), uuid.New(), "ROLE", 1, token)
+			if !errors.Is(err, InvalidPageTokenError) {
+				t.Errorf("expected invalid page token error, got %v", err)
+			}
+			_, _, err = graphClient.GetNodeEdgesOfTypePage(context.Background(), uuid.New(), uuid.New(), "ROLE", 1, token)
+			if !errors.Is(err, InvalidPageTokenError) {
+				t.Errorf("expected invalid page token error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy tells how Dygraph retries the calls failing because of throttling or transaction conflicts.
+// The calls failing for any other reason, e.g. a failed condition, are not retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a call, including the first one. One disables the retries.
+	MaxAttempts int
+	// BaseDelay is the upper bound of the delay before the first retry, it doubles with every retry up to MaxDelay.
+	// The delay is chosen at random below the bound, so that the clients retrying at once spread out.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Budget is the maximum number of retries of all the calls made with a context given by Dygraph.WithRetryBudget,
+	// zero means no limit.
+	Budget int
+}
+
+// DefaultRetryPolicy returns the policy of the graphs created by CreateGraphClient.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   50 * time.Millisecond,
+		MaxDelay:    2 * time.Second,
+		Budget:      20,
+	}
+}
+
+// delay returns the jittered delay before the retry following the attempt, attempts are counted from 1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	bound := p.BaseDelay
+	for i := 1; i < attempt && bound < p.MaxDelay; i++ {
+		bound *= 2
+	}
+	if bound > p.MaxDelay {
+		bound = p.MaxDelay
+	}
+	if bound <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(bound)))
+}
+
+// retryMetrics counts the retries by DynamoDB operation, it is published by expvar as dygraph_retries.
+// <operation>.retries counts the retries, <operation>.exhausted counts the calls which failed after MaxAttempts
+// attempts and <operation>.over_budget counts the calls which failed as the retry budget was spent.
+var retryMetrics = expvar.NewMap("dygraph_retries")
+
+type retryBudgetKey struct{}
+
+// WithRetryBudget returns a context limiting the retries of all the calls made with it,
+// e.g. by a request, to the budget of the retry policy.
+func (r *Dygraph) WithRetryBudget(ctx context.Context) context.Context {
+	if r.retryPolicy.Budget <= 0 {
+		return ctx
+	}
+	remaining := int64(r.retryPolicy.Budget)
+	return context.WithValue(ctx, retryBudgetKey{}, &remaining)
+}
+
+// SetRetryPolicy replaces the retry policy, DefaultRetryPolicy by default.
+func (r *Dygraph) SetRetryPolicy(policy RetryPolicy) {
+	r.retryPolicy = policy
+}
+
+// takeRetry spends a retry of the budget of the context, if any.
+func takeRetry(ctx context.Context) bool {
+	remaining, ok := ctx.Value(retryBudgetKey{}).(*int64)
+	return !ok || atomic.AddInt64(remaining, -1) >= 0
+}
+
+// ClientRetryer returns the retryer of the DynamoDB client of the graph. It is the standard retryer of the SDK,
+// retrying the transient errors, e.g. connection resets, timeouts and 5xx responses, except for the throttled calls
+// which the graph retries itself according to its RetryPolicy.
+func ClientRetryer() aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		notThrottled := retry.IsErrorRetryableFunc(func(err error) aws.Ternary {
+			if throttled(err) {
+				return aws.FalseTernary
+			}
+			return aws.UnknownTernary
+		})
+		o.Retryables = append([]retry.IsErrorRetryable{notThrottled}, retry.DefaultRetryables...)
+	})
+}
+
+// throttled tells whether the call failed because of throttling or a conflicting transaction.
+func throttled(err error) bool {
+	var throughputExceeded *types.ProvisionedThroughputExceededException
+	var requestLimitExceeded *types.RequestLimitExceeded
+	var transactionConflict *types.TransactionConflictException
+	var transactionCanceled *types.TransactionCanceledException
+	var apiError interface{ ErrorCode() string }
+	switch {
+	case errors.As(err, &throughputExceeded), errors.As(err, &requestLimitExceeded), errors.As(err, &transactionConflict):
+		return true
+	case errors.As(err, &transactionCanceled):
+		return retryableCancellation(transactionCanceled)
+	case errors.As(err, &apiError):
+		return apiError.ErrorCode() == "ThrottlingException"
+	}
+	return false
+}
+
+// retryableCancellation tells whether the transaction was cancelled because of throttling or a conflicting
+// transaction only. The transactions cancelled because of a failed condition fail again.
+func retryableCancellation(e *types.TransactionCanceledException) bool {
+	result := false
+	for _, reason := range e.CancellationReasons {
+		if reason.Code == nil {
+			continue
+		}
+		switch *reason.Code {
+		case "None":
+		case "TransactionConflict", "ThrottlingError", "ProvisionedThroughputExceeded":
+			result = true
+		default:
+			return false
+		}
+	}
+	return result
+}
+
+// retry makes the call until it succeeds, fails with an error which is not retryable,
+// runs out of attempts or of the retry budget of the context, or the context is done.
+// It returns the error of the last attempt.
+func (r *Dygraph) retry(ctx context.Context, operation string, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || !throttled(err) {
+			return err
+		}
+		if attempt >= r.retryPolicy.MaxAttempts {
+			retryMetrics.Add(operation+".exhausted", 1)
+			return err
+		}
+		if !takeRetry(ctx) {
+			retryMetrics.Add(operation+".over_budget", 1)
+			return err
+		}
+		retryMetrics.Add(operation+".retries", 1)
+		timer := time.NewTimer(r.retryPolicy.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
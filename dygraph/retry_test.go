@@ -0,0 +1,153 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func cancelled(codes ...string) error {
+	reasons := make([]types.CancellationReason, len(codes))
+	for i := range codes {
+		reasons[i].Code = aws.String(codes[i])
+	}
+	return &types.TransactionCanceledException{CancellationReasons: reasons}
+}
+
+// failingTransactions returns a graph whose transactions fail with the errors in turn and succeed afterwards.
+func failingTransactions(policy RetryPolicy, errs ...error) (*Dygraph, *int) {
+	calls := 0
+	stub := dynamodbAPIStub{
+		transactWriteItems: func(_ context.Context, _ *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+			calls++
+			if calls <= len(errs) {
+				return nil, errs[calls-1]
+			}
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		},
+	}
+	graphClient := CreateGraphClient(&stub, "test")
+	graphClient.SetRetryPolicy(policy)
+	return graphClient, &calls
+}
+
+func TestDygraph_TransactionalInsertRetries(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"Throttled", []error{&types.ProvisionedThroughputExceededException{}, &types.RequestLimitExceeded{}}, 3, nil},
+		{"Conflicting transaction", []error{cancelled("None", "TransactionConflict")}, 2, nil},
+		{"Failed condition", []error{cancelled("TransactionConflict", "ConditionalCheckFailed")}, 1, DuplicateError},
+		{"Attempts exhausted", []error{cancelled("ThrottlingError"), cancelled("ThrottlingError"), cancelled("ThrottlingError")}, 3, TooManyRequestsError},
+		{"Validation", []error{&types.ResourceNotFoundException{}}, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graphClient, calls := failingTransactions(policy, tt.errs...)
+			edges := []Edge{{OrganisationId: uuid.New(), Id: uuid.New(), TargetNodeId: uuid.New(), TargetNodeType: "ROLE"}, {OrganisationId: uuid.New(), Id: uuid.New(), TargetNodeId: uuid.New(), TargetNodeType: "ROLE"}}
+			err := graphClient.TransactionalInsert(context.Background(), edges)
+			if *calls != tt.wantCalls {
+				t.Errorf("TransactionalInsert() made %d calls, want %d", *calls, tt.wantCalls)
+			}
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("TransactionalInsert() error = %v, want %v", err, tt.wantErr)
+			case tt.wantErr == nil && *calls <= len(tt.errs) && err == nil:
+				t.Errorf("TransactionalInsert() succeeded, want %v", tt.errs[len(tt.errs)-1])
+			case tt.wantErr == nil && *calls > len(tt.errs) && err != nil:
+				t.Errorf("TransactionalInsert() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDygraph_RetryBudget(t *testing.T) {
+	errs := make([]error, 10)
+	for i := range errs {
+		errs[i] = &types.ProvisionedThroughputExceededException{}
+	}
+	graphClient, calls := failingTransactions(RetryPolicy{MaxAttempts: 5, Budget: 3}, errs...)
+	ctx := graphClient.WithRetryBudget(context.Background())
+	node := Node{OrganisationId: uuid.New(), Id: uuid.New(), Type: "ROLE"}
+
+	// The first call spends the whole budget, the second one is not retried.
+	if err := graphClient.InsertRecords(ctx, []Node{node}); !errors.Is(err, TooManyRequestsError) {
+		t.Errorf("InsertRecords() error = %v", err)
+	}
+	if err := graphClient.InsertRecords(ctx, []Node{node}); !errors.Is(err, TooManyRequestsError) {
+		t.Errorf("InsertRecords() error = %v", err)
+	}
+	if *calls != 5 {
+		t.Errorf("InsertRecords() made %d calls, want 5", *calls)
+	}
+}
+
+func TestDygraph_RetryStopsWhenContextIsDone(t *testing.T) {
+	graphClient, calls := failingTransactions(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}, &types.ProvisionedThroughputExceededException{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := graphClient.DeleteRecord(ctx, &Node{OrganisationId: uuid.New(), Id: uuid.New(), Type: "ROLE"}); !errors.Is(err, TooManyRequestsError) {
+		t.Errorf("DeleteRecord() error = %v", err)
+	}
+	if *calls != 1 {
+		t.Errorf("DeleteRecord() made %d calls, want 1", *calls)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	bounds := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+	for i, bound := range bounds {
+		for j := 0; j < 100; j++ {
+			if d := policy.delay(i + 1); d < 0 || d >= bound {
+				t.Fatalf("delay(%d) = %v, want below %v", i+1, d, bound)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).delay(1); d != 0 {
+		t.Errorf("delay() without a base delay = %v", d)
+	}
+}
+
+// responseError is an error of the SDK carrying the HTTP status code of the response.
+type responseError struct {
+	status int
+	err    error
+}
+
+func (e *responseError) Error() string       { return e.err.Error() }
+func (e *responseError) Unwrap() error       { return e.err }
+func (e *responseError) HTTPStatusCode() int { return e.status }
+
+func TestClientRetryer(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Internal server error", &responseError{500, &types.InternalServerError{}}, true},
+		{"Service unavailable", &responseError{503, errors.New("service unavailable")}, true},
+		{"Throttled", &responseError{400, &types.ProvisionedThroughputExceededException{}}, false},
+		{"Request limit exceeded", &responseError{400, &types.RequestLimitExceeded{}}, false},
+		{"Conflicting transaction", &responseError{400, cancelled("TransactionConflict")}, false},
+		{"Failed condition", &responseError{400, &types.ConditionalCheckFailedException{}}, false},
+	}
+	retryer := ClientRetryer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryer.IsErrorRetryable(tt.err); got != tt.want {
+				t.Errorf("IsErrorRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"time"
+)
+
+// TableNotReadyError is returned when the table or its indexes do not become active in time.
+var TableNotReadyError = errors.New("table not ready")
+
+// IncompatibleTableError is returned when an existing table lacks a key or an index the graph relies on.
+var IncompatibleTableError = errors.New("incompatible table")
+
+type tableAPI interface {
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	UpdateTable(ctx context.Context, params *dynamodb.UpdateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error)
+	DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+}
+
+const (
+	localIndexName  = "LSIApplicationTypeTargetTagless"
+	globalIndexName = "GSIApplicationTypeTarget"
+	ttlAttribute    = "expiresAt"
+)
+
+// TableOptions configure the table EnsureTable creates.
+type TableOptions struct {
+	// TimeToLive enables the deletion of the expired edges by DynamoDB.
+	TimeToLive bool
+	// StreamViewType enables the stream of the table unless it is empty.
+	StreamViewType types.StreamViewType
+	// PollInterval is the interval the table status is polled at while waiting for it, a second if zero.
+	PollInterval time.Duration
+	// Timeout is the time to wait for the table to become active, five minutes if zero.
+	Timeout time.Duration
+}
+
+func (o TableOptions) pollInterval() time.Duration {
+	if o.PollInterval > 0 {
+		return o.PollInterval
+	}
+	return time.Second
+}
+
+func (o TableOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return 5 * time.Minute
+}
+
+func createTableInput(tableName string) *dynamodb.CreateTableInput {
+	attribute := func(name string) types.AttributeDefinition {
+		return types.AttributeDefinition{AttributeName: aws.String(name), AttributeType: types.ScalarAttributeTypeS}
+	}
+	key := func(hash, rangeKey string) []types.KeySchemaElement {
+		return []types.KeySchemaElement{
+			{AttributeName: aws.String(hash), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(rangeKey), KeyType: types.KeyTypeRange},
+		}
+	}
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			attribute("globalId"),
+			attribute("typeTarget"),
+			attribute("organisationId"),
+			attribute("typeTargetTagless"),
+		},
+		KeySchema: key("globalId", "typeTarget"),
+		LocalSecondaryIndexes: []types.LocalSecondaryIndex{{
+			IndexName:  aws.String(localIndexName),
+			KeySchema:  key("globalId", "typeTargetTagless"),
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+		}},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName:  aws.String(globalIndexName),
+			KeySchema:  key("organisationId", "typeTarget"),
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// EnsureTable creates the table unless it exists and waits for it and its indexes to become active.
+// It enables the time to live and the stream if the options ask for them, it never disables them.
+// It fails with IncompatibleTableError if an existing table lacks the keys or the indexes of the graph.
+func EnsureTable(ctx context.Context, client tableAPI, tableName string, options TableOptions) error {
+	_, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		input := createTableInput(tableName)
+		if options.StreamViewType != "" {
+			input.StreamSpecification = &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: options.StreamViewType}
+		}
+		_, err = client.CreateTable(ctx, input)
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &inUse) {
+			// Created concurrently, e.g. by another instance starting up.
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("ensure table %s: %w", tableName, wrapAwsError(err))
+	}
+
+	table, err := waitForTable(ctx, client, tableName, options)
+	if err != nil {
+		return err
+	}
+	if err := checkTable(table); err != nil {
+		return err
+	}
+	if options.StreamViewType != "" && (table.StreamSpecification == nil || !aws.ToBool(table.StreamSpecification.StreamEnabled)) {
+		_, err := client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:           aws.String(tableName),
+			StreamSpecification: &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: options.StreamViewType},
+		})
+		if err != nil {
+			return fmt.Errorf("enable stream of %s: %w", tableName, wrapAwsError(err))
+		}
+		if _, err := waitForTable(ctx, client, tableName, options); err != nil {
+			return err
+		}
+	}
+	if options.TimeToLive {
+		return ensureTimeToLive(ctx, client, tableName)
+	}
+	return nil
+}
+
+// waitForTable polls the table until it and its global indexes are active.
+func waitForTable(ctx context.Context, client tableAPI, tableName string, options TableOptions) (*types.TableDescription, error) {
+	ctx, cancel := context.WithTimeout(ctx, options.timeout())
+	defer cancel()
+	for {
+		output, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		if err != nil {
+			return nil, fmt.Errorf("describe table %s: %w", tableName, wrapAwsError(err))
+		}
+		if active(output.Table) {
+			return output.Table, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("table %s is %s: %v: %w", tableName, output.Table.TableStatus, ctx.Err(), TableNotReadyError)
+		case <-time.After(options.pollInterval()):
+		}
+	}
+}
+
+func active(table *types.TableDescription) bool {
+	if table.TableStatus != types.TableStatusActive {
+		return false
+	}
+	for _, index := range table.GlobalSecondaryIndexes {
+		if index.IndexStatus != types.IndexStatusActive {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTable checks the keys and the indexes of the table.
+func checkTable(table *types.TableDescription) error {
+	want := createTableInput(aws.ToString(table.TableName))
+	incompatible := func(what string) error {
+		return fmt.Errorf("table %s: %s: %w", aws.ToString(table.TableName), what, IncompatibleTableError)
+	}
+	if !sameKey(want.KeySchema, table.KeySchema) {
+		return incompatible("unexpected key")
+	}
+	for _, index := range want.LocalSecondaryIndexes {
+		found := false
+		for _, got := range table.LocalSecondaryIndexes {
+			found = found || (aws.ToString(got.IndexName) == aws.ToString(index.IndexName) && sameKey(index.KeySchema, got.KeySchema))
+		}
+		if !found {
+			return incompatible("missing local secondary index " + aws.ToString(index.IndexName))
+		}
+	}
+	for _, index := range want.GlobalSecondaryIndexes {
+		found := false
+		for _, got := range table.GlobalSecondaryIndexes {
+			found = found || (aws.ToString(got.IndexName) == aws.ToString(index.IndexName) && sameKey(index.KeySchema, got.KeySchema))
+		}
+		if !found {
+			return incompatible("missing global secondary index " + aws.ToString(index.IndexName))
+		}
+	}
+	return nil
+}
+
+func sameKey(want, got []types.KeySchemaElement) bool {
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if aws.ToString(want[i].AttributeName) != aws.ToString(got[i].AttributeName) || want[i].KeyType != got[i].KeyType {
+			return false
+		}
+	}
+	return true
+}
+
+func ensureTimeToLive(ctx context.Context, client tableAPI, tableName string) error {
+	output, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return fmt.Errorf("describe time to live of %s: %w", tableName, wrapAwsError(err))
+	}
+	if d := output.TimeToLiveDescription; d != nil {
+		switch d.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if aws.ToString(d.AttributeName) != ttlAttribute {
+				return fmt.Errorf("table %s: time to live on %s: %w", tableName, aws.ToString(d.AttributeName), IncompatibleTableError)
+			}
+			return nil
+		}
+	}
+	_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(ttlAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("enable time to live of %s: %w", tableName, wrapAwsError(err))
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package dygraph
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dbuduev/authz-service-go/testutils"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+// tableAPIStub keeps the description of a single table, which becomes active after describing it activeAfter times.
+type tableAPIStub struct {
+	table       *types.TableDescription
+	ttl         *types.TimeToLiveDescription
+	activeAfter int
+	created     int
+	updated     int
+	createErr   error
+}
+
+func (s *tableAPIStub) CreateTable(_ context.Context, input *dynamodb.CreateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	if s.createErr != nil {
+		return nil, s.createErr
+	}
+	s.created++
+	s.table = &types.TableDescription{
+		TableName:           input.TableName,
+		TableStatus:         types.TableStatusCreating,
+		KeySchema:           input.KeySchema,
+		StreamSpecification: input.StreamSpecification,
+	}
+	for _, index := range input.LocalSecondaryIndexes {
+		s.table.LocalSecondaryIndexes = append(s.table.LocalSecondaryIndexes, types.LocalSecondaryIndexDescription{IndexName: index.IndexName, KeySchema: index.KeySchema})
+	}
+	for _, index := range input.GlobalSecondaryIndexes {
+		s.table.GlobalSecondaryIndexes = append(s.table.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{IndexName: index.IndexName, KeySchema: index.KeySchema, IndexStatus: types.IndexStatusCreating})
+	}
+	return &dynamodb.CreateTableOutput{TableDescription: s.table}, nil
+}
+
+func (s *tableAPIStub) DescribeTable(_ context.Context, _ *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	if s.table == nil {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	if s.activeAfter > 0 {
+		s.activeAfter--
+	} else {
+		s.table.TableStatus = types.TableStatusActive
+		for i := range s.table.GlobalSecondaryIndexes {
+			s.table.GlobalSecondaryIndexes[i].IndexStatus = types.IndexStatusActive
+		}
+	}
+	return &dynamodb.DescribeTableOutput{Table: s.table}, nil
+}
+
+func (s *tableAPIStub) UpdateTable(_ context.Context, input *dynamodb.UpdateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error) {
+	s.updated++
+	s.table.StreamSpecification = input.StreamSpecification
+	s.table.TableStatus = types.TableStatusUpdating
+	return &dynamodb.UpdateTableOutput{TableDescription: s.table}, nil
+}
+
+func (s *tableAPIStub) DescribeTimeToLive(_ context.Context, _ *dynamodb.DescribeTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	if s.ttl == nil {
+		return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}}, nil
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: s.ttl}, nil
+}
+
+func (s *tableAPIStub) UpdateTimeToLive(_ context.Context, input *dynamodb.UpdateTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	s.ttl = &types.TimeToLiveDescription{AttributeName: input.TimeToLiveSpecification.AttributeName, TimeToLiveStatus: types.TimeToLiveStatusEnabling}
+	return &dynamodb.UpdateTimeToLiveOutput{TimeToLiveSpecification: input.TimeToLiveSpecification}, nil
+}
+
+func TestEnsureTable(t *testing.T) {
+	options := TableOptions{TimeToLive: true, StreamViewType: types.StreamViewTypeNewAndOldImages, PollInterval: time.Millisecond}
+	stub := &tableAPIStub{activeAfter: 3}
+
+	if err := EnsureTable(context.Background(), stub, "Authorization-test", options); err != nil {
+		t.Fatal(err)
+	}
+	if stub.created != 1 || stub.table.TableStatus != types.TableStatusActive {
+		t.Errorf("EnsureTable() created the table %d times, status %s", stub.created, stub.table.TableStatus)
+	}
+	if stub.ttl == nil || aws.ToString(stub.ttl.AttributeName) != "expiresAt" {
+		t.Errorf("EnsureTable() time to live %v", stub.ttl)
+	}
+	if !aws.ToBool(stub.table.StreamSpecification.StreamEnabled) || stub.updated != 0 {
+		t.Errorf("EnsureTable() stream %v, updated %d times", stub.table.StreamSpecification, stub.updated)
+	}
+
+	// An existing table is left as it is.
+	if err := EnsureTable(context.Background(), stub, "Authorization-test", options); err != nil {
+		t.Fatal(err)
+	}
+	if stub.created != 1 || stub.updated != 0 {
+		t.Errorf("EnsureTable() of an existing table created it %d times, updated %d times", stub.created, stub.updated)
+	}
+}
+
+func TestEnsureTable_EnablesStreamOfExistingTable(t *testing.T) {
+	stub := &tableAPIStub{}
+	if err := EnsureTable(context.Background(), stub, "Authorization-test", TableOptions{PollInterval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if stub.table.StreamSpecification != nil || stub.ttl != nil {
+		t.Errorf("EnsureTable() enabled the stream %v or time to live %v", stub.table.StreamSpecification, stub.ttl)
+	}
+
+	stub.activeAfter = 1
+	if err := EnsureTable(context.Background(), stub, "Authorization-test", TableOptions{StreamViewType: types.StreamViewTypeKeysOnly, PollInterval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if stub.updated != 1 || stub.table.StreamSpecification.StreamViewType != types.StreamViewTypeKeysOnly || stub.table.TableStatus != types.TableStatusActive {
+		t.Errorf("EnsureTable() updated the table %d times, stream %v, status %s", stub.updated, stub.table.StreamSpecification, stub.table.TableStatus)
+	}
+}
+
+func TestEnsureTable_Errors(t *testing.T) {
+	withoutIndex := func() *tableAPIStub {
+		stub := &tableAPIStub{}
+		_, _ = stub.CreateTable(context.Background(), createTableInput("Authorization-test"))
+		stub.table.GlobalSecondaryIndexes = nil
+		return stub
+	}
+	tests := []struct {
+		name    string
+		stub    *tableAPIStub
+		options TableOptions
+		wantErr error
+	}{
+		{"Missing index", withoutIndex(), TableOptions{}, IncompatibleTableError},
+		{"Time to live on another attribute", &tableAPIStub{ttl: &types.TimeToLiveDescription{AttributeName: aws.String("ttl"), TimeToLiveStatus: types.TimeToLiveStatusEnabled}}, TableOptions{TimeToLive: true}, IncompatibleTableError},
+		{"Not active in time", &tableAPIStub{activeAfter: 1000}, TableOptions{Timeout: 10 * time.Millisecond}, TableNotReadyError},
+		{"Failed creation", &tableAPIStub{createErr: &types.LimitExceededException{}}, TableOptions{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.PollInterval = time.Millisecond
+			err := EnsureTable(context.Background(), tt.stub, "Authorization-test", tt.options)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("EnsureTable() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnsureTable_DynamoDBLocal(t *testing.T) {
+	testutils.RequireDynamoDB(t)
+	client := testutils.GetClient()
+	tableName := "Authorization-" + uuid.New().String()
+	defer func() {
+		_, _ = client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(tableName)})
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := EnsureTable(context.Background(), client, tableName, TableOptions{TimeToLive: true, PollInterval: 100 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	graph := CreateGraphClientForTable(client, tableName)
+	node := Node{OrganisationId: uuid.New(), Id: uuid.New(), Type: "ROLE", Data: "Admin"}
+	if err := graph.InsertRecord(context.Background(), &node); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.GetItems(context.Background(), node.OrganisationId); err != nil {
+		t.Errorf("GetItems() on the global index error = %v", err)
+	}
+}
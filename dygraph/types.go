@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"strings"
+	"time"
 )
 
 type Node struct {
@@ -20,6 +21,11 @@ type Edge struct {
 	TargetNodeType string
 	Tags           []string
 	Data           string
+	// ValidFrom and ValidUntil bound the lifetime of the edge, a zero time means no bound.
+	// Both are stored with a second precision. Once ValidUntil passes,
+	// the edge is eventually deleted by DynamoDB TTL, but it is returned by queries until then.
+	ValidFrom  time.Time
+	ValidUntil time.Time
 }
 
 type dto struct {
@@ -29,12 +35,26 @@ type dto struct {
 	Id             string `dynamodbav:"id"`
 	Type           string `dynamodbav:"type"`
 	Data           string `dynamodbav:"data"`
+	ValidFrom      int64  `dynamodbav:"validFrom,omitempty"`
+	// ExpiresAt is the TTL attribute of the table, Unix time in seconds.
+	ExpiresAt int64 `dynamodbav:"expiresAt,omitempty"`
+}
+
+// keyDto holds the primary key attributes of an item.
+type keyDto struct {
+	GlobalId   string `dynamodbav:"globalId"`
+	TypeTarget string `dynamodbav:"typeTarget"`
 }
 
 const separator = "|"
 const nodePrefix = "node_"
 const edgePrefix = "edge_"
 
+// Item returns the node as it is stored.
+func (node *Node) Item() Item {
+	return Item(*node.createNodeDto())
+}
+
 func (node *Node) createNodeDto() *dto {
 	return &dto{
 		GlobalId:       fmt.Sprintf("%s_%s", node.OrganisationId, node.Id),
@@ -55,6 +75,11 @@ func (d *dto) createNode() Node {
 	}
 }
 
+// Item returns the edge as it is stored.
+func (r *Edge) Item() Item {
+	return Item(*r.createEdgeDto())
+}
+
 func (r *Edge) createEdgeDto() *dto {
 	d := &dto{
 		GlobalId:       fmt.Sprintf("%s_%s", r.OrganisationId, r.Id),
@@ -63,6 +88,8 @@ func (r *Edge) createEdgeDto() *dto {
 		Id:             r.Id.String(),
 		Type:           r.TargetNodeType,
 		Data:           r.Data,
+		ValidFrom:      toUnix(r.ValidFrom),
+		ExpiresAt:      toUnix(r.ValidUntil),
 	}
 
 	if r.Tags != nil && len(r.Tags) != 0 {
@@ -80,9 +107,34 @@ func (d *dto) createEdge() Edge {
 		TargetNodeId:   uuid.MustParse(typeTarget[1]),
 		TargetNodeType: d.Type,
 		Data:           d.Data,
+		ValidFrom:      fromUnix(d.ValidFrom),
+		ValidUntil:     fromUnix(d.ExpiresAt),
 	}
 	if len(typeTarget) > 2 {
 		edge.Tags = typeTarget[2:]
 	}
 	return edge
 }
+
+func (d *dto) key() *keyDto {
+	return &keyDto{
+		GlobalId:   d.GlobalId,
+		TypeTarget: d.TypeTarget,
+	}
+}
+
+// toUnix returns zero for the zero time.
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// fromUnix returns the zero time for zero.
+func fromUnix(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
 	"testing"
+	"time"
 )
 
 func Test_dto_createEdge(t *testing.T) {
@@ -19,6 +20,16 @@ func Test_dto_createEdge(t *testing.T) {
 			Tags:           nil,
 			Data:           uuid.New().String(),
 		}},
+		{"Time-bound case", Edge{
+			OrganisationId: uuid.New(),
+			Id:             uuid.New(),
+			TargetNodeId:   uuid.New(),
+			TargetNodeType: "USER",
+			Tags:           []string{"ASSIGNED_IN_BRANCH", "b1"},
+			Data:           "b1",
+			ValidFrom:      time.Date(2021, 5, 1, 9, 0, 0, 0, time.UTC),
+			ValidUntil:     time.Date(2021, 5, 8, 17, 30, 0, 0, time.UTC),
+		}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -10,4 +10,5 @@ require (
 	github.com/go-chi/chi/v5 v5.0.2
 	github.com/google/go-cmp v0.5.5
 	github.com/google/uuid v1.2.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -1,7 +1,9 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/dbuduev/authz-service-go/core"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -10,7 +12,9 @@ import (
 
 type (
 	branchRepository interface {
-		AddBranch(b core.Branch) error
+		AddBranch(ctx context.Context, b core.Branch) error
+		DeleteBranch(ctx context.Context, organisationId, branchId uuid.UUID) error
+		hierarchyRepository
 	}
 	branchResource struct {
 		repository branchRepository
@@ -44,19 +48,82 @@ func (r branchResource) AddBranch() http.HandlerFunc {
 			return
 		}
 		branch := payload.ToBranch(organisationId)
-		err = r.repository.AddBranch(branch)
+		err = r.repository.AddBranch(ctx, branch)
 		if err != nil {
-			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(writer, err)
 			return
 		}
 		writer.Write([]byte("branch created"))
 	}
 }
 
+func (r branchResource) DeleteBranch() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		branchId, err := uuid.Parse(chi.URLParam(request, BranchIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", BranchIdKey), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.DeleteBranch(ctx, organisationId, branchId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writer.Write([]byte("branch deleted"))
+	}
+}
+
+// GetBranchGroupsOfBranch responds with the branch groups the branch belongs to.
+// Names are included if the names query parameter is true.
+func (r branchResource) GetBranchGroupsOfBranch() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		branchId, err := uuid.Parse(chi.URLParam(request, BranchIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", BranchIdKey), http.StatusBadRequest)
+			return
+		}
+		withNames, err := parseBool(request.URL.Query().Get("names"))
+		if err != nil {
+			http.Error(writer, "names should be boolean", http.StatusBadRequest)
+			return
+		}
+		hierarchy, err := r.repository.GetHierarchy(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		names, err := getNames(ctx, r.repository, organisationId, withNames)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(toNamedIds(hierarchy.Reverse()[branchId], names))
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
 func CreateBranchResourceRouter(repository branchRepository) func(r chi.Router) {
 	res := &branchResource{repository: repository}
 
 	return func(r chi.Router) {
 		r.Post("/", res.AddBranch())
+		r.Delete(fmt.Sprintf("/{%s}", BranchIdKey), res.DeleteBranch())
+		r.Get(fmt.Sprintf("/{%s}/groups", BranchIdKey), res.GetBranchGroupsOfBranch())
 	}
 }
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/dbuduev/authz-service-go/core"
@@ -13,10 +14,12 @@ import (
 
 type (
 	BranchGroupRepository interface {
-		AddBranchGroup(g core.BranchGroup) error
-		AssignBranchToBranchGroup(x core.BranchAssignment) error
-		GetBranchesByBranchGroup(organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error)
-		GetHierarchy(organisationId uuid.UUID) (sphinx.BranchGroupContent, error)
+		AddBranchGroup(ctx context.Context, g core.BranchGroup) error
+		AssignBranchToBranchGroup(ctx context.Context, x core.BranchAssignment) error
+		GetBranchesByBranchGroup(ctx context.Context, organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error)
+		GetHierarchy(ctx context.Context, organisationId uuid.UUID) (sphinx.BranchGroupContent, error)
+		RemoveBranchFromBranchGroup(ctx context.Context, x core.BranchAssignment) error
+		DeleteBranchGroup(ctx context.Context, organisationId, branchGroupId uuid.UUID) error
 	}
 	BranchGroupResource struct {
 		repository BranchGroupRepository
@@ -61,9 +64,9 @@ func (r BranchGroupResource) AddBranchGroup() http.HandlerFunc {
 			return
 		}
 		branchGroup := payload.To(organisationId)
-		err = r.repository.AddBranchGroup(branchGroup)
+		err = r.repository.AddBranchGroup(ctx, branchGroup)
 		if err != nil {
-			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(writer, err)
 			return
 		}
 		_, _ = writer.Write([]byte("branchGroup created"))
@@ -90,9 +93,9 @@ func (r BranchGroupResource) AssignBranchToBranchGroup() http.HandlerFunc {
 			return
 		}
 		branchAssignment := payload.To(organisationId, branchGroupId)
-		err = r.repository.AssignBranchToBranchGroup(branchAssignment)
+		err = r.repository.AssignBranchToBranchGroup(ctx, branchAssignment)
 		if err != nil {
-			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(writer, err)
 			return
 		}
 		_, _ = io.WriteString(writer, "branchAssignment created")
@@ -112,9 +115,9 @@ func (r BranchGroupResource) GetBranchesByBranchGroup() http.HandlerFunc {
 			http.Error(writer, fmt.Sprintf("%s should UUID", BranchGroupIdKey), http.StatusBadRequest)
 			return
 		}
-		branches, err := r.repository.GetBranchesByBranchGroup(organisationId, branchGroupId)
+		branches, err := r.repository.GetBranchesByBranchGroup(ctx, organisationId, branchGroupId)
 		if err != nil {
-			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(writer, err)
 			return
 		}
 		writer.Header().Set("Content-Type", "application/json")
@@ -126,6 +129,59 @@ func (r BranchGroupResource) GetBranchesByBranchGroup() http.HandlerFunc {
 	}
 }
 
+func (r BranchGroupResource) RemoveBranchFromBranchGroup() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		branchGroupId, err := uuid.Parse(chi.URLParam(request, BranchGroupIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", BranchGroupIdKey), http.StatusBadRequest)
+			return
+		}
+		branchId, err := uuid.Parse(chi.URLParam(request, BranchIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", BranchIdKey), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.RemoveBranchFromBranchGroup(ctx, core.BranchAssignment{
+			OrganisationId: organisationId,
+			BranchId:       branchId,
+			BranchGroupId:  branchGroupId,
+		})
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "branchAssignment deleted")
+	}
+}
+
+func (r BranchGroupResource) DeleteBranchGroup() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		branchGroupId, err := uuid.Parse(chi.URLParam(request, BranchGroupIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", BranchGroupIdKey), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.DeleteBranchGroup(ctx, organisationId, branchGroupId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "branchGroup deleted")
+	}
+}
+
 func CreateBranchGroupResourceRouter(repository BranchGroupRepository) func(r chi.Router) {
 	res := &BranchGroupResource{repository: repository}
 
@@ -133,5 +189,7 @@ func CreateBranchGroupResourceRouter(repository BranchGroupRepository) func(r ch
 		r.Post("/", res.AddBranchGroup())
 		r.Put(fmt.Sprintf("/{%s}", BranchGroupIdKey), res.AssignBranchToBranchGroup())
 		r.Get(fmt.Sprintf("/{%s}", BranchGroupIdKey), res.GetBranchesByBranchGroup())
+		r.Delete(fmt.Sprintf("/{%s}", BranchGroupIdKey), res.DeleteBranchGroup())
+		r.Delete(fmt.Sprintf("/{%s}/branch/{%s}", BranchGroupIdKey, BranchIdKey), res.RemoveBranchFromBranchGroup())
 	}
 }
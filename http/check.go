@@ -0,0 +1,138 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// maxBatchChecks bounds the checks of a batch, a larger batch is rejected with 413 Request Entity Too Large.
+const maxBatchChecks = 100
+
+type (
+	authoriser interface {
+		Check(ctx context.Context, organisationId, userId, opId, branchId uuid.UUID) (core.Decision, error)
+		CheckMany(ctx context.Context, organisationId uuid.UUID, requests []core.CheckRequest) ([]core.Decision, error)
+	}
+	checkResource struct {
+		authoriser authoriser
+	}
+	checkRequest struct {
+		UserId      uuid.UUID `json:"user_id"`
+		OperationId uuid.UUID `json:"operation_id"`
+		BranchId    uuid.UUID `json:"branch_id"`
+	}
+	checkBatchRequest struct {
+		Checks []checkRequest `json:"checks"`
+	}
+	checkBatchResponse struct {
+		Decisions []checkResponse `json:"decisions"`
+	}
+	checkResponse struct {
+		Allowed   bool      `json:"allowed"`
+		Reason    string    `json:"reason"`
+		RoleId    uuid.UUID `json:"role_id"`
+		GrantedIn uuid.UUID `json:"granted_in"`
+		DeniedIn  uuid.UUID `json:"denied_in"`
+	}
+)
+
+func (r checkRequest) To() core.CheckRequest {
+	return core.CheckRequest{
+		UserId:      r.UserId,
+		OperationId: r.OperationId,
+		BranchId:    r.BranchId,
+	}
+}
+
+func toCheckResponse(d core.Decision) checkResponse {
+	return checkResponse{
+		Allowed:   d.Allowed,
+		Reason:    string(d.Reason),
+		RoleId:    d.RoleId,
+		GrantedIn: d.GrantedIn,
+		DeniedIn:  d.DeniedIn,
+	}
+}
+
+func (r checkResource) Check() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		payload := &checkRequest{}
+		err := json.NewDecoder(request.Body).Decode(payload)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		check := payload.To()
+		decision, err := r.authoriser.Check(ctx, organisationId, check.UserId, check.OperationId, check.BranchId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(toCheckResponse(decision))
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (r checkResource) CheckMany() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		payload := &checkBatchRequest{}
+		err := json.NewDecoder(request.Body).Decode(payload)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if len(payload.Checks) > maxBatchChecks {
+			http.Error(writer, fmt.Sprintf("A batch must have at most %d checks.", maxBatchChecks), http.StatusRequestEntityTooLarge)
+			return
+		}
+		checks := make([]core.CheckRequest, len(payload.Checks))
+		for i, check := range payload.Checks {
+			checks[i] = check.To()
+		}
+		decisions, err := r.authoriser.CheckMany(ctx, organisationId, checks)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		response := checkBatchResponse{Decisions: make([]checkResponse, len(decisions))}
+		for i, decision := range decisions {
+			response.Decisions[i] = toCheckResponse(decision)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(response)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func CreateCheckResourceRouter(authoriser authoriser) func(r chi.Router) {
+	res := &checkResource{authoriser: authoriser}
+
+	return func(r chi.Router) {
+		r.Post("/", res.Check())
+		r.Post("/batch", res.CheckMany())
+	}
+}
@@ -0,0 +1,28 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckResource_CheckManyRejectsLargeBatch(t *testing.T) {
+	payload, err := json.Marshal(checkBatchRequest{Checks: make([]checkRequest, maxBatchChecks+1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/batch", bytes.NewReader(payload))
+	request = request.WithContext(context.WithValue(request.Context(), OrganisationIdKey, uuid.New()))
+	recorder := httptest.NewRecorder()
+
+	// The batch is rejected before any check, so no authoriser is needed.
+	checkResource{}.CheckMany()(recorder, request)
+
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("CheckMany() = %d %v, want %d", recorder.Code, recorder.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,37 @@
+package http
+
+import (
+	"errors"
+	"github.com/dbuduev/authz-service-go/core"
+	"net/http"
+)
+
+// statusOf maps an error to the HTTP status code reported to the client.
+func statusOf(err error) int {
+	err = core.Classify(err)
+	switch {
+	case errors.Is(err, core.InvalidInputError):
+		return http.StatusBadRequest
+	case errors.Is(err, core.NotFoundError):
+		return http.StatusNotFound
+	case errors.Is(err, core.ReferenceNotFoundError):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, core.ConflictError):
+		return http.StatusConflict
+	case errors.Is(err, core.ThrottledError):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// writeError replies to the request with the status code the error maps to.
+func writeError(writer http.ResponseWriter, err error) {
+	status := statusOf(err)
+	http.Error(writer, http.StatusText(status), status)
+}
+
+// isNotFound reports whether the error means the requested item does not exist.
+func isNotFound(err error) bool {
+	return err != nil && errors.Is(core.Classify(err), core.NotFoundError)
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"net/http"
+	"testing"
+)
+
+func Test_statusOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"Invalid input", &core.Error{Kind: core.InvalidInputError}, http.StatusBadRequest},
+		{"Not found", &core.Error{Kind: core.NotFoundError}, http.StatusNotFound},
+		{"Missing reference", fmt.Errorf("OP 1: %w", dygraph.ReferenceNotFoundError), http.StatusUnprocessableEntity},
+		{"Duplicate", fmt.Errorf("insert record: %w", dygraph.DuplicateError), http.StatusConflict},
+		{"Throttled", fmt.Errorf("get nodes: %w", dygraph.TooManyRequestsError), http.StatusTooManyRequests},
+		{"Unknown", errors.New("connection refused"), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusOf(tt.err); got != tt.want {
+				t.Errorf("statusOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
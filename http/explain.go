@@ -0,0 +1,111 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+type (
+	explainer interface {
+		Explain(ctx context.Context, organisationId, userId, opId, branchId uuid.UUID) (core.Explanation, error)
+	}
+	explainRepository interface {
+		GetAllRoles(ctx context.Context, organisationId uuid.UUID) ([]core.Role, error)
+	}
+	explainResource struct {
+		repository explainRepository
+		explainer  explainer
+	}
+	explainResponse struct {
+		Decision       checkResponse                  `json:"decision"`
+		Roles          []uuid.UUID                    `json:"roles"`
+		IncludingRoles []uuid.UUID                    `json:"including_roles"`
+		BranchGroups   []uuid.UUID                    `json:"branch_groups"`
+		Assignments    []consideredAssignmentResponse `json:"assignments"`
+	}
+	consideredAssignmentResponse struct {
+		Assignment   assignmentResponse `json:"assignment"`
+		InEffect     bool               `json:"in_effect"`
+		HasOperation bool               `json:"has_operation"`
+		Covers       string             `json:"covers"`
+		Decisive     bool               `json:"decisive"`
+	}
+)
+
+func toExplainResponse(e core.Explanation, names map[uuid.UUID]string, now time.Time) explainResponse {
+	result := explainResponse{
+		Decision:       toCheckResponse(e.Decision),
+		Roles:          nonNil(e.Roles),
+		IncludingRoles: nonNil(e.IncludingRoles),
+		BranchGroups:   nonNil(e.BranchGroups),
+		Assignments:    make([]consideredAssignmentResponse, len(e.Assignments)),
+	}
+	for i, a := range e.Assignments {
+		result.Assignments[i] = consideredAssignmentResponse{
+			Assignment:   toAssignmentResponse(a.Assignment, names[a.Assignment.RoleId], now),
+			InEffect:     a.InEffect,
+			HasOperation: a.HasOperation,
+			Covers:       string(a.Covers),
+			Decisive:     a.Decisive,
+		}
+	}
+	return result
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil(ids []uuid.UUID) []uuid.UUID {
+	if ids == nil {
+		return []uuid.UUID{}
+	}
+	return ids
+}
+
+func (r explainResource) Explain() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		payload := &checkRequest{}
+		err := json.NewDecoder(request.Body).Decode(payload)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		explanation, err := r.explainer.Explain(ctx, organisationId, payload.UserId, payload.OperationId, payload.BranchId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		roles, err := r.repository.GetAllRoles(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		names := make(map[uuid.UUID]string, len(roles))
+		for _, role := range roles {
+			names[role.Id] = role.Name
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(toExplainResponse(explanation, names, time.Now()))
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func CreateExplainResourceRouter(repository explainRepository, explainer explainer) func(r chi.Router) {
+	res := &explainResource{repository: repository, explainer: explainer}
+
+	return func(r chi.Router) {
+		r.Post("/", res.Explain())
+	}
+}
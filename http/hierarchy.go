@@ -0,0 +1,149 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/dbuduev/authz-service-go/sphinx"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+type (
+	hierarchyRepository interface {
+		GetHierarchy(ctx context.Context, organisationId uuid.UUID) (sphinx.BranchGroupContent, error)
+		GetAllBranches(ctx context.Context, organisationId uuid.UUID) ([]core.Branch, error)
+		GetAllBranchGroups(ctx context.Context, organisationId uuid.UUID) ([]core.BranchGroup, error)
+	}
+	hierarchyResource struct {
+		repository hierarchyRepository
+	}
+	namedIdResponse struct {
+		Id   uuid.UUID `json:"id"`
+		Name string    `json:"name,omitempty"`
+	}
+	branchGroupContentResponse struct {
+		Id           uuid.UUID         `json:"id"`
+		Name         string            `json:"name,omitempty"`
+		Branches     []namedIdResponse `json:"branches"`
+		BranchGroups []namedIdResponse `json:"branch_groups"`
+	}
+)
+
+// GetHierarchy responds with every branch group of the organisation and the branches and branch groups it contains.
+// Names are included if the names query parameter is true.
+func (r hierarchyResource) GetHierarchy() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		withNames, err := parseBool(request.URL.Query().Get("names"))
+		if err != nil {
+			http.Error(writer, "names should be boolean", http.StatusBadRequest)
+			return
+		}
+		hierarchy, err := r.repository.GetHierarchy(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		allGroups, err := r.repository.GetAllBranchGroups(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		var names map[uuid.UUID]string
+		if withNames {
+			branches, err := r.repository.GetAllBranches(ctx, organisationId)
+			if err != nil {
+				writeError(writer, err)
+				return
+			}
+			names = namesOf(branches, allGroups)
+		}
+		isGroup := make(map[uuid.UUID]bool, len(allGroups))
+		for _, g := range allGroups {
+			isGroup[g.Id] = true
+		}
+
+		groups := make([]uuid.UUID, 0, len(hierarchy))
+		for group := range hierarchy {
+			groups = append(groups, group)
+		}
+		result := make([]branchGroupContentResponse, len(groups))
+		for i, group := range toNamedIds(groups, names) {
+			var branches, nested []uuid.UUID
+			for _, member := range hierarchy[group.Id] {
+				if isGroup[member] {
+					nested = append(nested, member)
+				} else {
+					branches = append(branches, member)
+				}
+			}
+			result[i] = branchGroupContentResponse{
+				Id:           group.Id,
+				Name:         group.Name,
+				Branches:     toNamedIds(branches, names),
+				BranchGroups: toNamedIds(nested, names),
+			}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(result)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getNames returns the names of all the branches and branch groups of the organisation.
+// It returns nil without querying the repository unless withNames is true.
+func getNames(ctx context.Context, repository hierarchyRepository, organisationId uuid.UUID, withNames bool) (map[uuid.UUID]string, error) {
+	if !withNames {
+		return nil, nil
+	}
+	branches, err := repository.GetAllBranches(ctx, organisationId)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := repository.GetAllBranchGroups(ctx, organisationId)
+	if err != nil {
+		return nil, err
+	}
+	return namesOf(branches, groups), nil
+}
+
+// namesOf returns the names of the branches and branch groups by id.
+func namesOf(branches []core.Branch, groups []core.BranchGroup) map[uuid.UUID]string {
+	result := make(map[uuid.UUID]string, len(branches)+len(groups))
+	for _, b := range branches {
+		result[b.Id] = b.Name
+	}
+	for _, g := range groups {
+		result[g.Id] = g.Name
+	}
+	return result
+}
+
+// toNamedIds returns the ids with their names sorted by id.
+func toNamedIds(ids []uuid.UUID, names map[uuid.UUID]string) []namedIdResponse {
+	sorted := append([]uuid.UUID(nil), ids...)
+	sphinx.Sort(sorted)
+	result := make([]namedIdResponse, len(sorted))
+	for i, id := range sorted {
+		result[i] = namedIdResponse{Id: id, Name: names[id]}
+	}
+	return result
+}
+
+func CreateHierarchyResourceRouter(repository hierarchyRepository) func(r chi.Router) {
+	res := &hierarchyResource{repository: repository}
+
+	return func(r chi.Router) {
+		r.Get("/", res.GetHierarchy())
+	}
+}
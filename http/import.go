@@ -0,0 +1,239 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	importRepository interface {
+		Import(ctx context.Context, organisationId uuid.UUID, doc core.ImportDocument, progress func(core.ImportProgress)) (core.ImportReport, error)
+	}
+	importResource struct {
+		repository importRepository
+	}
+	importNode struct {
+		Id   uuid.UUID `json:"id" yaml:"id"`
+		Name string    `json:"name" yaml:"name"`
+	}
+	importOperationAssignment struct {
+		RoleId      uuid.UUID `json:"role_id" yaml:"role_id"`
+		OperationId uuid.UUID `json:"operation_id" yaml:"operation_id"`
+	}
+	importRoleInclusion struct {
+		RoleId         uuid.UUID `json:"role_id" yaml:"role_id"`
+		IncludedRoleId uuid.UUID `json:"included_role_id" yaml:"included_role_id"`
+	}
+	importMembership struct {
+		BranchGroupId uuid.UUID `json:"branch_group_id" yaml:"branch_group_id"`
+		// BranchId is either a branch or a nested branch group.
+		BranchId uuid.UUID `json:"branch_id" yaml:"branch_id"`
+	}
+	importUserAssignment struct {
+		UserId           uuid.UUID  `json:"user_id" yaml:"user_id"`
+		RoleId           uuid.UUID  `json:"role_id" yaml:"role_id"`
+		BranchId         uuid.UUID  `json:"branch_id" yaml:"branch_id"`
+		OrganisationWide bool       `json:"organisation_wide" yaml:"organisation_wide"`
+		Deny             bool       `json:"deny" yaml:"deny"`
+		ValidFrom        *time.Time `json:"valid_from,omitempty" yaml:"valid_from"`
+		ValidUntil       *time.Time `json:"valid_until,omitempty" yaml:"valid_until"`
+	}
+	importRequest struct {
+		Operations           []importNode                `json:"operations" yaml:"operations"`
+		Roles                []importNode                `json:"roles" yaml:"roles"`
+		Branches             []importNode                `json:"branches" yaml:"branches"`
+		BranchGroups         []importNode                `json:"branch_groups" yaml:"branch_groups"`
+		OperationAssignments []importOperationAssignment `json:"operation_assignments" yaml:"operation_assignments"`
+		RoleInclusions       []importRoleInclusion       `json:"role_inclusions" yaml:"role_inclusions"`
+		Memberships          []importMembership          `json:"memberships" yaml:"memberships"`
+		UserAssignments      []importUserAssignment      `json:"user_assignments" yaml:"user_assignments"`
+	}
+	importProblemResponse struct {
+		Kind  string `json:"kind"`
+		Index int    `json:"index"`
+		// Status is the status code the item would be rejected with if added on its own.
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	}
+	importResponse struct {
+		Total    int                     `json:"total"`
+		Created  int                     `json:"created"`
+		Existing int                     `json:"existing"`
+		Problems []importProblemResponse `json:"problems"`
+		// Error is the error the import stopped with after the progress was reported.
+		Error string `json:"error,omitempty"`
+	}
+	importProgressResponse struct {
+		Done  int `json:"done"`
+		Total int `json:"total"`
+	}
+)
+
+func (r importRequest) To(organisationId uuid.UUID) core.ImportDocument {
+	var doc core.ImportDocument
+	for _, op := range r.Operations {
+		doc.Operations = append(doc.Operations, core.Operation{Id: op.Id, Name: op.Name})
+	}
+	for _, role := range r.Roles {
+		doc.Roles = append(doc.Roles, core.Role{OrganisationId: organisationId, Id: role.Id, Name: role.Name})
+	}
+	for _, b := range r.Branches {
+		doc.Branches = append(doc.Branches, core.Branch{OrganisationId: organisationId, Id: b.Id, Name: b.Name})
+	}
+	for _, g := range r.BranchGroups {
+		doc.BranchGroups = append(doc.BranchGroups, core.BranchGroup{OrganisationId: organisationId, Id: g.Id, Name: g.Name})
+	}
+	for _, x := range r.OperationAssignments {
+		doc.OperationAssignments = append(doc.OperationAssignments, core.OperationAssignment{
+			OrganisationId: organisationId,
+			RoleId:         x.RoleId,
+			OperationId:    x.OperationId,
+		})
+	}
+	for _, x := range r.RoleInclusions {
+		doc.RoleInclusions = append(doc.RoleInclusions, core.RoleInclusion{
+			OrganisationId: organisationId,
+			RoleId:         x.RoleId,
+			IncludedRoleId: x.IncludedRoleId,
+		})
+	}
+	for _, x := range r.Memberships {
+		doc.Memberships = append(doc.Memberships, core.BranchAssignment{
+			OrganisationId: organisationId,
+			BranchId:       x.BranchId,
+			BranchGroupId:  x.BranchGroupId,
+		})
+	}
+	for _, x := range r.UserAssignments {
+		assignment := core.UserRoleAssignment{
+			OrganisationId:   organisationId,
+			RoleId:           x.RoleId,
+			UserId:           x.UserId,
+			BranchId:         x.BranchId,
+			OrganisationWide: x.OrganisationWide,
+			Deny:             x.Deny,
+		}
+		if x.ValidFrom != nil {
+			assignment.ValidFrom = *x.ValidFrom
+		}
+		if x.ValidUntil != nil {
+			assignment.ValidUntil = *x.ValidUntil
+		}
+		doc.UserAssignments = append(doc.UserAssignments, assignment)
+	}
+	return doc
+}
+
+func toImportResponse(report core.ImportReport) importResponse {
+	result := importResponse{
+		Total:    report.Total,
+		Created:  report.Created,
+		Existing: report.Existing,
+		Problems: make([]importProblemResponse, len(report.Problems)),
+	}
+	for i, problem := range report.Problems {
+		result.Problems[i] = importProblemResponse{
+			Kind:   problem.Item.Kind,
+			Index:  problem.Item.Index,
+			Status: statusOf(problem.Err),
+			Error:  problem.Err.Error(),
+		}
+	}
+	return result
+}
+
+// decodeImportRequest reads the document as YAML if the content type says so, as JSON otherwise.
+// Unknown fields are rejected either way.
+func decodeImportRequest(request *http.Request) (importRequest, error) {
+	var payload importRequest
+	if strings.Contains(request.Header.Get("Content-Type"), "yaml") {
+		decoder := yaml.NewDecoder(request.Body)
+		decoder.KnownFields(true)
+		err := decoder.Decode(&payload)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		return payload, err
+	}
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	return payload, decoder.Decode(&payload)
+}
+
+// Import imports the roles, the operations, the branches, the branch groups and the assignments
+// of the document into the organisation, see Repository.Import.
+// It replies with the report of the import, listing the items which are invalid, with 400 Bad Request,
+// or which could not be written.
+// With progress=true the reply is a stream of JSON lines, one after every chunk of items written,
+// followed by the report. Once the stream has started, an error is reported in the error field of the report
+// as the status is sent already.
+func (r importResource) Import() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		payload, err := decodeImportRequest(request)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		var progress func(core.ImportProgress)
+		streaming := false
+		encoder := json.NewEncoder(writer)
+		if request.URL.Query().Get("progress") == "true" {
+			flusher, _ := writer.(http.Flusher)
+			progress = func(p core.ImportProgress) {
+				streaming = true
+				writer.Header().Set("Content-Type", "application/x-ndjson")
+				_ = encoder.Encode(importProgressResponse(p))
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+		report, err := r.repository.Import(ctx, organisationId, payload.To(organisationId), progress)
+		if streaming {
+			result := toImportResponse(report)
+			if err != nil {
+				result.Error = err.Error()
+			}
+			_ = encoder.Encode(result)
+			return
+		}
+		if err != nil && len(report.Problems) == 0 {
+			writeError(writer, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			// The document is invalid, nothing was written.
+			writer.WriteHeader(statusOf(err))
+		}
+		err = encoder.Encode(toImportResponse(report))
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func CreateImportResourceRouter(repository importRepository) func(r chi.Router) {
+	res := &importResource{repository: repository}
+
+	return func(r chi.Router) {
+		r.Post("/", res.Import())
+	}
+}
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingImport reports the progress of a chunk and fails writing the next one.
+type failingImport struct{}
+
+func (failingImport) Import(_ context.Context, _ uuid.UUID, _ core.ImportDocument, progress func(core.ImportProgress)) (core.ImportReport, error) {
+	progress(core.ImportProgress{Done: 25, Total: 40})
+	return core.ImportReport{Total: 40, Created: 25}, fmt.Errorf("write chunk: %w", dygraph.TooManyRequestsError)
+}
+
+func TestImportResource_ImportFailsAfterProgress(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/?progress=true", strings.NewReader(`{}`))
+	request = request.WithContext(context.WithValue(request.Context(), OrganisationIdKey, uuid.New()))
+	recorder := httptest.NewRecorder()
+
+	importResource{repository: failingImport{}}.Import()(recorder, request)
+
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if recorder.Code != http.StatusOK || len(lines) != 2 || recorder.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Import() = %d %v %v", recorder.Code, recorder.Header(), lines)
+	}
+	var report importResponse
+	if err := json.Unmarshal([]byte(lines[1]), &report); err != nil {
+		t.Fatal(err)
+	}
+	want := importResponse{Total: 40, Created: 25, Problems: []importProblemResponse{}, Error: "write chunk: " + dygraph.TooManyRequestsError.Error()}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("Import() report diff %v", diff)
+	}
+}
@@ -2,16 +2,24 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"github.com/dbuduev/authz-service-go/core"
 	"github.com/dbuduev/authz-service-go/dygraph"
 	"github.com/dbuduev/authz-service-go/repository"
+	"github.com/dbuduev/authz-service-go/sphinx"
 	"github.com/dbuduev/authz-service-go/testutils"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type testClient struct {
@@ -81,6 +89,105 @@ func (c *testClient) GetBranchesByBranchGroup(branchGroupId uuid.UUID) []uuid.UU
 	return result
 }
 
+func (c *testClient) Check(check checkRequest) checkResponse {
+	buf, _ := json.Marshal(check)
+	res, err := c.client.Post(c.url+"/check", "application/json", bytes.NewBuffer(buf))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var result checkResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return result
+}
+
+func (c *testClient) CheckMany(checks checkBatchRequest) checkBatchResponse {
+	buf, _ := json.Marshal(checks)
+	res, err := c.client.Post(c.url+"/check/batch", "application/json", bytes.NewBuffer(buf))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var result checkBatchResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return result
+}
+
+func (c *testClient) Explain(check checkRequest) explainResponse {
+	buf, _ := json.Marshal(check)
+	res, err := c.client.Post(c.url+"/explain", "application/json", bytes.NewBuffer(buf))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.t.Fatalf("POST /explain status = %d", res.StatusCode)
+	}
+	var result explainResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return result
+}
+
+// newOperation returns an operation of a name unique to the test as the operation catalogue is shared by the tests.
+func newOperation(name string) operationCreateRequest {
+	id := uuid.New()
+	return operationCreateRequest{id, name + "-" + id.String()}
+}
+
+// catalogue returns the client of the operation catalogue which is served outside the organisation.
+func (c *testClient) catalogue() *testClient {
+	return &testClient{c.client, c.url[:strings.LastIndex(c.url, "/")] + "/operation", c.t}
+}
+
+// AddOperation adds the operation to the operation catalogue.
+func (c *testClient) AddOperation(op operationCreateRequest) {
+	c.catalogue().send(http.MethodPost, "", op, http.StatusOK)
+}
+
+// send sends the payload encoded as JSON and fails the test unless the response status is wantStatus.
+func (c *testClient) send(method, path string, payload interface{}, wantStatus int) {
+	var body bytes.Buffer
+	if payload != nil {
+		_ = json.NewEncoder(&body).Encode(payload)
+	}
+	req, err := http.NewRequest(method, c.url+path, &body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != wantStatus {
+		c.t.Fatalf("%s %s status = %d, want %d", method, path, res.StatusCode, wantStatus)
+	}
+}
+
+// getJSON decodes the response to a GET request into out.
+func (c *testClient) getJSON(path string, out interface{}) {
+	res, err := c.client.Get(c.url + path)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.t.Fatalf("GET %s status = %d", path, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
 func TestBranchesAndBranchGroups(t *testing.T) {
 	trans := cmp.Transformer("Sort", func(in []uuid.UUID) []uuid.UUID {
 		out := append([]uuid.UUID(nil), in...) // Copy input to avoid mutating it
@@ -119,10 +226,782 @@ func TestBranchesAndBranchGroups(t *testing.T) {
 	}
 }
 
-func CreateTestGraphClient() *dygraph.Dygraph {
-	return dygraph.CreateGraphClient(testutils.GetClient(), "test")
+func TestCheck(t *testing.T) {
+	repo := CreateTestRepository()
+	server := httptest.NewServer(ConfigureHandler(repo))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	albany := branchCreateRequest{uuid.New(), "Albany"}
+	client.AddBranch(albany)
+	milford := branchCreateRequest{uuid.New(), "Milford"}
+	client.AddBranch(milford)
+	branchGroup := branchGroupCreateRequest{uuid.New(), "Auckland"}
+	client.AddBranchGroup(branchGroup)
+	client.AssignBranchToBranchGroup(branchGroup.Id, assignBranchRequest{BranchId: albany.Id})
+
+	op := newOperation("view-member").To()
+	role := core.Role{OrganisationId: orgId, Id: uuid.New(), Name: "Staff"}
+	userId := uuid.New()
+	for _, err := range []error{
+		repo.AddOperation(context.Background(), op),
+		repo.AddRole(context.Background(), role),
+		repo.AssignOperationToRole(context.Background(), core.OperationAssignment{OrganisationId: orgId, RoleId: role.Id, OperationId: op.Id}),
+		repo.AssignRoleToUser(context.Background(), core.UserRoleAssignment{OrganisationId: orgId, RoleId: role.Id, UserId: userId, BranchId: branchGroup.Id}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		branchId uuid.UUID
+		want     checkResponse
+	}{
+		{
+			name:     "Granted in the branch group",
+			branchId: albany.Id,
+			want:     checkResponse{Allowed: true, Reason: string(core.ReasonGrantedInBranchGroup), RoleId: role.Id, GrantedIn: branchGroup.Id},
+		},
+		{
+			name:     "Not granted",
+			branchId: milford.Id,
+			want:     checkResponse{Reason: string(core.ReasonNotGranted)},
+		},
+	}
+	batch := checkBatchRequest{}
+	batchWant := checkBatchResponse{}
+	for _, tt := range tests {
+		batch.Checks = append(batch.Checks, checkRequest{UserId: userId, OperationId: op.Id, BranchId: tt.branchId})
+		batchWant.Decisions = append(batchWant.Decisions, tt.want)
+		t.Run(tt.name, func(t *testing.T) {
+			got := client.Check(checkRequest{UserId: userId, OperationId: op.Id, BranchId: tt.branchId})
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Check() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	if diff := cmp.Diff(batchWant, client.CheckMany(batch)); diff != "" {
+		t.Errorf("CheckMany() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// CreateTestGraphClient returns DynamoDB Local backed graph if testutils.UseDynamoDB is true,
+// otherwise it returns an in-memory graph.
+func TestExplain(t *testing.T) {
+	repo := CreateTestRepository()
+	server := httptest.NewServer(ConfigureHandler(repo))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	albany := branchCreateRequest{uuid.New(), "Albany"}
+	client.AddBranch(albany)
+	milford := branchCreateRequest{uuid.New(), "Milford"}
+	client.AddBranch(milford)
+	branchGroup := branchGroupCreateRequest{uuid.New(), "Auckland"}
+	client.AddBranchGroup(branchGroup)
+	client.AssignBranchToBranchGroup(branchGroup.Id, assignBranchRequest{BranchId: albany.Id})
+
+	op := newOperation("approve-refund").To()
+	role := core.Role{OrganisationId: orgId, Id: uuid.New(), Name: "Supervisor"}
+	userId := uuid.New()
+	for _, err := range []error{
+		repo.AddOperation(context.Background(), op),
+		repo.AddRole(context.Background(), role),
+		repo.AssignOperationToRole(context.Background(), core.OperationAssignment{OrganisationId: orgId, RoleId: role.Id, OperationId: op.Id}),
+		repo.AssignRoleToUser(context.Background(), core.UserRoleAssignment{OrganisationId: orgId, RoleId: role.Id, UserId: userId, BranchId: branchGroup.Id}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assignment := consideredAssignmentResponse{
+		Assignment:   assignmentResponse{RoleId: role.Id, RoleName: role.Name, BranchId: branchGroup.Id},
+		InEffect:     true,
+		HasOperation: true,
+	}
+	granted, notGranted := assignment, assignment
+	granted.Covers, granted.Decisive = string(core.CoverageBranchGroup), true
+	notGranted.Covers = string(core.CoverageNone)
+	tests := []struct {
+		name     string
+		branchId uuid.UUID
+		want     explainResponse
+	}{
+		{
+			name:     "Granted in the branch group",
+			branchId: albany.Id,
+			want: explainResponse{
+				Decision:       checkResponse{Allowed: true, Reason: string(core.ReasonGrantedInBranchGroup), RoleId: role.Id, GrantedIn: branchGroup.Id},
+				Roles:          []uuid.UUID{role.Id},
+				IncludingRoles: []uuid.UUID{},
+				BranchGroups:   []uuid.UUID{branchGroup.Id},
+				Assignments:    []consideredAssignmentResponse{granted},
+			},
+		},
+		{
+			name:     "Not granted",
+			branchId: milford.Id,
+			want: explainResponse{
+				Decision:       checkResponse{Reason: string(core.ReasonNotGranted)},
+				Roles:          []uuid.UUID{role.Id},
+				IncludingRoles: []uuid.UUID{},
+				BranchGroups:   []uuid.UUID{},
+				Assignments:    []consideredAssignmentResponse{notGranted},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := client.Explain(checkRequest{UserId: userId, OperationId: op.Id, BranchId: tt.branchId})
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Explain() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	client.send(http.MethodPost, "/explain", checkRequest{UserId: userId, BranchId: albany.Id}, http.StatusBadRequest)
+}
+
+func TestRolesAndOperations(t *testing.T) {
+	trans := cmp.Transformer("Sort", func(in []uuid.UUID) []uuid.UUID {
+		out := append([]uuid.UUID(nil), in...) // Copy input to avoid mutating it
+		sphinx.Sort(out)
+		return out
+	})
+	sortRoles := cmp.Transformer("Sort", func(in []roleResponse) []roleResponse {
+		out := append([]roleResponse(nil), in...) // Copy input to avoid mutating it
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].Name < out[j].Name
+		})
+		return out
+	})
+
+	server := httptest.NewServer(ConfigureHandler(CreateTestRepository()))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	admin := roleCreateRequest{uuid.New(), "Admin"}
+	staff := roleCreateRequest{uuid.New(), "Staff"}
+	manage := newOperation("manage-member")
+	view := newOperation("view-member")
+	client.send(http.MethodPost, "/role", admin, http.StatusOK)
+	client.send(http.MethodPost, "/role", staff, http.StatusOK)
+	client.send(http.MethodPost, "/role", staff, http.StatusConflict)
+	client.send(http.MethodPost, "/role", roleCreateRequest{uuid.New(), staff.Name}, http.StatusConflict)
+	client.AddOperation(manage)
+	client.AddOperation(view)
+	client.send(http.MethodPut, "/role/"+admin.Id.String()+"/operation", assignOperationRequest{manage.Id}, http.StatusOK)
+	client.send(http.MethodPut, "/role/"+admin.Id.String()+"/operation", assignOperationRequest{view.Id}, http.StatusOK)
+	client.send(http.MethodPut, "/role/"+staff.Id.String()+"/operation", assignOperationRequest{view.Id}, http.StatusOK)
+
+	var roles []roleResponse
+	client.getJSON("/role", &roles)
+	if diff := cmp.Diff([]roleResponse{roleResponse(admin), roleResponse(staff)}, roles, sortRoles); diff != "" {
+		t.Errorf("GET /role diff %v", diff)
+	}
+	var role roleResponse
+	client.getJSON("/role/"+staff.Id.String(), &role)
+	if diff := cmp.Diff(roleResponse(staff), role); diff != "" {
+		t.Errorf("GET /role/{roleId} diff %v", diff)
+	}
+	var op operationResponse
+	client.getJSON("/operation/"+view.Id.String(), &op)
+	if diff := cmp.Diff(operationResponse(view), op); diff != "" {
+		t.Errorf("GET /operation/{operationId} diff %v", diff)
+	}
+	var ops []operationResponse
+	client.getJSON("/operation", &ops)
+	found := 0
+	for _, op := range ops {
+		if op == operationResponse(manage) || op == operationResponse(view) {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("GET /operation returned %v", ops)
+	}
+
+	client.getJSON("/role?name="+staff.Name, &roles)
+	if diff := cmp.Diff([]roleResponse{roleResponse(staff)}, roles); diff != "" {
+		t.Errorf("GET /role?name= diff %v", diff)
+	}
+	client.getJSON("/role?name=Manager", &roles)
+	if len(roles) != 0 {
+		t.Errorf("GET /role?name= of a missing role returned %v", roles)
+	}
+	client.getJSON("/operation?name="+url.QueryEscape(view.Name), &ops)
+	if diff := cmp.Diff([]operationResponse{operationResponse(view)}, ops); diff != "" {
+		t.Errorf("GET /operation?name= diff %v", diff)
+	}
+	client.catalogue().send(http.MethodPost, "", operationCreateRequest{uuid.New(), view.Name}, http.StatusConflict)
+
+	var ids []uuid.UUID
+	client.getJSON("/role/"+admin.Id.String()+"/operation", &ids)
+	if diff := cmp.Diff([]uuid.UUID{manage.Id, view.Id}, ids, trans); diff != "" {
+		t.Errorf("GET /role/{roleId}/operation diff %v", diff)
+	}
+	client.getJSON("/operation/"+view.Id.String()+"/role", &ids)
+	if diff := cmp.Diff([]uuid.UUID{admin.Id, staff.Id}, ids, trans); diff != "" {
+		t.Errorf("GET /operation/{operationId}/role diff %v", diff)
+	}
+
+	client.send(http.MethodDelete, "/role/"+admin.Id.String()+"/operation/"+view.Id.String(), nil, http.StatusOK)
+	client.getJSON("/operation/"+view.Id.String()+"/role", &ids)
+	if diff := cmp.Diff([]uuid.UUID{staff.Id}, ids, trans); diff != "" {
+		t.Errorf("GET /operation/{operationId}/role after unassignment diff %v", diff)
+	}
+	client.send(http.MethodDelete, "/role/"+staff.Id.String(), nil, http.StatusOK)
+	client.send(http.MethodGet, "/role/"+staff.Id.String(), nil, http.StatusNotFound)
+}
+
+func TestRoleInclusion(t *testing.T) {
+	trans := cmp.Transformer("Sort", func(in []uuid.UUID) []uuid.UUID {
+		out := append([]uuid.UUID(nil), in...) // Copy input to avoid mutating it
+		sphinx.Sort(out)
+		return out
+	})
+
+	server := httptest.NewServer(ConfigureHandler(CreateTestRepository()))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	manager := roleCreateRequest{uuid.New(), "store-manager"}
+	lead := roleCreateRequest{uuid.New(), "shift-lead"}
+	staff := roleCreateRequest{uuid.New(), "staff-member"}
+	view := newOperation("view-rota")
+	for _, role := range []roleCreateRequest{manager, lead, staff} {
+		client.send(http.MethodPost, "/role", role, http.StatusOK)
+	}
+	client.AddOperation(view)
+	client.send(http.MethodPut, "/role/"+staff.Id.String()+"/operation", assignOperationRequest{view.Id}, http.StatusOK)
+	client.send(http.MethodPut, "/role/"+manager.Id.String()+"/role", includeRoleRequest{lead.Id}, http.StatusOK)
+	client.send(http.MethodPut, "/role/"+lead.Id.String()+"/role", includeRoleRequest{staff.Id}, http.StatusOK)
+	client.send(http.MethodPut, "/role/"+staff.Id.String()+"/role", includeRoleRequest{manager.Id}, http.StatusConflict)
+
+	var ids []uuid.UUID
+	client.getJSON("/role/"+manager.Id.String()+"/role", &ids)
+	if diff := cmp.Diff([]uuid.UUID{lead.Id}, ids); diff != "" {
+		t.Errorf("GET /role/{roleId}/role diff %v", diff)
+	}
+	client.getJSON("/role/"+manager.Id.String()+"/role?transitive=true", &ids)
+	if diff := cmp.Diff([]uuid.UUID{lead.Id, staff.Id}, ids); diff != "" {
+		t.Errorf("GET /role/{roleId}/role?transitive=true diff %v", diff)
+	}
+	client.getJSON("/role/"+manager.Id.String()+"/operation", &ids)
+	if len(ids) != 0 {
+		t.Errorf("GET /role/{roleId}/operation returned %v", ids)
+	}
+	client.getJSON("/role/"+manager.Id.String()+"/operation?transitive=true", &ids)
+	if diff := cmp.Diff([]uuid.UUID{view.Id}, ids); diff != "" {
+		t.Errorf("GET /role/{roleId}/operation?transitive=true diff %v", diff)
+	}
+	client.getJSON("/operation/"+view.Id.String()+"/role?transitive=true", &ids)
+	if diff := cmp.Diff([]uuid.UUID{manager.Id, lead.Id, staff.Id}, ids, trans); diff != "" {
+		t.Errorf("GET /operation/{operationId}/role?transitive=true diff %v", diff)
+	}
+
+	client.send(http.MethodDelete, "/role/"+lead.Id.String()+"/role/"+staff.Id.String(), nil, http.StatusOK)
+	client.send(http.MethodDelete, "/role/"+lead.Id.String()+"/role/"+staff.Id.String(), nil, http.StatusNotFound)
+	client.getJSON("/operation/"+view.Id.String()+"/role?transitive=true", &ids)
+	if diff := cmp.Diff([]uuid.UUID{staff.Id}, ids); diff != "" {
+		t.Errorf("GET /operation/{operationId}/role?transitive=true after unassignment diff %v", diff)
+	}
+}
+
+func TestUserAssignments(t *testing.T) {
+	sortAssignments := cmp.Transformer("Sort", func(in []assignmentResponse) []assignmentResponse {
+		out := append([]assignmentResponse(nil), in...) // Copy input to avoid mutating it
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].RoleName < out[j].RoleName
+		})
+		return out
+	})
+
+	server := httptest.NewServer(ConfigureHandler(CreateTestRepository()))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	albany := branchCreateRequest{uuid.New(), "Albany"}
+	client.AddBranch(albany)
+	branchGroup := branchGroupCreateRequest{uuid.New(), "Auckland"}
+	client.AddBranchGroup(branchGroup)
+	client.AssignBranchToBranchGroup(branchGroup.Id, assignBranchRequest{BranchId: albany.Id})
+	admin := roleCreateRequest{uuid.New(), "Admin"}
+	staff := roleCreateRequest{uuid.New(), "Staff"}
+	view := newOperation("view-member")
+	client.send(http.MethodPost, "/role", admin, http.StatusOK)
+	client.send(http.MethodPost, "/role", staff, http.StatusOK)
+	client.AddOperation(view)
+	client.send(http.MethodPut, "/role/"+staff.Id.String()+"/operation", assignOperationRequest{view.Id}, http.StatusOK)
+
+	user := "/user/" + uuid.New().String()
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: staff.Id, BranchId: branchGroup.Id}, http.StatusOK)
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: admin.Id, BranchId: albany.Id}, http.StatusOK)
+
+	var assignments []assignmentResponse
+	client.getJSON(user+"/assignment", &assignments)
+	want := []assignmentResponse{
+		{RoleId: admin.Id, RoleName: "Admin", BranchId: albany.Id},
+		{RoleId: staff.Id, RoleName: "Staff", BranchId: branchGroup.Id},
+	}
+	if diff := cmp.Diff(want, assignments, sortAssignments); diff != "" {
+		t.Errorf("GET /user/{userId}/assignment diff %v", diff)
+	}
+
+	var authorised authorisedResponse
+	client.getJSON(user+"/authorised?operation="+url.QueryEscape(view.Name), &authorised)
+	if diff := cmp.Diff(authorisedResponse{Branches: []uuid.UUID{branchGroup.Id}}, authorised); diff != "" {
+		t.Errorf("GET /user/{userId}/authorised diff %v", diff)
+	}
+	authorised = authorisedResponse{}
+	client.getJSON(user+"/authorised?expand=true&include_groups=true&operation="+view.Id.String(), &authorised)
+	if diff := cmp.Diff(authorisedResponse{Branches: []uuid.UUID{albany.Id}, BranchGroups: []uuid.UUID{branchGroup.Id}}, authorised); diff != "" {
+		t.Errorf("GET /user/{userId}/authorised expanded diff %v", diff)
+	}
+	client.send(http.MethodGet, user+"/authorised?operation=no-such-thing", nil, http.StatusNotFound)
+
+	client.send(http.MethodDelete, user+"/assignment?role_id="+staff.Id.String()+"&branch_id="+branchGroup.Id.String(), nil, http.StatusOK)
+	client.getJSON(user+"/assignment", &assignments)
+	if diff := cmp.Diff(want[:1], assignments); diff != "" {
+		t.Errorf("GET /user/{userId}/assignment after revocation diff %v", diff)
+	}
+	// Revoking all the roles takes all=true, a bare request is rejected.
+	client.send(http.MethodDelete, user+"/assignment", nil, http.StatusBadRequest)
+	client.send(http.MethodDelete, user+"/assignment?all=true&role_id="+staff.Id.String(), nil, http.StatusBadRequest)
+	client.getJSON(user+"/assignment", &assignments)
+	if diff := cmp.Diff(want[:1], assignments); diff != "" {
+		t.Errorf("GET /user/{userId}/assignment after rejected revocations diff %v", diff)
+	}
+	client.send(http.MethodDelete, user+"/assignment?all=true", nil, http.StatusOK)
+	client.getJSON(user+"/assignment", &assignments)
+	if len(assignments) != 0 {
+		t.Errorf("GET /user/{userId}/assignment after revoking all roles returned %v", assignments)
+	}
+}
+
+func TestDenials(t *testing.T) {
+	server := httptest.NewServer(ConfigureHandler(CreateTestRepository()))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	albany := branchCreateRequest{uuid.New(), "Albany"}
+	client.AddBranch(albany)
+	milford := branchCreateRequest{uuid.New(), "Milford"}
+	client.AddBranch(milford)
+	region := branchGroupCreateRequest{uuid.New(), "Auckland"}
+	client.AddBranchGroup(region)
+	client.AssignBranchToBranchGroup(region.Id, assignBranchRequest{BranchId: albany.Id})
+	client.AssignBranchToBranchGroup(region.Id, assignBranchRequest{BranchId: milford.Id})
+	manager := roleCreateRequest{uuid.New(), "area-manager"}
+	view := newOperation("view-member")
+	client.send(http.MethodPost, "/role", manager, http.StatusOK)
+	client.AddOperation(view)
+	client.send(http.MethodPut, "/role/"+manager.Id.String()+"/operation", assignOperationRequest{view.Id}, http.StatusOK)
+
+	userId := uuid.New()
+	user := "/user/" + userId.String()
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: manager.Id, BranchId: region.Id}, http.StatusOK)
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: manager.Id, BranchId: milford.Id, Deny: true}, http.StatusOK)
+
+	var assignments []assignmentResponse
+	client.getJSON(user+"/assignment", &assignments)
+	if len(assignments) != 2 {
+		t.Errorf("GET /user/{userId}/assignment returned %v", assignments)
+	}
+
+	got := client.Check(checkRequest{UserId: userId, OperationId: view.Id, BranchId: milford.Id})
+	want := checkResponse{Reason: string(core.ReasonDeniedInBranch), RoleId: manager.Id, DeniedIn: milford.Id}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Check() mismatch (-want +got):\n%s", diff)
+	}
+	var authorised authorisedResponse
+	client.getJSON(user+"/authorised?expand=true&operation="+view.Id.String(), &authorised)
+	if diff := cmp.Diff(authorisedResponse{Branches: []uuid.UUID{albany.Id}}, authorised); diff != "" {
+		t.Errorf("GET /user/{userId}/authorised diff %v", diff)
+	}
+
+	client.send(http.MethodDelete, user+"/assignment?deny=true&role_id="+manager.Id.String()+"&branch_id="+milford.Id.String(), nil, http.StatusOK)
+	got = client.Check(checkRequest{UserId: userId, OperationId: view.Id, BranchId: milford.Id})
+	want = checkResponse{Allowed: true, Reason: string(core.ReasonGrantedInBranchGroup), RoleId: manager.Id, GrantedIn: region.Id}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Check() after revoking the denial mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTimeBoundAssignments(t *testing.T) {
+	server := httptest.NewServer(ConfigureHandler(CreateTestRepository()))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	albany := branchCreateRequest{uuid.New(), "Albany"}
+	client.AddBranch(albany)
+	milford := branchCreateRequest{uuid.New(), "Milford"}
+	client.AddBranch(milford)
+	cover := roleCreateRequest{uuid.New(), "cover-staff"}
+	view := newOperation("view-member")
+	client.send(http.MethodPost, "/role", cover, http.StatusOK)
+	client.AddOperation(view)
+	client.send(http.MethodPut, "/role/"+cover.Id.String()+"/operation", assignOperationRequest{view.Id}, http.StatusOK)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	at := func(d time.Duration) *time.Time {
+		result := now.Add(d)
+		return &result
+	}
+	userId := uuid.New()
+	user := "/user/" + userId.String()
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: cover.Id, BranchId: albany.Id, ValidFrom: at(-time.Hour), ValidUntil: at(-time.Hour)}, http.StatusBadRequest)
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: cover.Id, BranchId: albany.Id, ValidFrom: at(-time.Hour), ValidUntil: at(time.Hour)}, http.StatusOK)
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: cover.Id, BranchId: milford.Id, ValidUntil: at(-time.Minute)}, http.StatusOK)
+
+	var assignments []assignmentResponse
+	client.getJSON(user+"/assignment", &assignments)
+	remaining := make(map[uuid.UUID]int64, len(assignments))
+	for _, assignment := range assignments {
+		if assignment.RemainingSeconds == nil || assignment.ValidUntil == nil {
+			t.Fatalf("GET /user/{userId}/assignment returned %v without lifetime", assignment)
+		}
+		remaining[assignment.BranchId] = *assignment.RemainingSeconds
+	}
+	if r := remaining[albany.Id]; r <= 0 || r > 3600 {
+		t.Errorf("GET /user/{userId}/assignment remaining lifetime %v", r)
+	}
+	if r := remaining[milford.Id]; r != 0 {
+		t.Errorf("GET /user/{userId}/assignment remaining lifetime of an expired assignment %v", r)
+	}
+
+	var authorised authorisedResponse
+	client.getJSON(user+"/authorised?operation="+view.Id.String(), &authorised)
+	if diff := cmp.Diff(authorisedResponse{Branches: []uuid.UUID{albany.Id}}, authorised); diff != "" {
+		t.Errorf("GET /user/{userId}/authorised diff %v", diff)
+	}
+	if got := client.Check(checkRequest{UserId: userId, OperationId: view.Id, BranchId: milford.Id}); got.Allowed {
+		t.Errorf("Check() allowed an expired assignment %v", got)
+	}
+}
+
+func TestOrganisationWideAssignments(t *testing.T) {
+	server := httptest.NewServer(ConfigureHandler(CreateTestRepository()))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	albany := branchCreateRequest{uuid.New(), "Albany"}
+	client.AddBranch(albany)
+	auditor := roleCreateRequest{uuid.New(), "auditor"}
+	view := newOperation("view-member")
+	client.send(http.MethodPost, "/role", auditor, http.StatusOK)
+	client.AddOperation(view)
+	client.send(http.MethodPut, "/role/"+auditor.Id.String()+"/operation", assignOperationRequest{view.Id}, http.StatusOK)
+
+	userId := uuid.New()
+	user := "/user/" + userId.String()
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: auditor.Id, BranchId: albany.Id, OrganisationWide: true}, http.StatusBadRequest)
+	client.send(http.MethodPost, user+"/assignment", assignRoleRequest{RoleId: auditor.Id, OrganisationWide: true}, http.StatusOK)
+
+	var assignments []assignmentResponse
+	client.getJSON(user+"/assignment", &assignments)
+	want := []assignmentResponse{{RoleId: auditor.Id, RoleName: auditor.Name, OrganisationWide: true}}
+	if diff := cmp.Diff(want, assignments); diff != "" {
+		t.Errorf("GET /user/{userId}/assignment diff %v", diff)
+	}
+
+	// A branch created after the assignment is covered as well.
+	milford := branchCreateRequest{uuid.New(), "Milford"}
+	client.AddBranch(milford)
+	got := client.Check(checkRequest{UserId: userId, OperationId: view.Id, BranchId: milford.Id})
+	wantCheck := checkResponse{Allowed: true, Reason: string(core.ReasonGrantedInOrganisation), RoleId: auditor.Id, GrantedIn: orgId}
+	if diff := cmp.Diff(wantCheck, got); diff != "" {
+		t.Errorf("Check() mismatch (-want +got):\n%s", diff)
+	}
+
+	var authorised authorisedResponse
+	client.getJSON(user+"/authorised?operation="+view.Id.String(), &authorised)
+	if diff := cmp.Diff(authorisedResponse{Branches: []uuid.UUID{}, OrganisationWide: true}, authorised); diff != "" {
+		t.Errorf("GET /user/{userId}/authorised diff %v", diff)
+	}
+	authorised = authorisedResponse{}
+	client.getJSON(user+"/authorised?expand=true&operation="+view.Id.String(), &authorised)
+	branches := []uuid.UUID{albany.Id, milford.Id}
+	sphinx.Sort(branches)
+	if diff := cmp.Diff(authorisedResponse{Branches: branches, OrganisationWide: true}, authorised); diff != "" {
+		t.Errorf("GET /user/{userId}/authorised?expand=true diff %v", diff)
+	}
+
+	client.send(http.MethodDelete, user+"/assignment?organisation_wide=true&role_id="+auditor.Id.String(), nil, http.StatusOK)
+	if got := client.Check(checkRequest{UserId: userId, OperationId: view.Id, BranchId: milford.Id}); got.Allowed {
+		t.Errorf("Check() allowed a revoked assignment %v", got)
+	}
+}
+
+func TestOperationCatalogue(t *testing.T) {
+	graph := CreateTestGraphClient()
+	server := httptest.NewServer(ConfigureHandler(repository.CreateRepository(graph)))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+	catalogue := client.catalogue()
+
+	staff := roleCreateRequest{uuid.New(), "Staff"}
+	client.send(http.MethodPost, "/role", staff, http.StatusOK)
+	// The operation stored in the organisation before the catalogue was introduced.
+	legacy := operationCreateRequest{uuid.New(), "open-till-" + orgId.String()}
+	if err := graph.InsertRecord(context.Background(), &dygraph.Node{OrganisationId: orgId, Id: legacy.Id, Type: repository.OperationRecordType, Data: legacy.Name}); err != nil {
+		t.Fatal(err)
+	}
+	// The operation is not in the catalogue yet, so the assignment is made the way it was made before.
+	client.send(http.MethodPut, "/role/"+staff.Id.String()+"/operation", assignOperationRequest{legacy.Id}, http.StatusUnprocessableEntity)
+	if err := graph.TransactionalInsert(context.Background(), []dygraph.Edge{
+		{OrganisationId: orgId, Id: legacy.Id, TargetNodeId: staff.Id, TargetNodeType: repository.RoleRecordType},
+		{OrganisationId: orgId, Id: staff.Id, TargetNodeId: legacy.Id, TargetNodeType: repository.OperationRecordType},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	client.send(http.MethodGet, "/operation/"+legacy.Id.String(), nil, http.StatusNotFound)
+
+	manifest := operationManifest{
+		Service:    "members",
+		Operations: []operationCreateRequest{{uuid.New(), "view-member-" + orgId.String()}},
+	}
+	catalogue.send(http.MethodPut, "", manifest, http.StatusOK)
+	catalogue.send(http.MethodPut, "", manifest, http.StatusOK)
+	renamed := operationManifest{
+		Service:    "members",
+		Operations: []operationCreateRequest{{manifest.Operations[0].Id, "view-members-" + orgId.String()}},
+	}
+	catalogue.send(http.MethodPut, "", renamed, http.StatusConflict)
+	catalogue.send(http.MethodPut, "", operationManifest{Operations: []operationCreateRequest{{Name: "nameless"}}}, http.StatusBadRequest)
+
+	// Every organisation sees the catalogue.
+	other := &testClient{server.Client(), server.URL + "/" + uuid.New().String(), t}
+	var op operationResponse
+	other.getJSON("/operation/"+manifest.Operations[0].Id.String(), &op)
+	if diff := cmp.Diff(operationResponse(manifest.Operations[0]), op); diff != "" {
+		t.Errorf("GET /operation/{operationId} diff %v", diff)
+	}
+	other.send(http.MethodPost, "/operation", legacy, http.StatusMethodNotAllowed)
+	client.send(http.MethodGet, "/operation/"+legacy.Id.String()+"/role", nil, http.StatusOK)
+
+	client.send(http.MethodPost, "/migrate", nil, http.StatusOK)
+	client.getJSON("/operation/"+legacy.Id.String(), &op)
+	if diff := cmp.Diff(operationResponse(legacy), op); diff != "" {
+		t.Errorf("GET /operation/{operationId} after migration diff %v", diff)
+	}
+	var ids []uuid.UUID
+	client.getJSON("/role/"+staff.Id.String()+"/operation", &ids)
+	if diff := cmp.Diff([]uuid.UUID{legacy.Id}, ids); diff != "" {
+		t.Errorf("GET /role/{roleId}/operation after migration diff %v", diff)
+	}
+
+	view := manifest.Operations[0].Id
+	client.send(http.MethodPut, "/role/"+staff.Id.String()+"/operation", assignOperationRequest{view}, http.StatusOK)
+	albany := branchCreateRequest{uuid.New(), "Albany"}
+	client.AddBranch(albany)
+	userId := uuid.New()
+	client.send(http.MethodPost, "/user/"+userId.String()+"/assignment", assignRoleRequest{RoleId: staff.Id, OrganisationWide: true}, http.StatusOK)
+	if got := client.Check(checkRequest{UserId: userId, OperationId: view, BranchId: albany.Id}); !got.Allowed {
+		t.Errorf("Check() denied an assigned operation %v", got)
+	}
+
+	// Deleting the operation unassigns it from the roles of the organisations.
+	catalogue.send(http.MethodDelete, "/"+view.String(), nil, http.StatusOK)
+	other.send(http.MethodGet, "/operation/"+view.String(), nil, http.StatusNotFound)
+	if got := client.Check(checkRequest{UserId: userId, OperationId: view, BranchId: albany.Id}); got.Allowed {
+		t.Errorf("Check() allowed a deleted operation %v", got)
+	}
+	ids = nil
+	client.getJSON("/role/"+staff.Id.String()+"/operation", &ids)
+	if diff := cmp.Diff([]uuid.UUID{legacy.Id}, ids); diff != "" {
+		t.Errorf("GET /role/{roleId}/operation after deleting an operation diff %v", diff)
+	}
+	nilOrg := &testClient{server.Client(), server.URL + "/" + uuid.Nil.String(), t}
+	nilOrg.send(http.MethodGet, "/role", nil, http.StatusBadRequest)
+}
+
+func TestHierarchy(t *testing.T) {
+	server := httptest.NewServer(ConfigureHandler(CreateTestRepository()))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+
+	albany := branchCreateRequest{uuid.New(), "Albany"}
+	client.AddBranch(albany)
+	milford := branchCreateRequest{uuid.New(), "Milford"}
+	client.AddBranch(milford)
+	auckland := branchGroupCreateRequest{uuid.New(), "Auckland"}
+	client.AddBranchGroup(auckland)
+	northShore := branchGroupCreateRequest{uuid.New(), "North Shore"}
+	client.AddBranchGroup(northShore)
+	client.AssignBranchToBranchGroup(auckland.Id, assignBranchRequest{BranchId: albany.Id})
+	client.AssignBranchToBranchGroup(auckland.Id, assignBranchRequest{BranchId: milford.Id})
+	client.AssignBranchToBranchGroup(northShore.Id, assignBranchRequest{BranchId: albany.Id})
+	nesting := `{"memberships": [{"branch_group_id": "` + auckland.Id.String() + `", "branch_id": "` + northShore.Id.String() + `"}]}`
+	if status, lines := client.importDocument("", "application/json", nesting); status != http.StatusOK {
+		t.Fatalf("POST /import = %d %v", status, lines)
+	}
+
+	named := func(withNames bool, items ...namedIdResponse) []namedIdResponse {
+		sort.Slice(items, func(i, j int) bool {
+			return bytes.Compare(items[i].Id[:], items[j].Id[:]) < 0
+		})
+		if !withNames {
+			for i := range items {
+				items[i].Name = ""
+			}
+		}
+		return items
+	}
+	for _, withNames := range []bool{false, true} {
+		query := "?names=" + strconv.FormatBool(withNames)
+		want := []branchGroupContentResponse{
+			{auckland.Id, auckland.Name, named(withNames, namedIdResponse(albany), namedIdResponse(milford)), named(withNames, namedIdResponse(northShore))},
+			{northShore.Id, northShore.Name, named(withNames, namedIdResponse(albany)), []namedIdResponse{}},
+		}
+		sort.Slice(want, func(i, j int) bool {
+			return bytes.Compare(want[i].Id[:], want[j].Id[:]) < 0
+		})
+		if !withNames {
+			for i := range want {
+				want[i].Name = ""
+			}
+		}
+		var hierarchy []branchGroupContentResponse
+		client.getJSON("/hierarchy"+query, &hierarchy)
+		if diff := cmp.Diff(want, hierarchy); diff != "" {
+			t.Errorf("GET /hierarchy%s diff %v", query, diff)
+		}
+
+		var groups []namedIdResponse
+		client.getJSON("/branch/"+albany.Id.String()+"/groups"+query, &groups)
+		wantGroups := named(withNames, namedIdResponse(auckland), namedIdResponse(northShore))
+		if diff := cmp.Diff(wantGroups, groups); diff != "" {
+			t.Errorf("GET /branch/{branchId}/groups%s diff %v", query, diff)
+		}
+	}
+}
+
+func CreateTestGraphClient() repository.GraphDB {
+	if testutils.UseDynamoDB() {
+		client := testutils.GetClient()
+		ensureTestTable.Do(func() {
+			if err := dygraph.EnsureTable(context.Background(), client, dygraph.TableName("test"), dygraph.TableOptions{TimeToLive: true}); err != nil {
+				panic(err)
+			}
+		})
+		return dygraph.CreateGraphClient(client, "test")
+	}
+	return dygraph.CreateMemoryGraph()
 }
 
+var ensureTestTable sync.Once
+
 func CreateTestRepository() *repository.Repository {
 	return repository.CreateRepository(CreateTestGraphClient())
 }
+
+func TestOptions(t *testing.T) {
+	type key struct{}
+	var contexts int
+	server := httptest.NewServer(ConfigureHandlerWithOptions(CreateTestRepository(), Options{
+		RequestContext: func(ctx context.Context) context.Context {
+			contexts++
+			return context.WithValue(ctx, key{}, contexts)
+		},
+		Metrics: true,
+	}))
+	client := &testClient{server.Client(), server.URL, t}
+
+	var vars map[string]json.RawMessage
+	client.getJSON("/debug/vars", &vars)
+	if _, ok := vars["dygraph_retries"]; !ok {
+		t.Errorf("GET /debug/vars = %v, want the retry counts", vars)
+	}
+	client.send(http.MethodGet, "/"+uuid.New().String()+"/role", nil, http.StatusOK)
+	if contexts != 2 {
+		t.Errorf("RequestContext called %d times, want 2", contexts)
+	}
+
+	plain := &testClient{server.Client(), httptest.NewServer(ConfigureHandler(CreateTestRepository())).URL, t}
+	// Without the metrics debug is taken for an organisation id.
+	plain.send(http.MethodGet, "/debug/vars", nil, http.StatusBadRequest)
+}
+
+// importDocument posts the document of the content type and returns the status and the lines of the reply.
+func (c *testClient) importDocument(query, contentType, document string) (int, []string) {
+	res, err := c.client.Post(c.url+"/import"+query, contentType, strings.NewReader(document))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(res.Body); err != nil {
+		c.t.Fatal(err)
+	}
+	return res.StatusCode, strings.Split(strings.TrimSpace(body.String()), "\n")
+}
+
+func TestImport(t *testing.T) {
+	server := httptest.NewServer(ConfigureHandler(CreateTestRepository()))
+	orgId := uuid.New()
+	client := &testClient{server.Client(), server.URL + "/" + orgId.String(), t}
+	admin, staff, view := uuid.New(), uuid.New(), uuid.New()
+	north, albany, userId := uuid.New(), uuid.New(), uuid.New()
+
+	document := `
+operations:
+  - {id: ` + view.String() + `, name: view-member-` + orgId.String() + `}
+roles:
+  - {id: ` + admin.String() + `, name: Admin}
+  - {id: ` + staff.String() + `, name: Staff}
+branches:
+  - {id: ` + albany.String() + `, name: Albany}
+branch_groups:
+  - {id: ` + north.String() + `, name: North}
+operation_assignments:
+  - {role_id: ` + staff.String() + `, operation_id: ` + view.String() + `}
+role_inclusions:
+  - {role_id: ` + admin.String() + `, included_role_id: ` + staff.String() + `}
+memberships:
+  - {branch_group_id: ` + north.String() + `, branch_id: ` + albany.String() + `}
+user_assignments:
+  - {user_id: ` + userId.String() + `, role_id: ` + admin.String() + `, branch_id: ` + north.String() + `}
+  - {user_id: ` + userId.String() + `, role_id: ` + staff.String() + `, organisation_wide: true, valid_until: 2000-01-01T00:00:00Z}
+`
+	status, lines := client.importDocument("?progress=true", "application/yaml", document)
+	var progress importProgressResponse
+	var report importResponse
+	if status != http.StatusOK || len(lines) < 2 || json.Unmarshal([]byte(lines[0]), &progress) != nil || json.Unmarshal([]byte(lines[len(lines)-1]), &report) != nil {
+		t.Fatalf("POST /import = %d %v", status, lines)
+	}
+	if diff := cmp.Diff(importResponse{Total: 10, Created: 10, Problems: []importProblemResponse{}}, report); diff != "" {
+		t.Errorf("POST /import diff %v", diff)
+	}
+	if got := client.Check(checkRequest{UserId: userId, OperationId: view, BranchId: albany}); !got.Allowed {
+		t.Errorf("Check() after the import = %v", got)
+	}
+
+	// Importing again changes nothing, the existing items are reported as such.
+	status, lines = client.importDocument("", "application/x-yaml", document)
+	if status != http.StatusOK || json.Unmarshal([]byte(lines[0]), &report) != nil || report.Existing != 10 {
+		t.Errorf("POST /import again = %d %v", status, lines)
+	}
+
+	invalid := `{"roles": [{"id": "` + uuid.New().String() + `", "name": "Admin"}], "role_inclusions": [{"role_id": "` + staff.String() + `", "included_role_id": "` + admin.String() + `"}]}`
+	status, lines = client.importDocument("?progress=true", "application/json", invalid)
+	if status != http.StatusBadRequest || json.Unmarshal([]byte(lines[0]), &report) != nil {
+		t.Fatalf("POST /import of an invalid document = %d %v", status, lines)
+	}
+	wantProblems := []importProblemResponse{
+		{Kind: "roles", Index: 0, Status: http.StatusConflict},
+		{Kind: "role_inclusions", Index: 0, Status: http.StatusConflict},
+	}
+	for i := range report.Problems {
+		report.Problems[i].Error = ""
+	}
+	if diff := cmp.Diff(wantProblems, report.Problems); diff != "" {
+		t.Errorf("POST /import of an invalid document diff %v", diff)
+	}
+
+	if status, _ = client.importDocument("", "application/json", `{"users": []}`); status != http.StatusBadRequest {
+		t.Errorf("POST /import of an unknown field = %d", status)
+	}
+}
@@ -0,0 +1,66 @@
+package http
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+)
+
+type (
+	migrationRepository interface {
+		MigrateOperations(ctx context.Context, organisationId uuid.UUID) error
+		IndexNames(ctx context.Context, organisationId uuid.UUID) error
+		MigrateRoleInclusions(ctx context.Context, organisationId uuid.UUID) error
+		IndexOperationOrganisations(ctx context.Context, organisationId uuid.UUID) error
+	}
+	migrationResource struct {
+		repository migrationRepository
+	}
+)
+
+// Migrate brings the data of the organisation stored by the earlier versions up to date:
+// it moves the operations stored in the organisation to the operation catalogue,
+// reserves the names of the roles and the operations, gives the role inclusions their own edge type
+// and records the organisation for the operations assigned in it.
+// It can be run any number of times.
+func (r migrationResource) Migrate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		err := r.repository.MigrateOperations(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		err = r.repository.IndexNames(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		err = r.repository.MigrateRoleInclusions(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		err = r.repository.IndexOperationOrganisations(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "organisation migrated")
+	}
+}
+
+func CreateMigrationResourceRouter(repository migrationRepository) func(r chi.Router) {
+	res := &migrationResource{repository: repository}
+
+	return func(r chi.Router) {
+		r.Post("/", res.Migrate())
+	}
+}
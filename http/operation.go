@@ -0,0 +1,250 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+)
+
+type (
+	operationRepository interface {
+		AddOperation(ctx context.Context, op core.Operation) error
+		RegisterOperations(ctx context.Context, ops []core.Operation) error
+		GetAllOperations(ctx context.Context) ([]core.Operation, error)
+		GetOperation(ctx context.Context, opId uuid.UUID) (core.Operation, error)
+		GetOperationByName(ctx context.Context, name string) (core.Operation, error)
+		DeleteOperation(ctx context.Context, opId uuid.UUID) error
+		GetRolesByOperation(ctx context.Context, organisationId, opId uuid.UUID) ([]uuid.UUID, error)
+		GetEffectiveRolesByOperation(ctx context.Context, organisationId, opId uuid.UUID) ([]uuid.UUID, error)
+	}
+	operationResource struct {
+		repository operationRepository
+	}
+	operationCreateRequest struct {
+		Id   uuid.UUID `json:"id"`
+		Name string    `json:"name"`
+	}
+	// operationManifest lists the operations a service defines.
+	operationManifest struct {
+		Service    string                   `json:"service"`
+		Operations []operationCreateRequest `json:"operations"`
+	}
+	operationResponse struct {
+		Id   uuid.UUID `json:"id"`
+		Name string    `json:"name"`
+	}
+)
+
+func (r operationCreateRequest) To() core.Operation {
+	return core.Operation{
+		Id:   r.Id,
+		Name: r.Name,
+	}
+}
+
+func (r operationCreateRequest) valid() bool {
+	return r.Id != uuid.Nil && r.Name != ""
+}
+
+func toOperationResponse(op core.Operation) operationResponse {
+	return operationResponse{
+		Id:   op.Id,
+		Name: op.Name,
+	}
+}
+
+// AddOperation adds the operation to the operation catalogue.
+func (r operationResource) AddOperation() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		payload := &operationCreateRequest{}
+		err := json.NewDecoder(request.Body).Decode(payload)
+		if err != nil || !payload.valid() {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.AddOperation(ctx, payload.To())
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "operation created")
+	}
+}
+
+// RegisterOperations adds the operations of the manifest missing from the operation catalogue.
+// The manifest can be registered on every deployment of the service, the operations registered under
+// a different name are rejected with 409.
+func (r operationResource) RegisterOperations() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		payload := &operationManifest{}
+		err := json.NewDecoder(request.Body).Decode(payload)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ops := make([]core.Operation, len(payload.Operations))
+		for i, op := range payload.Operations {
+			if !op.valid() {
+				http.Error(writer, fmt.Sprintf("operation %d should have id and name", i), http.StatusBadRequest)
+				return
+			}
+			ops[i] = op.To()
+		}
+		err = r.repository.RegisterOperations(ctx, ops)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "operations registered")
+	}
+}
+
+// GetAllOperations responds with the operation catalogue,
+// or with the operation having the name given by the name query parameter if any.
+func (r operationResource) GetAllOperations() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		var ops []core.Operation
+		var err error
+		if name := request.URL.Query().Get("name"); name != "" {
+			ops, err = r.getOperationsByName(ctx, name)
+		} else {
+			ops, err = r.repository.GetAllOperations(ctx)
+		}
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		result := make([]operationResponse, len(ops))
+		for i, op := range ops {
+			result[i] = toOperationResponse(op)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(result)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getOperationsByName returns the operation having the name, or none if there is no such operation.
+func (r operationResource) getOperationsByName(ctx context.Context, name string) ([]core.Operation, error) {
+	op, err := r.repository.GetOperationByName(ctx, name)
+	if isNotFound(err) {
+		return []core.Operation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []core.Operation{op}, nil
+}
+
+func (r operationResource) GetOperation() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		operationId, err := uuid.Parse(chi.URLParam(request, OperationIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", OperationIdKey), http.StatusBadRequest)
+			return
+		}
+		op, err := r.repository.GetOperation(ctx, operationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(toOperationResponse(op))
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// DeleteOperation deletes the operation from the operation catalogue and unassigns it from the roles
+// of all the organisations.
+func (r operationResource) DeleteOperation() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		operationId, err := uuid.Parse(chi.URLParam(request, OperationIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", OperationIdKey), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.DeleteOperation(ctx, operationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "operation deleted")
+	}
+}
+
+func (r operationResource) GetRolesByOperation() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		operationId, err := uuid.Parse(chi.URLParam(request, OperationIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", OperationIdKey), http.StatusBadRequest)
+			return
+		}
+		transitive, err := parseBool(request.URL.Query().Get("transitive"))
+		if err != nil {
+			http.Error(writer, "transitive should be boolean", http.StatusBadRequest)
+			return
+		}
+		getRoles := r.repository.GetRolesByOperation
+		if transitive {
+			getRoles = r.repository.GetEffectiveRolesByOperation
+		}
+		roles, err := getRoles(ctx, organisationId, operationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(roles)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// CreateOperationCatalogueRouter serves the operation catalogue shared by all the organisations.
+func CreateOperationCatalogueRouter(repository operationRepository) func(r chi.Router) {
+	res := &operationResource{repository: repository}
+
+	return func(r chi.Router) {
+		r.Post("/", res.AddOperation())
+		r.Put("/", res.RegisterOperations())
+		r.Get("/", res.GetAllOperations())
+		r.Get(fmt.Sprintf("/{%s}", OperationIdKey), res.GetOperation())
+		r.Delete(fmt.Sprintf("/{%s}", OperationIdKey), res.DeleteOperation())
+	}
+}
+
+// CreateOperationResourceRouter serves the operations as seen by the organisation,
+// the catalogue is read-only there.
+func CreateOperationResourceRouter(repository operationRepository) func(r chi.Router) {
+	res := &operationResource{repository: repository}
+
+	return func(r chi.Router) {
+		r.Get("/", res.GetAllOperations())
+		r.Get(fmt.Sprintf("/{%s}", OperationIdKey), res.GetOperation())
+		r.Get(fmt.Sprintf("/{%s}/role", OperationIdKey), res.GetRolesByOperation())
+	}
+}
@@ -0,0 +1,408 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/dbuduev/authz-service-go/sphinx"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+)
+
+type (
+	roleRepository interface {
+		AddRole(ctx context.Context, role core.Role) error
+		GetAllRoles(ctx context.Context, organisationId uuid.UUID) ([]core.Role, error)
+		GetRole(ctx context.Context, organisationId, roleId uuid.UUID) (core.Role, error)
+		GetRoleByName(ctx context.Context, organisationId uuid.UUID, name string) (core.Role, error)
+		DeleteRole(ctx context.Context, organisationId, roleId uuid.UUID) error
+		AssignOperationToRole(ctx context.Context, x core.OperationAssignment) error
+		UnassignOperationFromRole(ctx context.Context, x core.OperationAssignment) error
+		GetOperationsByRole(ctx context.Context, organisationId, roleId uuid.UUID) ([]uuid.UUID, error)
+		GetEffectiveOperationsByRole(ctx context.Context, organisationId, roleId uuid.UUID) ([]uuid.UUID, error)
+		AssignRoleToRole(ctx context.Context, x core.RoleInclusion) error
+		UnassignRoleFromRole(ctx context.Context, x core.RoleInclusion) error
+		GetRoleHierarchy(ctx context.Context, organisationId uuid.UUID) (sphinx.RoleContent, error)
+	}
+	roleResource struct {
+		repository roleRepository
+	}
+	roleCreateRequest struct {
+		Id   uuid.UUID `json:"id"`
+		Name string    `json:"name"`
+	}
+	roleResponse struct {
+		Id   uuid.UUID `json:"id"`
+		Name string    `json:"name"`
+	}
+	assignOperationRequest struct {
+		OperationId uuid.UUID `json:"operation_id"`
+	}
+	includeRoleRequest struct {
+		RoleId uuid.UUID `json:"role_id"`
+	}
+)
+
+func (r roleCreateRequest) To(organisationId uuid.UUID) core.Role {
+	return core.Role{
+		OrganisationId: organisationId,
+		Id:             r.Id,
+		Name:           r.Name,
+	}
+}
+
+func (r assignOperationRequest) To(organisationId, roleId uuid.UUID) core.OperationAssignment {
+	return core.OperationAssignment{
+		OrganisationId: organisationId,
+		RoleId:         roleId,
+		OperationId:    r.OperationId,
+	}
+}
+
+func (r includeRoleRequest) To(organisationId, roleId uuid.UUID) core.RoleInclusion {
+	return core.RoleInclusion{
+		OrganisationId: organisationId,
+		RoleId:         roleId,
+		IncludedRoleId: r.RoleId,
+	}
+}
+
+func toRoleResponse(role core.Role) roleResponse {
+	return roleResponse{
+		Id:   role.Id,
+		Name: role.Name,
+	}
+}
+
+func (r roleResource) AddRole() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		payload := &roleCreateRequest{}
+		err := json.NewDecoder(request.Body).Decode(payload)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.AddRole(ctx, payload.To(organisationId))
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "role created")
+	}
+}
+
+// GetAllRoles responds with the roles of the organisation,
+// or with the role having the name given by the name query parameter if any.
+func (r roleResource) GetAllRoles() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		var roles []core.Role
+		var err error
+		if name := request.URL.Query().Get("name"); name != "" {
+			roles, err = r.getRolesByName(ctx, organisationId, name)
+		} else {
+			roles, err = r.repository.GetAllRoles(ctx, organisationId)
+		}
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		result := make([]roleResponse, len(roles))
+		for i, role := range roles {
+			result[i] = toRoleResponse(role)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(result)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// getRolesByName returns the role having the name, or none if there is no such role.
+func (r roleResource) getRolesByName(ctx context.Context, organisationId uuid.UUID, name string) ([]core.Role, error) {
+	role, err := r.repository.GetRoleByName(ctx, organisationId, name)
+	if isNotFound(err) {
+		return []core.Role{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []core.Role{role}, nil
+}
+
+func (r roleResource) GetRole() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		roleId, err := uuid.Parse(chi.URLParam(request, RoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", RoleIdKey), http.StatusBadRequest)
+			return
+		}
+		role, err := r.repository.GetRole(ctx, organisationId, roleId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(toRoleResponse(role))
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (r roleResource) DeleteRole() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		roleId, err := uuid.Parse(chi.URLParam(request, RoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", RoleIdKey), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.DeleteRole(ctx, organisationId, roleId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "role deleted")
+	}
+}
+
+func (r roleResource) AssignOperationToRole() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		roleId, err := uuid.Parse(chi.URLParam(request, RoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", RoleIdKey), http.StatusBadRequest)
+			return
+		}
+		payload := &assignOperationRequest{}
+		err = json.NewDecoder(request.Body).Decode(payload)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.AssignOperationToRole(ctx, payload.To(organisationId, roleId))
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "operationAssignment created")
+	}
+}
+
+func (r roleResource) UnassignOperationFromRole() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		roleId, err := uuid.Parse(chi.URLParam(request, RoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", RoleIdKey), http.StatusBadRequest)
+			return
+		}
+		operationId, err := uuid.Parse(chi.URLParam(request, OperationIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", OperationIdKey), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.UnassignOperationFromRole(ctx, core.OperationAssignment{
+			OrganisationId: organisationId,
+			RoleId:         roleId,
+			OperationId:    operationId,
+		})
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "operationAssignment deleted")
+	}
+}
+
+func (r roleResource) GetOperationsByRole() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		roleId, err := uuid.Parse(chi.URLParam(request, RoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", RoleIdKey), http.StatusBadRequest)
+			return
+		}
+		transitive, err := parseBool(request.URL.Query().Get("transitive"))
+		if err != nil {
+			http.Error(writer, "transitive should be boolean", http.StatusBadRequest)
+			return
+		}
+		getOperations := r.repository.GetOperationsByRole
+		if transitive {
+			getOperations = r.repository.GetEffectiveOperationsByRole
+		}
+		operations, err := getOperations(ctx, organisationId, roleId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(operations)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (r roleResource) AssignRoleToRole() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		roleId, err := uuid.Parse(chi.URLParam(request, RoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", RoleIdKey), http.StatusBadRequest)
+			return
+		}
+		payload := &includeRoleRequest{}
+		err = json.NewDecoder(request.Body).Decode(payload)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.AssignRoleToRole(ctx, payload.To(organisationId, roleId))
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "roleInclusion created")
+	}
+}
+
+func (r roleResource) UnassignRoleFromRole() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		roleId, err := uuid.Parse(chi.URLParam(request, RoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", RoleIdKey), http.StatusBadRequest)
+			return
+		}
+		includedRoleId, err := uuid.Parse(chi.URLParam(request, IncludedRoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", IncludedRoleIdKey), http.StatusBadRequest)
+			return
+		}
+		err = r.repository.UnassignRoleFromRole(ctx, core.RoleInclusion{
+			OrganisationId: organisationId,
+			RoleId:         roleId,
+			IncludedRoleId: includedRoleId,
+		})
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "roleInclusion deleted")
+	}
+}
+
+// GetIncludedRoles replies with the roles the role includes directly,
+// or with all the roles it includes if transitive is true.
+func (r roleResource) GetIncludedRoles() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		roleId, err := uuid.Parse(chi.URLParam(request, RoleIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", RoleIdKey), http.StatusBadRequest)
+			return
+		}
+		transitive, err := parseBool(request.URL.Query().Get("transitive"))
+		if err != nil {
+			http.Error(writer, "transitive should be boolean", http.StatusBadRequest)
+			return
+		}
+		hierarchy, err := r.repository.GetRoleHierarchy(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		roles := hierarchy[roleId]
+		if transitive {
+			roles = hierarchy.Included(roleId)
+		}
+		if roles == nil {
+			roles = []uuid.UUID{}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(roles)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func CreateRoleResourceRouter(repository roleRepository) func(r chi.Router) {
+	res := &roleResource{repository: repository}
+
+	return func(r chi.Router) {
+		r.Post("/", res.AddRole())
+		r.Get("/", res.GetAllRoles())
+		r.Get(fmt.Sprintf("/{%s}", RoleIdKey), res.GetRole())
+		r.Delete(fmt.Sprintf("/{%s}", RoleIdKey), res.DeleteRole())
+		r.Put(fmt.Sprintf("/{%s}/operation", RoleIdKey), res.AssignOperationToRole())
+		r.Get(fmt.Sprintf("/{%s}/operation", RoleIdKey), res.GetOperationsByRole())
+		r.Delete(fmt.Sprintf("/{%s}/operation/{%s}", RoleIdKey, OperationIdKey), res.UnassignOperationFromRole())
+		r.Put(fmt.Sprintf("/{%s}/role", RoleIdKey), res.AssignRoleToRole())
+		r.Get(fmt.Sprintf("/{%s}/role", RoleIdKey), res.GetIncludedRoles())
+		r.Delete(fmt.Sprintf("/{%s}/role/{%s}", RoleIdKey, IncludedRoleIdKey), res.UnassignRoleFromRole())
+	}
+}
@@ -2,29 +2,78 @@ package http
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"github.com/dbuduev/authz-service-go/core"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
 	"net/http"
+	"time"
 )
 
 const (
 	OrganisationIdKey = "organisationId"
 	BranchGroupIdKey  = "branchGroupId"
+	BranchIdKey       = "branchId"
+	RoleIdKey         = "roleId"
+	IncludedRoleIdKey = "includedRoleId"
+	OperationIdKey    = "operationId"
+	UserIdKey         = "userId"
 )
 
+// Options tune the handler created by ConfigureHandlerWithOptions.
+type Options struct {
+	// RequestTimeout cancels the context of the requests taking longer, zero disables it.
+	RequestTimeout time.Duration
+	// LogRequests logs every request.
+	LogRequests bool
+	// RequestContext, if given, derives the context of every request, e.g. dygraph.Dygraph.WithRetryBudget.
+	RequestContext func(ctx context.Context) context.Context
+	// Metrics publishes the expvar variables, e.g. the DynamoDB retry counts, at /debug/vars.
+	Metrics bool
+}
+
 func ConfigureHandler(repo core.Repository) http.Handler {
+	return ConfigureHandlerWithOptions(repo, Options{LogRequests: true})
+}
+
+func ConfigureHandlerWithOptions(repo core.Repository, options Options) http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
+	if options.LogRequests {
+		r.Use(middleware.Logger)
+	}
 	r.Use(middleware.Recoverer)
+	if options.RequestTimeout > 0 {
+		r.Use(middleware.Timeout(options.RequestTimeout))
+	}
+	if options.RequestContext != nil {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(options.RequestContext(r.Context())))
+			})
+		})
+	}
+	if options.Metrics {
+		r.Handle("/debug/vars", expvar.Handler())
+	}
 
+	authorisationCore := core.CreateAuthorisationCore(repo)
+
+	r.Route("/operation", CreateOperationCatalogueRouter(repo))
 	r.Route(fmt.Sprintf("/{%s}", OrganisationIdKey), func(r chi.Router) {
 		r.Use(organisationContext)
 		r.Route("/branch", CreateBranchResourceRouter(repo))
 		r.Route("/branch-group", CreateBranchGroupResourceRouter(repo))
+		r.Route("/hierarchy", CreateHierarchyResourceRouter(repo))
+		r.Route("/role", CreateRoleResourceRouter(repo))
+		r.Route("/operation", CreateOperationResourceRouter(repo))
+		r.Route("/user", CreateUserResourceRouter(repo, &authorisationCore))
+		r.Route("/check", CreateCheckResourceRouter(&authorisationCore))
+		r.Route("/explain", CreateExplainResourceRouter(repo, &authorisationCore))
+		r.Route("/migrate", CreateMigrationResourceRouter(repo))
+		r.Route("/import", CreateImportResourceRouter(repo))
 	})
 	return r
 }
@@ -36,6 +85,11 @@ func organisationContext(next http.Handler) http.Handler {
 			http.Error(w, "Can't parse organisation id, must be UUID.", http.StatusBadRequest)
 			return
 		}
+		// The nil id is reserved for the operation catalogue.
+		if organisationId == uuid.Nil {
+			http.Error(w, "Organisation id must not be nil UUID.", http.StatusBadRequest)
+			return
+		}
 		ctx := context.WithValue(r.Context(), OrganisationIdKey, organisationId)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -0,0 +1,364 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type (
+	userRepository interface {
+		AssignRoleToUser(ctx context.Context, x core.UserRoleAssignment) error
+		GetUserRolesAssignments(ctx context.Context, organisationId, userId uuid.UUID) ([]core.UserRoleAssignment, error)
+		RevokeRoleFromUser(ctx context.Context, x core.UserRoleAssignment) error
+		RevokeUserRoles(ctx context.Context, organisationId, userId uuid.UUID) error
+		GetAllRoles(ctx context.Context, organisationId uuid.UUID) ([]core.Role, error)
+	}
+	userAuthoriser interface {
+		FindOpByName(ctx context.Context, name string) (*core.Operation, error)
+		WhereAuthorised(ctx context.Context, organisationId, userId, opId uuid.UUID) ([]uuid.UUID, error)
+		WhereAuthorisedBranches(ctx context.Context, organisationId, userId, opId uuid.UUID, includeGroups bool) (core.AuthorisedBranches, error)
+	}
+	userResource struct {
+		repository userRepository
+		authoriser userAuthoriser
+	}
+	assignRoleRequest struct {
+		RoleId           uuid.UUID  `json:"role_id"`
+		BranchId         uuid.UUID  `json:"branch_id"`
+		OrganisationWide bool       `json:"organisation_wide"`
+		Deny             bool       `json:"deny"`
+		ValidFrom        *time.Time `json:"valid_from,omitempty"`
+		ValidUntil       *time.Time `json:"valid_until,omitempty"`
+	}
+	assignmentResponse struct {
+		RoleId           uuid.UUID  `json:"role_id"`
+		RoleName         string     `json:"role_name"`
+		BranchId         uuid.UUID  `json:"branch_id"`
+		OrganisationWide bool       `json:"organisation_wide"`
+		Deny             bool       `json:"deny"`
+		ValidFrom        *time.Time `json:"valid_from,omitempty"`
+		ValidUntil       *time.Time `json:"valid_until,omitempty"`
+		// RemainingSeconds is the lifetime left, it is present only if the assignment expires.
+		RemainingSeconds *int64 `json:"remaining_seconds,omitempty"`
+	}
+	authorisedResponse struct {
+		Branches         []uuid.UUID `json:"branches"`
+		BranchGroups     []uuid.UUID `json:"branch_groups,omitempty"`
+		OrganisationWide bool        `json:"organisation_wide,omitempty"`
+	}
+)
+
+func (r assignRoleRequest) To(organisationId, userId uuid.UUID) core.UserRoleAssignment {
+	result := core.UserRoleAssignment{
+		OrganisationId:   organisationId,
+		RoleId:           r.RoleId,
+		UserId:           userId,
+		BranchId:         r.BranchId,
+		OrganisationWide: r.OrganisationWide,
+		Deny:             r.Deny,
+	}
+	if r.ValidFrom != nil {
+		result.ValidFrom = *r.ValidFrom
+	}
+	if r.ValidUntil != nil {
+		result.ValidUntil = *r.ValidUntil
+	}
+	return result
+}
+
+// validWindow reports whether the window the assignment is in effect is not empty.
+func (r assignRoleRequest) validWindow() bool {
+	return r.ValidFrom == nil || r.ValidUntil == nil || r.ValidFrom.Before(*r.ValidUntil)
+}
+
+func toAssignmentResponse(assignment core.UserRoleAssignment, roleName string, now time.Time) assignmentResponse {
+	result := assignmentResponse{
+		RoleId:           assignment.RoleId,
+		RoleName:         roleName,
+		BranchId:         assignment.BranchId,
+		OrganisationWide: assignment.OrganisationWide,
+		Deny:             assignment.Deny,
+	}
+	if !assignment.ValidFrom.IsZero() {
+		validFrom := assignment.ValidFrom
+		result.ValidFrom = &validFrom
+	}
+	if !assignment.ValidUntil.IsZero() {
+		validUntil := assignment.ValidUntil
+		result.ValidUntil = &validUntil
+		remaining := int64(validUntil.Sub(now) / time.Second)
+		if remaining < 0 {
+			remaining = 0
+		}
+		result.RemainingSeconds = &remaining
+	}
+	return result
+}
+
+func (r userResource) AssignRoleToUser() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		userId, err := uuid.Parse(chi.URLParam(request, UserIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", UserIdKey), http.StatusBadRequest)
+			return
+		}
+		payload := &assignRoleRequest{}
+		err = json.NewDecoder(request.Body).Decode(payload)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if !payload.validWindow() {
+			http.Error(writer, "valid_until should be after valid_from", http.StatusBadRequest)
+			return
+		}
+		if payload.OrganisationWide && payload.BranchId != uuid.Nil {
+			http.Error(writer, "branch_id should be omitted for organisation wide assignments", http.StatusBadRequest)
+			return
+		}
+		err = r.repository.AssignRoleToUser(ctx, payload.To(organisationId, userId))
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "userRoleAssignment created")
+	}
+}
+
+func (r userResource) GetUserRolesAssignments() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		userId, err := uuid.Parse(chi.URLParam(request, UserIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", UserIdKey), http.StatusBadRequest)
+			return
+		}
+		assignments, err := r.repository.GetUserRolesAssignments(ctx, organisationId, userId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		roles, err := r.repository.GetAllRoles(ctx, organisationId)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		names := make(map[uuid.UUID]string, len(roles))
+		for _, role := range roles {
+			names[role.Id] = role.Name
+		}
+		now := time.Now()
+		result := make([]assignmentResponse, len(assignments))
+		for i, assignment := range assignments {
+			result[i] = toAssignmentResponse(assignment, names[assignment.RoleId], now)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(result)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// RevokeRoleFromUser revokes the role designated by role_id and branch_id query parameters,
+// or the denial of the role if deny is true.
+// organisation_wide=true revokes the organisation-wide assignment of the role, branch_id is not needed then.
+// all=true revokes all the roles and denials of the user instead, the other parameters are not allowed then.
+func (r userResource) RevokeRoleFromUser() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		userId, err := uuid.Parse(chi.URLParam(request, UserIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", UserIdKey), http.StatusBadRequest)
+			return
+		}
+		query := request.URL.Query()
+		all, err := parseBool(query.Get("all"))
+		if err != nil {
+			http.Error(writer, "all should be boolean", http.StatusBadRequest)
+			return
+		}
+		if all {
+			if query.Get("role_id") != "" || query.Get("branch_id") != "" || query.Get("organisation_wide") != "" || query.Get("deny") != "" {
+				http.Error(writer, "all revokes every role, role_id, branch_id, organisation_wide and deny are not allowed with it", http.StatusBadRequest)
+				return
+			}
+			err = r.repository.RevokeUserRoles(ctx, organisationId, userId)
+			if err != nil {
+				writeError(writer, err)
+				return
+			}
+			_, _ = io.WriteString(writer, "userRoleAssignments deleted")
+			return
+		}
+		if query.Get("role_id") == "" {
+			http.Error(writer, "role_id is required, all=true revokes every role", http.StatusBadRequest)
+			return
+		}
+		roleId, err := uuid.Parse(query.Get("role_id"))
+		if err != nil {
+			http.Error(writer, "role_id should UUID", http.StatusBadRequest)
+			return
+		}
+		organisationWide, err := parseBool(query.Get("organisation_wide"))
+		if err != nil {
+			http.Error(writer, "organisation_wide should be boolean", http.StatusBadRequest)
+			return
+		}
+		var branchId uuid.UUID
+		if !organisationWide {
+			branchId, err = uuid.Parse(query.Get("branch_id"))
+			if err != nil {
+				http.Error(writer, "branch_id should UUID", http.StatusBadRequest)
+				return
+			}
+		}
+		deny, err := parseBool(query.Get("deny"))
+		if err != nil {
+			http.Error(writer, "deny should be boolean", http.StatusBadRequest)
+			return
+		}
+		err = r.repository.RevokeRoleFromUser(ctx, core.UserRoleAssignment{
+			OrganisationId:   organisationId,
+			RoleId:           roleId,
+			UserId:           userId,
+			BranchId:         branchId,
+			OrganisationWide: organisationWide,
+			Deny:             deny,
+		})
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		_, _ = io.WriteString(writer, "userRoleAssignment deleted")
+	}
+}
+
+// WhereAuthorised responds with the branches and branch groups where the user is authorised
+// to perform the operation given by the operation query parameter, either an id or a name.
+// If expand is true, branch groups are expanded into their branches,
+// and include_groups additionally lists the branch groups the operation is granted in
+// and the ones containing the branches where it is authorised.
+// organisation_wide is set if the operation is granted in the whole organisation, branches created later included.
+func (r userResource) WhereAuthorised() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		organisationId, ok := ctx.Value(OrganisationIdKey).(uuid.UUID)
+		if !ok {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		userId, err := uuid.Parse(chi.URLParam(request, UserIdKey))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("%s should UUID", UserIdKey), http.StatusBadRequest)
+			return
+		}
+		query := request.URL.Query()
+		operation := query.Get("operation")
+		if operation == "" {
+			http.Error(writer, "operation is required", http.StatusBadRequest)
+			return
+		}
+		expand, err := parseBool(query.Get("expand"))
+		if err != nil {
+			http.Error(writer, "expand should be boolean", http.StatusBadRequest)
+			return
+		}
+		includeGroups, err := parseBool(query.Get("include_groups"))
+		if err != nil {
+			http.Error(writer, "include_groups should be boolean", http.StatusBadRequest)
+			return
+		}
+		opId, err := uuid.Parse(operation)
+		if err != nil {
+			op, err := r.authoriser.FindOpByName(ctx, operation)
+			if err != nil {
+				writeError(writer, err)
+				return
+			}
+			opId = op.Id
+		}
+
+		var result authorisedResponse
+		if expand {
+			branches, err := r.authoriser.WhereAuthorisedBranches(ctx, organisationId, userId, opId, includeGroups)
+			if err != nil {
+				writeError(writer, err)
+				return
+			}
+			result = authorisedResponse{
+				Branches:         branches.Branches,
+				BranchGroups:     branches.BranchGroups,
+				OrganisationWide: branches.OrganisationWide,
+			}
+		} else {
+			ids, err := r.authoriser.WhereAuthorised(ctx, organisationId, userId, opId)
+			if err != nil {
+				writeError(writer, err)
+				return
+			}
+			// The organisation id stands for an organisation-wide grant.
+			result.Branches = make([]uuid.UUID, 0, len(ids))
+			for _, id := range ids {
+				if id == organisationId {
+					result.OrganisationWide = true
+				} else {
+					result.Branches = append(result.Branches, id)
+				}
+			}
+		}
+		if result.Branches == nil {
+			result.Branches = []uuid.UUID{}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(result)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// parseBool parses an optional boolean query parameter.
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func CreateUserResourceRouter(repository userRepository, authoriser userAuthoriser) func(r chi.Router) {
+	res := &userResource{repository: repository, authoriser: authoriser}
+
+	return func(r chi.Router) {
+		r.Post(fmt.Sprintf("/{%s}/assignment", UserIdKey), res.AssignRoleToUser())
+		r.Get(fmt.Sprintf("/{%s}/assignment", UserIdKey), res.GetUserRolesAssignments())
+		r.Delete(fmt.Sprintf("/{%s}/assignment", UserIdKey), res.RevokeRoleFromUser())
+		r.Get(fmt.Sprintf("/{%s}/authorised", UserIdKey), res.WhereAuthorised())
+	}
+}
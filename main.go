@@ -2,48 +2,90 @@ package main
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"expvar"
+	"flag"
 	"github.com/dbuduev/authz-service-go/dygraph"
 	resource "github.com/dbuduev/authz-service-go/http"
 	"github.com/dbuduev/authz-service-go/repository"
+	"github.com/dbuduev/authz-service-go/settings"
 	"log"
 	"net/http"
-	"time"
+	"os"
 )
 
-func GetClient() *dynamodb.Client {
-	// Create DynamoDB client
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithSharedConfigFiles(config.DefaultSharedConfigFiles),
-		config.WithSharedCredentialsFiles(config.DefaultSharedCredentialsFiles),
-	)
+// migrate creates the table unless it exists and applies the migrations not applied to it yet.
+func migrate(args []string) {
+	cfg, err := settings.Load(flag.NewFlagSet("migrate", flag.ExitOnError), args, os.LookupEnv)
 	if err != nil {
 		log.Fatalf("failed to load configuration, %v", err)
 	}
+	ctx := context.Background()
+	client, err := cfg.Client(ctx)
+	if err != nil {
+		log.Fatalf("failed to load configuration, %v", err)
+	}
+	table := cfg.DynamoDB.TableName()
+	if err := dygraph.EnsureTable(ctx, client, table, cfg.DynamoDB.TableOptions()); err != nil {
+		log.Fatalf("failed to create table %s, %v", table, err)
+	}
+	repo := repository.CreateRepository(dygraph.CreateGraphClientForTable(client, table))
+	if cfg.LogLevel == settings.LogDebug {
+		repo.SetLogger(log.New(log.Writer(), log.Prefix(), log.Flags()))
+	}
+	applied, err := repo.Migrate(ctx)
+	for _, m := range applied {
+		log.Printf("applied migration %v", m)
+	}
+	if err != nil {
+		log.Fatalf("migration failed, %v", err)
+	}
+	log.Printf("table %s is up to date", table)
+}
 
-	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
-		//o.ClientLogMode |= aws.LogRequestWithBody
-		o.EndpointResolver = dynamodb.EndpointResolverFunc(
-			func(region string, options dynamodb.EndpointResolverOptions) (aws.Endpoint, error) {
-				options.DisableHTTPS = true
-				return aws.Endpoint{URL: "http://localhost:8000", HostnameImmutable: true}, nil
-			})
-	})
+// serveMetrics serves the expvar variables, e.g. the DynamoDB retry counts, at /debug/vars.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	log.Printf("serving metrics on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("failed to serve metrics, %v", err)
+	}
 }
 
 func main() {
-	repo := repository.CreateRepository(dygraph.CreateGraphClient(GetClient(), "test"))
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+	cfg, err := settings.Load(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatalf("failed to load configuration, %v", err)
+	}
+	graph, err := cfg.GraphClient(context.Background())
+	if err != nil {
+		log.Fatalf("failed to load configuration, %v", err)
+	}
+	repo := repository.CreateRepository(graph)
+	if cfg.LogLevel == settings.LogDebug {
+		repo.SetLogger(log.New(log.Writer(), log.Prefix(), log.Flags()))
+	}
 
 	server := http.Server{
-		Addr:         ":8080",
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 90 * time.Second,
-		IdleTimeout:  120 * time.Second,
-		Handler:      resource.ConfigureHandler(repo),
+		Addr:         cfg.Listen,
+		ReadTimeout:  cfg.Timeouts.Read,
+		WriteTimeout: cfg.Timeouts.Write,
+		IdleTimeout:  cfg.Timeouts.Idle,
+		Handler: resource.ConfigureHandlerWithOptions(repo, resource.Options{
+			RequestTimeout: cfg.Timeouts.Request,
+			LogRequests:    cfg.LogLevel != settings.LogWarn,
+			RequestContext: graph.WithRetryBudget,
+		}),
+	}
+	if cfg.MetricsListen != "" {
+		go serveMetrics(cfg.MetricsListen)
 	}
-	err := server.ListenAndServe()
+	log.Printf("listening on %s, table %s", cfg.Listen, cfg.DynamoDB.TableName())
+	err = server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		panic(err)
 	}
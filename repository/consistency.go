@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// InconsistencyKind tells apart the inconsistencies CheckOrganisation and CheckAll report.
+type InconsistencyKind string
+
+const (
+	// MalformedItem is an item which is neither a node nor an edge, e.g. an edge without a target id.
+	MalformedItem InconsistencyKind = "malformed item"
+	// OrphanedEdge is an edge which lacks its mirrored half.
+	OrphanedEdge InconsistencyKind = "orphaned half-edge"
+	// DanglingEdge is an edge to a node which does not exist.
+	DanglingEdge InconsistencyKind = "edge to a missing node"
+)
+
+// Inconsistency is a problem found in the table together with the way to repair it.
+type Inconsistency struct {
+	Kind   InconsistencyKind
+	Item   dygraph.Item
+	Detail string
+	// Delete and Insert repair the inconsistency: malformed items and dangling edges are deleted together
+	// with their mirrored halves, missing halves are restored if both nodes exist and deleted otherwise.
+	Delete []dygraph.Item
+	Insert []dygraph.Edge
+	// Expect is the state of the table the repair relies on, e.g. the missing node of a dangling edge,
+	// the repair is applied only if it still holds.
+	Expect []dygraph.Condition
+}
+
+func (x Inconsistency) String() string {
+	return fmt.Sprintf("%s: %s %s: %s", x.Kind, x.Item.GlobalId, x.Item.TypeTarget, x.Detail)
+}
+
+// CheckOrganisation checks the items of the organisation. The operation catalogue is read
+// to check the edges to the operations, but its items are not checked unless organisationId is the catalogue id.
+func (r *Repository) CheckOrganisation(ctx context.Context, organisationId uuid.UUID) ([]Inconsistency, error) {
+	items, err := r.graphDB.GetItems(ctx, organisationId)
+	if err != nil {
+		return nil, err
+	}
+	var catalogue []dygraph.Item
+	if organisationId != catalogueId {
+		if catalogue, err = r.graphDB.GetItems(ctx, catalogueId); err != nil {
+			return nil, err
+		}
+	}
+	return checkItems(items, catalogue, time.Now()), nil
+}
+
+// CheckAll checks all the items of the table.
+func (r *Repository) CheckAll(ctx context.Context) ([]Inconsistency, error) {
+	items, err := r.graphDB.ScanItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return checkItems(items, nil, time.Now()), nil
+}
+
+// Repair applies the repairs of the inconsistencies. It can be run again if it fails half way.
+// Each repair is applied atomically provided the items it inserts are still missing and the state it relies on
+// still holds, otherwise it fails with dygraph.ConditionFailedError as the table changed since it was checked.
+func (r *Repository) Repair(ctx context.Context, inconsistencies []Inconsistency) error {
+	for _, x := range inconsistencies {
+		if err := r.graphDB.ReplaceItems(ctx, x.Delete, x.Insert, x.Expect); err != nil {
+			return fmt.Errorf("repair %v: %w", x, err)
+		}
+	}
+	return nil
+}
+
+// nodeKey identifies a node within the table.
+type nodeKey struct {
+	organisationId uuid.UUID
+	id             uuid.UUID
+}
+
+// edgeKey identifies an edge regardless of the type of its target, so that it can be matched against its mirrored half.
+type edgeKey struct {
+	organisationId uuid.UUID
+	id             uuid.UUID
+	targetId       uuid.UUID
+	tags           string
+}
+
+func keyOf(e dygraph.Edge) edgeKey {
+	return edgeKey{e.OrganisationId, e.Id, e.TargetNodeId, strings.Join(e.Tags, "|")}
+}
+
+func mirrorKeyOf(e dygraph.Edge) edgeKey {
+	return edgeKey{e.OrganisationId, e.TargetNodeId, e.Id, strings.Join(e.Tags, "|")}
+}
+
+// checkItems finds the inconsistencies of the items. The catalogue items are only used to look up operations.
+// Expired edges are skipped as DynamoDB TTL may have deleted one half already.
+func checkItems(items, catalogue []dygraph.Item, now time.Time) []Inconsistency {
+	var result []Inconsistency
+	nodeTypes := make(map[nodeKey]string)
+	edges := make(map[edgeKey]dygraph.Item)
+	var valid []dygraph.Edge
+	for _, list := range [][]dygraph.Item{catalogue, items} {
+		for _, item := range list {
+			if item.IsEdge() {
+				continue
+			}
+			if node, err := item.Node(); err == nil {
+				nodeTypes[nodeKey{node.OrganisationId, node.Id}] = node.Type
+			}
+		}
+	}
+	for _, item := range items {
+		if !item.IsEdge() {
+			if _, err := item.Node(); err != nil {
+				result = append(result, Inconsistency{Kind: MalformedItem, Item: item, Detail: err.Error(), Delete: []dygraph.Item{item}})
+			}
+			continue
+		}
+		edge, err := item.Edge()
+		if err != nil {
+			result = append(result, Inconsistency{Kind: MalformedItem, Item: item, Detail: err.Error(), Delete: []dygraph.Item{item}})
+			continue
+		}
+		if item.Expired(now) {
+			continue
+		}
+		edges[keyOf(edge)] = item
+		valid = append(valid, edge)
+	}
+
+	// typeOf returns the type of the node, operations are looked up in the catalogue as well.
+	typeOf := func(organisationId, id uuid.UUID) (string, bool) {
+		if t, ok := nodeTypes[nodeKey{organisationId, id}]; ok {
+			return t, true
+		}
+		if t, ok := nodeTypes[nodeKey{catalogueId, id}]; ok && t == OperationRecordType {
+			return t, true
+		}
+		return "", false
+	}
+	// exists expects the node found by typeOf to exist.
+	exists := func(organisationId, id uuid.UUID, nodeType string) dygraph.Condition {
+		if _, ok := nodeTypes[nodeKey{organisationId, id}]; !ok {
+			organisationId = catalogueId
+		}
+		node := dygraph.Node{OrganisationId: organisationId, Id: id, Type: nodeType}
+		return dygraph.Condition{Item: node.Item()}
+	}
+
+	for _, edge := range valid {
+		item := edges[keyOf(edge)]
+		if edge.TargetNodeType == OrganisationRecordType {
+			// The organisation records of the operations have no mirrored half and refer to no node.
+			if t, ok := typeOf(edge.OrganisationId, edge.Id); !ok || t != OperationRecordType {
+				result = append(result, Inconsistency{
+					Kind:   DanglingEdge,
+					Item:   item,
+					Detail: fmt.Sprintf("%s %s does not exist", OperationRecordType, edge.Id),
+					Delete: []dygraph.Item{item},
+					Expect: absent(edge.OrganisationId, edge.Id, OperationRecordType),
+				})
+			}
+			continue
+		}
+		mirror, hasMirror := edges[mirrorKeyOf(edge)]
+		targetType, ok := typeOf(edge.OrganisationId, edge.TargetNodeId)
+		// Users have no node record.
+		if edge.TargetNodeType != UserRecordType && (!ok || targetType != nodeTypeOf(edge.TargetNodeType)) {
+			x := Inconsistency{
+				Kind:   DanglingEdge,
+				Item:   item,
+				Detail: fmt.Sprintf("%s %s does not exist", edge.TargetNodeType, edge.TargetNodeId),
+				Delete: []dygraph.Item{item},
+				Expect: absent(edge.OrganisationId, edge.TargetNodeId, nodeTypeOf(edge.TargetNodeType)),
+			}
+			if hasMirror {
+				x.Delete = append(x.Delete, mirror)
+			}
+			result = append(result, x)
+			continue
+		}
+		if hasMirror {
+			continue
+		}
+		x := Inconsistency{Kind: OrphanedEdge, Item: item}
+		sourceType, ok := typeOf(edge.OrganisationId, edge.Id)
+		if !ok && edge.TargetNodeType == RoleRecordType && len(edge.Tags) > 0 && isUserRoleAssignmentTag(edge.Tags[0]) {
+			sourceType, ok = UserRecordType, true
+		}
+		if ok && edge.TargetNodeType == IncludedRoleRecordType {
+			// Both halves of a role inclusion are of the same type.
+			sourceType = IncludedRoleRecordType
+		}
+		if ok {
+			x.Detail = fmt.Sprintf("the half from %s %s is missing", edge.TargetNodeType, edge.TargetNodeId)
+			x.Insert = []dygraph.Edge{mirrorOf(edge, sourceType)}
+			x.Expect = []dygraph.Condition{{Item: item}}
+			if sourceType != UserRecordType {
+				x.Expect = append(x.Expect, exists(edge.OrganisationId, edge.Id, nodeTypeOf(sourceType)))
+			}
+			if edge.TargetNodeType != UserRecordType {
+				x.Expect = append(x.Expect, exists(edge.OrganisationId, edge.TargetNodeId, nodeTypeOf(edge.TargetNodeType)))
+			}
+		} else {
+			x.Detail = fmt.Sprintf("the half from %s %s is missing and %s does not exist", edge.TargetNodeType, edge.TargetNodeId, edge.Id)
+			x.Delete = []dygraph.Item{item}
+		}
+		result = append(result, x)
+	}
+	return result
+}
+
+// absent expects the node not to exist, operations are expected to be missing from the catalogue as well.
+func absent(organisationId, id uuid.UUID, nodeType string) []dygraph.Condition {
+	organisations := []uuid.UUID{organisationId}
+	if nodeType == OperationRecordType && organisationId != catalogueId {
+		organisations = append(organisations, catalogueId)
+	}
+	result := make([]dygraph.Condition, len(organisations))
+	for i, orgId := range organisations {
+		node := dygraph.Node{OrganisationId: orgId, Id: id, Type: nodeType}
+		result[i] = dygraph.Condition{Item: node.Item(), Absent: true}
+	}
+	return result
+}
+
+// mirrorOf returns the other half of the edge, sourceType is the type of the node the edge leads from.
+// Both halves of every relationship have the same tags, data and validity window.
+func mirrorOf(e dygraph.Edge, sourceType string) dygraph.Edge {
+	return dygraph.Edge{
+		OrganisationId: e.OrganisationId,
+		Id:             e.TargetNodeId,
+		TargetNodeId:   e.Id,
+		TargetNodeType: sourceType,
+		Tags:           e.Tags,
+		Data:           e.Data,
+		ValidFrom:      e.ValidFrom,
+		ValidUntil:     e.ValidUntil,
+	}
+}
+
+// nodeTypeOf returns the type of the node an edge of the type leads to.
+func nodeTypeOf(edgeType string) string {
+	if edgeType == IncludedRoleRecordType {
+		return RoleRecordType
+	}
+	return edgeType
+}
+
+func isUserRoleAssignmentTag(tag string) bool {
+	switch tag {
+	case assignedInBranchTag, deniedInBranchTag, assignedInOrganisationTag, deniedInOrganisationTag:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestRepository_CheckAndRepair(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	setUpTest(repository, testConfig{
+		roles:               []Role{{1, 3, "Admin"}, {1, 4, "PT"}},
+		operations:          []Operation{{5, "view-member"}},
+		assignments:         []OperationAssignment{{1, 3, 5}},
+		branches:            []Branch{{1, 10, "A"}},
+		userRoleAssignments: []UserRoleAssignment{{1, 3, 30, 10}},
+	}, id)
+	orgId := GenId(id, 1)
+	mustInsert := func(edges ...dygraph.Edge) {
+		if err := repository.graphDB.TransactionalInsert(context.Background(), edges); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustDelete := func(edges ...dygraph.Edge) {
+		if err := repository.graphDB.TransactionalDelete(context.Background(), edges); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Half of the operation assignment and half of the user assignment are lost.
+	mustDelete(operationAssignmentEdges(OperationAssignment{1, 3, 5}.To(id))[1])
+	mustDelete(userRoleAssignmentEdges(UserRoleAssignment{1, 3, 30, 10}.To(id))[0])
+	// Half of a role inclusion, the half from the including role is lost.
+	mustInsert(roleInclusionEdges(RoleInclusion{1, 3, 4}.To(id))[1])
+	// An operation which is not in the catalogue.
+	mustInsert(operationAssignmentEdges(OperationAssignment{1, 4, 6}.To(id))...)
+	// A half-edge from a node which does not exist.
+	mustInsert(dygraph.Edge{OrganisationId: orgId, Id: GenId(id, 40), TargetNodeId: GenId(id, 4), TargetNodeType: RoleRecordType})
+	// An expired half-edge, its other half may have been deleted by TTL already.
+	expired := userRoleAssignmentEdges(UserRoleAssignment{1, 4, 31, 10}.To(id))[1]
+	expired.ValidUntil = time.Now().Add(-time.Hour).Truncate(time.Second)
+	mustInsert(expired)
+
+	got, err := repository.CheckOrganisation(context.Background(), orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[InconsistencyKind]int)
+	for _, x := range got {
+		kinds[x.Kind]++
+	}
+	if diff := cmp.Diff(map[InconsistencyKind]int{OrphanedEdge: 4, DanglingEdge: 1}, kinds); diff != "" {
+		t.Errorf("CheckOrganisation() = %v, kinds diff %v", got, diff)
+	}
+
+	if err := repository.Repair(context.Background(), got); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = repository.CheckOrganisation(context.Background(), orgId); err != nil || len(got) != 0 {
+		t.Errorf("CheckOrganisation() after Repair() = %v, %v", got, err)
+	}
+	ops, err := repository.GetOperationsByRole(context.Background(), orgId, GenId(id, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{GenId(id, 5)}, ops); diff != "" {
+		t.Errorf("GetOperationsByRole() after Repair() diff %v", diff)
+	}
+	if ops, err = repository.GetOperationsByRole(context.Background(), orgId, GenId(id, 4)); err != nil || len(ops) != 0 {
+		t.Errorf("GetOperationsByRole() after Repair() = %v, %v", ops, err)
+	}
+	hierarchy, err := repository.GetRoleHierarchy(context.Background(), orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{GenId(id, 4)}, hierarchy[GenId(id, 3)]); diff != "" {
+		t.Errorf("GetRoleHierarchy() after Repair() diff %v", diff)
+	}
+	users, err := repository.GetUserRolesAssignments(context.Background(), orgId, GenId(id, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]core.UserRoleAssignment{UserRoleAssignment{1, 3, 30, 10}.To(id)}, users); diff != "" {
+		t.Errorf("GetUserRolesAssignments() after Repair() diff %v", diff)
+	}
+}
+
+func TestRepository_RepairChangedTable(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	setUpTest(repository, testConfig{
+		roles: []Role{{1, 3, "Admin"}, {1, 4, "PT"}},
+	}, id)
+	orgId := GenId(id, 1)
+	ctx := context.Background()
+	// A role inclusion of a role which does not exist yet and half of an inclusion of an existing role.
+	dangling := roleInclusionEdges(RoleInclusion{1, 3, 5}.To(id))
+	orphaned := roleInclusionEdges(RoleInclusion{1, 3, 4}.To(id))[1]
+	if err := repository.graphDB.TransactionalInsert(ctx, append(dangling, orphaned)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repository.CheckOrganisation(ctx, orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("CheckOrganisation() = %v, want 2 inconsistencies", got)
+	}
+
+	// The role is created and the half-edge is deleted after the check.
+	if err := repository.AddRole(ctx, Role{1, 5, "Manager"}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.graphDB.TransactionalDelete(ctx, []dygraph.Edge{orphaned}); err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range got {
+		if err := repository.Repair(ctx, []Inconsistency{x}); !errors.Is(err, dygraph.ConditionFailedError) {
+			t.Errorf("Repair(%v) error = %v, want %v", x, err, dygraph.ConditionFailedError)
+		}
+	}
+	if got, err = repository.CheckOrganisation(ctx, orgId); err != nil || len(got) != 0 {
+		t.Errorf("CheckOrganisation() after Repair() = %v, %v", got, err)
+	}
+	hierarchy, err := repository.GetRoleHierarchy(ctx, orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{GenId(id, 5)}, hierarchy[GenId(id, 3)]); diff != "" {
+		t.Errorf("GetRoleHierarchy() after Repair() diff %v", diff)
+	}
+}
+
+func Test_checkItems_Malformed(t *testing.T) {
+	orgId := uuid.New()
+	id := uuid.New()
+	items := []dygraph.Item{
+		{GlobalId: orgId.String() + "_" + id.String(), TypeTarget: "edge_ROLE", OrganisationId: orgId.String(), Id: id.String(), Type: RoleRecordType},
+		{GlobalId: orgId.String() + "_r1", TypeTarget: "node_ROLE|r1", OrganisationId: orgId.String(), Id: "r1", Type: RoleRecordType},
+	}
+
+	got := checkItems(items, nil, time.Now())
+	if len(got) != 2 {
+		t.Fatalf("checkItems() = %v, want 2 inconsistencies", got)
+	}
+	for i, x := range got {
+		if x.Kind != MalformedItem {
+			t.Errorf("checkItems() kind = %v, want %v", x.Kind, MalformedItem)
+		}
+		if diff := cmp.Diff([]dygraph.Item{items[i]}, x.Delete); diff != "" {
+			t.Errorf("checkItems() repair diff %v", diff)
+		}
+	}
+}
+
+func TestRepository_CheckOrganisationRecords(t *testing.T) {
+	// The catalogue is checked, so the test has a table of its own.
+	repository := CreateRepository(dygraph.CreateMemoryGraph())
+	id := uuid.New()
+	ctx := context.Background()
+	if err := repository.AddOperation(ctx, Operation{5, "view-member"}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.recordOperationOrganisation(ctx, GenId(id, 1), GenId(id, 5)); err != nil {
+		t.Fatal(err)
+	}
+	// The record of an operation which does not exist.
+	if err := repository.recordOperationOrganisation(ctx, GenId(id, 1), GenId(id, 6)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.CheckOrganisation(ctx, catalogueId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Kind != DanglingEdge || got[0].Item.Id != GenId(id, 6).String() {
+		t.Errorf("CheckOrganisation() = %v, want the record of the missing operation", got)
+	}
+}
@@ -0,0 +1,427 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/dbuduev/authz-service-go/sphinx"
+	"github.com/google/uuid"
+)
+
+// importUnit holds the records an item of an import document is written as, all of them or none.
+// The edges are written provided the referenced nodes exist.
+type importUnit struct {
+	item       core.ImportItem
+	nodes      []dygraph.Node
+	edges      []dygraph.Edge
+	references []dygraph.Node
+	// hierarchy is set for the role inclusions and the branch group nestings, they are written
+	// provided the hierarchy version did not change since they were checked for cycles.
+	hierarchy bool
+}
+
+func (u importUnit) size() int {
+	size := len(u.nodes) + len(u.edges) + len(u.references)
+	if u.hierarchy {
+		size++
+	}
+	return size
+}
+
+// importPlan checks the items of an import document against each other and the organisation
+// and collects the records to write.
+type importPlan struct {
+	organisationId uuid.UUID
+	// stored holds the names of the nodes of the organisation and the operation catalogue by type and id.
+	stored map[string]map[uuid.UUID]string
+	// listed holds the ids of the nodes of the document by type.
+	listed map[string]map[uuid.UUID]struct{}
+	// names holds the ids of the roles and the operations, stored or listed, by type and name.
+	names map[string]map[string]uuid.UUID
+	edges map[edgeKey]struct{}
+	// version is the hierarchy version roles and groups were read at.
+	version  string
+	roles    sphinx.RoleContent
+	groups   sphinx.BranchGroupContent
+	existing int
+	problems []core.ImportProblem
+	nodes    []importUnit
+	links    []importUnit
+}
+
+// Import adds the items of the document to the organisation and the operations to the operation catalogue.
+// The document is validated as a whole first, if any item is invalid nothing is written and Import fails
+// with core.InvalidInputError, the report lists the invalid items.
+// The items are written in chunks of transactions, the nodes before the edges.
+// The items the organisation has already are left as they are, so an import can be run again
+// after a failure. progress, if not nil, is called after every chunk.
+// The report lists the items which could not be written.
+func (r *Repository) Import(ctx context.Context, organisationId uuid.UUID, doc core.ImportDocument, progress func(core.ImportProgress)) (core.ImportReport, error) {
+	r.logf("Importing into organisation %v", organisationId)
+	plan, err := r.createImportPlan(ctx, organisationId)
+	if err != nil {
+		return core.ImportReport{}, err
+	}
+	plan.check(doc)
+	report := core.ImportReport{
+		Total: len(doc.Operations) + len(doc.Roles) + len(doc.Branches) + len(doc.BranchGroups) +
+			len(doc.OperationAssignments) + len(doc.RoleInclusions) + len(doc.Memberships) + len(doc.UserAssignments),
+		Existing: plan.existing,
+		Problems: plan.problems,
+	}
+	if len(report.Problems) > 0 {
+		return report, &core.Error{Kind: core.InvalidInputError, Err: fmt.Errorf("%d invalid items", len(report.Problems))}
+	}
+
+	// The organisation is recorded for the operations first, so there is no assignment they do not know of.
+	recorded := make(map[uuid.UUID]struct{})
+	for _, x := range doc.OperationAssignments {
+		if _, ok := recorded[x.OperationId]; ok {
+			continue
+		}
+		recorded[x.OperationId] = struct{}{}
+		if err := r.recordOperationOrganisation(ctx, organisationId, x.OperationId); err != nil {
+			return report, err
+		}
+	}
+
+	done := report.Existing
+	record := func(u importUnit, err error) {
+		switch {
+		case err == nil:
+			report.Created++
+		case len(u.edges) > 0 && errors.Is(err, dygraph.DuplicateError):
+			report.Existing++
+		default:
+			report.Problems = append(report.Problems, core.ImportProblem{Item: u.item, Err: err})
+		}
+		done++
+	}
+	for _, chunk := range append(importChunks(plan.nodes), importChunks(plan.links)...) {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		err := r.writeImportChunk(ctx, plan, chunk)
+		if len(chunk) > 1 && (errors.Is(err, dygraph.DuplicateError) || errors.Is(err, dygraph.ReferenceNotFoundError)) {
+			// The transaction does not tell which item failed, so the items are written one by one.
+			for _, u := range chunk {
+				record(u, r.writeImportChunk(ctx, plan, []importUnit{u}))
+			}
+		} else {
+			for _, u := range chunk {
+				record(u, err)
+			}
+		}
+		if progress != nil {
+			progress(core.ImportProgress{Done: done, Total: report.Total})
+		}
+	}
+	return report, nil
+}
+
+// importChunks splits the units into the chunks fitting in a transaction.
+func importChunks(units []importUnit) [][]importUnit {
+	var result [][]importUnit
+	var chunk []importUnit
+	size := 0
+	for _, u := range units {
+		if len(chunk) > 0 && size+u.size() > dygraph.MaxTransactionItems {
+			result = append(result, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, u)
+		size += u.size()
+	}
+	if len(chunk) > 0 {
+		result = append(result, chunk)
+	}
+	return result
+}
+
+// writeImportChunk writes all the units of the chunk or none of them.
+// A chunk holds either nodes or edges only. The chunks changing the hierarchies advance the version of the plan.
+func (r *Repository) writeImportChunk(ctx context.Context, plan *importPlan, chunk []importUnit) error {
+	var nodes []dygraph.Node
+	var edges []dygraph.Edge
+	var references []dygraph.Node
+	hierarchy := false
+	seen := make(map[dygraph.Node]struct{})
+	for _, u := range chunk {
+		hierarchy = hierarchy || u.hierarchy
+		nodes = append(nodes, u.nodes...)
+		edges = append(edges, u.edges...)
+		for _, node := range u.references {
+			// A transaction can't check the same node twice.
+			if _, ok := seen[node]; !ok {
+				seen[node] = struct{}{}
+				references = append(references, node)
+			}
+		}
+	}
+	if len(nodes) > 0 {
+		return r.graphDB.InsertRecords(ctx, nodes)
+	}
+	if !hierarchy {
+		return r.graphDB.TransactionalInsertReferencing(ctx, edges, references)
+	}
+	next := nextHierarchyVersion(plan.organisationId, plan.version)
+	if err := r.graphDB.TransactionalInsertVersioned(ctx, edges, references, next, plan.version); err != nil {
+		return err
+	}
+	plan.version = next.Data
+	return nil
+}
+
+// createImportPlan reads the nodes and the hierarchies of the organisation an import is checked against.
+func (r *Repository) createImportPlan(ctx context.Context, organisationId uuid.UUID) (*importPlan, error) {
+	plan := &importPlan{
+		organisationId: organisationId,
+		stored:         make(map[string]map[uuid.UUID]string),
+		listed:         make(map[string]map[uuid.UUID]struct{}),
+		names:          map[string]map[string]uuid.UUID{RoleRecordType: {}, OperationRecordType: {}},
+		edges:          make(map[edgeKey]struct{}),
+	}
+	for _, nodeType := range []string{OperationRecordType, RoleRecordType, BranchRecordType, BranchGroupRecordType} {
+		plan.stored[nodeType] = make(map[uuid.UUID]string)
+		plan.listed[nodeType] = make(map[uuid.UUID]struct{})
+	}
+
+	ops, err := r.GetAllOperations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		plan.stored[OperationRecordType][op.Id] = op.Name
+		plan.names[OperationRecordType][op.Name] = op.Id
+	}
+	roles, err := r.GetAllRoles(ctx, organisationId)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		plan.stored[RoleRecordType][role.Id] = role.Name
+		plan.names[RoleRecordType][role.Name] = role.Id
+	}
+	branches, err := r.GetAllBranches(ctx, organisationId)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range branches {
+		plan.stored[BranchRecordType][b.Id] = b.Name
+	}
+	groups, err := r.GetAllBranchGroups(ctx, organisationId)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		plan.stored[BranchGroupRecordType][g.Id] = g.Name
+	}
+	// The version is read first, so a change made while the hierarchies are read is detected.
+	if plan.version, err = r.hierarchyVersion(ctx, organisationId); err != nil {
+		return nil, err
+	}
+	if plan.roles, err = r.GetRoleHierarchy(ctx, organisationId); err != nil {
+		return nil, err
+	}
+	if plan.groups, err = r.GetHierarchy(ctx, organisationId); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func invalidItem(format string, a ...interface{}) error {
+	return &core.Error{Kind: core.InvalidInputError, Err: fmt.Errorf(format, a...)}
+}
+
+func (p *importPlan) fail(item core.ImportItem, err error) {
+	p.problems = append(p.problems, core.ImportProblem{Item: item, Err: err})
+}
+
+// known reports whether the node of the type is stored or listed in the document.
+func (p *importPlan) known(nodeType string, id uuid.UUID) bool {
+	_, stored := p.stored[nodeType][id]
+	_, listed := p.listed[nodeType][id]
+	return stored || listed
+}
+
+// check validates the items of the document, the nodes first, so the edges can refer to them.
+func (p *importPlan) check(doc core.ImportDocument) {
+	for i, op := range doc.Operations {
+		p.checkNode(core.ImportItem{Kind: core.ImportOperations, Index: i}, catalogueId, op.Id, op.Name, OperationRecordType)
+	}
+	for i, role := range doc.Roles {
+		p.checkNode(core.ImportItem{Kind: core.ImportRoles, Index: i}, p.organisationId, role.Id, role.Name, RoleRecordType)
+	}
+	for i, b := range doc.Branches {
+		p.checkNode(core.ImportItem{Kind: core.ImportBranches, Index: i}, p.organisationId, b.Id, b.Name, BranchRecordType)
+	}
+	for i, g := range doc.BranchGroups {
+		p.checkNode(core.ImportItem{Kind: core.ImportBranchGroups, Index: i}, p.organisationId, g.Id, g.Name, BranchGroupRecordType)
+	}
+	for i, x := range doc.OperationAssignments {
+		x.OrganisationId = p.organisationId
+		p.checkOperationAssignment(core.ImportItem{Kind: core.ImportOperationAssignments, Index: i}, x)
+	}
+	for i, x := range doc.RoleInclusions {
+		x.OrganisationId = p.organisationId
+		p.checkRoleInclusion(core.ImportItem{Kind: core.ImportRoleInclusions, Index: i}, x)
+	}
+	for i, x := range doc.Memberships {
+		x.OrganisationId = p.organisationId
+		p.checkMembership(core.ImportItem{Kind: core.ImportMemberships, Index: i}, x)
+	}
+	for i, x := range doc.UserAssignments {
+		x.OrganisationId = p.organisationId
+		p.checkUserAssignment(core.ImportItem{Kind: core.ImportUserAssignments, Index: i}, x)
+	}
+}
+
+// checkNode plans the node unless it is stored already. The names of the roles and the operations are reserved.
+func (p *importPlan) checkNode(item core.ImportItem, organisationId, id uuid.UUID, name, nodeType string) {
+	if id == uuid.Nil || name == "" {
+		p.fail(item, invalidItem("id and name are required"))
+		return
+	}
+	if _, ok := p.listed[nodeType][id]; ok {
+		p.fail(item, fmt.Errorf("%s %s is listed twice: %w", nodeType, id, dygraph.DuplicateError))
+		return
+	}
+	// Branches and branch groups are assigned by id alone, so they can't share ids.
+	if other := map[string]string{BranchRecordType: BranchGroupRecordType, BranchGroupRecordType: BranchRecordType}[nodeType]; other != "" && p.known(other, id) {
+		p.fail(item, fmt.Errorf("%s %s is a %s: %w", nodeType, id, other, dygraph.DuplicateError))
+		return
+	}
+	p.listed[nodeType][id] = struct{}{}
+	if names, ok := p.names[nodeType]; ok {
+		if owner, taken := names[name]; taken && owner != id {
+			p.fail(item, fmt.Errorf("%s name %q is taken: %w", nodeType, name, dygraph.DuplicateError))
+			return
+		}
+		names[name] = id
+	}
+	if stored, ok := p.stored[nodeType][id]; ok {
+		if stored != name {
+			p.fail(item, fmt.Errorf("%s %s exists as %q: %w", nodeType, id, stored, dygraph.DuplicateError))
+			return
+		}
+		p.existing++
+		return
+	}
+
+	unit := importUnit{item: item, nodes: []dygraph.Node{{OrganisationId: organisationId, Id: id, Type: nodeType, Data: name}}}
+	if nodeType == RoleRecordType || nodeType == OperationRecordType {
+		unit.nodes = append(unit.nodes, nameNode(organisationId, nodeType, name, id))
+	}
+	p.nodes = append(p.nodes, unit)
+}
+
+// checkReferences returns the references to the nodes, failing with dygraph.ReferenceNotFoundError
+// if any of them is neither stored nor listed in the document.
+func (p *importPlan) checkReferences(references ...dygraph.Node) ([]dygraph.Node, error) {
+	for _, node := range references {
+		if node.Id == uuid.Nil {
+			return nil, invalidItem("%s id is required", node.Type)
+		}
+		if !p.known(node.Type, node.Id) {
+			return nil, fmt.Errorf("%s %s: %w", node.Type, node.Id, dygraph.ReferenceNotFoundError)
+		}
+	}
+	return references, nil
+}
+
+// link plans the edges unless the document lists them already.
+// hierarchy tells the role inclusions and the branch group nestings apart.
+func (p *importPlan) link(item core.ImportItem, edges []dygraph.Edge, references []dygraph.Node, hierarchy bool) bool {
+	key := keyOf(edges[0])
+	if _, ok := p.edges[key]; ok {
+		p.fail(item, fmt.Errorf("%s is listed twice: %w", item.Kind, dygraph.DuplicateError))
+		return false
+	}
+	p.edges[key] = struct{}{}
+	p.links = append(p.links, importUnit{item: item, edges: edges, references: references, hierarchy: hierarchy})
+	return true
+}
+
+func (p *importPlan) checkOperationAssignment(item core.ImportItem, x core.OperationAssignment) {
+	references, err := p.checkReferences(
+		reference(x.OrganisationId, x.RoleId, RoleRecordType),
+		reference(catalogueId, x.OperationId, OperationRecordType),
+	)
+	if err != nil {
+		p.fail(item, err)
+		return
+	}
+	p.link(item, operationAssignmentEdges(x), references, false)
+}
+
+func (p *importPlan) checkRoleInclusion(item core.ImportItem, x core.RoleInclusion) {
+	references, err := p.checkReferences(
+		reference(x.OrganisationId, x.RoleId, RoleRecordType),
+		reference(x.OrganisationId, x.IncludedRoleId, RoleRecordType),
+	)
+	if err == nil {
+		err = p.roles.CheckInclusion(x.RoleId, x.IncludedRoleId)
+	}
+	if err != nil {
+		p.fail(item, fmt.Errorf("include role %v in %v: %w", x.IncludedRoleId, x.RoleId, err))
+		return
+	}
+	if p.link(item, roleInclusionEdges(x), references, true) {
+		p.roles[x.RoleId] = append(p.roles[x.RoleId], x.IncludedRoleId)
+	}
+}
+
+func (p *importPlan) checkMembership(item core.ImportItem, x core.BranchAssignment) {
+	group := reference(x.OrganisationId, x.BranchGroupId, BranchGroupRecordType)
+	if !p.known(BranchGroupRecordType, x.BranchId) {
+		references, err := p.checkReferences(group, reference(x.OrganisationId, x.BranchId, BranchRecordType))
+		if err != nil {
+			p.fail(item, err)
+			return
+		}
+		p.link(item, branchAssignmentEdges(x), references, false)
+		return
+	}
+
+	references, err := p.checkReferences(group, reference(x.OrganisationId, x.BranchId, BranchGroupRecordType))
+	if err == nil {
+		err = p.groups.CheckNesting(x.BranchGroupId, x.BranchId)
+	}
+	if err != nil {
+		p.fail(item, fmt.Errorf("assign branch group %v to %v: %w", x.BranchId, x.BranchGroupId, err))
+		return
+	}
+	if p.link(item, nestedBranchGroupEdges(x), references, true) {
+		p.groups[x.BranchGroupId] = append(p.groups[x.BranchGroupId], x.BranchId)
+	}
+}
+
+func (p *importPlan) checkUserAssignment(item core.ImportItem, x core.UserRoleAssignment) {
+	if x.UserId == uuid.Nil {
+		p.fail(item, invalidItem("user id is required"))
+		return
+	}
+	if !x.ValidFrom.IsZero() && !x.ValidUntil.IsZero() && !x.ValidFrom.Before(x.ValidUntil) {
+		p.fail(item, invalidItem("valid until should be after valid from"))
+		return
+	}
+	references := []dygraph.Node{reference(x.OrganisationId, x.RoleId, RoleRecordType)}
+	switch {
+	case x.OrganisationWide && x.BranchId != uuid.Nil:
+		p.fail(item, invalidItem("branch id should be omitted for organisation wide assignments"))
+		return
+	case x.OrganisationWide:
+	case p.known(BranchGroupRecordType, x.BranchId):
+		references = append(references, reference(x.OrganisationId, x.BranchId, BranchGroupRecordType))
+	default:
+		references = append(references, reference(x.OrganisationId, x.BranchId, BranchRecordType))
+	}
+	references, err := p.checkReferences(references...)
+	if err != nil {
+		p.fail(item, err)
+		return
+	}
+	p.link(item, userRoleAssignmentEdges(x), references, false)
+}
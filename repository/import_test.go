@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/dbuduev/authz-service-go/sphinx"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func importDocument(id uuid.UUID) core.ImportDocument {
+	doc := core.ImportDocument{
+		Operations:   []core.Operation{Operation{1, "view-member"}.To(id), Operation{2, "open-till"}.To(id)},
+		Roles:        []core.Role{Role{0, 3, "Admin"}.To(id), Role{0, 4, "Staff"}.To(id)},
+		BranchGroups: []core.BranchGroup{BranchGroup{0, 5, "North"}.To(id), BranchGroup{0, 6, "Auckland"}.To(id)},
+		OperationAssignments: []core.OperationAssignment{
+			OperationAssignment{0, 4, 1}.To(id),
+			OperationAssignment{0, 3, 2}.To(id),
+		},
+		RoleInclusions: []core.RoleInclusion{RoleInclusion{0, 3, 4}.To(id)},
+		Memberships:    []core.BranchAssignment{BranchAssignment{0, 6, 5}.To(id)},
+		UserAssignments: []core.UserRoleAssignment{
+			{RoleId: GenId(id, 3), UserId: GenId(id, 7), BranchId: GenId(id, 6)},
+			{RoleId: GenId(id, 4), UserId: GenId(id, 8), OrganisationWide: true, ValidUntil: time.Now().Add(time.Hour).Round(time.Second).UTC()},
+		},
+	}
+	// Enough branches to take more than one transaction.
+	for b := byte(10); b < 40; b++ {
+		doc.Branches = append(doc.Branches, Branch{0, b, "Branch"}.To(id))
+		doc.Memberships = append(doc.Memberships, BranchAssignment{0, b, 6}.To(id))
+	}
+	return doc
+}
+
+func TestRepository_Import(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	orgId := GenId(id, 0)
+	ctx := context.Background()
+	doc := importDocument(id)
+	// An item of the organisation already.
+	if err := repository.AddRole(ctx, Role{0, 4, "Staff"}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []core.ImportProgress
+	report, err := repository.Import(ctx, orgId, doc, func(p core.ImportProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(core.ImportReport{Total: 72, Created: 71, Existing: 1}, report); diff != "" {
+		t.Errorf("Import() diff %v", diff)
+	}
+	if len(progress) < 2 || progress[len(progress)-1] != (core.ImportProgress{Done: 72, Total: 72}) {
+		t.Errorf("Import() progress %v", progress)
+	}
+
+	if ops, err := repository.GetEffectiveOperationsByRole(ctx, orgId, GenId(id, 3)); err != nil || len(ops) != 2 {
+		t.Errorf("GetEffectiveOperationsByRole() = %v, %v", ops, err)
+	}
+	if role, err := repository.GetRoleByName(ctx, orgId, "Admin"); err != nil || role.Id != GenId(id, 3) {
+		t.Errorf("GetRoleByName() = %v, %v", role, err)
+	}
+	if op, err := repository.GetOperationByName(ctx, doc.Operations[1].Name); err != nil || op != doc.Operations[1] {
+		t.Errorf("GetOperationByName() = %v, %v", op, err)
+	}
+	hierarchy, err := repository.GetHierarchy(ctx, orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branches := hierarchy.Branches(GenId(id, 5)); len(branches) != 30 {
+		t.Errorf("GetHierarchy() branches of the group = %v", branches)
+	}
+	want := doc.UserAssignments[1]
+	want.OrganisationId = orgId
+	if got, err := repository.GetUserRolesAssignments(ctx, orgId, want.UserId); err != nil || len(got) != 1 || !cmp.Equal(got[0], want) {
+		t.Errorf("GetUserRolesAssignments() = %v, %v, want %v", got, err, want)
+	}
+
+	// Importing again changes nothing.
+	report, err = repository.Import(ctx, orgId, doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(core.ImportReport{Total: 72, Existing: 72}, report); diff != "" {
+		t.Errorf("Import() again diff %v", diff)
+	}
+}
+
+func TestRepository_ImportInvalid(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	orgId := GenId(id, 0)
+	ctx := context.Background()
+	if err := repository.AddRole(ctx, Role{0, 9, "Auditor"}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(doc *core.ImportDocument)
+		item    core.ImportItem
+		wantErr error
+	}{
+		{
+			name:    "Nameless role",
+			change:  func(doc *core.ImportDocument) { doc.Roles[1].Name = "" },
+			item:    core.ImportItem{Kind: core.ImportRoles, Index: 1},
+			wantErr: core.InvalidInputError,
+		},
+		{
+			name:    "Role name taken",
+			change:  func(doc *core.ImportDocument) { doc.Roles[1].Name = "Auditor" },
+			item:    core.ImportItem{Kind: core.ImportRoles, Index: 1},
+			wantErr: dygraph.DuplicateError,
+		},
+		{
+			name:    "Role listed twice",
+			change:  func(doc *core.ImportDocument) { doc.Roles = append(doc.Roles, doc.Roles[0]) },
+			item:    core.ImportItem{Kind: core.ImportRoles, Index: 2},
+			wantErr: dygraph.DuplicateError,
+		},
+		{
+			name:    "Branch group id of a branch",
+			change:  func(doc *core.ImportDocument) { doc.BranchGroups[1].Id = doc.Branches[0].Id },
+			item:    core.ImportItem{Kind: core.ImportBranchGroups, Index: 1},
+			wantErr: dygraph.DuplicateError,
+		},
+		{
+			name:    "Unknown operation",
+			change:  func(doc *core.ImportDocument) { doc.OperationAssignments[0].OperationId = uuid.New() },
+			item:    core.ImportItem{Kind: core.ImportOperationAssignments, Index: 0},
+			wantErr: dygraph.ReferenceNotFoundError,
+		},
+		{
+			name: "Role cycle",
+			change: func(doc *core.ImportDocument) {
+				doc.RoleInclusions = append(doc.RoleInclusions, RoleInclusion{0, 4, 3}.To(id))
+			},
+			item:    core.ImportItem{Kind: core.ImportRoleInclusions, Index: 1},
+			wantErr: sphinx.CycleError,
+		},
+		{
+			name: "Branch group cycle",
+			change: func(doc *core.ImportDocument) {
+				doc.Memberships = append(doc.Memberships, BranchAssignment{0, 5, 6}.To(id))
+			},
+			item:    core.ImportItem{Kind: core.ImportMemberships, Index: 31},
+			wantErr: sphinx.CycleError,
+		},
+		{
+			name:    "Assignment listed twice",
+			change:  func(doc *core.ImportDocument) { doc.Memberships[1] = doc.Memberships[2] },
+			item:    core.ImportItem{Kind: core.ImportMemberships, Index: 2},
+			wantErr: dygraph.DuplicateError,
+		},
+		{
+			name:    "Unknown branch",
+			change:  func(doc *core.ImportDocument) { doc.UserAssignments[0].BranchId = uuid.New() },
+			item:    core.ImportItem{Kind: core.ImportUserAssignments, Index: 0},
+			wantErr: dygraph.ReferenceNotFoundError,
+		},
+		{
+			name:    "Organisation wide assignment in a branch",
+			change:  func(doc *core.ImportDocument) { doc.UserAssignments[1].BranchId = doc.Branches[0].Id },
+			item:    core.ImportItem{Kind: core.ImportUserAssignments, Index: 1},
+			wantErr: core.InvalidInputError,
+		},
+		{
+			name: "Empty validity window",
+			change: func(doc *core.ImportDocument) {
+				doc.UserAssignments[1].ValidFrom = doc.UserAssignments[1].ValidUntil
+			},
+			item:    core.ImportItem{Kind: core.ImportUserAssignments, Index: 1},
+			wantErr: core.InvalidInputError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := importDocument(id)
+			tt.change(&doc)
+			report, err := repository.Import(ctx, orgId, doc, func(core.ImportProgress) {
+				t.Errorf("Import() of an invalid document made progress")
+			})
+			if !errors.Is(err, core.InvalidInputError) {
+				t.Errorf("Import() error = %v, want %v", err, core.InvalidInputError)
+			}
+			// The items referring to an invalid one may be reported as well.
+			if len(report.Problems) == 0 || report.Problems[0].Item != tt.item || !errors.Is(report.Problems[0].Err, tt.wantErr) {
+				t.Errorf("Import() problems = %v, want %v of %v", report.Problems, tt.wantErr, tt.item)
+			}
+		})
+	}
+
+	if roles, err := repository.GetAllRoles(ctx, orgId); err != nil || len(roles) != 1 {
+		t.Errorf("GetAllRoles() after the invalid imports = %v, %v", roles, err)
+	}
+}
+
+func TestRepository_ImportConcurrentHierarchyChange(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	orgId := GenId(id, 1)
+	ctx := context.Background()
+	setUpTest(repository, testConfig{
+		roles:          []Role{{1, 3, "Admin"}, {1, 4, "PT"}, {1, 5, "Staff"}},
+		roleInclusions: []RoleInclusion{{1, 3, 4}},
+	}, id)
+	graph := &concurrentChange{GraphDB: repository.graphDB}
+	concurrent := CreateRepository(graph)
+	// The inclusion closing the cycle is made after the import plan read the hierarchy.
+	graph.change = func() {
+		if err := repository.AssignRoleToRole(ctx, RoleInclusion{1, 4, 5}.To(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doc := core.ImportDocument{RoleInclusions: []core.RoleInclusion{RoleInclusion{1, 5, 3}.To(id)}}
+	report, err := concurrent.Import(ctx, orgId, doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || !errors.Is(report.Problems[0].Err, dygraph.ConditionFailedError) {
+		t.Errorf("Import() problems = %v, want the inclusion to fail", report.Problems)
+	}
+	hierarchy, err := repository.GetRoleHierarchy(ctx, orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hierarchy.CheckInclusion(GenId(id, 3), GenId(id, 4)); err != nil {
+		t.Errorf("Import() made a cycle, hierarchy %v", hierarchy)
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/google/uuid"
+)
+
+// Migrations returns the migrations bringing the data stored by the earlier versions up to date.
+// A new migration gets the next version, the existing ones are never changed or removed.
+func (r *Repository) Migrations() []dygraph.Migration {
+	return []dygraph.Migration{
+		{
+			Version:     1,
+			Description: "move the operations of the organisations to the operation catalogue",
+			Apply:       r.forEachOrganisation(r.MigrateOperations),
+		},
+		{
+			Version:     2,
+			Description: "reserve the names of the roles and the operations",
+			Apply:       r.forEachOrganisation(r.IndexNames),
+		},
+		{
+			Version:     3,
+			Description: "give the role inclusions an edge type of their own",
+			Apply:       r.forEachOrganisation(r.MigrateRoleInclusions),
+		},
+		{
+			Version:     4,
+			Description: "record the organisations the operations are assigned in",
+			Apply:       r.forEachOrganisation(r.IndexOperationOrganisations),
+		},
+	}
+}
+
+// Migrate applies the migrations not applied to the table yet and returns them.
+func (r *Repository) Migrate(ctx context.Context) ([]dygraph.Migration, error) {
+	return dygraph.ApplyMigrations(ctx, r.graphDB, r.Migrations())
+}
+
+// forEachOrganisation returns a migration applying f to the operation catalogue and every organisation of the table.
+func (r *Repository) forEachOrganisation(f func(ctx context.Context, organisationId uuid.UUID) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		items, err := r.graphDB.ScanItems(ctx)
+		if err != nil {
+			return err
+		}
+		organisations := []uuid.UUID{catalogueId}
+		seen := map[uuid.UUID]struct{}{catalogueId: {}}
+		for _, item := range items {
+			organisationId, err := uuid.Parse(item.OrganisationId)
+			if err != nil {
+				// Malformed items are reported by CheckAll.
+				continue
+			}
+			if _, ok := seen[organisationId]; !ok {
+				seen[organisationId] = struct{}{}
+				organisations = append(organisations, organisationId)
+			}
+		}
+		for _, organisationId := range organisations {
+			if err := f(ctx, organisationId); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
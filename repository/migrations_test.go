@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"github.com/dbuduev/authz-service-go/core"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestRepository_Migrate(t *testing.T) {
+	// The migrations apply to the whole table, so the test has a table of its own.
+	repository := CreateRepository(dygraph.CreateMemoryGraph())
+	id := uuid.New()
+	ctx := context.Background()
+	if err := repository.RegisterOperations(ctx, []core.Operation{Operation{5, "view-member"}.To(id)}); err != nil {
+		t.Fatal(err)
+	}
+	// An operation stored in the organisation and a role without its name record, as stored by the earlier versions.
+	orgId := GenId(id, 1)
+	legacyOp := Operation{6, "open-till"}.To(id)
+	if err := repository.graphDB.InsertRecord(ctx, operationNode(orgId, legacyOp)); err != nil {
+		t.Fatal(err)
+	}
+	role := Role{1, 3, "Admin"}.To(id)
+	if err := repository.graphDB.InsertRecord(ctx, &dygraph.Node{OrganisationId: orgId, Id: role.Id, Type: RoleRecordType, Data: role.Name}); err != nil {
+		t.Fatal(err)
+	}
+	// A role inclusion stored as a pair of tagged role edges.
+	if err := repository.AddRole(ctx, Role{1, 4, "Staff"}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+	legacyInclusion := []dygraph.Edge{
+		{OrganisationId: orgId, Id: role.Id, TargetNodeId: GenId(id, 4), TargetNodeType: RoleRecordType, Tags: []string{includedRoleTag}, Data: role.Id.String()},
+		{OrganisationId: orgId, Id: GenId(id, 4), TargetNodeId: role.Id, TargetNodeType: RoleRecordType, Tags: []string{includedRoleTag}, Data: role.Id.String()},
+	}
+	if err := repository.graphDB.TransactionalInsert(ctx, legacyInclusion); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := repository.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(repository.Migrations()) {
+		t.Errorf("Migrate() = %v, want all the migrations applied", applied)
+	}
+	if ops, err := repository.graphDB.GetNodes(ctx, orgId, OperationRecordType); err != nil || len(ops) != 0 {
+		t.Errorf("operations left in the organisation after Migrate() = %v, %v", ops, err)
+	}
+	if op, err := repository.GetOperationByName(ctx, legacyOp.Name); err != nil || op != legacyOp {
+		t.Errorf("GetOperationByName() after Migrate() = %v, %v", op, err)
+	}
+	if got, err := repository.GetRoleByName(ctx, orgId, role.Name); err != nil || got != role {
+		t.Errorf("GetRoleByName() after Migrate() = %v, %v", got, err)
+	}
+	if hierarchy, err := repository.GetRoleHierarchy(ctx, orgId); err != nil || len(hierarchy[role.Id]) != 1 || hierarchy[role.Id][0] != GenId(id, 4) {
+		t.Errorf("GetRoleHierarchy() after Migrate() = %v, %v", hierarchy, err)
+	}
+	if edges, err := repository.graphDB.GetEdges(ctx, orgId, RoleRecordType); err != nil || len(edges) != 0 {
+		t.Errorf("role edges left after Migrate() = %v, %v", edges, err)
+	}
+
+	if applied, err = repository.Migrate(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("Migrate() of a migrated table = %v, %v", applied, err)
+	}
+	// The migration records are stored with the catalogue but are not part of it.
+	if ops, err := repository.GetAllOperations(ctx); err != nil || len(ops) != 2 {
+		t.Errorf("GetAllOperations() after Migrate() = %v, %v", ops, err)
+	}
+	if got, err := repository.CheckOrganisation(ctx, catalogueId); err != nil || len(got) != 0 {
+		t.Errorf("CheckOrganisation() of the catalogue after Migrate() = %v, %v", got, err)
+	}
+}
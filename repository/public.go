@@ -1,11 +1,16 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/dbuduev/authz-service-go/core"
 	"github.com/dbuduev/authz-service-go/dygraph"
 	"github.com/dbuduev/authz-service-go/sphinx"
 	"github.com/google/uuid"
+	"log"
+	"sort"
+	"strconv"
 )
 
 const (
@@ -14,38 +19,252 @@ const (
 	BranchRecordType      = "BRANCH"
 	BranchGroupRecordType = "BRANCH_GROUP"
 	UserRecordType        = "USER"
+	// IncludedRoleRecordType is the type of the edges linking a role to a role it includes,
+	// so they are not mixed with the operation and user edges pointing to roles.
+	IncludedRoleRecordType = "INCLUDED_ROLE"
+	// OrganisationRecordType is the type of the edges from a catalogue operation to the organisations
+	// it is assigned in, so the assignments are found when the operation is deleted. Such an edge is written
+	// before the first assignment of the operation in the organisation and has no mirrored half.
+	OrganisationRecordType = "ORGANISATION"
+	// HierarchyVersionRecordType is the type of the node counting the changes made to the branch group nestings
+	// and the role inclusions of an organisation. Every such change rewrites it provided it did not change
+	// since the hierarchy was checked for cycles, so concurrent changes can't create a cycle together.
+	HierarchyVersionRecordType = "HIERARCHY_VERSION"
 )
 
+const (
+	nestedBranchGroupTag = "NESTED_BRANCH_GROUP"
+	// includedRoleTag tagged the role inclusions before they got an edge type of their own.
+	includedRoleTag           = "INCLUDED_ROLE"
+	assignedInBranchTag       = "ASSIGNED_IN_BRANCH"
+	deniedInBranchTag         = "DENIED_IN_BRANCH"
+	assignedInOrganisationTag = "ASSIGNED_IN_ORGANISATION"
+	deniedInOrganisationTag   = "DENIED_IN_ORGANISATION"
+)
+
+// nameRecordPrefix prefixes the type of the records reserving the names of the nodes of a type.
+const nameRecordPrefix = "NAME_"
+
+// nameSpace is the namespace of the ids of the name records.
+var nameSpace = uuid.MustParse("b69d630f-5016-4dc8-ae4d-84776bbdbff1")
+
+// hierarchyVersionId is the id of the hierarchy version node of every organisation.
+var hierarchyVersionId = uuid.MustParse("5d0c6b3e-6f51-4b8e-9a4e-0f4c3b7a9d21")
+
+// maxHierarchyAttempts bounds the attempts to change a hierarchy changed concurrently.
+const maxHierarchyAttempts = 3
+
+// catalogueId is the organisation id the operation catalogue is stored under.
+// Operations are shared by all the organisations, only the assignments of operations to roles are stored
+// in the organisation. The migration records, see dygraph.MigrationRecordType, are stored under it as well.
+var catalogueId = uuid.Nil
+
 type Repository struct {
 	graphDB GraphDB
+	logger  *log.Logger
 }
 
 func CreateRepository(graphDB GraphDB) *Repository {
 	return &Repository{graphDB: graphDB}
 }
 
-func (r *Repository) AddOperation(op core.Operation) error {
-	fmt.Printf("Adding operation %v\n", op)
-	return r.graphDB.InsertRecord(&dygraph.Node{
-		OrganisationId: op.OrganisationId,
+// SetLogger logs the changes made by the repository, e.g. at debug level, to the logger. Nothing is logged by default.
+func (r *Repository) SetLogger(logger *log.Logger) {
+	r.logger = logger
+}
+
+func (r *Repository) logf(format string, v ...interface{}) {
+	if r.logger != nil {
+		r.logger.Printf(format, v...)
+	}
+}
+
+// AddOperation adds the operation to the operation catalogue.
+// It fails with dygraph.DuplicateError if there is an operation with the same id or name.
+func (r *Repository) AddOperation(ctx context.Context, op core.Operation) error {
+	r.logf("Adding operation %v", op)
+	return r.graphDB.InsertRecords(ctx, []dygraph.Node{
+		*operationNode(catalogueId, op),
+		nameNode(catalogueId, OperationRecordType, op.Name, op.Id),
+	})
+}
+
+// RegisterOperations adds the operations missing from the operation catalogue, e.g. the ones listed
+// in the manifest of a service. Registering an operation again is a no-op, registering it under
+// a different name is rejected with dygraph.DuplicateError.
+func (r *Repository) RegisterOperations(ctx context.Context, ops []core.Operation) error {
+	for _, op := range ops {
+		err := r.AddOperation(ctx, op)
+		if !errors.Is(err, dygraph.DuplicateError) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		existing, err := r.GetOperation(ctx, op.Id)
+		if errors.Is(err, dygraph.NotFoundError) {
+			return fmt.Errorf("operation name %q is taken: %w", op.Name, dygraph.DuplicateError)
+		}
+		if err != nil {
+			return err
+		}
+		if existing.Name != op.Name {
+			return fmt.Errorf("operation %v is registered as %q: %w", op.Id, existing.Name, dygraph.DuplicateError)
+		}
+	}
+	return nil
+}
+
+func operationNode(organisationId uuid.UUID, op core.Operation) *dygraph.Node {
+	return &dygraph.Node{
+		OrganisationId: organisationId,
 		Id:             op.Id,
 		Type:           OperationRecordType,
 		Data:           op.Name,
-	})
+	}
 }
 
-func (r *Repository) AddRole(role core.Role) error {
-	fmt.Printf("Adding role %v\n", role)
-	return r.graphDB.InsertRecord(&dygraph.Node{
-		OrganisationId: role.OrganisationId,
-		Id:             role.Id,
-		Type:           RoleRecordType,
-		Data:           role.Name,
+// nameNode reserves the name for the node of the type in the organisation.
+// The id of the record is derived from the name, so it can be looked up by the name
+// and a second record of the same name can't be inserted. The data holds the node id.
+func nameNode(organisationId uuid.UUID, nodeType, name string, id uuid.UUID) dygraph.Node {
+	return dygraph.Node{
+		OrganisationId: organisationId,
+		Id:             uuid.NewSHA1(nameSpace, []byte(name)),
+		Type:           nameRecordPrefix + nodeType,
+		Data:           id.String(),
+	}
+}
+
+// getIdByName returns the id of the node of the type the name is reserved for.
+func (r *Repository) getIdByName(ctx context.Context, organisationId uuid.UUID, nodeType, name string) (uuid.UUID, error) {
+	record := nameNode(organisationId, nodeType, name, uuid.Nil)
+	node, err := r.graphDB.GetNode(ctx, organisationId, record.Id, record.Type)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(node.Data)
+}
+
+// releaseName deletes the name record, the name may not be reserved if the node was added
+// before the names were indexed or if a deletion failed half way.
+func (r *Repository) releaseName(ctx context.Context, organisationId uuid.UUID, nodeType, name string) error {
+	record := nameNode(organisationId, nodeType, name, uuid.Nil)
+	err := r.graphDB.DeleteRecord(ctx, &record)
+	if errors.Is(err, dygraph.NotFoundError) {
+		return nil
+	}
+	return err
+}
+
+// deleteNamedNode deletes the node with its edges and then releases its name, so a failed deletion leaves
+// the name reserved rather than a node without it. If the node is gone already, e.g. as a deletion failed
+// to release the name, the name records still designating the node are released.
+func (r *Repository) deleteNamedNode(ctx context.Context, organisationId, id uuid.UUID, nodeType string) error {
+	node, err := r.graphDB.GetNode(ctx, organisationId, id, nodeType)
+	if err != nil && !errors.Is(err, dygraph.NotFoundError) {
+		return err
+	}
+	named := err == nil
+	deleteErr := r.graphDB.DeleteNode(ctx, organisationId, id, nodeType)
+	if deleteErr != nil && !errors.Is(deleteErr, dygraph.NotFoundError) {
+		return deleteErr
+	}
+	if named {
+		return r.releaseName(ctx, organisationId, nodeType, node.Data)
+	}
+	released, err := r.releaseNamesOf(ctx, organisationId, nodeType, id)
+	if err != nil || released {
+		return err
+	}
+	return deleteErr
+}
+
+// releaseNamesOf deletes the name records designating the node and reports whether there were any.
+func (r *Repository) releaseNamesOf(ctx context.Context, organisationId uuid.UUID, nodeType string, id uuid.UUID) (bool, error) {
+	records, err := r.graphDB.GetNodes(ctx, organisationId, nameRecordPrefix+nodeType)
+	if err != nil {
+		return false, err
+	}
+	released := false
+	for _, record := range records {
+		if record.Data != id.String() {
+			continue
+		}
+		if err := r.graphDB.DeleteRecord(ctx, &record); err != nil && !errors.Is(err, dygraph.NotFoundError) {
+			return released, err
+		}
+		released = true
+	}
+	return released, nil
+}
+
+// IndexNames reserves the names of the roles of the organisation and of the operations of the catalogue
+// added before the names were indexed. It fails with dygraph.DuplicateError listing the names
+// which are used more than once, the other names are indexed anyway.
+func (r *Repository) IndexNames(ctx context.Context, organisationId uuid.UUID) error {
+	roles, err := r.GetAllRoles(ctx, organisationId)
+	if err != nil {
+		return err
+	}
+	ops, err := r.GetAllOperations(ctx)
+	if err != nil {
+		return err
+	}
+	type namedNode struct {
+		name string
+		node dygraph.Node
+	}
+	names := make([]namedNode, 0, len(roles)+len(ops))
+	for _, role := range roles {
+		names = append(names, namedNode{"role " + role.Name, nameNode(organisationId, RoleRecordType, role.Name, role.Id)})
+	}
+	for _, op := range ops {
+		names = append(names, namedNode{"operation " + op.Name, nameNode(catalogueId, OperationRecordType, op.Name, op.Id)})
+	}
+
+	var ambiguous []string
+	for _, x := range names {
+		name, node := x.name, x.node
+		err := r.graphDB.InsertRecord(ctx, &node)
+		if !errors.Is(err, dygraph.DuplicateError) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		existing, err := r.graphDB.GetNode(ctx, node.OrganisationId, node.Id, node.Type)
+		if err != nil {
+			return err
+		}
+		if existing.Data != node.Data {
+			ambiguous = append(ambiguous, name)
+		}
+	}
+	if len(ambiguous) > 0 {
+		sort.Strings(ambiguous)
+		return fmt.Errorf("names used more than once %q: %w", ambiguous, dygraph.DuplicateError)
+	}
+	return nil
+}
+
+// AddRole adds the role to the organisation.
+// It fails with dygraph.DuplicateError if there is a role with the same id or name in the organisation.
+func (r *Repository) AddRole(ctx context.Context, role core.Role) error {
+	r.logf("Adding role %v", role)
+	return r.graphDB.InsertRecords(ctx, []dygraph.Node{
+		{
+			OrganisationId: role.OrganisationId,
+			Id:             role.Id,
+			Type:           RoleRecordType,
+			Data:           role.Name,
+		},
+		nameNode(role.OrganisationId, RoleRecordType, role.Name, role.Id),
 	})
 }
 
-func (r *Repository) AddBranch(b core.Branch) error {
-	return r.graphDB.InsertRecord(&dygraph.Node{
+func (r *Repository) AddBranch(ctx context.Context, b core.Branch) error {
+	return r.graphDB.InsertRecord(ctx, &dygraph.Node{
 		OrganisationId: b.OrganisationId,
 		Id:             b.Id,
 		Type:           BranchRecordType,
@@ -53,8 +272,8 @@ func (r *Repository) AddBranch(b core.Branch) error {
 	})
 }
 
-func (r *Repository) AddBranchGroup(g core.BranchGroup) error {
-	return r.graphDB.InsertRecord(&dygraph.Node{
+func (r *Repository) AddBranchGroup(ctx context.Context, g core.BranchGroup) error {
+	return r.graphDB.InsertRecord(ctx, &dygraph.Node{
 		OrganisationId: g.OrganisationId,
 		Id:             g.Id,
 		Type:           BranchGroupRecordType,
@@ -62,9 +281,242 @@ func (r *Repository) AddBranchGroup(g core.BranchGroup) error {
 	})
 }
 
-func (r *Repository) AssignOperationToRole(x core.OperationAssignment) error {
-	fmt.Printf("Assigning operation to role %v\n", x)
-	request := []dygraph.Edge{
+// DeleteOperation deletes the operation from the operation catalogue and unassigns it from the roles
+// of all the organisations it is assigned in.
+func (r *Repository) DeleteOperation(ctx context.Context, opId uuid.UUID) error {
+	r.logf("Deleting operation %v", opId)
+	if err := r.unassignOperation(ctx, opId); err != nil {
+		return err
+	}
+	return r.deleteNamedNode(ctx, catalogueId, opId, OperationRecordType)
+}
+
+// unassignOperation unassigns the operation from the roles of all the organisations it is assigned in.
+func (r *Repository) unassignOperation(ctx context.Context, opId uuid.UUID) error {
+	organisations, err := r.graphDB.GetNodeEdgesOfType(ctx, catalogueId, opId, OrganisationRecordType)
+	if err != nil {
+		return err
+	}
+	for _, organisation := range organisations {
+		roles, err := r.GetRolesByOperation(ctx, organisation.TargetNodeId, opId)
+		if err != nil {
+			return err
+		}
+		for _, roleId := range roles {
+			err := r.UnassignOperationFromRole(ctx, core.OperationAssignment{
+				OrganisationId: organisation.TargetNodeId,
+				RoleId:         roleId,
+				OperationId:    opId,
+			})
+			if err != nil && !errors.Is(err, dygraph.NotFoundError) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// organisationRecord is the edge recording that the operation is assigned in the organisation.
+func organisationRecord(organisationId, opId uuid.UUID) dygraph.Edge {
+	return dygraph.Edge{OrganisationId: catalogueId, Id: opId, TargetNodeId: organisationId, TargetNodeType: OrganisationRecordType}
+}
+
+// recordOperationOrganisation records that the operation is assigned in the organisation, unless it is already.
+func (r *Repository) recordOperationOrganisation(ctx context.Context, organisationId, opId uuid.UUID) error {
+	err := r.graphDB.TransactionalInsert(ctx, []dygraph.Edge{organisationRecord(organisationId, opId)})
+	if errors.Is(err, dygraph.DuplicateError) {
+		return nil
+	}
+	return err
+}
+
+// IndexOperationOrganisations records the organisation for the operations assigned in it
+// before the organisations of the operations were recorded.
+func (r *Repository) IndexOperationOrganisations(ctx context.Context, organisationId uuid.UUID) error {
+	if organisationId == catalogueId {
+		return nil
+	}
+	edges, err := r.graphDB.GetEdges(ctx, organisationId, OperationRecordType)
+	if err != nil {
+		return err
+	}
+	recorded := make(map[uuid.UUID]struct{})
+	for _, edge := range edges {
+		if _, ok := recorded[edge.TargetNodeId]; ok {
+			continue
+		}
+		recorded[edge.TargetNodeId] = struct{}{}
+		if err := r.recordOperationOrganisation(ctx, organisationId, edge.TargetNodeId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateOperations moves the operations stored in the organisation, as they were before
+// the operation catalogue was introduced, to the catalogue.
+// An operation is matched with the catalogue one by id, or else by name, in which case its assignments
+// are moved to the catalogue operation. The operations missing from the catalogue are added to it.
+// The operation record is removed from the organisation last, so a failed migration can be run again.
+func (r *Repository) MigrateOperations(ctx context.Context, organisationId uuid.UUID) error {
+	if organisationId == catalogueId {
+		return nil
+	}
+	nodes, err := r.graphDB.GetNodes(ctx, organisationId, OperationRecordType)
+	if err != nil || len(nodes) == 0 {
+		return err
+	}
+	catalogue, err := r.GetAllOperations(ctx)
+	if err != nil {
+		return err
+	}
+	ids := make(map[uuid.UUID]struct{}, len(catalogue))
+	byName := make(map[string]uuid.UUID, len(catalogue))
+	for _, op := range catalogue {
+		ids[op.Id] = struct{}{}
+		byName[op.Name] = op.Id
+	}
+
+	for _, node := range nodes {
+		op := ToOperation(node)
+		r.logf("Migrating operation %v of %v", op, organisationId)
+		if _, ok := ids[op.Id]; !ok {
+			if id, ok := byName[op.Name]; ok {
+				if err := r.moveOperationAssignments(ctx, organisationId, op.Id, id); err != nil {
+					return err
+				}
+			} else {
+				if err := r.AddOperation(ctx, op); err != nil {
+					return err
+				}
+				ids[op.Id] = struct{}{}
+				byName[op.Name] = op.Id
+			}
+		}
+		if err := r.graphDB.DeleteRecord(ctx, &node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveOperationAssignments assigns the operation designated by to to the roles having the operation
+// designated by from instead of it.
+func (r *Repository) moveOperationAssignments(ctx context.Context, organisationId, from, to uuid.UUID) error {
+	roles, err := r.GetRolesByOperation(ctx, organisationId, from)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		err := r.AssignOperationToRole(ctx, core.OperationAssignment{OrganisationId: organisationId, RoleId: role, OperationId: to})
+		// The role may have both operations.
+		if err != nil && !errors.Is(err, dygraph.DuplicateError) {
+			return err
+		}
+		err = r.UnassignOperationFromRole(ctx, core.OperationAssignment{OrganisationId: organisationId, RoleId: role, OperationId: from})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRole deletes the role, unassigns all its operations, removes it from the role inclusions
+// and revokes it from all users.
+func (r *Repository) DeleteRole(ctx context.Context, organisationId, roleId uuid.UUID) error {
+	r.logf("Deleting role %v", roleId)
+	if err := r.removeRoleInclusions(ctx, organisationId, roleId); err != nil {
+		return err
+	}
+	return r.deleteNamedNode(ctx, organisationId, roleId, RoleRecordType)
+}
+
+// removeRoleInclusions removes the role from the roles including it and the roles it includes from it.
+// DeleteNode can not do it, as the mirrored halves of the inclusions are not of the type of the role.
+func (r *Repository) removeRoleInclusions(ctx context.Context, organisationId, roleId uuid.UUID) error {
+	links, err := r.graphDB.GetNodeEdgesOfType(ctx, organisationId, roleId, IncludedRoleRecordType)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if err := r.UnassignRoleFromRole(ctx, inclusionOf(organisationId, link)); err != nil && !errors.Is(err, dygraph.NotFoundError) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBranch deletes the branch, removes it from all branch groups
+// and revokes all the roles assigned in the branch.
+func (r *Repository) DeleteBranch(ctx context.Context, organisationId, branchId uuid.UUID) error {
+	r.logf("Deleting branch %v", branchId)
+	if err := r.revokeRolesAssignedIn(ctx, organisationId, branchId); err != nil {
+		return err
+	}
+	return r.graphDB.DeleteNode(ctx, organisationId, branchId, BranchRecordType)
+}
+
+// DeleteBranchGroup deletes the branch group, removes all branches from it
+// and revokes all the roles assigned in the branch group.
+func (r *Repository) DeleteBranchGroup(ctx context.Context, organisationId, branchGroupId uuid.UUID) error {
+	r.logf("Deleting branch group %v", branchGroupId)
+	if err := r.revokeRolesAssignedIn(ctx, organisationId, branchGroupId); err != nil {
+		return err
+	}
+	return r.graphDB.DeleteNode(ctx, organisationId, branchGroupId, BranchGroupRecordType)
+}
+
+// revokeRolesAssignedIn revokes all the roles assigned in the branch or branch group.
+func (r *Repository) revokeRolesAssignedIn(ctx context.Context, organisationId, branchId uuid.UUID) error {
+	edges, err := r.graphDB.GetEdges(ctx, organisationId, UserRecordType)
+	if err != nil {
+		return err
+	}
+	// Role to user edges carry the branch or branch group id in the data.
+	for _, edge := range edges {
+		if edge.Data != branchId.String() {
+			continue
+		}
+		err := r.RevokeRoleFromUser(ctx, core.UserRoleAssignment{
+			OrganisationId: organisationId,
+			RoleId:         edge.Id,
+			UserId:         edge.TargetNodeId,
+			BranchId:       branchId,
+			Deny:           isDenial(edge),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AssignOperationToRole assigns the catalogue operation to the role.
+// It fails with dygraph.ReferenceNotFoundError if either of them does not exist.
+func (r *Repository) AssignOperationToRole(ctx context.Context, x core.OperationAssignment) error {
+	r.logf("Assigning operation to role %v", x)
+	// The record comes first, so there is no assignment the operation does not know of.
+	if err := r.recordOperationOrganisation(ctx, x.OrganisationId, x.OperationId); err != nil {
+		return err
+	}
+	return r.graphDB.TransactionalInsertReferencing(ctx, operationAssignmentEdges(x), []dygraph.Node{
+		reference(x.OrganisationId, x.RoleId, RoleRecordType),
+		reference(catalogueId, x.OperationId, OperationRecordType),
+	})
+}
+
+// reference designates the node an assignment requires to exist.
+func reference(organisationId, id uuid.UUID, nodeType string) dygraph.Node {
+	return dygraph.Node{OrganisationId: organisationId, Id: id, Type: nodeType}
+}
+
+func (r *Repository) UnassignOperationFromRole(ctx context.Context, x core.OperationAssignment) error {
+	r.logf("Unassigning operation from role %v", x)
+	return r.graphDB.TransactionalDelete(ctx, operationAssignmentEdges(x))
+}
+
+func operationAssignmentEdges(x core.OperationAssignment) []dygraph.Edge {
+	return []dygraph.Edge{
 		{
 			OrganisationId: x.OrganisationId,
 			Id:             x.OperationId,
@@ -78,13 +530,201 @@ func (r *Repository) AssignOperationToRole(x core.OperationAssignment) error {
 			TargetNodeType: OperationRecordType,
 		},
 	}
+}
+
+// AssignRoleToRole makes the role include the role designated by x.IncludedRoleId.
+// It is rejected with sphinx.CycleError if the role would end up including itself.
+func (r *Repository) AssignRoleToRole(ctx context.Context, x core.RoleInclusion) error {
+	r.logf("Including role in role %v", x)
+	return r.changeHierarchy(ctx, x.OrganisationId, func(version string) error {
+		hierarchy, err := r.GetRoleHierarchy(ctx, x.OrganisationId)
+		if err != nil {
+			return err
+		}
+		if err := hierarchy.CheckInclusion(x.RoleId, x.IncludedRoleId); err != nil {
+			return fmt.Errorf("include role %v in %v: %w", x.IncludedRoleId, x.RoleId, err)
+		}
+		return r.graphDB.TransactionalInsertVersioned(ctx, roleInclusionEdges(x), []dygraph.Node{
+			reference(x.OrganisationId, x.RoleId, RoleRecordType),
+			reference(x.OrganisationId, x.IncludedRoleId, RoleRecordType),
+		}, nextHierarchyVersion(x.OrganisationId, version), version)
+	})
+}
+
+// changeHierarchy reads the hierarchy version of the organisation and makes the change checked against it,
+// again if the hierarchy changed concurrently.
+func (r *Repository) changeHierarchy(ctx context.Context, organisationId uuid.UUID, change func(version string) error) error {
+	for attempt := 1; ; attempt++ {
+		version, err := r.hierarchyVersion(ctx, organisationId)
+		if err != nil {
+			return err
+		}
+		err = change(version)
+		if !errors.Is(err, dygraph.ConditionFailedError) || attempt == maxHierarchyAttempts {
+			return err
+		}
+	}
+}
+
+// hierarchyVersion returns the hierarchy version of the organisation, empty if its hierarchy never changed.
+func (r *Repository) hierarchyVersion(ctx context.Context, organisationId uuid.UUID) (string, error) {
+	node, err := r.graphDB.GetNode(ctx, organisationId, hierarchyVersionId, HierarchyVersionRecordType)
+	if errors.Is(err, dygraph.NotFoundError) {
+		return "", nil
+	}
+	return node.Data, err
+}
+
+// nextHierarchyVersion returns the hierarchy version node following the version.
+func nextHierarchyVersion(organisationId uuid.UUID, version string) dygraph.Node {
+	n, _ := strconv.Atoi(version)
+	return dygraph.Node{
+		OrganisationId: organisationId,
+		Id:             hierarchyVersionId,
+		Type:           HierarchyVersionRecordType,
+		Data:           strconv.Itoa(n + 1),
+	}
+}
+
+func (r *Repository) UnassignRoleFromRole(ctx context.Context, x core.RoleInclusion) error {
+	r.logf("Excluding role from role %v", x)
+	return r.graphDB.TransactionalDelete(ctx, roleInclusionEdges(x))
+}
+
+// roleInclusionEdges links two roles. Both edges are of the same type,
+// so the direction is kept in the data which holds the including role id.
+func roleInclusionEdges(x core.RoleInclusion) []dygraph.Edge {
+	return []dygraph.Edge{
+		{
+			OrganisationId: x.OrganisationId,
+			Id:             x.RoleId,
+			TargetNodeId:   x.IncludedRoleId,
+			TargetNodeType: IncludedRoleRecordType,
+			Data:           x.RoleId.String(),
+		},
+		{
+			OrganisationId: x.OrganisationId,
+			Id:             x.IncludedRoleId,
+			TargetNodeId:   x.RoleId,
+			TargetNodeType: IncludedRoleRecordType,
+			Data:           x.RoleId.String(),
+		},
+	}
+}
+
+// MigrateRoleInclusions rewrites the role inclusions stored as role edges tagged INCLUDED_ROLE,
+// as they were before the inclusions got an edge type of their own.
+// The new edges are inserted before the old ones are deleted, so a failed migration can be run again.
+func (r *Repository) MigrateRoleInclusions(ctx context.Context, organisationId uuid.UUID) error {
+	links, err := r.graphDB.GetEdges(ctx, organisationId, RoleRecordType)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if len(link.Tags) == 0 || link.Tags[0] != includedRoleTag {
+			continue
+		}
+		// Either half designates the inclusion, the other one may have been lost.
+		x := inclusionOf(organisationId, link)
+		r.logf("Migrating role inclusion %v", x)
+		err := r.graphDB.TransactionalInsert(ctx, roleInclusionEdges(x))
+		if err != nil && !errors.Is(err, dygraph.DuplicateError) {
+			return err
+		}
+		mirror := link
+		mirror.Id, mirror.TargetNodeId = link.TargetNodeId, link.Id
+		for _, legacy := range []dygraph.Edge{link, mirror} {
+			err := r.graphDB.TransactionalDelete(ctx, []dygraph.Edge{legacy})
+			if err != nil && !errors.Is(err, dygraph.NotFoundError) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inclusionOf returns the role inclusion either half of which is the edge, its data holds the including role id.
+func inclusionOf(organisationId uuid.UUID, link dygraph.Edge) core.RoleInclusion {
+	if link.Data == link.Id.String() {
+		return core.RoleInclusion{OrganisationId: organisationId, RoleId: link.Id, IncludedRoleId: link.TargetNodeId}
+	}
+	return core.RoleInclusion{OrganisationId: organisationId, RoleId: link.TargetNodeId, IncludedRoleId: link.Id}
+}
+
+// GetRoleHierarchy returns the roles every role includes directly.
+func (r *Repository) GetRoleHierarchy(ctx context.Context, organisationId uuid.UUID) (sphinx.RoleContent, error) {
+	links, err := r.graphDB.GetEdges(ctx, organisationId, IncludedRoleRecordType)
+	if err != nil {
+		return nil, err
+	}
+	result := make(sphinx.RoleContent)
 
-	return r.graphDB.TransactionalInsert(request)
+	for _, link := range links {
+		// Each inclusion is stored twice, keep the edge leading from the including role.
+		if link.Data != link.Id.String() {
+			continue
+		}
+		result[link.Id] = append(result[link.Id], link.TargetNodeId)
+	}
+	return result, nil
 }
 
-func (r *Repository) AssignBranchToBranchGroup(x core.BranchAssignment) error {
-	fmt.Printf("Assigning branch to branch group %v\n", x)
-	request := []dygraph.Edge{
+// AssignBranchToBranchGroup makes the branch or the branch group designated by x.BranchId
+// a member of the branch group. Nesting a branch group is rejected with sphinx.CycleError
+// if the branch group would end up containing itself.
+// It fails with dygraph.ReferenceNotFoundError if the branch group or the member does not exist.
+func (r *Repository) AssignBranchToBranchGroup(ctx context.Context, x core.BranchAssignment) error {
+	r.logf("Assigning branch to branch group %v", x)
+	nested, err := r.isBranchGroup(ctx, x.OrganisationId, x.BranchId)
+	if err != nil {
+		return err
+	}
+	group := reference(x.OrganisationId, x.BranchGroupId, BranchGroupRecordType)
+	if !nested {
+		return r.graphDB.TransactionalInsertReferencing(ctx, branchAssignmentEdges(x), []dygraph.Node{
+			group,
+			reference(x.OrganisationId, x.BranchId, BranchRecordType),
+		})
+	}
+
+	return r.changeHierarchy(ctx, x.OrganisationId, func(version string) error {
+		hierarchy, err := r.GetHierarchy(ctx, x.OrganisationId)
+		if err != nil {
+			return err
+		}
+		if err := hierarchy.CheckNesting(x.BranchGroupId, x.BranchId); err != nil {
+			return fmt.Errorf("assign branch group %v to %v: %w", x.BranchId, x.BranchGroupId, err)
+		}
+		return r.graphDB.TransactionalInsertVersioned(ctx, nestedBranchGroupEdges(x), []dygraph.Node{
+			group,
+			reference(x.OrganisationId, x.BranchId, BranchGroupRecordType),
+		}, nextHierarchyVersion(x.OrganisationId, version), version)
+	})
+}
+
+// RemoveBranchFromBranchGroup removes the branch or the nested branch group from the branch group.
+func (r *Repository) RemoveBranchFromBranchGroup(ctx context.Context, x core.BranchAssignment) error {
+	r.logf("Removing branch from branch group %v", x)
+	nested, err := r.isBranchGroup(ctx, x.OrganisationId, x.BranchId)
+	if err != nil {
+		return err
+	}
+	if nested {
+		return r.graphDB.TransactionalDelete(ctx, nestedBranchGroupEdges(x))
+	}
+	return r.graphDB.TransactionalDelete(ctx, branchAssignmentEdges(x))
+}
+
+func (r *Repository) isBranchGroup(ctx context.Context, organisationId, id uuid.UUID) (bool, error) {
+	_, err := r.graphDB.GetNode(ctx, organisationId, id, BranchGroupRecordType)
+	if errors.Is(err, dygraph.NotFoundError) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func branchAssignmentEdges(x core.BranchAssignment) []dygraph.Edge {
+	return []dygraph.Edge{
 		{
 			OrganisationId: x.OrganisationId,
 			Id:             x.BranchId,
@@ -98,26 +738,52 @@ func (r *Repository) AssignBranchToBranchGroup(x core.BranchAssignment) error {
 			TargetNodeType: BranchRecordType,
 		},
 	}
+}
 
-	return r.graphDB.TransactionalInsert(request)
+// nestedBranchGroupEdges links two branch groups. Both edges point to a branch group,
+// so the direction is kept in the data which holds the containing branch group id.
+func nestedBranchGroupEdges(x core.BranchAssignment) []dygraph.Edge {
+	tags := []string{nestedBranchGroupTag}
+	return []dygraph.Edge{
+		{
+			OrganisationId: x.OrganisationId,
+			Id:             x.BranchId,
+			TargetNodeId:   x.BranchGroupId,
+			TargetNodeType: BranchGroupRecordType,
+			Tags:           tags,
+			Data:           x.BranchGroupId.String(),
+		},
+		{
+			OrganisationId: x.OrganisationId,
+			Id:             x.BranchGroupId,
+			TargetNodeId:   x.BranchId,
+			TargetNodeType: BranchGroupRecordType,
+			Tags:           tags,
+			Data:           x.BranchGroupId.String(),
+		},
+	}
 }
 
-func (r *Repository) GetBranchesByBranchGroup(organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error) {
-	items, err := r.graphDB.GetNodeEdgesOfType(organisationId, branchGroupId, BranchRecordType)
+// GetBranchesByBranchGroup returns the branches the branch group contains directly.
+func (r *Repository) GetBranchesByBranchGroup(ctx context.Context, organisationId, branchGroupId uuid.UUID) ([]uuid.UUID, error) {
+	items, err := r.graphDB.GetNodeEdgesOfType(ctx, organisationId, branchGroupId, BranchRecordType)
 	if err != nil {
 		return nil, err
 	}
 
-	result := make([]uuid.UUID, len(items))
-	for i, item := range items {
-		result[i] = item.TargetNodeId
+	result := make([]uuid.UUID, 0, len(items))
+	for _, item := range items {
+		// BranchRecordType is a prefix of BranchGroupRecordType.
+		if item.TargetNodeType == BranchRecordType {
+			result = append(result, item.TargetNodeId)
+		}
 	}
 
 	return result, nil
 }
 
-func (r *Repository) GetRolesByOperation(organisationId, opId uuid.UUID) ([]uuid.UUID, error) {
-	items, err := r.graphDB.GetNodeEdgesOfType(organisationId, opId, RoleRecordType)
+func (r *Repository) GetRolesByOperation(ctx context.Context, organisationId, opId uuid.UUID) ([]uuid.UUID, error) {
+	items, err := r.graphDB.GetNodeEdgesOfType(ctx, organisationId, opId, RoleRecordType)
 	if err != nil {
 		return nil, err
 	}
@@ -130,8 +796,8 @@ func (r *Repository) GetRolesByOperation(organisationId, opId uuid.UUID) ([]uuid
 	return result, nil
 }
 
-func (r *Repository) GetOperationsByRole(organisationId, roleId uuid.UUID) ([]uuid.UUID, error) {
-	items, err := r.graphDB.GetNodeEdgesOfType(organisationId, roleId, OperationRecordType)
+func (r *Repository) GetOperationsByRole(ctx context.Context, organisationId, roleId uuid.UUID) ([]uuid.UUID, error) {
+	items, err := r.graphDB.GetNodeEdgesOfType(ctx, organisationId, roleId, OperationRecordType)
 	if err != nil {
 		return nil, err
 	}
@@ -144,8 +810,49 @@ func (r *Repository) GetOperationsByRole(organisationId, roleId uuid.UUID) ([]uu
 	return result, nil
 }
 
-func (r *Repository) GetAllRoles(organisationId uuid.UUID) ([]core.Role, error) {
-	nodes, err := r.graphDB.GetNodes(organisationId, RoleRecordType)
+// GetEffectiveRolesByOperation returns the roles having the operation directly
+// together with the roles including them directly or transitively.
+func (r *Repository) GetEffectiveRolesByOperation(ctx context.Context, organisationId, opId uuid.UUID) ([]uuid.UUID, error) {
+	roles, err := r.GetRolesByOperation(ctx, organisationId, opId)
+	if err != nil || len(roles) == 0 {
+		return roles, err
+	}
+	hierarchy, err := r.GetRoleHierarchy(ctx, organisationId)
+	if err != nil {
+		return nil, err
+	}
+
+	return hierarchy.Reverse().Closure(roles), nil
+}
+
+// GetEffectiveOperationsByRole returns the operations of the role
+// together with the operations of all the roles it includes directly or transitively.
+func (r *Repository) GetEffectiveOperationsByRole(ctx context.Context, organisationId, roleId uuid.UUID) ([]uuid.UUID, error) {
+	hierarchy, err := r.GetRoleHierarchy(ctx, organisationId)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uuid.UUID]struct{})
+	result := make([]uuid.UUID, 0)
+	for _, role := range hierarchy.Closure([]uuid.UUID{roleId}) {
+		ops, err := r.GetOperationsByRole(ctx, organisationId, role)
+		if err != nil {
+			return nil, err
+		}
+		for _, op := range ops {
+			if _, ok := seen[op]; !ok {
+				seen[op] = struct{}{}
+				result = append(result, op)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (r *Repository) GetAllRoles(ctx context.Context, organisationId uuid.UUID) ([]core.Role, error) {
+	nodes, err := r.graphDB.GetNodes(ctx, organisationId, RoleRecordType)
 	if err != nil {
 		return nil, err
 	}
@@ -157,8 +864,46 @@ func (r *Repository) GetAllRoles(organisationId uuid.UUID) ([]core.Role, error)
 	return result, nil
 }
 
-func (r *Repository) GetAllOperations(organisationId uuid.UUID) ([]core.Operation, error) {
-	nodes, err := r.graphDB.GetNodes(organisationId, OperationRecordType)
+func (r *Repository) GetRole(ctx context.Context, organisationId, roleId uuid.UUID) (core.Role, error) {
+	node, err := r.graphDB.GetNode(ctx, organisationId, roleId, RoleRecordType)
+	if err != nil {
+		return core.Role{}, err
+	}
+
+	return ToRole(node), nil
+}
+
+// GetRoleByName returns the role of the organisation having the name.
+func (r *Repository) GetRoleByName(ctx context.Context, organisationId uuid.UUID, name string) (core.Role, error) {
+	id, err := r.getIdByName(ctx, organisationId, RoleRecordType, name)
+	if err != nil {
+		return core.Role{}, err
+	}
+	return core.Role{OrganisationId: organisationId, Id: id, Name: name}, nil
+}
+
+// GetOperationByName returns the operation of the operation catalogue having the name.
+func (r *Repository) GetOperationByName(ctx context.Context, name string) (core.Operation, error) {
+	id, err := r.getIdByName(ctx, catalogueId, OperationRecordType, name)
+	if err != nil {
+		return core.Operation{}, err
+	}
+	return core.Operation{Id: id, Name: name}, nil
+}
+
+// GetOperation returns the operation of the operation catalogue.
+func (r *Repository) GetOperation(ctx context.Context, opId uuid.UUID) (core.Operation, error) {
+	node, err := r.graphDB.GetNode(ctx, catalogueId, opId, OperationRecordType)
+	if err != nil {
+		return core.Operation{}, err
+	}
+
+	return ToOperation(node), nil
+}
+
+// GetAllOperations returns the operation catalogue.
+func (r *Repository) GetAllOperations(ctx context.Context) ([]core.Operation, error) {
+	nodes, err := r.graphDB.GetNodes(ctx, catalogueId, OperationRecordType)
 	if err != nil {
 		return nil, err
 	}
@@ -170,17 +915,93 @@ func (r *Repository) GetAllOperations(organisationId uuid.UUID) ([]core.Operatio
 	return result, nil
 }
 
-func (r *Repository) AssignRoleToUser(x core.UserRoleAssignment) error {
-	fmt.Printf("Assigning role to a user in a branch %v\n", x)
-	tags := []string{"ASSIGNED_IN_BRANCH", x.BranchId.String()}
-	request := []dygraph.Edge{
+func (r *Repository) GetAllBranches(ctx context.Context, organisationId uuid.UUID) ([]core.Branch, error) {
+	nodes, err := r.graphDB.GetNodes(ctx, organisationId, BranchRecordType)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]core.Branch, 0, len(nodes))
+	for _, node := range nodes {
+		// BranchRecordType is a prefix of BranchGroupRecordType.
+		if node.Type == BranchRecordType {
+			result = append(result, ToBranch(node))
+		}
+	}
+
+	return result, nil
+}
+
+func (r *Repository) GetAllBranchGroups(ctx context.Context, organisationId uuid.UUID) ([]core.BranchGroup, error) {
+	nodes, err := r.graphDB.GetNodes(ctx, organisationId, BranchGroupRecordType)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]core.BranchGroup, len(nodes))
+	for i, node := range nodes {
+		result[i] = ToBranchGroup(node)
+	}
+
+	return result, nil
+}
+
+// AssignRoleToUser assigns or denies the role to the user in the branch, the branch group or the organisation.
+// It fails with dygraph.ReferenceNotFoundError if the role, the branch or the branch group does not exist.
+func (r *Repository) AssignRoleToUser(ctx context.Context, x core.UserRoleAssignment) error {
+	r.logf("Assigning role to a user in a branch %v", x)
+	references := []dygraph.Node{reference(x.OrganisationId, x.RoleId, RoleRecordType)}
+	if !x.OrganisationWide {
+		isGroup, err := r.isBranchGroup(ctx, x.OrganisationId, x.BranchId)
+		if err != nil {
+			return err
+		}
+		branchType := BranchRecordType
+		if isGroup {
+			branchType = BranchGroupRecordType
+		}
+		references = append(references, reference(x.OrganisationId, x.BranchId, branchType))
+	}
+	return r.graphDB.TransactionalInsertReferencing(ctx, userRoleAssignmentEdges(x), references)
+}
+
+func (r *Repository) RevokeRoleFromUser(ctx context.Context, x core.UserRoleAssignment) error {
+	r.logf("Revoking role from a user in a branch %v", x)
+	return r.graphDB.TransactionalDelete(ctx, userRoleAssignmentEdges(x))
+}
+
+// RevokeUserRoles revokes all the roles of the user in every branch and branch group.
+func (r *Repository) RevokeUserRoles(ctx context.Context, organisationId, userId uuid.UUID) error {
+	r.logf("Revoking all roles from a user %v", userId)
+	return r.graphDB.DeleteNode(ctx, organisationId, userId, UserRecordType)
+}
+
+// userRoleAssignmentEdges links the role and the user. Grants and denials are told apart by the tags,
+// so a role can be both assigned and denied to a user in the same branch.
+// The validity window is not a part of the key, so there is at most one grant of a role
+// to a user in a branch whatever its window is.
+// Organisation-wide assignments have tags of their own and keep the organisation id in the data.
+func userRoleAssignmentEdges(x core.UserRoleAssignment) []dygraph.Edge {
+	var tags []string
+	data := x.BranchId.String()
+	switch {
+	case x.OrganisationWide && x.Deny:
+		tags, data = []string{deniedInOrganisationTag}, x.OrganisationId.String()
+	case x.OrganisationWide:
+		tags, data = []string{assignedInOrganisationTag}, x.OrganisationId.String()
+	case x.Deny:
+		tags = []string{deniedInBranchTag, x.BranchId.String()}
+	default:
+		tags = []string{assignedInBranchTag, x.BranchId.String()}
+	}
+	return []dygraph.Edge{
 		{
 			OrganisationId: x.OrganisationId,
 			Id:             x.RoleId,
 			TargetNodeId:   x.UserId,
 			TargetNodeType: UserRecordType,
 			Tags:           tags,
-			Data:           x.BranchId.String(),
+			Data:           data,
+			ValidFrom:      x.ValidFrom,
+			ValidUntil:     x.ValidUntil,
 		},
 		{
 			OrganisationId: x.OrganisationId,
@@ -188,15 +1009,15 @@ func (r *Repository) AssignRoleToUser(x core.UserRoleAssignment) error {
 			TargetNodeId:   x.RoleId,
 			TargetNodeType: RoleRecordType,
 			Tags:           tags,
-			Data:           x.BranchId.String(),
+			Data:           data,
+			ValidFrom:      x.ValidFrom,
+			ValidUntil:     x.ValidUntil,
 		},
 	}
-
-	return r.graphDB.TransactionalInsert(request)
 }
 
-func (r *Repository) GetUserRolesAssignments(organisationId, userId uuid.UUID) ([]core.UserRoleAssignment, error) {
-	records, err := r.graphDB.GetNodeEdgesOfType(organisationId, userId, RoleRecordType)
+func (r *Repository) GetUserRolesAssignments(ctx context.Context, organisationId, userId uuid.UUID) ([]core.UserRoleAssignment, error) {
+	records, err := r.graphDB.GetNodeEdgesOfType(ctx, organisationId, userId, RoleRecordType)
 	if err != nil {
 		return nil, err
 	}
@@ -208,8 +1029,9 @@ func (r *Repository) GetUserRolesAssignments(organisationId, userId uuid.UUID) (
 	return result, nil
 }
 
-func (r *Repository) GetHierarchy(organisationId uuid.UUID) (sphinx.BranchGroupContent, error) {
-	links, err := r.graphDB.GetEdges(organisationId, BranchGroupRecordType)
+// GetHierarchy returns the direct members, branches and nested branch groups, of every branch group.
+func (r *Repository) GetHierarchy(ctx context.Context, organisationId uuid.UUID) (sphinx.BranchGroupContent, error) {
+	links, err := r.graphDB.GetEdges(ctx, organisationId, BranchGroupRecordType)
 	if err != nil {
 		return nil, err
 	}
@@ -217,6 +1039,12 @@ func (r *Repository) GetHierarchy(organisationId uuid.UUID) (sphinx.BranchGroupC
 
 	for _, link := range links {
 		branchGroupId := link.TargetNodeId
+		if len(link.Tags) > 0 && link.Tags[0] == nestedBranchGroupTag {
+			// Each nesting is stored twice, keep the edge leading from the member.
+			if link.Data != branchGroupId.String() {
+				continue
+			}
+		}
 		result[branchGroupId] = append(result[branchGroupId], link.Id)
 	}
 	return result, nil
@@ -232,17 +1060,47 @@ func ToRole(r dygraph.Node) core.Role {
 
 func ToOperation(r dygraph.Node) core.Operation {
 	return core.Operation{
+		Id:   r.Id,
+		Name: r.Data,
+	}
+}
+
+func ToBranch(r dygraph.Node) core.Branch {
+	return core.Branch{
 		OrganisationId: r.OrganisationId,
 		Id:             r.Id,
 		Name:           r.Data,
 	}
 }
 
-func ToUserRoleAssignment(r dygraph.Edge) core.UserRoleAssignment {
-	return core.UserRoleAssignment{
+func ToBranchGroup(r dygraph.Node) core.BranchGroup {
+	return core.BranchGroup{
 		OrganisationId: r.OrganisationId,
-		RoleId:         r.TargetNodeId,
-		UserId:         r.Id,
-		BranchId:       uuid.MustParse(r.Data),
+		Id:             r.Id,
+		Name:           r.Data,
+	}
+}
+
+func ToUserRoleAssignment(r dygraph.Edge) core.UserRoleAssignment {
+	result := core.UserRoleAssignment{
+		OrganisationId:   r.OrganisationId,
+		RoleId:           r.TargetNodeId,
+		UserId:           r.Id,
+		OrganisationWide: isOrganisationWide(r),
+		Deny:             isDenial(r),
+		ValidFrom:        r.ValidFrom,
+		ValidUntil:       r.ValidUntil,
+	}
+	if !result.OrganisationWide {
+		result.BranchId = uuid.MustParse(r.Data)
 	}
+	return result
+}
+
+func isDenial(r dygraph.Edge) bool {
+	return len(r.Tags) > 0 && (r.Tags[0] == deniedInBranchTag || r.Tags[0] == deniedInOrganisationTag)
+}
+
+func isOrganisationWide(r dygraph.Edge) bool {
+	return len(r.Tags) > 0 && (r.Tags[0] == assignedInOrganisationTag || r.Tags[0] == deniedInOrganisationTag)
 }
@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"github.com/dbuduev/authz-service-go/core"
 	"github.com/dbuduev/authz-service-go/dygraph"
 	"github.com/dbuduev/authz-service-go/sphinx"
@@ -9,20 +12,21 @@ import (
 	"github.com/google/uuid"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
+	"time"
 )
 
 type Operation struct {
-	OrganisationId byte
-	Id             byte
-	Name           string
+	Id   byte
+	Name string
 }
 
+// To makes the name unique to the test as the operation catalogue is shared by the tests.
 func (o Operation) To(id uuid.UUID) core.Operation {
 	return core.Operation{
-		OrganisationId: GenId(id, o.OrganisationId),
-		Id:             GenId(id, o.Id),
-		Name:           o.Name,
+		Id:   GenId(id, o.Id),
+		Name: o.Name + "-" + id.String(),
 	}
 }
 
@@ -86,6 +90,20 @@ func (b BranchGroup) To(id uuid.UUID) core.BranchGroup {
 	}
 }
 
+type RoleInclusion struct {
+	OrganisationId byte
+	RoleId         byte
+	IncludedRoleId byte
+}
+
+func (a RoleInclusion) To(id uuid.UUID) core.RoleInclusion {
+	return core.RoleInclusion{
+		OrganisationId: GenId(id, a.OrganisationId),
+		RoleId:         GenId(id, a.RoleId),
+		IncludedRoleId: GenId(id, a.IncludedRoleId),
+	}
+}
+
 type BranchAssignment struct {
 	OrganisationId byte
 	BranchId       byte
@@ -120,53 +138,69 @@ type testConfig struct {
 	roles               []Role
 	operations          []Operation
 	assignments         []OperationAssignment
+	roleInclusions      []RoleInclusion
 	branches            []Branch
 	branchGroups        []BranchGroup
 	branchAssignments   []BranchAssignment
 	userRoleAssignments []UserRoleAssignment
+	userRoleDenials     []UserRoleAssignment
 }
 
 func setUpTest(repository *Repository, config testConfig, id uuid.UUID) {
 	for _, r := range config.roles {
-		err := repository.AddRole(r.To(id))
+		err := repository.AddRole(context.Background(), r.To(id))
 		if err != nil {
 			panic(err)
 		}
 	}
 	for _, operation := range config.operations {
-		err := repository.AddOperation(operation.To(id))
+		err := repository.AddOperation(context.Background(), operation.To(id))
 		if err != nil {
 			panic(err)
 		}
 
 	}
 	for _, assignment := range config.assignments {
-		err := repository.AssignOperationToRole(assignment.To(id))
+		err := repository.AssignOperationToRole(context.Background(), assignment.To(id))
 		if err != nil {
 			panic(err)
 		}
 	}
 
+	for _, x := range config.roleInclusions {
+		if err := repository.AssignRoleToRole(context.Background(), x.To(id)); err != nil {
+			panic(err)
+		}
+	}
+
 	for _, b := range config.branches {
-		if err := repository.AddBranch(b.To(id)); err != nil {
+		if err := repository.AddBranch(context.Background(), b.To(id)); err != nil {
 			panic(err)
 		}
 	}
 
 	for _, b := range config.branchGroups {
-		if err := repository.AddBranchGroup(b.To(id)); err != nil {
+		if err := repository.AddBranchGroup(context.Background(), b.To(id)); err != nil {
 			panic(err)
 		}
 	}
 
 	for _, x := range config.branchAssignments {
-		if err := repository.AssignBranchToBranchGroup(x.To(id)); err != nil {
+		if err := repository.AssignBranchToBranchGroup(context.Background(), x.To(id)); err != nil {
 			panic(err)
 		}
 	}
 
 	for _, x := range config.userRoleAssignments {
-		if err := repository.AssignRoleToUser(x.To(id)); err != nil {
+		if err := repository.AssignRoleToUser(context.Background(), x.To(id)); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, x := range config.userRoleDenials {
+		denial := x.To(id)
+		denial.Deny = true
+		if err := repository.AssignRoleToUser(context.Background(), denial); err != nil {
 			panic(err)
 		}
 	}
@@ -200,7 +234,7 @@ func TestRepository_GetRolesByOperation(t *testing.T) {
 			id:   uuid.New(),
 			config: testConfig{
 				roles:       []Role{{1, 3, "Admin"}, {1, 4, "PT"}},
-				operations:  []Operation{{1, 2, "manage-member"}, {1, 5, "view-member"}},
+				operations:  []Operation{{2, "manage-member"}, {5, "view-member"}},
 				assignments: []OperationAssignment{{1, 3, 2}, {1, 3, 5}, {1, 4, 5}},
 			},
 			args: args{
@@ -215,7 +249,7 @@ func TestRepository_GetRolesByOperation(t *testing.T) {
 			id:   uuid.New(),
 			config: testConfig{
 				roles:       []Role{{1, 3, "Admin"}, {1, 4, "Owner"}},
-				operations:  []Operation{{1, 2, "manage-member"}, {1, 5, "view-member"}},
+				operations:  []Operation{{2, "manage-member"}, {5, "view-member"}},
 				assignments: []OperationAssignment{{1, 3, 2}, {1, 4, 2}, {1, 4, 5}},
 			},
 			args: args{
@@ -229,7 +263,7 @@ func TestRepository_GetRolesByOperation(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			setUpTest(repository, tt.config, tt.id)
-			got, err := repository.GetRolesByOperation(GenId(tt.id, tt.args.organisationId), GenId(tt.id, tt.args.opId))
+			got, err := repository.GetRolesByOperation(context.Background(), GenId(tt.id, tt.args.organisationId), GenId(tt.id, tt.args.opId))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetRolesByOperation() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -280,7 +314,7 @@ func TestRepository_GetOperationsByRole(t *testing.T) {
 			id:   uuid.New(),
 			config: testConfig{
 				roles:       []Role{{1, 3, "Admin"}, {1, 4, "PT"}},
-				operations:  []Operation{{1, 2, "manage-member"}, {1, 5, "view-member"}},
+				operations:  []Operation{{2, "manage-member"}, {5, "view-member"}},
 				assignments: []OperationAssignment{{1, 3, 2}, {1, 3, 5}, {1, 4, 5}},
 			},
 			args: args{
@@ -295,7 +329,7 @@ func TestRepository_GetOperationsByRole(t *testing.T) {
 			id:   uuid.New(),
 			config: testConfig{
 				roles:       []Role{{1, 3, "Admin"}, {1, 4, "Owner"}},
-				operations:  []Operation{{1, 2, "manage-member"}, {1, 5, "view-member"}},
+				operations:  []Operation{{2, "manage-member"}, {5, "view-member"}},
 				assignments: []OperationAssignment{{1, 3, 2}, {1, 4, 2}, {1, 4, 5}},
 			},
 			args: args{
@@ -309,7 +343,7 @@ func TestRepository_GetOperationsByRole(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			setUpTest(repository, tt.config, tt.id)
-			got, err := repository.GetOperationsByRole(GenId(tt.id, tt.args.organisationId), GenId(tt.id, tt.args.roleId))
+			got, err := repository.GetOperationsByRole(context.Background(), GenId(tt.id, tt.args.organisationId), GenId(tt.id, tt.args.roleId))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetOperationsByRole() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -381,7 +415,7 @@ func TestRepository_GetAllRoles(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			setUpTest(repository, tt.config, tt.id)
 			organisationId := GenId(tt.id, tt.args.organisationId)
-			got, err := repository.GetAllRoles(organisationId)
+			got, err := repository.GetAllRoles(context.Background(), organisationId)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getAllRoles() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -450,7 +484,7 @@ func TestRepository_GetBranchesByBranchGroup(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			setUpTest(repository, tt.config, tt.id)
-			got, err := repository.GetBranchesByBranchGroup(GenId(tt.id, tt.args.organisationId), GenId(tt.id, tt.args.branchGroupId))
+			got, err := repository.GetBranchesByBranchGroup(context.Background(), GenId(tt.id, tt.args.organisationId), GenId(tt.id, tt.args.branchGroupId))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetBranchesByBranchGroup() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -560,7 +594,7 @@ func TestRepository_GetUserRolesAssignments(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			setUpTest(repository, tt.config, tt.id)
-			got, err := repository.GetUserRolesAssignments(GenId(tt.id, tt.args.organisationId), GenId(tt.id, tt.args.userId))
+			got, err := repository.GetUserRolesAssignments(context.Background(), GenId(tt.id, tt.args.organisationId), GenId(tt.id, tt.args.userId))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetBranchesByBranchGroup() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -635,11 +669,29 @@ func TestRepository_GetHierarchy(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Nested groups",
+			id:   uuid.New(),
+			config: testConfig{
+				branches:          []Branch{{1, 3, "A"}, {1, 4, "B"}},
+				branchGroups:      []BranchGroup{{1, 2, "X"}, {1, 5, "Y"}, {1, 6, "Z"}},
+				branchAssignments: []BranchAssignment{{1, 3, 2}, {1, 2, 5}, {1, 4, 5}, {1, 5, 6}},
+			},
+			args: args{
+				organisationId: 1,
+			},
+			want: map[byte][]byte{
+				2: {3},
+				5: {2, 4},
+				6: {5},
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			setUpTest(repository, tt.config, tt.id)
-			got, err := repository.GetHierarchy(GenId(tt.id, tt.args.organisationId))
+			got, err := repository.GetHierarchy(context.Background(), GenId(tt.id, tt.args.organisationId))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetHierarchy() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -660,10 +712,798 @@ func TestRepository_GetHierarchy(t *testing.T) {
 
 }
 
-func CreateTestGraphClient() *dygraph.Dygraph {
-	return dygraph.CreateGraphClient(testutils.GetClient(), "test")
+// CreateTestGraphClient returns DynamoDB Local backed graph if testutils.UseDynamoDB is true,
+// otherwise it returns an in-memory graph.
+func CreateTestGraphClient() GraphDB {
+	if testutils.UseDynamoDB() {
+		client := testutils.GetClient()
+		ensureTestTable.Do(func() {
+			if err := dygraph.EnsureTable(context.Background(), client, dygraph.TableName("test"), dygraph.TableOptions{TimeToLive: true}); err != nil {
+				panic(err)
+			}
+		})
+		return dygraph.CreateGraphClient(client, "test")
+	}
+	return dygraph.CreateMemoryGraph()
 }
 
+var ensureTestTable sync.Once
+
 func CreateTestRepository() *Repository {
 	return CreateRepository(CreateTestGraphClient())
 }
+
+func TestRepository_Deletion(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	config := testConfig{
+		roles:               []Role{{1, 3, "Admin"}, {1, 4, "PT"}},
+		operations:          []Operation{{5, "manage-member"}, {6, "view-member"}},
+		assignments:         []OperationAssignment{{1, 3, 5}, {1, 3, 6}, {1, 4, 6}},
+		branches:            []Branch{{1, 10, "A"}, {1, 11, "B"}},
+		branchGroups:        []BranchGroup{{1, 20, "X"}},
+		branchAssignments:   []BranchAssignment{{1, 10, 20}, {1, 11, 20}},
+		userRoleAssignments: []UserRoleAssignment{{1, 3, 30, 10}, {1, 4, 30, 20}, {1, 4, 31, 11}},
+	}
+	setUpTest(repository, config, id)
+	orgId := GenId(id, 1)
+	ids := func(bs ...byte) []uuid.UUID {
+		result := make([]uuid.UUID, len(bs))
+		for i, b := range bs {
+			result[i] = GenId(id, b)
+		}
+		sphinx.Sort(result)
+		return result
+	}
+	sorted := func(in []uuid.UUID, err error) []uuid.UUID {
+		if err != nil {
+			t.Fatal(err)
+		}
+		sphinx.Sort(in)
+		return in
+	}
+	roles := func(userId byte) []uuid.UUID {
+		assignments, err := repository.GetUserRolesAssignments(context.Background(), orgId, GenId(id, userId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make([]uuid.UUID, len(assignments))
+		for i, a := range assignments {
+			result[i] = a.RoleId
+		}
+		sphinx.Sort(result)
+		return result
+	}
+
+	if err := repository.UnassignOperationFromRole(context.Background(), OperationAssignment{1, 3, 6}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids(5), sorted(repository.GetOperationsByRole(context.Background(), orgId, GenId(id, 3)))); diff != "" {
+		t.Errorf("UnassignOperationFromRole() operations diff %v", diff)
+	}
+	if diff := cmp.Diff(ids(4), sorted(repository.GetRolesByOperation(context.Background(), orgId, GenId(id, 6)))); diff != "" {
+		t.Errorf("UnassignOperationFromRole() roles diff %v", diff)
+	}
+	if err := repository.UnassignOperationFromRole(context.Background(), OperationAssignment{1, 3, 6}.To(id)); !errors.Is(err, dygraph.NotFoundError) {
+		t.Errorf("UnassignOperationFromRole() of a missing assignment error = %v", err)
+	}
+
+	if err := repository.RemoveBranchFromBranchGroup(context.Background(), BranchAssignment{1, 11, 20}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids(10), sorted(repository.GetBranchesByBranchGroup(context.Background(), orgId, GenId(id, 20)))); diff != "" {
+		t.Errorf("RemoveBranchFromBranchGroup() diff %v", diff)
+	}
+
+	if err := repository.RevokeRoleFromUser(context.Background(), UserRoleAssignment{1, 3, 30, 10}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids(4), roles(30)); diff != "" {
+		t.Errorf("RevokeRoleFromUser() diff %v", diff)
+	}
+
+	if err := repository.DeleteBranchGroup(context.Background(), orgId, GenId(id, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{}, roles(30)); diff != "" {
+		t.Errorf("DeleteBranchGroup() did not revoke roles assigned in the group %v", diff)
+	}
+	hierarchy, err := repository.GetHierarchy(context.Background(), orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hierarchy) != 0 {
+		t.Errorf("DeleteBranchGroup() left the hierarchy %v", hierarchy)
+	}
+
+	if err := repository.DeleteRole(context.Background(), orgId, GenId(id, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{}, roles(31)); diff != "" {
+		t.Errorf("DeleteRole() did not revoke the role %v", diff)
+	}
+	if diff := cmp.Diff([]uuid.UUID{}, sorted(repository.GetRolesByOperation(context.Background(), orgId, GenId(id, 6)))); diff != "" {
+		t.Errorf("DeleteRole() did not unassign operations %v", diff)
+	}
+	allRoles, err := repository.GetAllRoles(context.Background(), orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]core.Role{Role{1, 3, "Admin"}.To(id)}, allRoles); diff != "" {
+		t.Errorf("DeleteRole() roles diff %v", diff)
+	}
+
+	if err := repository.DeleteRole(context.Background(), orgId, GenId(id, 4)); !errors.Is(err, dygraph.NotFoundError) {
+		t.Errorf("DeleteRole() of a missing role error = %v", err)
+	}
+}
+
+func TestRepository_GetAllBranchesAndBranchGroups(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	setUpTest(repository, testConfig{
+		branches:     []Branch{{1, 3, "A"}, {1, 4, "B"}, {2, 5, "C"}},
+		branchGroups: []BranchGroup{{1, 6, "X"}},
+	}, id)
+
+	branches, err := repository.GetAllBranches(context.Background(), GenId(id, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].Name < branches[j].Name
+	})
+	if diff := cmp.Diff([]core.Branch{Branch{1, 3, "A"}.To(id), Branch{1, 4, "B"}.To(id)}, branches); diff != "" {
+		t.Errorf("GetAllBranches() diff %v", diff)
+	}
+
+	groups, err := repository.GetAllBranchGroups(context.Background(), GenId(id, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]core.BranchGroup{BranchGroup{1, 6, "X"}.To(id)}, groups); diff != "" {
+		t.Errorf("GetAllBranchGroups() diff %v", diff)
+	}
+}
+
+func TestRepository_NestedBranchGroups(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	setUpTest(repository, testConfig{
+		branches:          []Branch{{1, 10, "A"}, {1, 11, "B"}},
+		branchGroups:      []BranchGroup{{1, 20, "X"}, {1, 21, "Y"}, {1, 22, "Z"}},
+		branchAssignments: []BranchAssignment{{1, 10, 20}, {1, 11, 21}, {1, 20, 21}, {1, 21, 22}},
+	}, id)
+	orgId := GenId(id, 1)
+
+	cycles := []BranchAssignment{{1, 22, 20}, {1, 21, 20}, {1, 20, 20}}
+	for _, x := range cycles {
+		if err := repository.AssignBranchToBranchGroup(context.Background(), x.To(id)); !errors.Is(err, sphinx.CycleError) {
+			t.Errorf("AssignBranchToBranchGroup(%v) error = %v, want a cycle error", x, err)
+		}
+	}
+
+	branches, err := repository.GetBranchesByBranchGroup(context.Background(), orgId, GenId(id, 21))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]uuid.UUID{GenId(id, 11)}, branches); diff != "" {
+		t.Errorf("GetBranchesByBranchGroup() returned nested groups %v", diff)
+	}
+
+	if err := repository.RemoveBranchFromBranchGroup(context.Background(), BranchAssignment{1, 20, 21}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.AssignBranchToBranchGroup(context.Background(), BranchAssignment{1, 22, 20}.To(id)); err != nil {
+		t.Errorf("AssignBranchToBranchGroup() after removal error = %v", err)
+	}
+
+	if err := repository.DeleteBranchGroup(context.Background(), orgId, GenId(id, 22)); err != nil {
+		t.Fatal(err)
+	}
+	hierarchy, err := repository.GetHierarchy(context.Background(), orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sphinx.BranchGroupContent{
+		GenId(id, 20): {GenId(id, 10)},
+		GenId(id, 21): {GenId(id, 11)},
+	}
+	if diff := cmp.Diff(want, hierarchy); diff != "" {
+		t.Errorf("DeleteBranchGroup() hierarchy diff %v", diff)
+	}
+}
+
+// concurrentChange is a graph making a change right after edges are read the first time, e.g. a hierarchy.
+type concurrentChange struct {
+	GraphDB
+	change func()
+}
+
+func (g *concurrentChange) GetEdges(ctx context.Context, organisationId uuid.UUID, edgeType string) ([]dygraph.Edge, error) {
+	edges, err := g.GraphDB.GetEdges(ctx, organisationId, edgeType)
+	if change := g.change; change != nil {
+		g.change = nil
+		change()
+	}
+	return edges, err
+}
+
+func TestRepository_ConcurrentHierarchyChanges(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	// Role 3 includes role 4, group 21 contains group 20.
+	setUpTest(repository, testConfig{
+		roles:             []Role{{1, 3, "Admin"}, {1, 4, "PT"}, {1, 5, "Staff"}},
+		roleInclusions:    []RoleInclusion{{1, 3, 4}},
+		branchGroups:      []BranchGroup{{1, 20, "X"}, {1, 21, "Y"}, {1, 22, "Z"}},
+		branchAssignments: []BranchAssignment{{1, 20, 21}},
+	}, id)
+	graph := &concurrentChange{GraphDB: repository.graphDB}
+	concurrent := CreateRepository(graph)
+	ctx := context.Background()
+
+	// A change closing the cycle is made after the hierarchy is read and before it is changed.
+	graph.change = func() {
+		if err := repository.AssignRoleToRole(ctx, RoleInclusion{1, 4, 5}.To(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := concurrent.AssignRoleToRole(ctx, RoleInclusion{1, 5, 3}.To(id)); !errors.Is(err, sphinx.CycleError) {
+		t.Errorf("AssignRoleToRole() error = %v, want a cycle error", err)
+	}
+	graph.change = func() {
+		if err := repository.AssignBranchToBranchGroup(ctx, BranchAssignment{1, 21, 22}.To(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := concurrent.AssignBranchToBranchGroup(ctx, BranchAssignment{1, 22, 20}.To(id)); !errors.Is(err, sphinx.CycleError) {
+		t.Errorf("AssignBranchToBranchGroup() error = %v, want a cycle error", err)
+	}
+	if got, err := repository.CheckOrganisation(ctx, GenId(id, 1)); err != nil || len(got) != 0 {
+		t.Errorf("CheckOrganisation() = %v, %v", got, err)
+	}
+}
+
+func TestRepository_RoleInclusion(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	// Role 3 includes role 4 which includes role 5.
+	setUpTest(repository, testConfig{
+		roles:               []Role{{1, 3, "store-manager"}, {1, 4, "shift-lead"}, {1, 5, "staff-member"}},
+		operations:          []Operation{{6, "manage-staff"}, {7, "open-till"}, {8, "view-rota"}},
+		assignments:         []OperationAssignment{{1, 3, 6}, {1, 4, 7}, {1, 5, 8}, {1, 3, 8}},
+		roleInclusions:      []RoleInclusion{{1, 3, 4}, {1, 4, 5}},
+		branches:            []Branch{{1, 10, "A"}},
+		userRoleAssignments: []UserRoleAssignment{{1, 4, 30, 10}},
+	}, id)
+	orgId := GenId(id, 1)
+	ids := func(bs ...byte) []uuid.UUID {
+		result := make([]uuid.UUID, len(bs))
+		for i, b := range bs {
+			result[i] = GenId(id, b)
+		}
+		sphinx.Sort(result)
+		return result
+	}
+	sorted := func(in []uuid.UUID, err error) []uuid.UUID {
+		if err != nil {
+			t.Fatal(err)
+		}
+		sphinx.Sort(in)
+		return in
+	}
+
+	for _, x := range []RoleInclusion{{1, 5, 3}, {1, 4, 3}, {1, 3, 3}} {
+		if err := repository.AssignRoleToRole(context.Background(), x.To(id)); !errors.Is(err, sphinx.CycleError) {
+			t.Errorf("AssignRoleToRole(%v) error = %v, want a cycle error", x, err)
+		}
+	}
+
+	if diff := cmp.Diff(ids(6, 7, 8), sorted(repository.GetEffectiveOperationsByRole(context.Background(), orgId, GenId(id, 3)))); diff != "" {
+		t.Errorf("GetEffectiveOperationsByRole() diff %v", diff)
+	}
+	if diff := cmp.Diff(ids(7), sorted(repository.GetOperationsByRole(context.Background(), orgId, GenId(id, 4)))); diff != "" {
+		t.Errorf("GetOperationsByRole() diff %v", diff)
+	}
+	if diff := cmp.Diff(ids(3, 4, 5), sorted(repository.GetEffectiveRolesByOperation(context.Background(), orgId, GenId(id, 8)))); diff != "" {
+		t.Errorf("GetEffectiveRolesByOperation() diff %v", diff)
+	}
+	if diff := cmp.Diff(ids(3, 5), sorted(repository.GetRolesByOperation(context.Background(), orgId, GenId(id, 8)))); diff != "" {
+		t.Errorf("GetRolesByOperation() diff %v", diff)
+	}
+
+	if err := repository.UnassignRoleFromRole(context.Background(), RoleInclusion{1, 4, 5}.To(id)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids(6, 7, 8), sorted(repository.GetEffectiveOperationsByRole(context.Background(), orgId, GenId(id, 3)))); diff != "" {
+		t.Errorf("GetEffectiveOperationsByRole() after unassignment diff %v", diff)
+	}
+	if diff := cmp.Diff(ids(7), sorted(repository.GetEffectiveOperationsByRole(context.Background(), orgId, GenId(id, 4)))); diff != "" {
+		t.Errorf("GetEffectiveOperationsByRole() after unassignment diff %v", diff)
+	}
+
+	if err := repository.DeleteRole(context.Background(), orgId, GenId(id, 4)); err != nil {
+		t.Fatal(err)
+	}
+	hierarchy, err := repository.GetRoleHierarchy(context.Background(), orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hierarchy) != 0 {
+		t.Errorf("DeleteRole() left the role hierarchy %v", hierarchy)
+	}
+	if got, err := repository.CheckOrganisation(context.Background(), orgId); err != nil || len(got) != 0 {
+		t.Errorf("CheckOrganisation() after DeleteRole() = %v, %v", got, err)
+	}
+}
+
+func TestRepository_Denials(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	setUpTest(repository, testConfig{
+		roles:               []Role{{1, 3, "area-manager"}},
+		branches:            []Branch{{1, 10, "A"}, {1, 11, "B"}},
+		userRoleAssignments: []UserRoleAssignment{{1, 3, 30, 10}, {1, 3, 30, 11}},
+		userRoleDenials:     []UserRoleAssignment{{1, 3, 30, 10}, {1, 3, 30, 11}},
+	}, id)
+	orgId := GenId(id, 1)
+	denial := func(x UserRoleAssignment) core.UserRoleAssignment {
+		result := x.To(id)
+		result.Deny = true
+		return result
+	}
+	assignments := func() []core.UserRoleAssignment {
+		result, err := repository.GetUserRolesAssignments(context.Background(), orgId, GenId(id, 30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			if result[i].BranchId != result[j].BranchId {
+				return bytes.Compare(result[i].BranchId[:], result[j].BranchId[:]) < 0
+			}
+			return !result[i].Deny && result[j].Deny
+		})
+		return result
+	}
+	sorted := func(in ...core.UserRoleAssignment) []core.UserRoleAssignment {
+		sort.SliceStable(in, func(i, j int) bool {
+			return bytes.Compare(in[i].BranchId[:], in[j].BranchId[:]) < 0
+		})
+		return in
+	}
+
+	want := sorted(
+		UserRoleAssignment{1, 3, 30, 10}.To(id), denial(UserRoleAssignment{1, 3, 30, 10}),
+		UserRoleAssignment{1, 3, 30, 11}.To(id), denial(UserRoleAssignment{1, 3, 30, 11}),
+	)
+	if diff := cmp.Diff(want, assignments()); diff != "" {
+		t.Errorf("GetUserRolesAssignments() diff %v", diff)
+	}
+
+	if err := repository.RevokeRoleFromUser(context.Background(), denial(UserRoleAssignment{1, 3, 30, 10})); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.DeleteBranch(context.Background(), orgId, GenId(id, 11)); err != nil {
+		t.Fatal(err)
+	}
+	want = []core.UserRoleAssignment{UserRoleAssignment{1, 3, 30, 10}.To(id)}
+	if diff := cmp.Diff(want, assignments()); diff != "" {
+		t.Errorf("GetUserRolesAssignments() after revocation diff %v", diff)
+	}
+}
+
+func TestRepository_TimeBoundAssignments(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	setUpTest(repository, testConfig{
+		roles:    []Role{{1, 3, "cover-staff"}},
+		branches: []Branch{{1, 10, "A"}},
+	}, id)
+	from := time.Date(2021, 5, 1, 9, 0, 0, 0, time.UTC)
+	assignment := UserRoleAssignment{1, 3, 30, 10}.To(id)
+	assignment.ValidFrom = from
+	assignment.ValidUntil = from.Add(7 * 24 * time.Hour)
+	if err := repository.AssignRoleToUser(context.Background(), assignment); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.GetUserRolesAssignments(context.Background(), GenId(id, 1), GenId(id, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]core.UserRoleAssignment{assignment}, got); diff != "" {
+		t.Errorf("GetUserRolesAssignments() diff %v", diff)
+	}
+
+	if err := repository.RevokeRoleFromUser(context.Background(), UserRoleAssignment{1, 3, 30, 10}.To(id)); err != nil {
+		t.Errorf("RevokeRoleFromUser() without the window error = %v", err)
+	}
+}
+
+func TestRepository_OrganisationWideAssignments(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	setUpTest(repository, testConfig{
+		roles:               []Role{{1, 3, "auditor"}, {1, 4, "cashier"}},
+		branches:            []Branch{{1, 10, "A"}},
+		userRoleAssignments: []UserRoleAssignment{{1, 4, 30, 10}},
+	}, id)
+	orgId := GenId(id, 1)
+	organisationWide := func(roleId byte, deny bool) core.UserRoleAssignment {
+		return core.UserRoleAssignment{
+			OrganisationId:   orgId,
+			RoleId:           GenId(id, roleId),
+			UserId:           GenId(id, 30),
+			OrganisationWide: true,
+			Deny:             deny,
+		}
+	}
+	for _, x := range []core.UserRoleAssignment{organisationWide(3, false), organisationWide(4, true)} {
+		if err := repository.AssignRoleToUser(context.Background(), x); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assignments := func() []core.UserRoleAssignment {
+		result, err := repository.GetUserRolesAssignments(context.Background(), orgId, GenId(id, 30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return bytes.Compare(result[i].RoleId[:], result[j].RoleId[:]) < 0 ||
+				result[i].RoleId == result[j].RoleId && !result[i].OrganisationWide && result[j].OrganisationWide
+		})
+		return result
+	}
+	sorted := func(in ...core.UserRoleAssignment) []core.UserRoleAssignment {
+		sort.SliceStable(in, func(i, j int) bool {
+			return bytes.Compare(in[i].RoleId[:], in[j].RoleId[:]) < 0
+		})
+		return in
+	}
+
+	want := sorted(organisationWide(3, false), UserRoleAssignment{1, 4, 30, 10}.To(id), organisationWide(4, true))
+	if diff := cmp.Diff(want, assignments()); diff != "" {
+		t.Errorf("GetUserRolesAssignments() diff %v", diff)
+	}
+
+	// Deleting a branch leaves the organisation-wide assignments intact.
+	if err := repository.DeleteBranch(context.Background(), orgId, GenId(id, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.RevokeRoleFromUser(context.Background(), organisationWide(4, true)); err != nil {
+		t.Fatal(err)
+	}
+	want = []core.UserRoleAssignment{organisationWide(3, false)}
+	if diff := cmp.Diff(want, assignments()); diff != "" {
+		t.Errorf("GetUserRolesAssignments() after revocation diff %v", diff)
+	}
+}
+
+func TestRepository_ReferentialIntegrity(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	ctx := context.Background()
+	setUpTest(repository, testConfig{
+		roles:        []Role{{1, 3, "Admin"}, {2, 4, "Admin"}},
+		operations:   []Operation{{5, "view-member"}},
+		branches:     []Branch{{1, 10, "A"}, {2, 11, "B"}},
+		branchGroups: []BranchGroup{{1, 20, "X"}, {1, 21, "Y"}},
+	}, id)
+	orgWide := UserRoleAssignment{1, 3, 30, 0}.To(id)
+	orgWide.OrganisationWide = true
+	missingRole := UserRoleAssignment{1, 9, 30, 0}.To(id)
+	missingRole.OrganisationWide = true
+
+	tests := []struct {
+		name    string
+		f       func() error
+		wantErr bool
+	}{
+		{"Operation to role", func() error { return repository.AssignOperationToRole(ctx, OperationAssignment{1, 3, 5}.To(id)) }, false},
+		{"Missing operation", func() error { return repository.AssignOperationToRole(ctx, OperationAssignment{1, 3, 6}.To(id)) }, true},
+		{"Role of another organisation", func() error { return repository.AssignOperationToRole(ctx, OperationAssignment{1, 4, 5}.To(id)) }, true},
+		{"Missing included role", func() error { return repository.AssignRoleToRole(ctx, RoleInclusion{1, 3, 9}.To(id)) }, true},
+		{"Branch to branch group", func() error { return repository.AssignBranchToBranchGroup(ctx, BranchAssignment{1, 10, 20}.To(id)) }, false},
+		{"Branch group to branch group", func() error { return repository.AssignBranchToBranchGroup(ctx, BranchAssignment{1, 21, 20}.To(id)) }, false},
+		{"Branch of another organisation", func() error { return repository.AssignBranchToBranchGroup(ctx, BranchAssignment{1, 11, 20}.To(id)) }, true},
+		{"Missing branch group", func() error { return repository.AssignBranchToBranchGroup(ctx, BranchAssignment{1, 10, 22}.To(id)) }, true},
+		{"Role in branch", func() error { return repository.AssignRoleToUser(ctx, UserRoleAssignment{1, 3, 30, 10}.To(id)) }, false},
+		{"Role in branch group", func() error { return repository.AssignRoleToUser(ctx, UserRoleAssignment{1, 3, 30, 20}.To(id)) }, false},
+		{"Role in organisation", func() error { return repository.AssignRoleToUser(ctx, orgWide) }, false},
+		{"Missing branch", func() error { return repository.AssignRoleToUser(ctx, UserRoleAssignment{1, 3, 30, 12}.To(id)) }, true},
+		{"Missing role", func() error { return repository.AssignRoleToUser(ctx, missingRole) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.f()
+			if tt.wantErr && !errors.Is(err, dygraph.ReferenceNotFoundError) {
+				t.Errorf("expected a reference not found error, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+
+	got, err := repository.GetUserRolesAssignments(context.Background(), GenId(id, 1), GenId(id, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Errorf("GetUserRolesAssignments() = %v, want the 3 valid assignments only", got)
+	}
+}
+
+func TestRepository_OperationCatalogue(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	catalogue := []core.Operation{
+		Operation{5, "view-member"}.To(id),
+		Operation{7, "manage-member"}.To(id),
+	}
+	if err := repository.RegisterOperations(context.Background(), catalogue); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.RegisterOperations(context.Background(), catalogue[:1]); err != nil {
+		t.Errorf("RegisterOperations() of the registered operations error = %v", err)
+	}
+	renamed := Operation{5, "view-staff"}.To(id)
+	if err := repository.RegisterOperations(context.Background(), []core.Operation{renamed}); !errors.Is(err, dygraph.DuplicateError) {
+		t.Errorf("RegisterOperations() of a renamed operation error = %v", err)
+	}
+
+	// The operations stored in the organisation before the catalogue was introduced:
+	// 5 is in the catalogue, 6 is in the catalogue under the id 7 and 8 is not in the catalogue.
+	orgId := GenId(id, 1)
+	legacy := []core.Operation{
+		Operation{5, "view-member"}.To(id),
+		Operation{6, "manage-member"}.To(id),
+		Operation{8, "open-till"}.To(id),
+	}
+	for _, op := range legacy {
+		if err := repository.graphDB.InsertRecord(context.Background(), operationNode(orgId, op)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setUpTest(repository, testConfig{
+		roles: []Role{{1, 3, "Admin"}, {1, 4, "PT"}},
+	}, id)
+	// The assignments of the operations missing from the catalogue could only have been made before the catalogue.
+	for _, x := range []OperationAssignment{{1, 3, 5}, {1, 3, 6}, {1, 4, 6}, {1, 4, 7}, {1, 4, 8}} {
+		if err := repository.graphDB.TransactionalInsert(context.Background(), operationAssignmentEdges(x.To(id))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(bs ...byte) []uuid.UUID {
+		result := make([]uuid.UUID, len(bs))
+		for i, b := range bs {
+			result[i] = GenId(id, b)
+		}
+		sphinx.Sort(result)
+		return result
+	}
+	sorted := func(in []uuid.UUID, err error) []uuid.UUID {
+		if err != nil {
+			t.Fatal(err)
+		}
+		sphinx.Sort(in)
+		return in
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := repository.MigrateOperations(context.Background(), orgId); err != nil {
+			t.Fatalf("MigrateOperations() run %d error = %v", i, err)
+		}
+	}
+	nodes, err := repository.graphDB.GetNodes(context.Background(), orgId, OperationRecordType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 0 {
+		t.Errorf("MigrateOperations() left the operations %v", nodes)
+	}
+	for _, op := range []core.Operation{catalogue[0], catalogue[1], legacy[2]} {
+		got, err := repository.GetOperation(context.Background(), op.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(op, got); diff != "" {
+			t.Errorf("GetOperation() diff %v", diff)
+		}
+	}
+	if diff := cmp.Diff(ids(5, 7), sorted(repository.GetOperationsByRole(context.Background(), orgId, GenId(id, 3)))); diff != "" {
+		t.Errorf("MigrateOperations() operations diff %v", diff)
+	}
+	if diff := cmp.Diff(ids(7, 8), sorted(repository.GetOperationsByRole(context.Background(), orgId, GenId(id, 4)))); diff != "" {
+		t.Errorf("MigrateOperations() operations diff %v", diff)
+	}
+	if diff := cmp.Diff(ids(3, 4), sorted(repository.GetRolesByOperation(context.Background(), orgId, GenId(id, 7)))); diff != "" {
+		t.Errorf("MigrateOperations() roles diff %v", diff)
+	}
+
+	if err := repository.DeleteOperation(context.Background(), legacy[2].Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.GetOperation(context.Background(), legacy[2].Id); !errors.Is(err, dygraph.NotFoundError) {
+		t.Errorf("GetOperation() of a deleted operation error = %v", err)
+	}
+}
+
+// noScan is a graph refusing to scan the table.
+type noScan struct {
+	GraphDB
+}
+
+func (noScan) ScanItems(context.Context) ([]dygraph.Item, error) {
+	return nil, errors.New("scan")
+}
+
+func TestRepository_DeleteOperation(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	// The operation is assigned in two organisations.
+	setUpTest(repository, testConfig{
+		roles:       []Role{{1, 3, "Admin"}, {2, 3, "Admin"}, {2, 4, "Staff"}},
+		operations:  []Operation{{5, "view-member"}, {6, "open-till"}},
+		assignments: []OperationAssignment{{1, 3, 5}, {2, 3, 5}, {2, 4, 5}, {2, 4, 6}},
+	}, id)
+	// An assignment made before the organisations of the operations were recorded.
+	legacy := OperationAssignment{1, 3, 6}.To(id)
+	if err := repository.graphDB.TransactionalInsert(context.Background(), operationAssignmentEdges(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.IndexOperationOrganisations(context.Background(), legacy.OrganisationId); err != nil {
+		t.Fatal(err)
+	}
+
+	deleting := CreateRepository(noScan{repository.graphDB})
+	for _, op := range []byte{5, 6} {
+		if err := deleting.DeleteOperation(context.Background(), GenId(id, op)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, role := range []struct{ org, id byte }{{1, 3}, {2, 3}, {2, 4}} {
+		if ops, err := repository.GetOperationsByRole(context.Background(), GenId(id, role.org), GenId(id, role.id)); err != nil || len(ops) != 0 {
+			t.Errorf("GetOperationsByRole() after DeleteOperation() = %v, %v", ops, err)
+		}
+	}
+	for _, org := range []byte{1, 2} {
+		if got, err := repository.CheckOrganisation(context.Background(), GenId(id, org)); err != nil || len(got) != 0 {
+			t.Errorf("CheckOrganisation() after DeleteOperation() = %v, %v", got, err)
+		}
+	}
+	if got, err := repository.graphDB.GetNodeEdgesOfType(context.Background(), catalogueId, GenId(id, 5), OrganisationRecordType); err != nil || len(got) != 0 {
+		t.Errorf("organisation records left after DeleteOperation() = %v, %v", got, err)
+	}
+}
+
+// failingDeletion is a graph failing to delete the nodes.
+type failingDeletion struct {
+	GraphDB
+}
+
+func (failingDeletion) DeleteNode(context.Context, uuid.UUID, uuid.UUID, string) error {
+	return errors.New("deletion failed")
+}
+
+// failingRelease is a graph failing to delete the first record, e.g. a name record.
+type failingRelease struct {
+	GraphDB
+	failed *bool
+}
+
+func (g failingRelease) DeleteRecord(ctx context.Context, node *dygraph.Node) error {
+	if !*g.failed {
+		*g.failed = true
+		return errors.New("deletion failed")
+	}
+	return g.GraphDB.DeleteRecord(ctx, node)
+}
+
+func TestRepository_Names(t *testing.T) {
+	repository := CreateTestRepository()
+	id := uuid.New()
+	setUpTest(repository, testConfig{
+		roles:      []Role{{1, 3, "Admin"}, {1, 4, "PT"}, {2, 3, "Admin"}},
+		operations: []Operation{{5, "view-member"}},
+	}, id)
+	orgId := GenId(id, 1)
+
+	if err := repository.AddRole(context.Background(), Role{1, 6, "Admin"}.To(id)); !errors.Is(err, dygraph.DuplicateError) {
+		t.Errorf("AddRole() of a taken name error = %v", err)
+	}
+	if err := repository.AddOperation(context.Background(), Operation{6, "view-member"}.To(id)); !errors.Is(err, dygraph.DuplicateError) {
+		t.Errorf("AddOperation() of a taken name error = %v", err)
+	}
+	role, err := repository.GetRoleByName(context.Background(), orgId, "PT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Role{1, 4, "PT"}.To(id), role); diff != "" {
+		t.Errorf("GetRoleByName() diff %v", diff)
+	}
+	op, err := repository.GetOperationByName(context.Background(), Operation{5, "view-member"}.To(id).Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Operation{5, "view-member"}.To(id), op); diff != "" {
+		t.Errorf("GetOperationByName() diff %v", diff)
+	}
+	if _, err := repository.GetRoleByName(context.Background(), orgId, "Manager"); !errors.Is(err, dygraph.NotFoundError) {
+		t.Errorf("GetRoleByName() of a missing role error = %v", err)
+	}
+
+	// A failed deletion keeps the name of the role reserved.
+	failing := CreateRepository(failingDeletion{repository.graphDB})
+	if err := failing.DeleteRole(context.Background(), orgId, GenId(id, 4)); err == nil {
+		t.Fatal("DeleteRole() succeeded, want the deletion to fail")
+	}
+	if role, err := repository.GetRoleByName(context.Background(), orgId, "PT"); err != nil || role.Id != GenId(id, 4) {
+		t.Errorf("GetRoleByName() after a failed deletion = %v, %v", role, err)
+	}
+
+	// A deletion failing to release the name releases it when retried.
+	for _, x := range []struct {
+		name   string
+		delete func(repository *Repository) error
+		add    func() error
+	}{
+		{
+			name: "role",
+			delete: func(repository *Repository) error {
+				return repository.DeleteRole(context.Background(), orgId, GenId(id, 3))
+			},
+			add: func() error { return repository.AddRole(context.Background(), Role{1, 11, "Admin"}.To(id)) },
+		},
+		{
+			name: "operation",
+			delete: func(repository *Repository) error {
+				return repository.DeleteOperation(context.Background(), GenId(id, 5))
+			},
+			add: func() error {
+				return repository.AddOperation(context.Background(), Operation{12, "view-member"}.To(id))
+			},
+		},
+	} {
+		failed := false
+		if err := x.delete(CreateRepository(failingRelease{repository.graphDB, &failed})); err == nil {
+			t.Errorf("deletion of the %s succeeded, want the release of the name to fail", x.name)
+		}
+		if err := x.delete(repository); err != nil {
+			t.Errorf("deletion of the %s retried error = %v", x.name, err)
+		}
+		if err := x.add(); err != nil {
+			t.Errorf("addition of the %s name again error = %v", x.name, err)
+		}
+	}
+	if _, err := repository.GetOperation(context.Background(), GenId(id, 5)); !errors.Is(err, dygraph.NotFoundError) {
+		t.Errorf("GetOperation() of a deleted operation error = %v", err)
+	}
+
+	// Deleting a role releases its name.
+	if err := repository.DeleteRole(context.Background(), orgId, GenId(id, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.AddRole(context.Background(), Role{1, 7, "PT"}.To(id)); err != nil {
+		t.Errorf("AddRole() of a released name error = %v", err)
+	}
+
+	// The roles added before the names were indexed.
+	for _, role := range []Role{{1, 8, "Manager"}, {1, 9, "Cover"}, {1, 10, "Cover"}} {
+		x := role.To(id)
+		if err := repository.graphDB.InsertRecord(context.Background(), &dygraph.Node{OrganisationId: x.OrganisationId, Id: x.Id, Type: RoleRecordType, Data: x.Name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repository.IndexNames(context.Background(), orgId); !errors.Is(err, dygraph.DuplicateError) {
+		t.Errorf("IndexNames() of an ambiguous name error = %v", err)
+	}
+	role, err = repository.GetRoleByName(context.Background(), orgId, "Manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Role{1, 8, "Manager"}.To(id), role); diff != "" {
+		t.Errorf("GetRoleByName() after indexing diff %v", diff)
+	}
+}
@@ -1,14 +1,29 @@
 package repository
 
 import (
+	"context"
 	"github.com/dbuduev/authz-service-go/dygraph"
 	"github.com/google/uuid"
 )
 
 type GraphDB interface {
-	InsertRecord(node *dygraph.Node) error
-	GetNodes(organisationId uuid.UUID, nodeType string) ([]dygraph.Node, error)
-	GetEdges(organisationId uuid.UUID, edgeType string) ([]dygraph.Edge, error)
-	GetNodeEdgesOfType(organisationId, id uuid.UUID, edgeType string) ([]dygraph.Edge, error)
-	TransactionalInsert(items []dygraph.Edge) error
+	InsertRecord(ctx context.Context, node *dygraph.Node) error
+	InsertRecords(ctx context.Context, nodes []dygraph.Node) error
+	GetNode(ctx context.Context, organisationId, id uuid.UUID, nodeType string) (dygraph.Node, error)
+	GetNodes(ctx context.Context, organisationId uuid.UUID, nodeType string) ([]dygraph.Node, error)
+	GetEdges(ctx context.Context, organisationId uuid.UUID, edgeType string) ([]dygraph.Edge, error)
+	GetNodeEdgesOfType(ctx context.Context, organisationId, id uuid.UUID, edgeType string) ([]dygraph.Edge, error)
+	GetNodesPage(ctx context.Context, organisationId uuid.UUID, nodeType string, limit int32, token string) ([]dygraph.Node, string, error)
+	GetEdgesPage(ctx context.Context, organisationId uuid.UUID, edgeType string, limit int32, token string) ([]dygraph.Edge, string, error)
+	GetNodeEdgesOfTypePage(ctx context.Context, organisationId, id uuid.UUID, edgeType string, limit int32, token string) ([]dygraph.Edge, string, error)
+	TransactionalInsert(ctx context.Context, items []dygraph.Edge) error
+	TransactionalInsertReferencing(ctx context.Context, items []dygraph.Edge, references []dygraph.Node) error
+	TransactionalInsertVersioned(ctx context.Context, items []dygraph.Edge, references []dygraph.Node, version dygraph.Node, previous string) error
+	TransactionalDelete(ctx context.Context, items []dygraph.Edge) error
+	DeleteNode(ctx context.Context, organisationId, id uuid.UUID, nodeType string) error
+	DeleteRecord(ctx context.Context, node *dygraph.Node) error
+	GetItems(ctx context.Context, organisationId uuid.UUID) ([]dygraph.Item, error)
+	ScanItems(ctx context.Context) ([]dygraph.Item, error)
+	DeleteItems(ctx context.Context, items []dygraph.Item) error
+	ReplaceItems(ctx context.Context, items []dygraph.Item, edges []dygraph.Edge, conditions []dygraph.Condition) error
 }
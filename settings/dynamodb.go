@@ -0,0 +1,65 @@
+package settings
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"strings"
+)
+
+// TableName returns the table the graph is stored in.
+func (d DynamoDB) TableName() string {
+	if d.Table != "" {
+		return d.Table
+	}
+	return dygraph.TableName(d.Environment)
+}
+
+// TableOptions returns the options the migrate command creates the table with.
+func (d DynamoDB) TableOptions() dygraph.TableOptions {
+	return dygraph.TableOptions{TimeToLive: d.TimeToLive, StreamViewType: types.StreamViewType(d.Stream)}
+}
+
+// Client creates a DynamoDB client. The credentials and, unless overridden, the region
+// come from the shared AWS configuration and the AWS environment variables.
+func (s Settings) Client(ctx context.Context) (*dynamodb.Client, error) {
+	options := []func(*config.LoadOptions) error{
+		config.WithSharedConfigFiles(config.DefaultSharedConfigFiles),
+		config.WithSharedCredentialsFiles(config.DefaultSharedCredentialsFiles),
+	}
+	if s.DynamoDB.Region != "" {
+		options = append(options, config.WithRegion(s.DynamoDB.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		o.Retryer = dygraph.ClientRetryer()
+		if s.LogLevel == LogDebug {
+			o.ClientLogMode |= aws.LogRequest
+		}
+		if endpoint := s.DynamoDB.Endpoint; endpoint != "" {
+			o.EndpointResolver = dynamodb.EndpointResolverFunc(
+				func(region string, options dynamodb.EndpointResolverOptions) (aws.Endpoint, error) {
+					options.DisableHTTPS = strings.HasPrefix(endpoint, "http:")
+					return aws.Endpoint{URL: endpoint, HostnameImmutable: true}, nil
+				})
+		}
+	}), nil
+}
+
+// GraphClient creates the graph stored in the table.
+func (s Settings) GraphClient(ctx context.Context) (*dygraph.Dygraph, error) {
+	client, err := s.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	graph := dygraph.CreateGraphClientForTable(client, s.DynamoDB.TableName())
+	graph.SetRetryPolicy(dygraph.RetryPolicy(s.DynamoDB.Retry))
+	return graph, nil
+}
@@ -0,0 +1,293 @@
+// Package settings loads the runtime configuration of the service.
+//
+// The settings come from the defaults, an optional YAML or JSON file, the environment variables and
+// the command line flags, the later overriding the earlier. The file is given by -config or AUTHZ_CONFIG.
+package settings
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dbuduev/authz-service-go/dygraph"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// InvalidError is returned when the configuration is malformed or fails validation.
+var InvalidError = errors.New("invalid configuration")
+
+// LogLevel controls how much the service logs.
+type LogLevel string
+
+const (
+	// LogDebug logs every request together with the requests sent to DynamoDB and the changes made to the graph.
+	LogDebug LogLevel = "debug"
+	// LogInfo logs every request.
+	LogInfo LogLevel = "info"
+	// LogWarn logs the failures only.
+	LogWarn LogLevel = "warn"
+)
+
+// Settings is the runtime configuration of the service.
+type Settings struct {
+	// Listen is the address the service listens on, e.g. ":8080".
+	Listen string `yaml:"listen"`
+	// MetricsListen is the address /debug/vars is served on apart from the API, it is not served if it is empty.
+	MetricsListen string   `yaml:"metrics_listen"`
+	LogLevel      LogLevel `yaml:"log_level"`
+	DynamoDB      DynamoDB `yaml:"dynamodb"`
+	Timeouts      Timeouts `yaml:"timeouts"`
+}
+
+// DynamoDB tells where the table is.
+type DynamoDB struct {
+	// Endpoint overrides the endpoint of DynamoDB, e.g. to use DynamoDB Local. AWS is used if it is empty.
+	Endpoint string `yaml:"endpoint"`
+	// Region overrides the region of the AWS configuration.
+	Region string `yaml:"region"`
+	// Environment is the suffix of the table name, see dygraph.TableName.
+	Environment string `yaml:"environment"`
+	// Table overrides the table name derived from the environment.
+	Table string `yaml:"table"`
+	// TimeToLive lets DynamoDB delete the expired role assignments, it is enabled by the migrate command.
+	TimeToLive bool `yaml:"ttl"`
+	// Stream is the view type of the table stream the migrate command enables, e.g. NEW_AND_OLD_IMAGES.
+	// The stream is not enabled if it is empty.
+	Stream string `yaml:"stream"`
+	Retry  Retry  `yaml:"retry"`
+}
+
+// Retry tells how the throttled calls to DynamoDB are retried, see dygraph.RetryPolicy.
+type Retry struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	// Budget is the number of retries a request may make, zero means no limit.
+	Budget int `yaml:"budget"`
+}
+
+// Timeouts of the server, zero means no timeout.
+type Timeouts struct {
+	Read  time.Duration `yaml:"read"`
+	Write time.Duration `yaml:"write"`
+	Idle  time.Duration `yaml:"idle"`
+	// Request cancels the context of the requests taking longer, along with their calls to DynamoDB.
+	Request time.Duration `yaml:"request"`
+}
+
+// Default returns the settings used unless overridden, they are suitable for DynamoDB Local.
+func Default() Settings {
+	return Settings{
+		Listen:   ":8080",
+		LogLevel: LogInfo,
+		DynamoDB: DynamoDB{
+			Endpoint:    "http://localhost:8000",
+			Environment: "test",
+			TimeToLive:  true,
+			Retry:       Retry(dygraph.DefaultRetryPolicy()),
+		},
+		Timeouts: Timeouts{
+			Read:    30 * time.Second,
+			Write:   90 * time.Second,
+			Idle:    120 * time.Second,
+			Request: 60 * time.Second,
+		},
+	}
+}
+
+// setting is a value which can be given by a flag and an environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(s *Settings, value string) error
+}
+
+func stringSetting(p func(s *Settings) *string) func(s *Settings, value string) error {
+	return func(s *Settings, value string) error {
+		*p(s) = value
+		return nil
+	}
+}
+
+func boolSetting(p func(s *Settings) *bool) func(s *Settings, value string) error {
+	return func(s *Settings, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p(s) = b
+		return nil
+	}
+}
+
+func intSetting(p func(s *Settings) *int) func(s *Settings, value string) error {
+	return func(s *Settings, value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p(s) = i
+		return nil
+	}
+}
+
+func durationSetting(p func(s *Settings) *time.Duration) func(s *Settings, value string) error {
+	return func(s *Settings, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p(s) = d
+		return nil
+	}
+}
+
+var settings = []setting{
+	{"listen", "AUTHZ_LISTEN", "the address to listen on", stringSetting(func(s *Settings) *string { return &s.Listen })},
+	{"metrics-listen", "AUTHZ_METRICS_LISTEN", "the address serving /debug/vars, empty to disable it", stringSetting(func(s *Settings) *string { return &s.MetricsListen })},
+	{"log-level", "AUTHZ_LOG_LEVEL", "debug, info or warn", func(s *Settings, value string) error {
+		s.LogLevel = LogLevel(value)
+		return nil
+	}},
+	{"dynamodb-endpoint", "AUTHZ_DYNAMODB_ENDPOINT", "the DynamoDB endpoint, empty to use AWS", stringSetting(func(s *Settings) *string { return &s.DynamoDB.Endpoint })},
+	{"region", "AUTHZ_REGION", "the AWS region", stringSetting(func(s *Settings) *string { return &s.DynamoDB.Region })},
+	{"environment", "AUTHZ_ENVIRONMENT", "the environment the table belongs to", stringSetting(func(s *Settings) *string { return &s.DynamoDB.Environment })},
+	{"table", "AUTHZ_TABLE", "the table name, overrides the environment", stringSetting(func(s *Settings) *string { return &s.DynamoDB.Table })},
+	{"ttl", "AUTHZ_TTL", "enable the time to live of the table", boolSetting(func(s *Settings) *bool { return &s.DynamoDB.TimeToLive })},
+	{"stream", "AUTHZ_STREAM", "the view type of the table stream, empty to disable it", stringSetting(func(s *Settings) *string { return &s.DynamoDB.Stream })},
+	{"retry-max-attempts", "AUTHZ_RETRY_MAX_ATTEMPTS", "the attempts of a throttled DynamoDB call, 1 disables the retries", intSetting(func(s *Settings) *int { return &s.DynamoDB.Retry.MaxAttempts })},
+	{"retry-base-delay", "AUTHZ_RETRY_BASE_DELAY", "the delay before the first retry, doubled with every retry", durationSetting(func(s *Settings) *time.Duration { return &s.DynamoDB.Retry.BaseDelay })},
+	{"retry-max-delay", "AUTHZ_RETRY_MAX_DELAY", "the longest delay between retries", durationSetting(func(s *Settings) *time.Duration { return &s.DynamoDB.Retry.MaxDelay })},
+	{"retry-budget", "AUTHZ_RETRY_BUDGET", "the retries a request may make, 0 for no limit", intSetting(func(s *Settings) *int { return &s.DynamoDB.Retry.Budget })},
+	{"read-timeout", "AUTHZ_READ_TIMEOUT", "the server read timeout", durationSetting(func(s *Settings) *time.Duration { return &s.Timeouts.Read })},
+	{"write-timeout", "AUTHZ_WRITE_TIMEOUT", "the server write timeout", durationSetting(func(s *Settings) *time.Duration { return &s.Timeouts.Write })},
+	{"idle-timeout", "AUTHZ_IDLE_TIMEOUT", "the server idle timeout", durationSetting(func(s *Settings) *time.Duration { return &s.Timeouts.Idle })},
+	{"request-timeout", "AUTHZ_REQUEST_TIMEOUT", "the time a request may take", durationSetting(func(s *Settings) *time.Duration { return &s.Timeouts.Request })},
+}
+
+// ConfigEnv is the environment variable giving the configuration file unless -config is given.
+const ConfigEnv = "AUTHZ_CONFIG"
+
+// Load defines the flags of the settings in fs, parses the command line arguments, without the program name,
+// and returns the validated settings. fs may define the flags of the program beforehand.
+// lookupEnv is usually os.LookupEnv.
+func Load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (Settings, error) {
+	configFile := fs.String("config", "", "the YAML or JSON configuration file, "+ConfigEnv+" is used if not given")
+	byFlag := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		fs.String(s.flag, "", fmt.Sprintf("%s (%s)", s.usage, s.env))
+		byFlag[s.flag] = s
+	}
+	if err := fs.Parse(args); err != nil {
+		return Settings{}, err
+	}
+
+	result := Default()
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv(ConfigEnv)
+	}
+	if path != "" {
+		if err := readFile(path, &result); err != nil {
+			return Settings{}, err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok {
+			if err := s.set(&result, value); err != nil {
+				return Settings{}, fmt.Errorf("%s: %v: %w", s.env, err, InvalidError)
+			}
+		}
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		s, ok := byFlag[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := s.set(&result, f.Value.String()); setErr != nil {
+			err = fmt.Errorf("-%s: %v: %w", s.flag, setErr, InvalidError)
+		}
+	})
+	if err != nil {
+		return Settings{}, err
+	}
+	if err := result.Validate(); err != nil {
+		return Settings{}, err
+	}
+	return result, nil
+}
+
+// readFile overrides the settings with those of the file. JSON is read as YAML, its subset.
+func readFile(path string, s *Settings) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(s); err != nil {
+		return fmt.Errorf("%s: %v: %w", path, err, InvalidError)
+	}
+	return nil
+}
+
+// tableNamePattern is the DynamoDB table naming rule.
+var tableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+// Validate checks the settings.
+func (s Settings) Validate() error {
+	invalid := func(format string, a ...interface{}) error {
+		return fmt.Errorf("%s: %w", fmt.Sprintf(format, a...), InvalidError)
+	}
+	if _, _, err := net.SplitHostPort(s.Listen); err != nil {
+		return invalid("listen address %q: %v", s.Listen, err)
+	}
+	if s.MetricsListen != "" {
+		if _, _, err := net.SplitHostPort(s.MetricsListen); err != nil {
+			return invalid("metrics listen address %q: %v", s.MetricsListen, err)
+		}
+		if s.MetricsListen == s.Listen {
+			return invalid("metrics listen address %q should differ from the listen address", s.MetricsListen)
+		}
+	}
+	switch s.LogLevel {
+	case LogDebug, LogInfo, LogWarn:
+	default:
+		return invalid("log level %q should be debug, info or warn", s.LogLevel)
+	}
+	if s.DynamoDB.Endpoint != "" {
+		u, err := url.Parse(s.DynamoDB.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid("DynamoDB endpoint %q should be an http or https URL", s.DynamoDB.Endpoint)
+		}
+	}
+	if s.DynamoDB.Table == "" && s.DynamoDB.Environment == "" {
+		return invalid("either the environment or the table should be given")
+	}
+	switch types.StreamViewType(s.DynamoDB.Stream) {
+	case "", types.StreamViewTypeKeysOnly, types.StreamViewTypeNewImage, types.StreamViewTypeOldImage, types.StreamViewTypeNewAndOldImages:
+	default:
+		return invalid("stream view type %q should be KEYS_ONLY, NEW_IMAGE, OLD_IMAGE or NEW_AND_OLD_IMAGES", s.DynamoDB.Stream)
+	}
+	if !tableNamePattern.MatchString(s.DynamoDB.TableName()) {
+		return invalid("table name %q should be 3 to 255 letters, digits, '_', '-' or '.'", s.DynamoDB.TableName())
+	}
+	if r := s.DynamoDB.Retry; r.MaxAttempts < 1 || r.BaseDelay < 0 || r.MaxDelay < r.BaseDelay || r.Budget < 0 {
+		return invalid("retry policy %+v should have at least one attempt, delays not decreasing and a budget not negative", r)
+	}
+	for name, d := range map[string]time.Duration{"read": s.Timeouts.Read, "write": s.Timeouts.Write, "idle": s.Timeouts.Idle, "request": s.Timeouts.Request} {
+		if d < 0 {
+			return invalid("%s timeout %v should not be negative", name, d)
+		}
+	}
+	return nil
+}
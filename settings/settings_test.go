@@ -0,0 +1,164 @@
+package settings
+
+import (
+	"errors"
+	"flag"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(args []string, vars map[string]string) (Settings, error) {
+	return Load(flag.NewFlagSet("test", flag.ContinueOnError), args, env(vars))
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	yamlFile := writeFile(t, "authz.yaml", `
+listen: ":9000"
+log_level: debug
+dynamodb:
+  endpoint: ""
+  region: eu-west-1
+  environment: prod
+  stream: NEW_AND_OLD_IMAGES
+  retry:
+    max_attempts: 3
+timeouts:
+  read: 5s
+  request: 10s
+`)
+	jsonFile := writeFile(t, "authz.json", `{"listen": ":9001", "dynamodb": {"table": "authz"}}`)
+
+	tests := []struct {
+		name string
+		args []string
+		vars map[string]string
+		want func(s *Settings)
+	}{
+		{
+			name: "Defaults",
+			want: func(s *Settings) {},
+		},
+		{
+			name: "YAML file",
+			args: []string{"-config", yamlFile},
+			want: func(s *Settings) {
+				s.Listen = ":9000"
+				s.LogLevel = LogDebug
+				s.DynamoDB = DynamoDB{Region: "eu-west-1", Environment: "prod", TimeToLive: true, Stream: "NEW_AND_OLD_IMAGES", Retry: s.DynamoDB.Retry}
+				s.DynamoDB.Retry.MaxAttempts = 3
+				s.Timeouts.Read = 5 * time.Second
+				s.Timeouts.Request = 10 * time.Second
+			},
+		},
+		{
+			name: "JSON file given by the environment",
+			vars: map[string]string{ConfigEnv: jsonFile},
+			want: func(s *Settings) {
+				s.Listen = ":9001"
+				s.DynamoDB.Table = "authz"
+			},
+		},
+		{
+			name: "Environment overrides the file",
+			args: []string{"-config", yamlFile},
+			vars: map[string]string{"AUTHZ_ENVIRONMENT": "staging", "AUTHZ_READ_TIMEOUT": "1m", "AUTHZ_TTL": "false", "AUTHZ_RETRY_BUDGET": "0"},
+			want: func(s *Settings) {
+				s.Listen = ":9000"
+				s.LogLevel = LogDebug
+				s.DynamoDB = DynamoDB{Region: "eu-west-1", Environment: "staging", Stream: "NEW_AND_OLD_IMAGES", Retry: s.DynamoDB.Retry}
+				s.DynamoDB.Retry.MaxAttempts = 3
+				s.DynamoDB.Retry.Budget = 0
+				s.Timeouts.Read = time.Minute
+				s.Timeouts.Request = 10 * time.Second
+			},
+		},
+		{
+			name: "Flags override the environment",
+			args: []string{"-environment", "dev", "-dynamodb-endpoint", "", "-log-level", "warn", "-retry-max-delay", "1s"},
+			vars: map[string]string{"AUTHZ_ENVIRONMENT": "staging", "AUTHZ_LOG_LEVEL": "debug", "AUTHZ_RETRY_MAX_DELAY": "5s"},
+			want: func(s *Settings) {
+				s.DynamoDB.Retry.MaxDelay = time.Second
+				s.LogLevel = LogWarn
+				s.DynamoDB.Endpoint = ""
+				s.DynamoDB.Environment = "dev"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := load(tt.args, tt.vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Default()
+			tt.want(&want)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Load() diff %v", diff)
+			}
+		})
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		vars map[string]string
+	}{
+		{"Unknown field", []string{"-config", writeFile(t, "unknown.yaml", "port: 8080\n")}, nil},
+		{"Malformed file", []string{"-config", writeFile(t, "malformed.json", `{"listen": `)}, nil},
+		{"Malformed duration", nil, map[string]string{"AUTHZ_REQUEST_TIMEOUT": "10"}},
+		{"Negative timeout", []string{"-idle-timeout", "-1s"}, nil},
+		{"Listen address", []string{"-listen", "8080"}, nil},
+		{"Metrics listen address", []string{"-metrics-listen", "9090"}, nil},
+		{"Same listen addresses", []string{"-metrics-listen", ":8080"}, nil},
+		{"Log level", []string{"-log-level", "trace"}, nil},
+		{"Endpoint", []string{"-dynamodb-endpoint", "localhost:8000"}, nil},
+		{"No table", []string{"-environment", ""}, nil},
+		{"Table name", []string{"-table", "authz/test"}, nil},
+		{"Stream view type", []string{"-stream", "ALL"}, nil},
+		{"No attempts", []string{"-retry-max-attempts", "0"}, nil},
+		{"Malformed attempts", nil, map[string]string{"AUTHZ_RETRY_MAX_ATTEMPTS": "many"}},
+		{"Delays", []string{"-retry-base-delay", "1s", "-retry-max-delay", "100ms"}, nil},
+		{"Negative budget", []string{"-retry-budget", "-1"}, nil},
+		{"Malformed boolean", nil, map[string]string{"AUTHZ_TTL": "maybe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := load(tt.args, tt.vars); !errors.Is(err, InvalidError) {
+				t.Errorf("expected an invalid configuration error, got %v", err)
+			}
+		})
+	}
+
+	if _, err := load([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, nil); err == nil {
+		t.Errorf("expected an error reading a missing file")
+	}
+}
+
+func TestDynamoDB_TableName(t *testing.T) {
+	if got := (DynamoDB{Environment: "prod"}).TableName(); got != "Authorization-prod" {
+		t.Errorf("TableName() = %v", got)
+	}
+	if got := (DynamoDB{Environment: "prod", Table: "authz"}).TableName(); got != "authz" {
+		t.Errorf("TableName() = %v", got)
+	}
+}
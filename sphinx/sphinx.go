@@ -1,17 +1,26 @@
 package sphinx
 
-import "github.com/google/uuid"
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"sort"
+)
+
+// CycleError is returned when a branch group or a role would end up containing itself.
+var CycleError = errors.New("hierarchy cycle")
 
 // BranchGroupContent represents a type aliasing a map
 // where key is a branch group UUID and value is a slice of UUIDs.
-// Each of latter UUIDs is a ID of a branch belonging to the branch group
-// designated by the key. The value contains all the branches of the branch group.
+// Each of latter UUIDs is a ID of a branch or a nested branch group belonging to the branch group
+// designated by the key. The value contains all the direct members of the branch group.
 type BranchGroupContent map[uuid.UUID][]uuid.UUID
 
 // BranchGroupsOfBranch represents a type aliasing a map
 // where key is a branch UUID and value is a slice of UUIDs.
 // Each of latter UUIDs is an ID of a branch group containing the branch
-// designated by the key. The value contains all the branch groups the branch belongs to.
+// designated by the key. The value contains all the branch groups the branch belongs to directly.
+// Nested branch groups are keys as well.
 type BranchGroupsOfBranch map[uuid.UUID][]uuid.UUID
 
 func (m BranchGroupContent) Reverse() BranchGroupsOfBranch {
@@ -23,3 +32,169 @@ func (m BranchGroupContent) Reverse() BranchGroupsOfBranch {
 	}
 	return result
 }
+
+// Expand resolves a slice of UUIDs, each being either a branch or a branch group ID,
+// into the branches they designate, descending into nested branch groups.
+// A UUID which is not a key of the map is considered a branch ID.
+// The first result contains the branches, the second one contains the branch groups found among ids
+// together with the branch groups nested in them.
+// Both results are de-duplicated and sorted.
+func (m BranchGroupContent) Expand(ids []uuid.UUID) ([]uuid.UUID, []uuid.UUID) {
+	branches := make(map[uuid.UUID]struct{})
+	groups := make(map[uuid.UUID]struct{})
+	m.walk(ids, func(id uuid.UUID, isGroup bool) {
+		if isGroup {
+			groups[id] = struct{}{}
+		} else {
+			branches[id] = struct{}{}
+		}
+	})
+
+	return sortedKeys(branches), sortedKeys(groups)
+}
+
+// Branches returns all the branches of the branch group including the ones of nested branch groups.
+// The result is sorted.
+func (m BranchGroupContent) Branches(group uuid.UUID) []uuid.UUID {
+	branches := make(map[uuid.UUID]struct{})
+	m.walk(m[group], func(id uuid.UUID, isGroup bool) {
+		if !isGroup {
+			branches[id] = struct{}{}
+		}
+	})
+
+	return sortedKeys(branches)
+}
+
+// CheckNesting returns CycleError if making child a member of parent would create a cycle,
+// i.e. if child is parent or child contains parent directly or transitively.
+func (m BranchGroupContent) CheckNesting(parent, child uuid.UUID) error {
+	found := parent == child
+	m.walk(m[child], func(id uuid.UUID, _ bool) {
+		found = found || id == parent
+	})
+	if found {
+		return CycleError
+	}
+	return nil
+}
+
+// walk visits every id and, if it is a branch group, every member of it recursively.
+// Each id is visited once, so walk terminates even if the map contains a cycle.
+func (m BranchGroupContent) walk(ids []uuid.UUID, visit func(id uuid.UUID, isGroup bool)) {
+	visited := make(map[uuid.UUID]struct{})
+	queue := append([]uuid.UUID(nil), ids...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+		members, isGroup := m[id]
+		visit(id, isGroup)
+		queue = append(queue, members...)
+	}
+}
+
+// Ancestors returns all the branch groups containing the branch or branch group directly or transitively.
+// Closer ancestors come first.
+func (m BranchGroupsOfBranch) Ancestors(id uuid.UUID) []uuid.UUID {
+	return reachable(m, id)
+}
+
+// RoleContent represents a type aliasing a map
+// where key is a role UUID and value is a slice of UUIDs.
+// Each of latter UUIDs is an ID of a role included by the role designated by the key.
+// The value contains the roles included directly. A role grants its own operations
+// as well as the operations of all the roles it includes directly or transitively.
+type RoleContent map[uuid.UUID][]uuid.UUID
+
+// Reverse returns the map where key is a role UUID and value contains the roles including it directly.
+func (m RoleContent) Reverse() RoleContent {
+	result := make(RoleContent)
+	for role, included := range m {
+		for _, r := range included {
+			result[r] = append(result[r], role)
+		}
+	}
+	return result
+}
+
+// Included returns all the roles the role includes directly or transitively.
+// Directly included roles come first.
+func (m RoleContent) Included(role uuid.UUID) []uuid.UUID {
+	return reachable(m, role)
+}
+
+// Closure returns the roles together with all the roles reachable from them, without duplicates.
+// Called on a reversed map it returns the roles together with all the roles including them.
+func (m RoleContent) Closure(roles []uuid.UUID) []uuid.UUID {
+	var result []uuid.UUID
+	visited := make(map[uuid.UUID]struct{}, len(roles))
+	for _, role := range roles {
+		if _, ok := visited[role]; ok {
+			continue
+		}
+		visited[role] = struct{}{}
+		result = append(result, role)
+	}
+	for _, role := range roles {
+		for _, r := range reachable(m, role) {
+			if _, ok := visited[r]; !ok {
+				visited[r] = struct{}{}
+				result = append(result, r)
+			}
+		}
+	}
+	return result
+}
+
+// CheckInclusion returns CycleError if making role include the included one would create a cycle,
+// i.e. if included is role or included includes role directly or transitively.
+func (m RoleContent) CheckInclusion(role, included uuid.UUID) error {
+	if role == included {
+		return CycleError
+	}
+	for _, r := range reachable(m, included) {
+		if r == role {
+			return CycleError
+		}
+	}
+	return nil
+}
+
+// reachable returns the ids reachable from id, closer ones first.
+// id itself is not included, and each id is visited once, so reachable terminates even if m contains a cycle.
+func reachable(m map[uuid.UUID][]uuid.UUID, id uuid.UUID) []uuid.UUID {
+	var result []uuid.UUID
+	visited := map[uuid.UUID]struct{}{id: {}}
+	queue := append([]uuid.UUID(nil), m[id]...)
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[next]; ok {
+			continue
+		}
+		visited[next] = struct{}{}
+		result = append(result, next)
+		queue = append(queue, m[next]...)
+	}
+	return result
+}
+
+// Sort sorts a slice of UUIDs in place in ascending byte order.
+func Sort(ids []uuid.UUID) {
+	sort.SliceStable(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+}
+
+func sortedKeys(set map[uuid.UUID]struct{}) []uuid.UUID {
+	result := make([]uuid.UUID, 0, len(set))
+	for id := range set {
+		result = append(result, id)
+	}
+	Sort(result)
+	return result
+}
@@ -1,6 +1,7 @@
 package sphinx
 
 import (
+	"errors"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
 	"sort"
@@ -51,3 +52,161 @@ func TestBranchGroupContent_Reverse(t *testing.T) {
 		})
 	}
 }
+
+func TestBranchGroupContent_Expand(t *testing.T) {
+	var g = [...]uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	var b = [...]uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+	sorted := func(ids ...uuid.UUID) []uuid.UUID {
+		Sort(ids)
+		return ids
+	}
+	m := BranchGroupContent{
+		g[0]: {b[0], b[1]},
+		g[1]: {b[1], b[2]},
+		g[2]: {b[0], g[1]},
+	}
+
+	tests := []struct {
+		name         string
+		ids          []uuid.UUID
+		wantBranches []uuid.UUID
+		wantGroups   []uuid.UUID
+	}{
+		{
+			name:         "Empty",
+			ids:          nil,
+			wantBranches: []uuid.UUID{},
+			wantGroups:   []uuid.UUID{},
+		},
+		{
+			name:         "Branches only",
+			ids:          []uuid.UUID{b[3], b[0], b[3]},
+			wantBranches: sorted(b[0], b[3]),
+			wantGroups:   []uuid.UUID{},
+		},
+		{
+			name:         "Nested group",
+			ids:          []uuid.UUID{g[2]},
+			wantBranches: sorted(b[0], b[1], b[2]),
+			wantGroups:   sorted(g[1], g[2]),
+		},
+		{
+			name:         "Overlapping groups and a branch",
+			ids:          []uuid.UUID{g[0], g[1], b[3], b[0]},
+			wantBranches: sorted(b[0], b[1], b[2], b[3]),
+			wantGroups:   sorted(g[0], g[1]),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			branches, groups := m.Expand(tt.ids)
+			if diff := cmp.Diff(tt.wantBranches, branches); diff != "" {
+				t.Errorf("Expand() branches mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantGroups, groups); diff != "" {
+				t.Errorf("Expand() groups mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBranchGroupContent_Nesting(t *testing.T) {
+	var g = [...]uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+	var b = [...]uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	sorted := func(ids ...uuid.UUID) []uuid.UUID {
+		Sort(ids)
+		return ids
+	}
+	// g[3] contains g[2] which contains g[0] and g[1].
+	m := BranchGroupContent{
+		g[0]: {b[0]},
+		g[1]: {b[1], b[0]},
+		g[2]: {g[0], g[1], b[2]},
+		g[3]: {g[2]},
+	}
+
+	if diff := cmp.Diff(sorted(b[0], b[1], b[2]), m.Branches(g[3])); diff != "" {
+		t.Errorf("Branches() mismatch (-want +got):\n%s", diff)
+	}
+	ancestors := m.Reverse().Ancestors(b[2])
+	if diff := cmp.Diff([]uuid.UUID{g[2], g[3]}, ancestors); diff != "" {
+		t.Errorf("Ancestors() mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name    string
+		parent  uuid.UUID
+		child   uuid.UUID
+		wantErr bool
+	}{
+		{"Sibling", g[1], g[0], false},
+		{"Branch", g[0], b[2], false},
+		{"Itself", g[0], g[0], true},
+		{"Direct parent", g[0], g[2], true},
+		{"Transitive parent", g[1], g[3], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.CheckNesting(tt.parent, tt.child)
+			if (err != nil) != tt.wantErr || (tt.wantErr && !errors.Is(err, CycleError)) {
+				t.Errorf("CheckNesting() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBranchGroupsOfBranch_Ancestors(t *testing.T) {
+	var g = [...]uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	b := uuid.New()
+	// The groups form a cycle, which must not make Ancestors loop.
+	m := BranchGroupContent{
+		g[0]: {b, g[2]},
+		g[1]: {g[0]},
+		g[2]: {g[1]},
+	}.Reverse()
+
+	got := m.Ancestors(b)
+	if diff := cmp.Diff([]uuid.UUID{g[0], g[1], g[2]}, got); diff != "" {
+		t.Errorf("Ancestors() mismatch (-want +got):\n%s", diff)
+	}
+	if got := m.Ancestors(uuid.New()); len(got) != 0 {
+		t.Errorf("Ancestors() of an unknown branch = %v", got)
+	}
+}
+
+func TestRoleContent(t *testing.T) {
+	var r = [...]uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+	// r[0] includes r[1] which includes r[2], r[3] is unrelated.
+	m := RoleContent{
+		r[0]: {r[1]},
+		r[1]: {r[2]},
+	}
+
+	if diff := cmp.Diff([]uuid.UUID{r[1], r[2]}, m.Included(r[0])); diff != "" {
+		t.Errorf("Included() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]uuid.UUID{r[2], r[3], r[1], r[0]}, m.Reverse().Closure([]uuid.UUID{r[2], r[3], r[2]})); diff != "" {
+		t.Errorf("Closure() mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name     string
+		role     uuid.UUID
+		included uuid.UUID
+		wantErr  bool
+	}{
+		{"Unrelated", r[3], r[0], false},
+		{"Already included transitively", r[0], r[2], false},
+		{"Itself", r[1], r[1], true},
+		{"Direct cycle", r[1], r[0], true},
+		{"Transitive cycle", r[2], r[0], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.CheckInclusion(tt.role, tt.included)
+			if (err != nil) != tt.wantErr || (tt.wantErr && !errors.Is(err, CycleError)) {
+				t.Errorf("CheckInclusion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
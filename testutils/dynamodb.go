@@ -0,0 +1,22 @@
+package testutils
+
+import (
+	"os"
+	"testing"
+)
+
+// DynamoDBEnv is the environment variable enabling the tests against DynamoDB Local.
+const DynamoDBEnv = "AUTHZ_TEST_DYNAMODB"
+
+// UseDynamoDB reports whether the tests should run against DynamoDB Local rather than in memory.
+func UseDynamoDB() bool {
+	return os.Getenv(DynamoDBEnv) != ""
+}
+
+// RequireDynamoDB skips the test unless the tests run against DynamoDB Local.
+func RequireDynamoDB(t *testing.T) {
+	t.Helper()
+	if !UseDynamoDB() {
+		t.Skipf("set %s to run the test against DynamoDB Local", DynamoDBEnv)
+	}
+}